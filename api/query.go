@@ -1,5 +1,7 @@
 package api
 
+import "context"
+
 // Query is an interface for a query that returns data
 type Query interface {
 	// Name returns a constant string name with which to represent the query
@@ -8,3 +10,11 @@ type Query interface {
 	// QueryRow executes the query with the passed in runtime parameters
 	QueryRow(args ...interface{}) (interface{}, error)
 }
+
+// ContextQuery is a Query that can also be executed with a context, which cancels the query when the context is done (e.g. on a deadline)
+type ContextQuery interface {
+	Query
+
+	// QueryRowContext executes the query with the passed in runtime parameters until the ctx is done
+	QueryRowContext(ctx context.Context, args ...interface{}) (interface{}, error)
+}
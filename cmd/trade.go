@@ -58,6 +58,9 @@ var upgradeScripts = []*database.UpgradeScript{
 		kelpdb.SqlStrategyMirrorTradeTriggersTableCreate,
 		kelpdb.SqlTradesTableAlter2,
 	),
+	database.MakeUpgradeScript(7,
+		kelpdb.SqlPendingVolumesTableCreate,
+	),
 }
 
 const tradeExamples = `  kelp trade --botConf ./path/trader.cfg --strategy buysell --stratConf ./path/buysell.cfg
@@ -696,13 +699,25 @@ func runTradeCmd(options inputs) {
 		tradingPair,
 		sdexAssetMap,
 	)
+	// the results of the volume queries are shared by the volume filters within a tick, the TTL is half the tick interval so a result is never
+	// reused by the next tick
+	var volumeQueryCache *plugins.VolumeQueryCache
+	if db != nil && botConfig.TickIntervalSeconds > 0 {
+		cache, e := plugins.MakeVolumeQueryCache(time.Duration(botConfig.TickIntervalSeconds) * time.Second / 2)
+		if e != nil {
+			l.Infof("not sharing the volume query results between the volume filters, could not make the volume query cache: %s\n", e)
+		} else {
+			volumeQueryCache = cache
+		}
+	}
 	filterFactory := &plugins.FilterFactory{
-		ExchangeName:   botConfig.TradingExchangeName(),
-		TradingPair:    tradingPair,
-		AssetDisplayFn: assetDisplayFn,
-		BaseAsset:      assetBase,
-		QuoteAsset:     assetQuote,
-		DB:             db,
+		ExchangeName:     botConfig.TradingExchangeName(),
+		TradingPair:      tradingPair,
+		AssetDisplayFn:   assetDisplayFn,
+		BaseAsset:        assetBase,
+		QuoteAsset:       assetQuote,
+		DB:               db,
+		VolumeQueryCache: volumeQueryCache,
 	}
 	baseString, e := assetDisplayFn(tradingPair.Base)
 	if e != nil {
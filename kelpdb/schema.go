@@ -9,6 +9,10 @@ const SqlTradesTableAlter1 = "ALTER TABLE trades ADD COLUMN account_id TEXT"
 const SqlStrategyMirrorTradeTriggersTableCreate = "CREATE TABLE IF NOT EXISTS strategy_mirror_trade_triggers (market_id TEXT NOT NULL, txid TEXT NOT NULL, backing_market_id TEXT NOT NULL, backing_order_id TEXT NOT NULL, PRIMARY KEY (market_id, txid))"
 const SqlTradesTableAlter2 = "ALTER TABLE trades ADD COLUMN order_id TEXT"
 
+// SqlPendingVolumesTableCreate creates the table of the volume that was placed but is not yet settled in the trades table, where the
+// trading_date is the date of the day of the volume filter that recorded it
+const SqlPendingVolumesTableCreate = "CREATE TABLE IF NOT EXISTS pending_volumes (market_id TEXT NOT NULL, trading_date DATE NOT NULL, action TEXT NOT NULL, base_volume DOUBLE PRECISION NOT NULL, counter_cost DOUBLE PRECISION NOT NULL, PRIMARY KEY (market_id, trading_date, action))"
+
 /*
 	indexes
 */
@@ -30,6 +34,9 @@ const SqlMarketsInsertTemplate = "INSERT INTO markets (market_id, exchange_name,
 // SqlTradesInsertTemplate inserts into the trades table
 const SqlTradesInsertTemplate = "INSERT INTO trades (market_id, txid, date_utc, action, type, counter_price, base_volume, counter_cost, fee, account_id, order_id) VALUES ('%s', '%s', '%s', '%s', '%s', %.15f, %.15f, %.15f, %.15f, '%s', '%s')"
 
+// SqlPendingVolumesUpsert inserts into the pending_volumes table or replaces the pending volume of the market, date, and action
+const SqlPendingVolumesUpsert = "INSERT INTO pending_volumes (market_id, trading_date, action, base_volume, counter_cost) VALUES ($1, $2, $3, $4, $5) ON CONFLICT (market_id, trading_date, action) DO UPDATE SET base_volume = EXCLUDED.base_volume, counter_cost = EXCLUDED.counter_cost"
+
 // SqlStrategyMirrorTradeTriggersInsertTemplate inserts into the strategy_mirror_trade_triggers table
 const SqlStrategyMirrorTradeTriggersInsertTemplate = "INSERT INTO strategy_mirror_trade_triggers (market_id, txid, backing_market_id, backing_order_id) VALUES ('%s', '%s', '%s', '%s')"
 
@@ -0,0 +1,162 @@
+package model
+
+import (
+	"context"
+	"fmt"
+)
+
+// BookDeltaType is the type of change to a price level of an orderbook
+type BookDeltaType int8
+
+// These are the available book delta types
+const (
+	BookDeltaTypeAdd    BookDeltaType = 0
+	BookDeltaTypeUpdate BookDeltaType = 1
+	BookDeltaTypeRemove BookDeltaType = 2
+)
+
+// String is the stringer function
+func (t BookDeltaType) String() string {
+	if t == BookDeltaTypeAdd {
+		return "add"
+	} else if t == BookDeltaTypeUpdate {
+		return "update"
+	} else if t == BookDeltaTypeRemove {
+		return "remove"
+	}
+	return "error, unrecognized book delta type"
+}
+
+// BookDelta represents a change to a single price level of an orderbook, the action is sell for asks and buy for bids
+type BookDelta struct {
+	Type        BookDeltaType
+	OrderAction OrderAction
+	Price       *Number
+	// Volume is the new total volume at the price level, zero for removes
+	Volume *Number
+}
+
+// String is the stringer function
+func (d BookDelta) String() string {
+	return fmt.Sprintf("BookDelta[type=%s, action=%s, price=%s, vol=%s]",
+		d.Type,
+		d.OrderAction,
+		d.Price.AsString(),
+		d.Volume.AsString(),
+	)
+}
+
+// DiffOrderBooks returns the deltas that transform the prev orderbook into the next orderbook, where a nil prev is treated as an empty book
+// so every level of next is an add. Orders on the same price level are aggregated. Deltas are ordered by side (asks then bids) with the
+// removes first in the order of the prev book, followed by the adds and updates in the order of the next book.
+func DiffOrderBooks(prev *OrderBook, next *OrderBook) []BookDelta {
+	prevAsks, prevBids := []Order{}, []Order{}
+	if prev != nil {
+		prevAsks, prevBids = prev.Asks(), prev.Bids()
+	}
+
+	deltas := diffLevels(OrderActionSell, prevAsks, next.Asks())
+	return append(deltas, diffLevels(OrderActionBuy, prevBids, next.Bids())...)
+}
+
+// priceLevel is the aggregated volume on a single price
+type priceLevel struct {
+	price  *Number
+	volume *Number
+}
+
+// aggregateLevels aggregates the volume of orders on the same price, keeping the order of the first occurrence of each price
+func aggregateLevels(orders []Order) ([]priceLevel, map[float64]*Number) {
+	levels := []priceLevel{}
+	volumes := map[float64]*Number{}
+	for _, order := range orders {
+		key := order.Price.AsFloat()
+		if existing, ok := volumes[key]; ok {
+			volumes[key] = existing.Add(*order.Volume)
+			continue
+		}
+		volumes[key] = order.Volume
+		levels = append(levels, priceLevel{price: order.Price})
+	}
+
+	for i := range levels {
+		levels[i].volume = volumes[levels[i].price.AsFloat()]
+	}
+	return levels, volumes
+}
+
+func diffLevels(action OrderAction, prevOrders []Order, nextOrders []Order) []BookDelta {
+	prevLevels, prevVolumes := aggregateLevels(prevOrders)
+	nextLevels, nextVolumes := aggregateLevels(nextOrders)
+
+	deltas := []BookDelta{}
+	for _, level := range prevLevels {
+		if _, ok := nextVolumes[level.price.AsFloat()]; !ok {
+			deltas = append(deltas, BookDelta{
+				Type:        BookDeltaTypeRemove,
+				OrderAction: action,
+				Price:       level.price,
+				Volume:      NumberFromFloat(0.0, level.volume.Precision()),
+			})
+		}
+	}
+
+	for _, level := range nextLevels {
+		prevVolume, ok := prevVolumes[level.price.AsFloat()]
+		if !ok {
+			deltas = append(deltas, BookDelta{
+				Type:        BookDeltaTypeAdd,
+				OrderAction: action,
+				Price:       level.price,
+				Volume:      level.volume,
+			})
+		} else if prevVolume.AsFloat() != level.volume.AsFloat() {
+			deltas = append(deltas, BookDelta{
+				Type:        BookDeltaTypeUpdate,
+				OrderAction: action,
+				Price:       level.price,
+				Volume:      level.volume,
+			})
+		}
+	}
+	return deltas
+}
+
+// StreamDeltas consumes a channel of orderbook snapshots and emits the deltas between each snapshot and the one before it, which adapts
+// a polling source into a delta stream. All the levels of the first snapshot are emitted as adds. Nil snapshots are skipped. The returned
+// channel is closed once the snapshots channel is closed and all deltas have been emitted, or once the ctx is done, in which case any
+// deltas that were not yet received are dropped. Consumers that stop reading before the snapshots channel is closed need to cancel the ctx
+// so the goroutine exits.
+func StreamDeltas(ctx context.Context, snapshots <-chan *OrderBook) <-chan BookDelta {
+	deltas := make(chan BookDelta)
+	go func() {
+		defer close(deltas)
+
+		var prev *OrderBook
+		for {
+			var snapshot *OrderBook
+			var ok bool
+			select {
+			case <-ctx.Done():
+				return
+			case snapshot, ok = <-snapshots:
+				if !ok {
+					return
+				}
+			}
+			if snapshot == nil {
+				continue
+			}
+
+			for _, delta := range DiffOrderBooks(prev, snapshot) {
+				select {
+				case <-ctx.Done():
+					return
+				case deltas <- delta:
+				}
+			}
+			prev = snapshot
+		}
+	}()
+	return deltas
+}
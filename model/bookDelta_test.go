@@ -0,0 +1,130 @@
+package model
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func makeTestDelta(deltaType BookDeltaType, action OrderAction, price float64, volume float64) BookDelta {
+	return BookDelta{
+		Type:        deltaType,
+		OrderAction: action,
+		Price:       NumberFromFloat(price, 7),
+		Volume:      NumberFromFloat(volume, 7),
+	}
+}
+
+// assertDeltas compares the deltas by their string representation
+func assertDeltas(t *testing.T, want []BookDelta, actual []BookDelta) {
+	if !assert.Equal(t, len(want), len(actual)) {
+		return
+	}
+	for i, d := range actual {
+		assert.Equal(t, want[i].String(), d.String(), "delta at index %d", i)
+	}
+}
+
+func TestDiffOrderBooks(t *testing.T) {
+	prev := MakeOrderBook(testPair, makeTestAsks(1.1, 5.0, 1.2, 3.0), makeTestBids(0.9, 4.0, 0.8, 2.0))
+
+	testCases := []struct {
+		name       string
+		prev       *OrderBook
+		next       *OrderBook
+		wantDeltas []BookDelta
+	}{
+		{
+			name: "nil prev",
+			prev: nil,
+			next: prev,
+			wantDeltas: []BookDelta{
+				makeTestDelta(BookDeltaTypeAdd, OrderActionSell, 1.1, 5.0),
+				makeTestDelta(BookDeltaTypeAdd, OrderActionSell, 1.2, 3.0),
+				makeTestDelta(BookDeltaTypeAdd, OrderActionBuy, 0.9, 4.0),
+				makeTestDelta(BookDeltaTypeAdd, OrderActionBuy, 0.8, 2.0),
+			},
+		}, {
+			name:       "no change",
+			prev:       prev,
+			next:       MakeOrderBook(testPair, makeTestAsks(1.1, 5.0, 1.2, 3.0), makeTestBids(0.9, 4.0, 0.8, 2.0)),
+			wantDeltas: []BookDelta{},
+		}, {
+			name: "adds, updates, and removes",
+			prev: prev,
+			next: MakeOrderBook(testPair, makeTestAsks(1.05, 1.0, 1.1, 6.0), makeTestBids(0.8, 2.0, 0.7, 1.0)),
+			wantDeltas: []BookDelta{
+				makeTestDelta(BookDeltaTypeRemove, OrderActionSell, 1.2, 0.0),
+				makeTestDelta(BookDeltaTypeAdd, OrderActionSell, 1.05, 1.0),
+				makeTestDelta(BookDeltaTypeUpdate, OrderActionSell, 1.1, 6.0),
+				makeTestDelta(BookDeltaTypeRemove, OrderActionBuy, 0.9, 0.0),
+				makeTestDelta(BookDeltaTypeAdd, OrderActionBuy, 0.7, 1.0),
+			},
+		}, {
+			name:       "orders on the same level are aggregated",
+			prev:       prev,
+			next:       MakeOrderBook(testPair, makeTestAsks(1.1, 2.0, 1.1, 3.0, 1.2, 3.0), makeTestBids(0.9, 4.0, 0.8, 2.0)),
+			wantDeltas: []BookDelta{},
+		},
+	}
+
+	for _, kase := range testCases {
+		t.Run(kase.name, func(t *testing.T) {
+			assertDeltas(t, kase.wantDeltas, DiffOrderBooks(kase.prev, kase.next))
+		})
+	}
+}
+
+func TestStreamDeltas(t *testing.T) {
+	snapshots := make(chan *OrderBook)
+	deltas := StreamDeltas(context.Background(), snapshots)
+
+	go func() {
+		snapshots <- MakeOrderBook(testPair, makeTestAsks(1.1, 5.0), makeTestBids(0.9, 4.0))
+		snapshots <- nil
+		snapshots <- MakeOrderBook(testPair, makeTestAsks(1.1, 5.0), makeTestBids(0.9, 4.0))
+		snapshots <- MakeOrderBook(testPair, makeTestAsks(1.1, 2.0), []Order{})
+		close(snapshots)
+	}()
+
+	actual := []BookDelta{}
+	for d := range deltas {
+		actual = append(actual, d)
+	}
+
+	assertDeltas(t, []BookDelta{
+		// first snapshot is all adds
+		makeTestDelta(BookDeltaTypeAdd, OrderActionSell, 1.1, 5.0),
+		makeTestDelta(BookDeltaTypeAdd, OrderActionBuy, 0.9, 4.0),
+		// the nil snapshot and the unchanged snapshot emit nothing
+		makeTestDelta(BookDeltaTypeUpdate, OrderActionSell, 1.1, 2.0),
+		makeTestDelta(BookDeltaTypeRemove, OrderActionBuy, 0.9, 0.0),
+	}, actual)
+}
+
+func TestStreamDeltasCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	snapshots := make(chan *OrderBook, 1)
+	deltas := StreamDeltas(ctx, snapshots)
+
+	// the consumer reads only the first of the two deltas and then cancels without closing the snapshots channel
+	snapshots <- MakeOrderBook(testPair, makeTestAsks(1.1, 5.0), makeTestBids(0.9, 4.0))
+	assertDeltas(t, []BookDelta{makeTestDelta(BookDeltaTypeAdd, OrderActionSell, 1.1, 5.0)}, []BookDelta{<-deltas})
+	cancel()
+
+	// at most the delta that was being sent when the ctx was cancelled is still received before the channel is closed
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-deltas:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			assert.Fail(t, "deltas channel was not closed after the ctx was cancelled")
+			return
+		}
+	}
+}
@@ -24,7 +24,10 @@ const InvertPrecision = 15
 // InternalCalculationsPrecision is the precision to be used for internal calculations in a function
 const InternalCalculationsPrecision = 15
 
-// Number abstraction
+// Number abstraction, backed by a float64 that is rounded to the precision. A value is exact to its precision only while the magnitude of the
+// value scaled by 10^precision is within the 2^53 integers that a float64 can represent exactly (e.g. up to ~9e8 at a precision of 7), larger
+// values keep the full float64 resolution (~16 significant digits) without being rounded. The largest finite value is math.MaxFloat64, and
+// a value that is out of range is kept as +/-Inf, which callers need to check with IsFinite.
 type Number struct {
 	value     float64
 	precision int8
@@ -113,6 +116,11 @@ func (n Number) EqualsPrecisionNormalized(n2 Number, epsilon float64) bool {
 	return n.Subtract(n2).Abs().AsFloat() < epsilon
 }
 
+// IsFinite returns false if the value is +/-Inf or NaN, which is the result of an operation that is out of the range of a float64
+func (n Number) IsFinite() bool {
+	return !math.IsInf(n.value, 0) && !math.IsNaN(n.value)
+}
+
 // String is the Stringer interface impl.
 func (n Number) String() string {
 	return n.AsString()
@@ -188,9 +196,17 @@ const (
 	RoundTruncate
 )
 
+// maxExactFloat is the largest integer below which every integer can be represented exactly by a float64 (2^53)
+const maxExactFloat = 1 << 53
+
 func toFixed(num float64, precision int8, rounding Rounding) float64 {
 	output := math.Pow(10, float64(precision))
-	return float64(round(num*output, rounding)) / output
+	scaled := num * output
+	// converting the scaled value to an int64 overflows for large values, which have no digits beyond the precision to round anyway
+	if math.IsInf(scaled, 0) || math.IsNaN(scaled) || math.Abs(scaled) >= maxExactFloat {
+		return num
+	}
+	return float64(round(scaled, rounding)) / output
 }
 
 func minPrecision(n1 Number, n2 Number) int8 {
@@ -2,6 +2,7 @@ package model
 
 import (
 	"fmt"
+	"math"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -39,6 +40,17 @@ func TestNumberFromFloat(t *testing.T) {
 			precision:  1,
 			wantString: "0.1",
 			wantFloat:  0.1,
+		}, {
+			// too large to be scaled by the precision without overflowing an int64 so the value is kept as it is
+			f:          1e15,
+			precision:  7,
+			wantString: "1000000000000000.0000000",
+			wantFloat:  1e15,
+		}, {
+			f:          -1e15,
+			precision:  7,
+			wantString: "-1000000000000000.0000000",
+			wantFloat:  -1e15,
 		},
 	}
 
@@ -55,6 +67,12 @@ func TestNumberFromFloat(t *testing.T) {
 	}
 }
 
+func TestNumberIsFinite(t *testing.T) {
+	assert.True(t, NumberFromFloat(1e300, 7).IsFinite())
+	assert.False(t, NumberFromFloat(1e300, 7).Multiply(*NumberFromFloat(1e300, 7)).IsFinite())
+	assert.False(t, NumberFromFloat(math.NaN(), 7).IsFinite())
+}
+
 func TestNumberFromFloatRoundTruncate(t *testing.T) {
 	testCases := []struct {
 		f          float64
@@ -0,0 +1,101 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// OrderBookAnalyzer ingests successive orderbooks and flags a sustained one-sided imbalance of the volume in the book, which gives a smoothed
+// signal of buying or selling pressure (a crude spoofing detector) instead of the noisy imbalance of a single snapshot. It is not safe for
+// concurrent use.
+type OrderBookAnalyzer struct {
+	threshold float64
+	duration  time.Duration
+	// nowFn is the clock used by the analyzer, defaults to time.Now when nil
+	nowFn func() time.Time
+
+	// pressure is the side that has been imbalanced beyond the threshold since imbalancedSince, only valid when hasPressure is true
+	pressure        OrderAction
+	hasPressure     bool
+	imbalancedSince time.Time
+	lastObserved    time.Time
+}
+
+// MakeOrderBookAnalyzer makes an OrderBookAnalyzer that flags an imbalance once the volume imbalance of the observed books stays on the same
+// side beyond the threshold, which is within (0, 1], for at least the passed in duration
+func MakeOrderBookAnalyzer(threshold float64, duration time.Duration) (*OrderBookAnalyzer, error) {
+	if threshold <= 0 || threshold > 1 {
+		return nil, fmt.Errorf("threshold needs to be within (0, 1] but was %f", threshold)
+	}
+	if duration < 0 {
+		return nil, fmt.Errorf("duration needs to be non-negative but was %s", duration)
+	}
+
+	return &OrderBookAnalyzer{
+		threshold: threshold,
+		duration:  duration,
+	}, nil
+}
+
+// now returns the current time based on the clock of the analyzer
+func (a *OrderBookAnalyzer) now() time.Time {
+	if a.nowFn == nil {
+		return time.Now()
+	}
+	return a.nowFn()
+}
+
+// Observe ingests the next orderbook. A book that is balanced within the threshold, flips to the other side, or is empty resets the
+// imbalance. Nil books are ignored.
+func (a *OrderBookAnalyzer) Observe(ob *OrderBook) {
+	if ob == nil {
+		return
+	}
+
+	now := a.now()
+	a.lastObserved = now
+	imbalance, ok := volumeImbalance(ob)
+	if !ok || math.Abs(imbalance) < a.threshold {
+		a.hasPressure = false
+		return
+	}
+
+	pressure := OrderActionBuy
+	if imbalance < 0 {
+		pressure = OrderActionSell
+	}
+	if !a.hasPressure || a.pressure != pressure {
+		a.pressure = pressure
+		a.hasPressure = true
+		a.imbalancedSince = now
+	}
+}
+
+// IsImbalanced returns the side with the pressure (buy when the bids dominate, sell when the asks dominate) and true if the imbalance has
+// been sustained for at least the configured duration as of the latest observed book
+func (a *OrderBookAnalyzer) IsImbalanced() (OrderAction, bool) {
+	if !a.hasPressure || a.lastObserved.Sub(a.imbalancedSince) < a.duration {
+		return OrderActionBuy, false
+	}
+	return a.pressure, true
+}
+
+// volumeImbalance returns (bidVolume - askVolume) / (bidVolume + askVolume) over all the levels of the book, which is within [-1, 1] where
+// positive values mean the bids dominate. Returns false if the book has no volume.
+func volumeImbalance(ob *OrderBook) (float64, bool) {
+	bidVolume := 0.0
+	for _, bid := range ob.Bids() {
+		bidVolume += bid.Volume.AsFloat()
+	}
+	askVolume := 0.0
+	for _, ask := range ob.Asks() {
+		askVolume += ask.Volume.AsFloat()
+	}
+
+	total := bidVolume + askVolume
+	if total <= 0 {
+		return 0, false
+	}
+	return (bidVolume - askVolume) / total, true
+}
@@ -0,0 +1,92 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrderBookAnalyzer(t *testing.T) {
+	balanced := MakeOrderBook(testPair, makeTestAsks(1.1, 5.0), makeTestBids(0.9, 5.0))
+	bidHeavy := MakeOrderBook(testPair, makeTestAsks(1.1, 1.0), makeTestBids(0.9, 9.0))
+	askHeavy := MakeOrderBook(testPair, makeTestAsks(1.1, 9.0), makeTestBids(0.9, 1.0))
+	empty := MakeOrderBook(testPair, []Order{}, []Order{})
+
+	type observation struct {
+		ob             *OrderBook
+		wantOk         bool
+		wantImbalanced OrderAction
+	}
+	testCases := []struct {
+		name         string
+		observations []observation
+	}{
+		{
+			name: "crosses the threshold and then recovers",
+			observations: []observation{
+				{ob: balanced},
+				{ob: bidHeavy},
+				{ob: bidHeavy},
+				{ob: bidHeavy, wantOk: true, wantImbalanced: OrderActionBuy},
+				{ob: bidHeavy, wantOk: true, wantImbalanced: OrderActionBuy},
+				{ob: balanced},
+				{ob: bidHeavy},
+			},
+		}, {
+			name: "flipping sides restarts the duration",
+			observations: []observation{
+				{ob: askHeavy},
+				{ob: askHeavy},
+				{ob: bidHeavy},
+				{ob: bidHeavy},
+				{ob: bidHeavy, wantOk: true, wantImbalanced: OrderActionBuy},
+			},
+		}, {
+			name: "sell pressure",
+			observations: []observation{
+				{ob: askHeavy},
+				{ob: askHeavy},
+				{ob: askHeavy, wantOk: true, wantImbalanced: OrderActionSell},
+			},
+		}, {
+			name: "empty and nil books",
+			observations: []observation{
+				{ob: bidHeavy},
+				{ob: bidHeavy},
+				{ob: nil},
+				{ob: empty},
+				{ob: bidHeavy},
+				{ob: bidHeavy},
+			},
+		},
+	}
+
+	for _, kase := range testCases {
+		t.Run(kase.name, func(t *testing.T) {
+			analyzer, e := MakeOrderBookAnalyzer(0.5, 2*time.Second)
+			if !assert.NoError(t, e) {
+				return
+			}
+			now, _ := time.Parse(time.RFC3339, "2020-01-21T12:00:00Z")
+			analyzer.nowFn = func() time.Time { return now }
+
+			for i, o := range kase.observations {
+				analyzer.Observe(o.ob)
+				action, ok := analyzer.IsImbalanced()
+				assert.Equal(t, o.wantOk, ok, "observation at index %d", i)
+				if o.wantOk {
+					assert.Equal(t, o.wantImbalanced, action, "observation at index %d", i)
+				}
+				now = now.Add(time.Second)
+			}
+		})
+	}
+
+	_, e := MakeOrderBookAnalyzer(0.0, time.Second)
+	assert.Error(t, e)
+	_, e = MakeOrderBookAnalyzer(1.5, time.Second)
+	assert.Error(t, e)
+	_, e = MakeOrderBookAnalyzer(0.5, -time.Second)
+	assert.Error(t, e)
+}
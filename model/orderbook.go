@@ -2,6 +2,11 @@ package model
 
 import (
 	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/stellar/kelp/support/utils"
 )
@@ -52,9 +57,14 @@ var orderActionMap = map[string]OrderAction{
 	"sell": OrderActionSell,
 }
 
-// OrderActionFromString is a convenience to convert from common strings to the corresponding OrderAction
-func OrderActionFromString(s string) OrderAction {
-	return orderActionMap[s]
+// OrderActionFromString is a convenience to convert from common strings to the corresponding OrderAction, it returns an error for an
+// unrecognized string instead of defaulting to a buy
+func OrderActionFromString(s string) (OrderAction, error) {
+	action, ok := orderActionMap[s]
+	if !ok {
+		return OrderActionBuy, fmt.Errorf("unrecognized order action '%s'", s)
+	}
+	return action, nil
 }
 
 // OrderType represents a type of an order, example market, limit, etc.
@@ -64,6 +74,10 @@ type OrderType int8
 const (
 	OrderTypeMarket OrderType = 0
 	OrderTypeLimit  OrderType = 1
+	// OrderTypeStop is a market order that is triggered when the market reaches the StopPrice of the order
+	OrderTypeStop OrderType = 2
+	// OrderTypeStopLimit is a limit order at the Price of the order that is triggered when the market reaches the StopPrice of the order
+	OrderTypeStopLimit OrderType = 3
 )
 
 // IsMarket returns true for market orders
@@ -76,24 +90,45 @@ func (o OrderType) IsLimit() bool {
 	return o == OrderTypeLimit
 }
 
+// IsStop returns true for stop orders
+func (o OrderType) IsStop() bool {
+	return o == OrderTypeStop
+}
+
+// IsStopLimit returns true for stop-limit orders
+func (o OrderType) IsStopLimit() bool {
+	return o == OrderTypeStopLimit
+}
+
 // String is the stringer function
 func (o OrderType) String() string {
 	if o == OrderTypeMarket {
 		return "market"
 	} else if o == OrderTypeLimit {
 		return "limit"
+	} else if o == OrderTypeStop {
+		return "stop"
+	} else if o == OrderTypeStopLimit {
+		return "stop-limit"
 	}
 	return "error, unrecognized order type"
 }
 
 var orderTypeMap = map[string]OrderType{
-	"market": OrderTypeMarket,
-	"limit":  OrderTypeLimit,
+	"market":     OrderTypeMarket,
+	"limit":      OrderTypeLimit,
+	"stop":       OrderTypeStop,
+	"stop-limit": OrderTypeStopLimit,
 }
 
-// OrderTypeFromString is a convenience to convert from common strings to the corresponding OrderType
-func OrderTypeFromString(s string) OrderType {
-	return orderTypeMap[s]
+// OrderTypeFromString is a convenience to convert from common strings to the corresponding OrderType, it returns an error for an
+// unrecognized string instead of defaulting to a market order
+func OrderTypeFromString(s string) (OrderType, error) {
+	orderType, ok := orderTypeMap[s]
+	if !ok {
+		return OrderTypeMarket, fmt.Errorf("unrecognized order type '%s'", s)
+	}
+	return orderType, nil
 }
 
 // Order represents an order in the orderbook
@@ -104,6 +139,12 @@ type Order struct {
 	Price       *Number
 	Volume      *Number
 	Timestamp   *Timestamp
+	// StopPrice is the price that triggers an OrderTypeStop or OrderTypeStopLimit order, nil for the other order types
+	StopPrice *Number
+	// Owner is the account that placed the order, empty when unknown
+	Owner string
+	// Exchange is the venue that the order is on, empty when unknown (e.g. in a book from a single venue)
+	Exchange string
 }
 
 // String is the stringer function
@@ -112,17 +153,76 @@ func (o Order) String() string {
 	if o.Timestamp != nil {
 		tsString = fmt.Sprintf("%d", o.Timestamp.AsInt64())
 	}
+	// the stop price is only included when it is set so the string of the other order types is unchanged
+	stopPriceString := ""
+	if o.StopPrice != nil {
+		stopPriceString = fmt.Sprintf(", stopPrice=%s", o.StopPrice.AsString())
+	}
 
-	return fmt.Sprintf("Order[pair=%s, action=%s, type=%s, price=%s, vol=%s, ts=%s]",
+	return fmt.Sprintf("Order[pair=%s, action=%s, type=%s, price=%s, vol=%s%s, ts=%s]",
 		o.Pair,
 		o.OrderAction,
 		o.OrderType,
 		o.Price.AsString(),
 		o.Volume.AsString(),
+		stopPriceString,
 		tsString,
 	)
 }
 
+// Equals returns true if the two orders have the same pair, action, type, price, volume, stop price, timestamp, owner, and exchange, where the pointer
+// fields are compared by value (the numbers at the lower of their precisions) so two orders decoded separately can be equal
+func (o Order) Equals(other Order) bool {
+	if (o.Pair == nil) != (other.Pair == nil) || (o.Pair != nil && *o.Pair != *other.Pair) {
+		return false
+	}
+	if (o.Timestamp == nil) != (other.Timestamp == nil) || (o.Timestamp != nil && *o.Timestamp != *other.Timestamp) {
+		return false
+	}
+
+	return o.OrderAction == other.OrderAction &&
+		o.OrderType == other.OrderType &&
+		numbersEqual(o.Price, other.Price) &&
+		numbersEqual(o.Volume, other.Volume) &&
+		numbersEqual(o.StopPrice, other.StopPrice) &&
+		o.Owner == other.Owner &&
+		o.Exchange == other.Exchange
+}
+
+// numbersEqual returns true if both numbers are nil or they are equal at the lower of their precisions
+func numbersEqual(n1 *Number, n2 *Number) bool {
+	if n1 == nil || n2 == nil {
+		return n1 == nil && n2 == nil
+	}
+	return n1.EqualsPrecisionNormalized(*n2, floatEpsilon)
+}
+
+// Clone returns a deep copy of the order, with new pointers for the pair, price, volume, stop price, and timestamp so modifying the copy (or the
+// original) does not affect the other
+func (o Order) Clone() Order {
+	clone := o
+	if o.Pair != nil {
+		pair := *o.Pair
+		clone.Pair = &pair
+	}
+	if o.Price != nil {
+		price := *o.Price
+		clone.Price = &price
+	}
+	if o.Volume != nil {
+		volume := *o.Volume
+		clone.Volume = &volume
+	}
+	if o.StopPrice != nil {
+		stopPrice := *o.StopPrice
+		clone.StopPrice = &stopPrice
+	}
+	if o.Timestamp != nil {
+		clone.Timestamp = MakeTimestamp(o.Timestamp.AsInt64())
+	}
+	return clone
+}
+
 // OrderBook encapsulates the concept of an orderbook on a market
 type OrderBook struct {
 	pair *TradingPair
@@ -161,6 +261,922 @@ func (o OrderBook) TopBid() *Order {
 	return nil
 }
 
+// BestBid returns the best bid in an orderbook, returns false when there are no bids
+func (o OrderBook) BestBid() (*Order, bool) {
+	topBid := o.TopBid()
+	return topBid, topBid != nil
+}
+
+// BestAsk returns the best ask in an orderbook, returns false when there are no asks
+func (o OrderBook) BestAsk() (*Order, bool) {
+	topAsk := o.TopAsk()
+	return topAsk, topAsk != nil
+}
+
+// TopN returns a new orderbook with at most the top n asks and n bids in their existing order, or all the levels of a side when it has fewer
+// than n. A negative n is treated as 0. The sides share the backing arrays of this orderbook instead of copying the orders, but are capped so
+// appending to them does not modify this orderbook.
+func (o OrderBook) TopN(n int) *OrderBook {
+	topFn := func(orders []Order) []Order {
+		if n < 0 {
+			n = 0
+		}
+		if n > len(orders) {
+			return orders[:len(orders):len(orders)]
+		}
+		return orders[:n:n]
+	}
+	return MakeOrderBook(o.pair, topFn(o.asks), topFn(o.bids))
+}
+
+// Clone returns a deep copy of the orderbook, where the asks and bids are new slices of cloned orders and the pair is copied, so sorting,
+// trimming, or modifying the orders of the copy does not affect this orderbook (e.g. when it is cached and shared between strategies)
+func (o OrderBook) Clone() *OrderBook {
+	cloneFn := func(orders []Order) []Order {
+		if orders == nil {
+			return nil
+		}
+		cloned := make([]Order, len(orders))
+		for i, order := range orders {
+			cloned[i] = order.Clone()
+		}
+		return cloned
+	}
+
+	var pair *TradingPair
+	if o.pair != nil {
+		pairCopy := *o.pair
+		pair = &pairCopy
+	}
+	return MakeOrderBook(pair, cloneFn(o.asks), cloneFn(o.bids))
+}
+
+// Imbalance returns the order flow imbalance (bidVolume - askVolume) / (bidVolume + askVolume) over the top levels on each side of the book,
+// which is within [-1, 1] where positive values mean the bids dominate. Returns 0 when the top levels have no volume.
+func (o OrderBook) Imbalance(levels int) float64 {
+	imbalance, _ := volumeImbalance(o.TopN(levels))
+	return imbalance
+}
+
+// Spread returns the best ask minus the best bid, assuming that the asks and the bids are sorted. Returns an error when either side is empty.
+func (o OrderBook) Spread() (*Number, error) {
+	topBid, topAsk, e := o.topBidAndAsk()
+	if e != nil {
+		return nil, e
+	}
+	return topAsk.Price.Subtract(*topBid.Price), nil
+}
+
+// MidPrice returns the average of the best ask and the best bid, assuming that the asks and the bids are sorted. Returns an error when
+// either side is empty.
+func (o OrderBook) MidPrice() (*Number, error) {
+	topBid, topAsk, e := o.topBidAndAsk()
+	if e != nil {
+		return nil, e
+	}
+	return topAsk.Price.Add(*topBid.Price).Scale(0.5), nil
+}
+
+// SpreadPct returns the spread as a fraction of the mid price (e.g. 0.01 for a 1% spread). Returns an error when either side is empty or
+// the mid price is zero.
+func (o OrderBook) SpreadPct() (*Number, error) {
+	spread, e := o.Spread()
+	if e != nil {
+		return nil, e
+	}
+	midPrice, e := o.MidPrice()
+	if e != nil {
+		return nil, e
+	}
+	if midPrice.AsFloat() == 0 {
+		return nil, fmt.Errorf("cannot compute the spread as a fraction of a zero mid price")
+	}
+	return NumberFromFloat(spread.AsFloat()/midPrice.AsFloat(), InternalCalculationsPrecision), nil
+}
+
+// topBidAndAsk returns the best bid and the best ask, returns an error when either side is empty
+func (o OrderBook) topBidAndAsk() (*Order, *Order, error) {
+	topBid, ok := o.BestBid()
+	if !ok {
+		return nil, nil, fmt.Errorf("there are no bids in the orderbook")
+	}
+	topAsk, ok := o.BestAsk()
+	if !ok {
+		return nil, nil, fmt.Errorf("there are no asks in the orderbook")
+	}
+	return topBid, topAsk, nil
+}
+
+// TopOfBook captures the best levels on both sides of an orderbook
+type TopOfBook struct {
+	BestBid       *Number
+	BestAsk       *Number
+	BestBidVolume *Number
+	BestAskVolume *Number
+	Spread        *Number
+	MidPrice      *Number
+}
+
+// String is the stringer function
+func (t TopOfBook) String() string {
+	return fmt.Sprintf("TopOfBook[bestBid=%s, bestAsk=%s, bestBidVolume=%s, bestAskVolume=%s, spread=%s, midPrice=%s]",
+		utils.CheckedString(t.BestBid),
+		utils.CheckedString(t.BestAsk),
+		utils.CheckedString(t.BestBidVolume),
+		utils.CheckedString(t.BestAskVolume),
+		utils.CheckedString(t.Spread),
+		utils.CheckedString(t.MidPrice),
+	)
+}
+
+// TopOfBook computes the best bid, best ask, their volumes, the spread, and the mid price in one pass, returns false when either side is empty
+func (o *OrderBook) TopOfBook() (TopOfBook, bool) {
+	topBid := o.TopBid()
+	topAsk := o.TopAsk()
+	if topBid == nil || topAsk == nil {
+		return TopOfBook{}, false
+	}
+
+	return TopOfBook{
+		BestBid:       topBid.Price,
+		BestAsk:       topAsk.Price,
+		BestBidVolume: topBid.Volume,
+		BestAskVolume: topAsk.Volume,
+		Spread:        topAsk.Price.Subtract(*topBid.Price),
+		MidPrice:      topAsk.Price.Add(*topBid.Price).Scale(0.5),
+	}, true
+}
+
+// sideForAction returns the resting orders that have the passed in action, i.e. asks for sell and bids for buy
+func (o OrderBook) sideForAction(action OrderAction) []Order {
+	if action.IsSell() {
+		return o.asks
+	}
+	return o.bids
+}
+
+// LevelGaps returns the absolute price differences between consecutive levels on the side of the book with the passed in action
+// (asks for sell, bids for buy). Large gaps indicate a thin book.
+func (o OrderBook) LevelGaps(action OrderAction) []*Number {
+	orders := o.sideForAction(action)
+	gaps := []*Number{}
+	for i := 1; i < len(orders); i++ {
+		gaps = append(gaps, orders[i].Price.Subtract(*orders[i-1].Price).Abs())
+	}
+	return gaps
+}
+
+// PriceLaddersEqual returns true if both books have the same price levels on each side, ignoring the volumes, so strategies can skip
+// re-quoting when only the volumes changed. Multiple orders on the same price make up a single price level, and prices are compared
+// within floatEpsilon. A nil book only equals another nil book.
+func (o *OrderBook) PriceLaddersEqual(other *OrderBook) bool {
+	if o == nil || other == nil {
+		return o == other
+	}
+	return priceLevelsEqual(priceLevels(o.asks), priceLevels(other.asks)) && priceLevelsEqual(priceLevels(o.bids), priceLevels(other.bids))
+}
+
+// priceLevels returns the distinct prices of the sorted orders
+func priceLevels(orders []Order) []float64 {
+	levels := []float64{}
+	for _, order := range orders {
+		price := order.Price.AsFloat()
+		if len(levels) > 0 && math.Abs(levels[len(levels)-1]-price) < floatEpsilon {
+			continue
+		}
+		levels = append(levels, price)
+	}
+	return levels
+}
+
+func priceLevelsEqual(a []float64, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if math.Abs(a[i]-b[i]) >= floatEpsilon {
+			return false
+		}
+	}
+	return true
+}
+
+// canonicalStringPrecision is the fixed number of decimal places of the prices and volumes in the CanonicalString
+const canonicalStringPrecision = 10
+
+// CanonicalString returns a deterministic representation of the book that is suitable as a cache key, for hashing, or for golden-file tests.
+// Each side lists the price and volume of every order with a fixed precision, sorted by price (asks ascending, bids descending) and then by
+// volume, so the result does not depend on the order of the input or on the precision of the numbers. The other fields of the orders
+// (type, timestamp, owner, exchange) are not included.
+func (o *OrderBook) CanonicalString() string {
+	pairString := nilString
+	if o.pair != nil {
+		pairString = o.pair.String()
+	}
+	return fmt.Sprintf("%s|asks=%s|bids=%s", pairString, canonicalLevels(o.asks, OrderActionSell), canonicalLevels(o.bids, OrderActionBuy))
+}
+
+// canonicalLevels returns the comma-separated price@volume pairs of the orders in the canonical sort order of the side with the action
+func canonicalLevels(orders []Order, action OrderAction) string {
+	type level struct {
+		price  float64
+		volume float64
+	}
+	levels := []level{}
+	for _, order := range orders {
+		levels = append(levels, level{price: order.Price.AsFloat(), volume: order.Volume.AsFloat()})
+	}
+	sort.SliceStable(levels, func(i int, j int) bool {
+		if levels[i].price != levels[j].price {
+			return (levels[i].price < levels[j].price) == action.IsSell()
+		}
+		return levels[i].volume < levels[j].volume
+	})
+
+	parts := []string{}
+	for _, l := range levels {
+		parts = append(parts, strconv.FormatFloat(l.price, 'f', canonicalStringPrecision, 64)+"@"+strconv.FormatFloat(l.volume, 'f', canonicalStringPrecision, 64))
+	}
+	return strings.Join(parts, ",")
+}
+
+// takerSideForAction returns the resting orders that would be consumed by a taker with the passed in action, i.e. asks for buy and bids for sell
+func (o OrderBook) takerSideForAction(action OrderAction) []Order {
+	return o.sideForAction(action.Reverse())
+}
+
+// VWAP walks the side of the book consumed by a taker with the passed in action (asks for buy, bids for sell) until targetVolume is reached,
+// returning the volume-weighted average price and the volume that was actually available, which is less than targetVolume if the book is
+// too thin. The volumes and costs are accumulated with Number arithmetic. Returns an error when targetVolume is not positive or there is no
+// liquidity on that side.
+func (o OrderBook) VWAP(action OrderAction, targetVolume *Number) (*Number, *Number, error) {
+	if targetVolume == nil || targetVolume.AsFloat() <= 0 {
+		return nil, nil, fmt.Errorf("targetVolume needs to be positive but was %s", utils.CheckedString(targetVolume))
+	}
+
+	orders := o.takerSideForAction(action)
+	if len(orders) == 0 {
+		return nil, nil, fmt.Errorf("no liquidity available to %s", action)
+	}
+
+	filled := NumberConstants.Zero
+	cost := NumberConstants.Zero
+	for _, order := range orders {
+		remaining := targetVolume.Subtract(*filled)
+		if remaining.AsFloat() <= 0 {
+			break
+		}
+
+		take := order.Volume
+		if take.AsFloat() > remaining.AsFloat() {
+			take = remaining
+		}
+		filled = filled.Add(*take)
+		cost = cost.Add(*notional(order.Price, take))
+	}
+
+	if filled.AsFloat() <= 0 {
+		return nil, nil, fmt.Errorf("no volume available to %s", action)
+	}
+	if !cost.IsFinite() {
+		return nil, nil, fmt.Errorf("the cost to %s volume %s is out of the representable range", action, filled.AsString())
+	}
+	return NumberFromFloat(cost.AsFloat()/filled.AsFloat(), minPrecision(*orders[0].Price, *filled)), filled, nil
+}
+
+// PriceForVolume walks the side of the book consumed by a taker with the passed in action (asks for buy, bids for sell) and returns the price
+// of the last level that is needed to fill targetVolume, i.e. the worst price paid for a buy or received for a sell. Unlike the VWAP this is
+// the marginal price, which is the limit price needed to fill the volume. Assumes that the book is sorted, and returns an error when
+// targetVolume is not positive or the side of the book does not have enough volume to fill it.
+func (o OrderBook) PriceForVolume(action OrderAction, targetVolume *Number) (*Number, error) {
+	if targetVolume == nil || targetVolume.AsFloat() <= 0 {
+		return nil, fmt.Errorf("targetVolume needs to be positive but was %s", utils.CheckedString(targetVolume))
+	}
+
+	filled := NumberConstants.Zero
+	for _, order := range o.takerSideForAction(action) {
+		filled = filled.Add(*order.Volume)
+		if filled.AsFloat() >= targetVolume.AsFloat()-floatEpsilon {
+			price := *order.Price
+			return &price, nil
+		}
+	}
+	return nil, fmt.Errorf("not enough volume to %s %s, only %s is available", action, targetVolume.AsString(), filled.AsString())
+}
+
+// SimulateFill simulates a market order with the passed in action against the book, consuming the levels of the opposing side from the best
+// price (the asks for a buy and the bids for a sell) until the volume is filled or the side runs out of liquidity. The last level that is
+// touched can be partially consumed. Returns the filled volume, the volume-weighted average price of the fill (nil when nothing was filled),
+// and the volume that was left unfilled. A nil or non-positive volume fills nothing. This is the VWAP walk where a book that cannot fill
+// anything is not an error. Assumes that the book is sorted.
+func (o OrderBook) SimulateFill(action OrderAction, volume *Number) (filledVolume *Number, avgPrice *Number, remaining *Number) {
+	if volume == nil || volume.AsFloat() <= 0 {
+		return NumberConstants.Zero, nil, NumberConstants.Zero
+	}
+
+	avgPrice, filled, e := o.VWAP(action, volume)
+	if e != nil {
+		// there is no liquidity on the side (or the cost cannot be represented), so nothing is filled
+		return NumberConstants.Zero, nil, volume
+	}
+
+	remaining = volume.Subtract(*filled)
+	if remaining.AsFloat() <= floatEpsilon {
+		remaining = NumberFromFloat(0.0, volume.Precision())
+	}
+	return filled, avgPrice, remaining
+}
+
+// notional returns the product of price and volume at the InternalCalculationsPrecision, so the product of a tiny price and a small volume
+// is not rounded away at the precision of the price or volume
+func notional(price *Number, volume *Number) *Number {
+	return NumberFromFloat(price.AsFloat()*volume.AsFloat(), InternalCalculationsPrecision)
+}
+
+// VolumeUpToPrice returns the cumulative volume that a taker with the passed in action can fill without crossing the limit price, i.e. the
+// volume of the asks priced at or below limitPrice for a buy and of the bids priced at or above limitPrice for a sell, which is useful to
+// estimate slippage before submitting an order. Assumes that the book is sorted, and returns zero when the side is empty or limitPrice is nil.
+func (o OrderBook) VolumeUpToPrice(action OrderAction, limitPrice *Number) *Number {
+	total := NumberConstants.Zero
+	if limitPrice == nil {
+		return total
+	}
+
+	for _, order := range o.takerSideForAction(action) {
+		if action.IsBuy() && order.Price.AsFloat() > limitPrice.AsFloat()+floatEpsilon {
+			break
+		}
+		if action.IsSell() && order.Price.AsFloat() < limitPrice.AsFloat()-floatEpsilon {
+			break
+		}
+		total = total.Add(*order.Volume)
+	}
+	return total
+}
+
+// TotalNotional returns the sum of price * volume (in quote units) of all the orders on the side of the book with the passed in action (asks
+// for sell, bids for buy). See Number for the representable range, an error is returned when the total is out of range (i.e. Inf).
+func (o OrderBook) TotalNotional(action OrderAction) (*Number, error) {
+	total := NumberConstants.Zero
+	for _, order := range o.sideForAction(action) {
+		total = total.Add(*notional(order.Price, order.Volume))
+	}
+	if !total.IsFinite() {
+		return nil, fmt.Errorf("the total notional of the %s side is out of the representable range", action)
+	}
+	return total, nil
+}
+
+// EffectivePriceWithFee returns the volume-weighted average price to fill volume as a taker with the passed in action, adjusted by the taker
+// feeRate (added for buys and subtracted for sells) which gives the true cost basis. Returns an error if the book cannot fill the entire volume.
+func (o OrderBook) EffectivePriceWithFee(action OrderAction, volume *Number, feeRate *Number) (*Number, error) {
+	if feeRate == nil {
+		return nil, fmt.Errorf("feeRate cannot be nil")
+	}
+
+	avgPrice, filled, e := o.VWAP(action, volume)
+	if e != nil {
+		return nil, fmt.Errorf("could not compute vwap: %s", e)
+	}
+	if filled.AsFloat() < volume.AsFloat() {
+		return nil, fmt.Errorf("insufficient liquidity to %s volume %s, only %s available", action, volume.AsString(), filled.AsString())
+	}
+
+	if action.IsBuy() {
+		return avgPrice.Multiply(*NumberConstants.One.Add(*feeRate)), nil
+	}
+	return avgPrice.Multiply(*NumberConstants.One.Subtract(*feeRate)), nil
+}
+
+// WeightedSpread returns the difference between the volume-weighted average price to buy volume from the asks and the volume-weighted
+// average price to sell volume into the bids, which is a size-aware spread that reflects the execution cost at the target size. Returns an
+// error if either side of the book cannot fill the entire volume.
+func (o OrderBook) WeightedSpread(volume *Number) (*Number, error) {
+	buyPrice, filled, e := o.VWAP(OrderActionBuy, volume)
+	if e != nil {
+		return nil, fmt.Errorf("could not compute vwap to buy: %s", e)
+	}
+	if filled.AsFloat() < volume.AsFloat() {
+		return nil, fmt.Errorf("insufficient liquidity to buy volume %s, only %s available", volume.AsString(), filled.AsString())
+	}
+
+	sellPrice, filled, e := o.VWAP(OrderActionSell, volume)
+	if e != nil {
+		return nil, fmt.Errorf("could not compute vwap to sell: %s", e)
+	}
+	if filled.AsFloat() < volume.AsFloat() {
+		return nil, fmt.Errorf("insufficient liquidity to sell volume %s, only %s available", volume.AsString(), filled.AsString())
+	}
+
+	return buyPrice.Subtract(*sellPrice), nil
+}
+
+// ExpectedFillTime estimates how long it takes until a resting order with the passed in action, price, and volume would be completely filled,
+// given the tradeRate which is the volume (in base units) traded per second against that side of the book. It makes a crude queue-position
+// assumption: the order is placed at the back of the queue at its price level, so all the volume at better prices and at the same price is
+// filled before it, and the book does not change otherwise (no cancellations or new orders ahead of it). A price that crosses the spread fills
+// immediately with a duration of zero. Returns false if the tradeRate or volume is not positive or price is not set.
+func (o OrderBook) ExpectedFillTime(action OrderAction, price *Number, volume *Number, tradeRate *Number) (time.Duration, bool) {
+	if price == nil || volume == nil || volume.AsFloat() <= 0 || tradeRate == nil || tradeRate.AsFloat() <= 0 {
+		return 0, false
+	}
+
+	// a price that crosses the spread is filled immediately as a taker
+	takerSide := o.takerSideForAction(action)
+	if len(takerSide) > 0 {
+		topPrice := takerSide[0].Price.AsFloat()
+		if (action.IsSell() && price.AsFloat() <= topPrice) || (action.IsBuy() && price.AsFloat() >= topPrice) {
+			return 0, true
+		}
+	}
+
+	volumeAhead := 0.0
+	for _, order := range o.sideForAction(action) {
+		isAhead := order.Price.AsFloat() <= price.AsFloat()
+		if action.IsBuy() {
+			isAhead = order.Price.AsFloat() >= price.AsFloat()
+		}
+		if !isAhead {
+			break
+		}
+		volumeAhead += order.Volume.AsFloat()
+	}
+
+	seconds := (volumeAhead + volume.AsFloat()) / tradeRate.AsFloat()
+	return time.Duration(seconds * float64(time.Second)), true
+}
+
+// QueuePosition returns the cumulative volume resting ahead of the passed in order at its price level, on the side of the book with the
+// action of the order, according to price-time priority. When the order and all the orders on its level have a Timestamp the orders
+// with an earlier timestamp are ahead of it, otherwise the levels are assumed to be time-ordered and the orders before it in the book are
+// ahead of it, in which case the order needs to be in the book (matched by its price, volume, owner, and timestamp). Returns false if the
+// order is not on a price level of the book or cannot be found in the book when falling back to the order of the book.
+func (o OrderBook) QueuePosition(order Order) (*Number, bool) {
+	if order.Price == nil || order.Volume == nil {
+		return nil, false
+	}
+
+	level := []Order{}
+	hasTimestamps := order.Timestamp != nil
+	for _, resting := range o.sideForAction(order.OrderAction) {
+		if resting.Price.AsFloat() != order.Price.AsFloat() {
+			continue
+		}
+		level = append(level, resting)
+		hasTimestamps = hasTimestamps && resting.Timestamp != nil
+	}
+	if len(level) == 0 {
+		return nil, false
+	}
+
+	volumeAhead := NumberFromFloat(0.0, order.Volume.Precision())
+	if hasTimestamps {
+		for _, resting := range level {
+			if resting.Timestamp.AsInt64() < order.Timestamp.AsInt64() {
+				volumeAhead = volumeAhead.Add(*resting.Volume)
+			}
+		}
+		return volumeAhead, true
+	}
+
+	for _, resting := range level {
+		if isSameOrder(resting, order) {
+			return volumeAhead, true
+		}
+		volumeAhead = volumeAhead.Add(*resting.Volume)
+	}
+	return nil, false
+}
+
+// isSameOrder returns true if both orders have the same price, volume, owner, and timestamp
+func isSameOrder(a Order, b Order) bool {
+	sameTimestamp := (a.Timestamp == nil && b.Timestamp == nil) || (a.Timestamp != nil && b.Timestamp != nil && *a.Timestamp == *b.Timestamp)
+	return sameTimestamp && a.Owner == b.Owner && a.Price.AsFloat() == b.Price.AsFloat() && a.Volume.AsFloat() == b.Volume.AsFloat()
+}
+
+// PriceAtVolumeFraction returns the price of the first level on the side of the book with the passed in action (asks for sell, bids for buy)
+// at which the cumulative volume, starting from the top of the book, reaches the passed in fraction of the total volume on that side.
+// A fraction of 0 returns the top of the book and a fraction of 1 returns the deepest level. Returns false if the fraction is not within
+// [0, 1] or the side is empty.
+func (o OrderBook) PriceAtVolumeFraction(action OrderAction, fraction float64) (*Number, bool) {
+	if fraction < 0 || fraction > 1 {
+		return nil, false
+	}
+
+	orders := o.sideForAction(action)
+	totalVolume := 0.0
+	for _, order := range orders {
+		totalVolume += order.Volume.AsFloat()
+	}
+	if totalVolume <= 0 {
+		return nil, false
+	}
+
+	targetVolume := fraction * totalVolume
+	cumulativeVolume := 0.0
+	for _, order := range orders {
+		cumulativeVolume += order.Volume.AsFloat()
+		if cumulativeVolume >= targetVolume-floatEpsilon {
+			return order.Price, true
+		}
+	}
+	return orders[len(orders)-1].Price, true
+}
+
+// TotalVolume returns the total volume on the side of the book with the passed in action (asks for sell, bids for buy), which is zero when
+// the side is empty
+func (o OrderBook) TotalVolume(action OrderAction) *Number {
+	totalVolume := 0.0
+	for _, order := range o.sideForAction(action) {
+		totalVolume += order.Volume.AsFloat()
+	}
+	return NumberFromFloat(totalVolume, InternalCalculationsPrecision)
+}
+
+// TotalBidVolume returns the sum of the volumes of all the bids, which is zero when there are no bids
+func (o OrderBook) TotalBidVolume() *Number {
+	return o.TotalVolume(OrderActionBuy)
+}
+
+// TotalAskVolume returns the sum of the volumes of all the asks, which is zero when there are no asks
+func (o OrderBook) TotalAskVolume() *Number {
+	return o.TotalVolume(OrderActionSell)
+}
+
+// TotalBidValue returns the sum of price * volume (in quote units) of all the bids, which is zero when there are no bids
+func (o OrderBook) TotalBidValue() *Number {
+	return sumValue(o.bids)
+}
+
+// TotalAskValue returns the sum of price * volume (in quote units) of all the asks, which is zero when there are no asks
+func (o OrderBook) TotalAskValue() *Number {
+	return sumValue(o.asks)
+}
+
+// sumValue returns the sum of the notional (price * volume) of the orders
+func sumValue(orders []Order) *Number {
+	total := NumberConstants.Zero
+	for _, order := range orders {
+		total = total.Add(*notional(order.Price, order.Volume))
+	}
+	return total
+}
+
+// LiquidityWithinBps returns the volume of the bids and the asks with a price within the passed in basis points of the mid price, i.e. the
+// bids at or above mid * (1 - bps/10000) and the asks at or below mid * (1 + bps/10000). Returns false when either side is empty, since
+// there is no mid price, or when bps is negative.
+func (o *OrderBook) LiquidityWithinBps(bps float64) (bidVolume *Number, askVolume *Number, ok bool) {
+	if bps < 0 {
+		return nil, nil, false
+	}
+	tob, ok := o.TopOfBook()
+	if !ok {
+		return nil, nil, false
+	}
+
+	mid := tob.MidPrice.AsFloat()
+	minBidPrice := mid * (1 - bps/10000)
+	maxAskPrice := mid * (1 + bps/10000)
+	bidTotal := 0.0
+	for _, bid := range o.bids {
+		if bid.Price.AsFloat() < minBidPrice-floatEpsilon {
+			break
+		}
+		bidTotal += bid.Volume.AsFloat()
+	}
+	askTotal := 0.0
+	for _, ask := range o.asks {
+		if ask.Price.AsFloat() > maxAskPrice+floatEpsilon {
+			break
+		}
+		askTotal += ask.Volume.AsFloat()
+	}
+	return NumberFromFloat(bidTotal, InternalCalculationsPrecision), NumberFromFloat(askTotal, InternalCalculationsPrecision), true
+}
+
+// RelativeDepthWithinBps returns the volume within the passed in basis points of the mid price as a fraction of the total volume on each
+// side, which normalizes the depth into figures between 0 and 1 that are comparable across assets with different prices. Returns false
+// under the same conditions as LiquidityWithinBps.
+func (o *OrderBook) RelativeDepthWithinBps(bps float64) (bidFrac *Number, askFrac *Number, ok bool) {
+	bidVolume, askVolume, ok := o.LiquidityWithinBps(bps)
+	if !ok {
+		return nil, nil, false
+	}
+
+	// the total volume on each side is positive when the side has a top of the book, unless every level has a zero volume
+	totalBidVolume := o.TotalVolume(OrderActionBuy).AsFloat()
+	totalAskVolume := o.TotalVolume(OrderActionSell).AsFloat()
+	if totalBidVolume <= 0 || totalAskVolume <= 0 {
+		return nil, nil, false
+	}
+	return NumberFromFloat(bidVolume.AsFloat()/totalBidVolume, InternalCalculationsPrecision),
+		NumberFromFloat(askVolume.AsFloat()/totalAskVolume, InternalCalculationsPrecision),
+		true
+}
+
+// ShiftPrices returns a copy of the orderbook with every price increased by delta (which can be negative) and volumes unchanged, which is
+// useful for scenario analysis and stress testing. A uniform shift preserves the ordering of each side so no re-sorting is needed, but any
+// levels that would end up with a non-positive price are dropped.
+func (o *OrderBook) ShiftPrices(delta *Number) *OrderBook {
+	shiftFn := func(orders []Order) []Order {
+		shifted := []Order{}
+		for _, order := range orders {
+			newPrice := order.Price.Add(*delta)
+			if newPrice.AsFloat() <= 0 {
+				continue
+			}
+			order.Price = newPrice
+			shifted = append(shifted, order)
+		}
+		return shifted
+	}
+	return MakeOrderBook(o.pair, shiftFn(o.asks), shiftFn(o.bids))
+}
+
+// PriceStats returns the min price, max price, and volume-weighted average price across all levels on the side of the book with the passed
+// in action (asks for sell, bids for buy), which is a compact summary of the shape of the book. Returns false when the side is empty.
+func (o OrderBook) PriceStats(action OrderAction) (min *Number, max *Number, volumeWeightedAvg *Number, ok bool) {
+	orders := o.sideForAction(action)
+	if len(orders) == 0 {
+		return nil, nil, nil, false
+	}
+
+	min = orders[0].Price
+	max = orders[0].Price
+	totalVolume := NumberConstants.Zero
+	totalValue := NumberConstants.Zero
+	for _, order := range orders {
+		if order.Price.AsFloat() < min.AsFloat() {
+			min = order.Price
+		}
+		if order.Price.AsFloat() > max.AsFloat() {
+			max = order.Price
+		}
+		totalVolume = totalVolume.Add(*order.Volume)
+		totalValue = totalValue.Add(*order.Price.Multiply(*order.Volume))
+	}
+
+	if totalVolume.AsFloat() <= 0 {
+		return nil, nil, nil, false
+	}
+	return min, max, totalValue.Divide(*totalVolume), true
+}
+
+// FeeAdjusted returns a new orderbook with the prices adjusted to the net price after fees so books from venues with different fee schedules
+// can be compared directly. Each side uses the fee of the trade that it prices:
+//   - asks are the prices that a taker buys at, so they use the takerFee and are adjusted to price * (1 + takerFee), the net cost of buying
+//   - bids are the resting maker-side orders, so they use the makerFee and are adjusted to price * (1 - makerFee), the net proceeds of selling
+//
+// Volumes are unchanged. A nil fee is treated as 0 and a negative makerFee models a maker rebate.
+func (o *OrderBook) FeeAdjusted(makerFee *Number, takerFee *Number) *OrderBook {
+	adjustFn := func(orders []Order, multiplier *Number) []Order {
+		adjusted := []Order{}
+		for _, order := range orders {
+			order.Price = order.Price.Multiply(*multiplier)
+			adjusted = append(adjusted, order)
+		}
+		return adjusted
+	}
+
+	if makerFee == nil {
+		makerFee = NumberConstants.Zero
+	}
+	if takerFee == nil {
+		takerFee = NumberConstants.Zero
+	}
+	askMultiplier := NumberConstants.One.Add(*takerFee)
+	bidMultiplier := NumberConstants.One.Subtract(*makerFee)
+	return MakeOrderBook(o.pair, adjustFn(o.asks, askMultiplier), adjustFn(o.bids, bidMultiplier))
+}
+
+// DropOwn returns a new orderbook without the orders owned by any of the passed in account IDs, which lets strategies avoid self-trading
+// against their own orders in consolidated or SDEX books. Orders with an unknown (empty) Owner are always kept.
+func (o *OrderBook) DropOwn(accountIDs []string) *OrderBook {
+	ownAccounts := map[string]bool{}
+	for _, id := range accountIDs {
+		ownAccounts[id] = true
+	}
+
+	dropFn := func(orders []Order) []Order {
+		kept := []Order{}
+		for _, order := range orders {
+			if order.Owner != "" && ownAccounts[order.Owner] {
+				continue
+			}
+			kept = append(kept, order)
+		}
+		return kept
+	}
+	return MakeOrderBook(o.pair, dropFn(o.asks), dropFn(o.bids))
+}
+
+// MarketSpreadExcludingOwn returns the spread of the book after dropping the orders owned by any of the passed in account IDs, i.e. the
+// spread offered by the rest of the market, which is wider than the measured spread when our own orders are at the top of the book.
+// Returns false when either side is empty once our own orders are dropped.
+func (o *OrderBook) MarketSpreadExcludingOwn(ownAccountIDs []string) (*Number, bool) {
+	tob, ok := o.DropOwn(ownAccountIDs).TopOfBook()
+	if !ok {
+		return nil, false
+	}
+	return tob.Spread, true
+}
+
+// minLayeringRunLength is the min number of orders in a run that DetectEvenlySpacedLayering flags
+const minLayeringRunLength = 3
+
+// DetectEvenlySpacedLayering looks for runs of consecutive orders on the side of the book with the passed in action (asks for sell, bids
+// for buy) that have the same volume at evenly-spaced prices, which is a crude heuristic for layering / spoofing. The tolerance is the max
+// absolute difference allowed between the volumes of the orders and between the price increments of the run, a nil tolerance requires
+// exact matches. Returns a copy of the longest run of at least minLayeringRunLength orders (the first one on a tie) and true if one was
+// found, otherwise returns false.
+func (o *OrderBook) DetectEvenlySpacedLayering(action OrderAction, tolerance *Number) ([]Order, bool) {
+	tol := 0.0
+	if tolerance != nil {
+		tol = math.Abs(tolerance.AsFloat())
+	}
+
+	orders := o.sideForAction(action)
+	bestStart := 0
+	bestLength := 0
+	runStart := 0
+	for i := 1; i < len(orders); i++ {
+		if !extendsLayeringRun(orders[runStart:i], orders[i], tol) {
+			// the previous order can start a new run with this order
+			runStart = i - 1
+			if !extendsLayeringRun(orders[runStart:i], orders[i], tol) {
+				runStart = i
+			}
+		}
+		if i+1-runStart > bestLength {
+			bestStart = runStart
+			bestLength = i + 1 - runStart
+		}
+	}
+
+	if bestLength < minLayeringRunLength {
+		return nil, false
+	}
+	return append([]Order{}, orders[bestStart:bestStart+bestLength]...), true
+}
+
+// extendsLayeringRun returns true if the next order has the same volume as the orders in the run and continues the price increment of the
+// run within the tolerance. The price increment needs to exceed the tolerance, so orders on the same price level never form a run.
+func extendsLayeringRun(run []Order, next Order, tol float64) bool {
+	if math.Abs(next.Volume.AsFloat()-run[0].Volume.AsFloat()) > tol+floatEpsilon {
+		return false
+	}
+
+	increment := math.Abs(next.Price.AsFloat() - run[len(run)-1].Price.AsFloat())
+	if increment <= tol+floatEpsilon {
+		return false
+	}
+	if len(run) == 1 {
+		return true
+	}
+	runIncrement := math.Abs(run[1].Price.AsFloat() - run[0].Price.AsFloat())
+	return math.Abs(increment-runIncrement) <= tol+floatEpsilon
+}
+
+// DropBelowMinNotional returns a new orderbook without the levels whose notional value (price * volume, in quote units) is below min, which
+// exchanges reject as orders, so the levels of a reference book can be used to derive submittable orders. A level exactly at min is kept.
+// A nil min drops nothing.
+func (o *OrderBook) DropBelowMinNotional(min *Number) *OrderBook {
+	if min == nil {
+		return MakeOrderBook(o.pair, o.asks, o.bids)
+	}
+
+	dropFn := func(orders []Order) []Order {
+		kept := []Order{}
+		for _, order := range orders {
+			if notional(order.Price, order.Volume).AsFloat() < min.AsFloat()-floatEpsilon {
+				continue
+			}
+			kept = append(kept, order)
+		}
+		return kept
+	}
+	return MakeOrderBook(o.pair, dropFn(o.asks), dropFn(o.bids))
+}
+
+// NormalizeVolumesToBase returns a new orderbook where the volume of each order is scaled by the conversion factor of its Exchange, which is
+// needed for a consolidated book when different venues quote volume in different units, so all volumes are in the same base unit before
+// aggregation. Orders from an exchange that is missing in converters (including orders with an empty Exchange) are dropped, because their
+// volume cannot be expressed in the base unit and mixing units would misstate the depth. Prices are unchanged.
+func (o *OrderBook) NormalizeVolumesToBase(converters map[string]*Number) *OrderBook {
+	normalizeFn := func(orders []Order) []Order {
+		normalized := []Order{}
+		for _, order := range orders {
+			factor, ok := converters[order.Exchange]
+			if !ok || factor == nil {
+				continue
+			}
+
+			order.Volume = NumberFromFloat(order.Volume.AsFloat()*factor.AsFloat(), order.Volume.Precision())
+			normalized = append(normalized, order)
+		}
+		return normalized
+	}
+	return MakeOrderBook(o.pair, normalizeFn(o.asks), normalizeFn(o.bids))
+}
+
+// VolumeByExchange returns the total volume contributed by each exchange on the side of the book with the passed in action (asks for sell,
+// bids for buy), keyed by the Exchange of the orders where orders with an unknown exchange are bucketed under "". The volumes are summed
+// as they are, see NormalizeVolumesToBase for books where exchanges quote volume in different units.
+func (o *OrderBook) VolumeByExchange(action OrderAction) map[string]*Number {
+	volumes := map[string]*Number{}
+	for _, order := range o.sideForAction(action) {
+		if existing, ok := volumes[order.Exchange]; ok {
+			volumes[order.Exchange] = existing.Add(*order.Volume)
+			continue
+		}
+		volumes[order.Exchange] = order.Volume
+	}
+	return volumes
+}
+
+// RouteOrder routes a taker order with the passed in action and volume across the venues of a consolidated book for best execution, by
+// walking the consolidated book from the best price and grouping the consumed levels by their Exchange. It returns one limit order per venue
+// (in the order in which the venues are first reached) with the total volume to take on that venue and the worst price consumed on that venue
+// as its limit price. Returns an error if the book does not have enough liquidity to fill the entire volume.
+func (o *OrderBook) RouteOrder(action OrderAction, volume *Number) ([]Order, error) {
+	if volume == nil || volume.AsFloat() <= 0 {
+		return nil, fmt.Errorf("volume needs to be positive but was %s", utils.CheckedString(volume))
+	}
+
+	routes := []Order{}
+	routeIndex := map[string]int{}
+	remaining := volume
+	for _, order := range o.takerSideForAction(action) {
+		if remaining.AsFloat() <= floatEpsilon {
+			break
+		}
+
+		take := order.Volume
+		if take.AsFloat() > remaining.AsFloat() {
+			take = remaining
+		}
+		remaining = remaining.Subtract(*take)
+
+		if idx, ok := routeIndex[order.Exchange]; ok {
+			routes[idx].Volume = routes[idx].Volume.Add(*take)
+			// the levels are walked from the best price so the latest level is the worst price on this venue
+			routes[idx].Price = order.Price
+			continue
+		}
+		routeIndex[order.Exchange] = len(routes)
+		routes = append(routes, Order{
+			Pair:        o.pair,
+			OrderAction: action,
+			OrderType:   OrderTypeLimit,
+			Price:       order.Price,
+			Volume:      take,
+			Exchange:    order.Exchange,
+		})
+	}
+
+	if remaining.AsFloat() > floatEpsilon {
+		return nil, fmt.Errorf("insufficient liquidity to %s volume %s, only %s available", action, volume.AsString(), volume.Subtract(*remaining).AsString())
+	}
+	return routes, nil
+}
+
+// floatEpsilon absorbs floating point error when comparing prices and volumes
+const floatEpsilon = 1e-9
+
+// QuantizePrices returns a new orderbook with each level's price rounded to a multiple of tick, which is needed before submitting derived orders.
+// Prices are rounded toward the spread, i.e. asks are rounded down and bids are rounded up. Levels that collapse onto the same tick are merged
+// into a single level by summing their volumes (the remaining fields are taken from the first order on that level).
+func (o *OrderBook) QuantizePrices(tick *Number) *OrderBook {
+	if tick == nil || tick.AsFloat() <= 0 {
+		return MakeOrderBook(o.pair, o.asks, o.bids)
+	}
+
+	quantizeFn := func(orders []Order, roundFn func(float64) float64) []Order {
+		quantized := []Order{}
+		for _, order := range orders {
+			newPrice := NumberFromFloat(roundFn(order.Price.AsFloat()/tick.AsFloat())*tick.AsFloat(), order.Price.Precision())
+
+			lastIdx := len(quantized) - 1
+			if lastIdx >= 0 && quantized[lastIdx].Price.AsFloat() == newPrice.AsFloat() {
+				quantized[lastIdx].Volume = quantized[lastIdx].Volume.Add(*order.Volume)
+				continue
+			}
+
+			order.Price = newPrice
+			quantized = append(quantized, order)
+		}
+		return quantized
+	}
+
+	floorFn := func(f float64) float64 {
+		return math.Floor(f + floatEpsilon)
+	}
+	ceilFn := func(f float64) float64 {
+		return math.Ceil(f - floatEpsilon)
+	}
+	return MakeOrderBook(o.pair, quantizeFn(o.asks, floorFn), quantizeFn(o.bids, ceilFn))
+}
+
 // MakeOrderBook creates a new OrderBook from the asks and the bids
 func MakeOrderBook(pair *TradingPair, asks []Order, bids []Order) *OrderBook {
 	return &OrderBook{
@@ -170,6 +1186,228 @@ func MakeOrderBook(pair *TradingPair, asks []Order, bids []Order) *OrderBook {
 	}
 }
 
+// MakeOrderBookSorted creates a new OrderBook from the asks and the bids after sorting the asks by ascending price and the bids by
+// descending price. Orders at the same price keep their relative order. The passed in slices are not modified.
+func MakeOrderBookSorted(pair *TradingPair, asks []Order, bids []Order) *OrderBook {
+	sortedAsks := append([]Order{}, asks...)
+	sort.SliceStable(sortedAsks, func(i int, j int) bool {
+		return sortedAsks[i].Price.AsFloat() < sortedAsks[j].Price.AsFloat()
+	})
+	sortedBids := append([]Order{}, bids...)
+	sort.SliceStable(sortedBids, func(i int, j int) bool {
+		return sortedBids[i].Price.AsFloat() > sortedBids[j].Price.AsFloat()
+	})
+	return MakeOrderBook(pair, sortedAsks, sortedBids)
+}
+
+// MergeOrderBooks combines the orders of two orderbooks on the same pair into a new orderbook, e.g. to aggregate the liquidity across venues.
+// The asks are re-sorted by ascending price and the bids by descending price, where orders at the same price keep the orders of a before
+// those of b. Use CoalesceLevels on the result to combine the orders at the same price into a single level.
+func MergeOrderBooks(a *OrderBook, b *OrderBook) (*OrderBook, error) {
+	if a == nil || b == nil {
+		return nil, fmt.Errorf("cannot merge a nil orderbook")
+	}
+	if a.pair == nil || b.pair == nil {
+		return nil, fmt.Errorf("cannot merge an orderbook with a nil pair")
+	}
+	if *a.pair != *b.pair {
+		return nil, fmt.Errorf("cannot merge orderbooks with different pairs: %s and %s", a.pair.String(), b.pair.String())
+	}
+
+	asks := append(append([]Order{}, a.asks...), b.asks...)
+	bids := append(append([]Order{}, a.bids...), b.bids...)
+	return MakeOrderBookSorted(a.pair, asks, bids), nil
+}
+
+// CoalesceLevels returns a new orderbook where the consecutive orders at the same price on each side are combined into a single order with
+// the sum of their volumes and the latest of their timestamps. The owner and exchange of a combined order are kept only when all its orders
+// agree on them. Assumes that the book is sorted so the orders at the same price are consecutive.
+func (o *OrderBook) CoalesceLevels() *OrderBook {
+	coalesceFn := func(orders []Order) []Order {
+		coalesced := []Order{}
+		for _, order := range orders {
+			last := len(coalesced) - 1
+			if last < 0 || coalesced[last].Price.AsFloat() != order.Price.AsFloat() {
+				coalesced = append(coalesced, order)
+				continue
+			}
+
+			level := &coalesced[last]
+			level.Volume = level.Volume.Add(*order.Volume)
+			if order.Timestamp != nil && (level.Timestamp == nil || order.Timestamp.AsInt64() > level.Timestamp.AsInt64()) {
+				level.Timestamp = order.Timestamp
+			}
+			if level.Owner != order.Owner {
+				level.Owner = ""
+			}
+			if level.Exchange != order.Exchange {
+				level.Exchange = ""
+			}
+		}
+		return coalesced
+	}
+	return MakeOrderBook(o.pair, coalesceFn(o.asks), coalesceFn(o.bids))
+}
+
+// LadderOrders generates limit orders of sizePerLevel on consecutive levels stepping away from the start price by stepBps (linearly) on
+// each level, i.e. up for sell orders and down for buy orders, which is the sort order of the asks and bids respectively. The first level
+// is at the start price. Buy levels that would have a non-positive price are not generated. The orders are on the passed in pair so they
+// pass Validate on an orderbook of that pair. Returns an error when stepBps is not positive since the levels would not step away from the
+// start price.
+func LadderOrders(pair *TradingPair, action OrderAction, start *Number, stepBps float64, levels int, sizePerLevel *Number) ([]Order, error) {
+	if stepBps <= 0 {
+		return nil, fmt.Errorf("stepBps needs to be positive but was %f", stepBps)
+	}
+
+	direction := 1.0
+	if action.IsBuy() {
+		direction = -1.0
+	}
+
+	orders := []Order{}
+	for i := 0; i < levels; i++ {
+		offset := start.Scale(direction * float64(i) * stepBps / 10000)
+		price := start.Add(*offset)
+		if price.AsFloat() <= 0 {
+			break
+		}
+
+		orders = append(orders, Order{
+			Pair:        pair,
+			OrderAction: action,
+			OrderType:   OrderTypeLimit,
+			Price:       price,
+			Volume:      sizePerLevel,
+		})
+	}
+	return orders, nil
+}
+
+// IsValid checks the structural invariants of the orderbook so adapters can use it as a single gate after constructing a book from a feed:
+//   - the pair is non-nil
+//   - all asks are sell orders and all bids are buy orders
+//   - no order has a nil price or volume
+//   - asks are sorted by ascending price and bids by descending price
+//   - there are no duplicate price levels on either side
+func (o *OrderBook) IsValid() error {
+	if o.pair == nil {
+		return fmt.Errorf("orderbook pair was nil")
+	}
+
+	for _, side := range []struct {
+		name   string
+		orders []Order
+		action OrderAction
+	}{
+		{name: "asks", orders: o.asks, action: OrderActionSell},
+		{name: "bids", orders: o.bids, action: OrderActionBuy},
+	} {
+		if e := checkOrderActions(side.orders, side.action); e != nil {
+			return fmt.Errorf("invalid %s: %s", side.name, e)
+		}
+		if e := checkOrdersNormalized(side.orders); e != nil {
+			return fmt.Errorf("invalid %s: %s", side.name, e)
+		}
+		if e := checkOrdersSorted(side.orders, side.action); e != nil {
+			return fmt.Errorf("invalid %s: %s", side.name, e)
+		}
+		if e := checkNoDuplicateLevels(side.orders); e != nil {
+			return fmt.Errorf("invalid %s: %s", side.name, e)
+		}
+	}
+	return nil
+}
+
+// checkOrderActions ensures that every order has the expected action
+func checkOrderActions(orders []Order, action OrderAction) error {
+	for i, order := range orders {
+		if order.OrderAction != action {
+			return fmt.Errorf("order at index %d had action '%s' but expected '%s'", i, order.OrderAction, action)
+		}
+	}
+	return nil
+}
+
+// checkOrdersNormalized ensures that every order has a non-nil price and volume
+func checkOrdersNormalized(orders []Order) error {
+	for i, order := range orders {
+		if order.Price == nil {
+			return fmt.Errorf("order at index %d had a nil price", i)
+		}
+		if order.Volume == nil {
+			return fmt.Errorf("order at index %d had a nil volume", i)
+		}
+	}
+	return nil
+}
+
+// checkOrdersSorted ensures the orders are non-decreasing in price for sells (asks) and non-increasing in price for buys (bids)
+func checkOrdersSorted(orders []Order, action OrderAction) error {
+	for i := 1; i < len(orders); i++ {
+		prev := orders[i-1].Price.AsFloat()
+		curr := orders[i].Price.AsFloat()
+		if action.IsSell() && curr < prev {
+			return fmt.Errorf("order at index %d had price %s which was less than the previous price %s", i, orders[i].Price.AsString(), orders[i-1].Price.AsString())
+		}
+		if action.IsBuy() && curr > prev {
+			return fmt.Errorf("order at index %d had price %s which was greater than the previous price %s", i, orders[i].Price.AsString(), orders[i-1].Price.AsString())
+		}
+	}
+	return nil
+}
+
+// Validate checks that the orderbook is sorted the way the analytics on it expect, i.e. asks are non-decreasing in price and bids are
+// non-increasing in price, and that every order is on the pair of the orderbook. Unlike IsValid it allows multiple orders at the same
+// price level, so it can be used on books that are built from individual offers.
+func (o *OrderBook) Validate() error {
+	if o.pair == nil {
+		return fmt.Errorf("orderbook pair was nil")
+	}
+
+	for _, side := range []struct {
+		name   string
+		orders []Order
+		action OrderAction
+	}{
+		{name: "asks", orders: o.asks, action: OrderActionSell},
+		{name: "bids", orders: o.bids, action: OrderActionBuy},
+	} {
+		if e := checkOrdersNormalized(side.orders); e != nil {
+			return fmt.Errorf("invalid %s: %s", side.name, e)
+		}
+		if e := checkOrdersSorted(side.orders, side.action); e != nil {
+			return fmt.Errorf("invalid %s: %s", side.name, e)
+		}
+		if e := checkOrdersPair(side.orders, o.pair); e != nil {
+			return fmt.Errorf("invalid %s: %s", side.name, e)
+		}
+	}
+	return nil
+}
+
+// checkOrdersPair ensures that every order is on the passed in pair
+func checkOrdersPair(orders []Order, pair *TradingPair) error {
+	for i, order := range orders {
+		if order.Pair == nil {
+			return fmt.Errorf("order at index %d had a nil pair", i)
+		}
+		if *order.Pair != *pair {
+			return fmt.Errorf("order at index %d had pair '%s' but expected '%s'", i, order.Pair.String(), pair.String())
+		}
+	}
+	return nil
+}
+
+// checkNoDuplicateLevels ensures no two orders share the same price, expects orders to be sorted
+func checkNoDuplicateLevels(orders []Order) error {
+	for i := 1; i < len(orders); i++ {
+		if orders[i].Price.AsFloat() == orders[i-1].Price.AsFloat() {
+			return fmt.Errorf("orders at index %d and %d had the same price level %s", i-1, i, orders[i].Price.AsString())
+		}
+	}
+	return nil
+}
+
 // TransactionID is typed for the concept of a transaction ID of an order
 type TransactionID string
 
@@ -208,6 +1446,40 @@ func (o OpenOrder) String() string {
 	)
 }
 
+// IsExpired returns true when the order has an expire time that is before now. An order with a nil or zero ExpireTime never expires, as
+// exchanges such as kraken report an expire time of 0 for orders without an expiry. Returns false when now is nil.
+func (o OpenOrder) IsExpired(now *Timestamp) bool {
+	if o.ExpireTime == nil || o.ExpireTime.AsInt64() == 0 || now == nil {
+		return false
+	}
+	return o.ExpireTime.AsInt64() < now.AsInt64()
+}
+
+// volumeExecuted returns the executed volume of the order, where a nil VolumeExecuted (which some exchanges omit on fresh orders) is zero
+func (o OpenOrder) volumeExecuted() *Number {
+	if o.VolumeExecuted == nil {
+		return NumberFromFloat(0.0, o.Volume.Precision())
+	}
+	return o.VolumeExecuted
+}
+
+// RemainingVolume returns the volume of the order that is not executed yet, i.e. Volume - VolumeExecuted, which is never negative
+func (o OpenOrder) RemainingVolume() *Number {
+	remaining := o.Volume.Subtract(*o.volumeExecuted())
+	if remaining.AsFloat() < 0 {
+		return NumberFromFloat(0.0, remaining.Precision())
+	}
+	return remaining
+}
+
+// FillPercent returns the fraction of the volume of the order that is executed, within [0, 1]. Returns 0 for an order without volume.
+func (o OpenOrder) FillPercent() float64 {
+	if o.Volume.AsFloat() <= 0 {
+		return 0.0
+	}
+	return math.Max(0.0, math.Min(o.volumeExecuted().AsFloat()/o.Volume.AsFloat(), 1.0))
+}
+
 // CancelOrderResult is the result of a CancelOrder call
 type CancelOrderResult int8
 
@@ -0,0 +1,428 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// compactOrderBookJSON is the compact JSON form of an orderbook, matching the shape of common exchange REST payloads
+type compactOrderBookJSON struct {
+	Base  Asset `json:"base"`
+	Quote Asset `json:"quote"`
+	// Timestamp is the latest timestamp of all the orders in the book, omitted when no order has a timestamp
+	Timestamp int64       `json:"timestamp,omitempty"`
+	Asks      [][2]string `json:"asks"`
+	Bids      [][2]string `json:"bids"`
+}
+
+// MarshalCompactJSON encodes the orderbook as a compact JSON object where each side is an array of [price, volume] string pairs, along with
+// a header containing the pair and the timestamp of the book (the latest timestamp of the orders). Only the price and volume of each order
+// are encoded, i.e. this drops the order type, owner, and the individual timestamps of the orders.
+func (o *OrderBook) MarshalCompactJSON() ([]byte, error) {
+	if o.pair == nil {
+		return nil, fmt.Errorf("cannot marshal an orderbook with a nil pair")
+	}
+
+	var timestamp int64
+	toLevels := func(orders []Order) [][2]string {
+		levels := [][2]string{}
+		for _, order := range orders {
+			levels = append(levels, [2]string{order.Price.AsString(), order.Volume.AsString()})
+			if order.Timestamp != nil && order.Timestamp.AsInt64() > timestamp {
+				timestamp = order.Timestamp.AsInt64()
+			}
+		}
+		return levels
+	}
+
+	return json.Marshal(compactOrderBookJSON{
+		Base:      o.pair.Base,
+		Quote:     o.pair.Quote,
+		Asks:      toLevels(o.asks),
+		Bids:      toLevels(o.bids),
+		Timestamp: timestamp,
+	})
+}
+
+// UnmarshalCompactJSON decodes an orderbook from the compact JSON form produced by MarshalCompactJSON. The orders are limit orders and get
+// the timestamp from the header (if any). The precision of each number is the number of decimal places in its string.
+func UnmarshalCompactJSON(data []byte) (*OrderBook, error) {
+	var compact compactOrderBookJSON
+	e := json.Unmarshal(data, &compact)
+	if e != nil {
+		return nil, fmt.Errorf("could not unmarshal compact orderbook JSON: %s", e)
+	}
+
+	pair := &TradingPair{Base: compact.Base, Quote: compact.Quote}
+	var timestamp *Timestamp
+	if compact.Timestamp != 0 {
+		timestamp = MakeTimestamp(compact.Timestamp)
+	}
+
+	fromLevels := func(levels [][2]string, action OrderAction) ([]Order, error) {
+		orders := []Order{}
+		for i, level := range levels {
+			price, e := NumberFromString(level[0], precisionFromString(level[0]))
+			if e != nil {
+				return nil, fmt.Errorf("could not parse price of level at index %d: %s", i, e)
+			}
+			volume, e := NumberFromString(level[1], precisionFromString(level[1]))
+			if e != nil {
+				return nil, fmt.Errorf("could not parse volume of level at index %d: %s", i, e)
+			}
+
+			orders = append(orders, Order{
+				Pair:        pair,
+				OrderAction: action,
+				OrderType:   OrderTypeLimit,
+				Price:       price,
+				Volume:      volume,
+				Timestamp:   timestamp,
+			})
+		}
+		return orders, nil
+	}
+
+	asks, e := fromLevels(compact.Asks, OrderActionSell)
+	if e != nil {
+		return nil, fmt.Errorf("could not parse asks: %s", e)
+	}
+	bids, e := fromLevels(compact.Bids, OrderActionBuy)
+	if e != nil {
+		return nil, fmt.Errorf("could not parse bids: %s", e)
+	}
+	return MakeOrderBook(pair, asks, bids), nil
+}
+
+// precisionFromString returns the number of decimal places in the string representation of a number, which can be in exponent notation
+// (e.g. "1e-5" has 5 decimal places and "1.5e3" has none)
+func precisionFromString(s string) int8 {
+	mantissa := s
+	exponent := 0
+	if idx := strings.IndexAny(s, "eE"); idx != -1 {
+		mantissa = s[:idx]
+		parsed, e := strconv.Atoi(s[idx+1:])
+		if e == nil {
+			exponent = parsed
+		}
+	}
+
+	decimals := 0
+	if idx := strings.Index(mantissa, "."); idx != -1 {
+		decimals = len(mantissa) - idx - 1
+	}
+	precision := decimals - exponent
+	if precision < 0 {
+		return 0
+	}
+	if precision > math.MaxInt8 {
+		return math.MaxInt8
+	}
+	return int8(precision)
+}
+
+// tradingPairJSON is the JSON form of a trading pair
+type tradingPairJSON struct {
+	Base  Asset `json:"base"`
+	Quote Asset `json:"quote"`
+}
+
+// orderJSON is the JSON form of an order, where the price and volume are decimal strings (omitted when nil) and the timestamp is in unix
+// millis (omitted when nil)
+type orderJSON struct {
+	Pair      *tradingPairJSON `json:"pair,omitempty"`
+	Action    string           `json:"action"`
+	Type      string           `json:"type"`
+	Price     string           `json:"price,omitempty"`
+	Volume    string           `json:"volume,omitempty"`
+	StopPrice string           `json:"stop_price,omitempty"`
+	Timestamp *int64           `json:"timestamp,omitempty"`
+	Owner     string           `json:"owner,omitempty"`
+	Exchange  string           `json:"exchange,omitempty"`
+}
+
+// orderBookJSON is the JSON form of an orderbook
+type orderBookJSON struct {
+	Pair tradingPairJSON `json:"pair"`
+	Asks []orderJSON     `json:"asks"`
+	Bids []orderJSON     `json:"bids"`
+}
+
+// tradeJSON is the JSON form of a trade, which keeps the fields of the order at the top level
+type tradeJSON struct {
+	orderJSON
+	TransactionID *string `json:"transaction_id,omitempty"`
+	OrderID       string  `json:"order_id,omitempty"`
+	Cost          string  `json:"cost,omitempty"`
+	Fee           string  `json:"fee,omitempty"`
+}
+
+// openOrderJSON is the JSON form of an open order, which keeps the fields of the order at the top level
+type openOrderJSON struct {
+	orderJSON
+	ID             string `json:"id,omitempty"`
+	StartTime      *int64 `json:"start_time,omitempty"`
+	ExpireTime     *int64 `json:"expire_time,omitempty"`
+	VolumeExecuted string `json:"volume_executed,omitempty"`
+}
+
+// numberToJSON converts a number to its decimal string, where nil is the empty string
+func numberToJSON(n *Number) string {
+	if n == nil {
+		return ""
+	}
+	return n.AsString()
+}
+
+// numberFromJSON parses a decimal string into a number with the precision of the string, where the empty string is nil
+func numberFromJSON(s string) (*Number, error) {
+	if s == "" {
+		return nil, nil
+	}
+	return NumberFromString(s, precisionFromString(s))
+}
+
+// timestampToJSON converts a timestamp to unix millis, where nil is omitted
+func timestampToJSON(t *Timestamp) *int64 {
+	if t == nil {
+		return nil
+	}
+	ts := t.AsInt64()
+	return &ts
+}
+
+// timestampFromJSON converts unix millis to a timestamp, where a missing value is nil
+func timestampFromJSON(ts *int64) *Timestamp {
+	if ts == nil {
+		return nil
+	}
+	return MakeTimestamp(*ts)
+}
+
+func (o Order) toJSON() orderJSON {
+	var pair *tradingPairJSON
+	if o.Pair != nil {
+		pair = &tradingPairJSON{Base: o.Pair.Base, Quote: o.Pair.Quote}
+	}
+	return orderJSON{
+		Pair:      pair,
+		Action:    o.OrderAction.String(),
+		Type:      o.OrderType.String(),
+		Price:     numberToJSON(o.Price),
+		Volume:    numberToJSON(o.Volume),
+		StopPrice: numberToJSON(o.StopPrice),
+		Timestamp: timestampToJSON(o.Timestamp),
+		Owner:     o.Owner,
+		Exchange:  o.Exchange,
+	}
+}
+
+func (j orderJSON) toOrder() (*Order, error) {
+	action, ok := orderActionMap[j.Action]
+	if !ok {
+		return nil, fmt.Errorf("invalid order action '%s'", j.Action)
+	}
+	orderType, ok := orderTypeMap[j.Type]
+	if !ok {
+		return nil, fmt.Errorf("invalid order type '%s'", j.Type)
+	}
+	price, e := numberFromJSON(j.Price)
+	if e != nil {
+		return nil, fmt.Errorf("could not parse price: %s", e)
+	}
+	volume, e := numberFromJSON(j.Volume)
+	if e != nil {
+		return nil, fmt.Errorf("could not parse volume: %s", e)
+	}
+	stopPrice, e := numberFromJSON(j.StopPrice)
+	if e != nil {
+		return nil, fmt.Errorf("could not parse stop price: %s", e)
+	}
+
+	var pair *TradingPair
+	if j.Pair != nil {
+		pair = &TradingPair{Base: j.Pair.Base, Quote: j.Pair.Quote}
+	}
+	return &Order{
+		Pair:        pair,
+		OrderAction: action,
+		OrderType:   orderType,
+		Price:       price,
+		Volume:      volume,
+		StopPrice:   stopPrice,
+		Timestamp:   timestampFromJSON(j.Timestamp),
+		Owner:       j.Owner,
+		Exchange:    j.Exchange,
+	}, nil
+}
+
+// MarshalJSON encodes the order as a JSON object with the price and volume as decimal strings and the timestamp in unix millis
+func (o Order) MarshalJSON() ([]byte, error) {
+	return json.Marshal(o.toJSON())
+}
+
+// UnmarshalJSON decodes an order from the JSON produced by MarshalJSON. The precision of the price and volume is the number of decimal
+// places in their strings.
+func (o *Order) UnmarshalJSON(data []byte) error {
+	var j orderJSON
+	e := json.Unmarshal(data, &j)
+	if e != nil {
+		return fmt.Errorf("could not unmarshal order JSON: %s", e)
+	}
+
+	order, e := j.toOrder()
+	if e != nil {
+		return fmt.Errorf("could not convert order JSON: %s", e)
+	}
+	*o = *order
+	return nil
+}
+
+// MarshalJSON encodes the trade as the JSON of its order along with the fields of the trade. This is needed since the trade would otherwise
+// use the MarshalJSON of the embedded Order and lose the fields of the trade.
+func (t Trade) MarshalJSON() ([]byte, error) {
+	var txID *string
+	if t.TransactionID != nil {
+		s := t.TransactionID.String()
+		txID = &s
+	}
+
+	return json.Marshal(tradeJSON{
+		orderJSON:     t.Order.toJSON(),
+		TransactionID: txID,
+		OrderID:       t.OrderID,
+		Cost:          numberToJSON(t.Cost),
+		Fee:           numberToJSON(t.Fee),
+	})
+}
+
+// UnmarshalJSON decodes a trade from the JSON produced by MarshalJSON
+func (t *Trade) UnmarshalJSON(data []byte) error {
+	var j tradeJSON
+	e := json.Unmarshal(data, &j)
+	if e != nil {
+		return fmt.Errorf("could not unmarshal trade JSON: %s", e)
+	}
+
+	order, e := j.orderJSON.toOrder()
+	if e != nil {
+		return fmt.Errorf("could not convert order JSON of trade: %s", e)
+	}
+	cost, e := numberFromJSON(j.Cost)
+	if e != nil {
+		return fmt.Errorf("could not parse cost: %s", e)
+	}
+	fee, e := numberFromJSON(j.Fee)
+	if e != nil {
+		return fmt.Errorf("could not parse fee: %s", e)
+	}
+	var txID *TransactionID
+	if j.TransactionID != nil {
+		txID = MakeTransactionID(*j.TransactionID)
+	}
+
+	*t = Trade{
+		Order:         *order,
+		TransactionID: txID,
+		OrderID:       j.OrderID,
+		Cost:          cost,
+		Fee:           fee,
+	}
+	return nil
+}
+
+// MarshalJSON encodes the open order as the JSON of its order along with the fields of the open order, with the start and expire times in
+// unix millis. Like Trade.MarshalJSON this is needed so the fields of the open order are not lost to the MarshalJSON of the embedded Order.
+func (o OpenOrder) MarshalJSON() ([]byte, error) {
+	return json.Marshal(openOrderJSON{
+		orderJSON:      o.Order.toJSON(),
+		ID:             o.ID,
+		StartTime:      timestampToJSON(o.StartTime),
+		ExpireTime:     timestampToJSON(o.ExpireTime),
+		VolumeExecuted: numberToJSON(o.VolumeExecuted),
+	})
+}
+
+// UnmarshalJSON decodes an open order from the JSON produced by MarshalJSON
+func (o *OpenOrder) UnmarshalJSON(data []byte) error {
+	var j openOrderJSON
+	e := json.Unmarshal(data, &j)
+	if e != nil {
+		return fmt.Errorf("could not unmarshal open order JSON: %s", e)
+	}
+
+	order, e := j.orderJSON.toOrder()
+	if e != nil {
+		return fmt.Errorf("could not convert order JSON of open order: %s", e)
+	}
+	volumeExecuted, e := numberFromJSON(j.VolumeExecuted)
+	if e != nil {
+		return fmt.Errorf("could not parse volume executed: %s", e)
+	}
+
+	*o = OpenOrder{
+		Order:          *order,
+		ID:             j.ID,
+		StartTime:      timestampFromJSON(j.StartTime),
+		ExpireTime:     timestampFromJSON(j.ExpireTime),
+		VolumeExecuted: volumeExecuted,
+	}
+	return nil
+}
+
+// MarshalJSON encodes the orderbook as a JSON object with the pair and the full orders of each side (see Order.MarshalJSON), so it can be
+// persisted as a snapshot and replayed without losing any fields. Use MarshalCompactJSON for a smaller payload with only the price levels.
+func (o OrderBook) MarshalJSON() ([]byte, error) {
+	if o.pair == nil {
+		return nil, fmt.Errorf("cannot marshal an orderbook with a nil pair")
+	}
+
+	toJSON := func(orders []Order) []orderJSON {
+		list := []orderJSON{}
+		for _, order := range orders {
+			list = append(list, order.toJSON())
+		}
+		return list
+	}
+
+	return json.Marshal(orderBookJSON{
+		Pair: tradingPairJSON{Base: o.pair.Base, Quote: o.pair.Quote},
+		Asks: toJSON(o.asks),
+		Bids: toJSON(o.bids),
+	})
+}
+
+// UnmarshalJSON decodes an orderbook from the JSON produced by MarshalJSON
+func (o *OrderBook) UnmarshalJSON(data []byte) error {
+	var j orderBookJSON
+	e := json.Unmarshal(data, &j)
+	if e != nil {
+		return fmt.Errorf("could not unmarshal orderbook JSON: %s", e)
+	}
+
+	fromJSON := func(list []orderJSON) ([]Order, error) {
+		orders := []Order{}
+		for i, oj := range list {
+			order, e := oj.toOrder()
+			if e != nil {
+				return nil, fmt.Errorf("could not convert order at index %d: %s", i, e)
+			}
+			orders = append(orders, *order)
+		}
+		return orders, nil
+	}
+
+	asks, e := fromJSON(j.Asks)
+	if e != nil {
+		return fmt.Errorf("could not parse asks: %s", e)
+	}
+	bids, e := fromJSON(j.Bids)
+	if e != nil {
+		return fmt.Errorf("could not parse bids: %s", e)
+	}
+	*o = *MakeOrderBook(&TradingPair{Base: j.Pair.Base, Quote: j.Pair.Quote}, asks, bids)
+	return nil
+}
@@ -0,0 +1,278 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrderBookCompactJSONRoundTrip(t *testing.T) {
+	asks := makeTestAsks(1.1, 5.0, 1.2, 3.0)
+	asks[0].Timestamp = MakeTimestamp(1000)
+	asks[1].Timestamp = MakeTimestamp(3000)
+	bids := makeTestBids(0.9, 4.0)
+	bids[0].Timestamp = MakeTimestamp(2000)
+
+	testCases := []struct {
+		name     string
+		ob       *OrderBook
+		wantJSON string
+	}{
+		{
+			name:     "both sides",
+			ob:       MakeOrderBook(testPair, asks, bids),
+			wantJSON: `{"base":"XLM","quote":"USDT","timestamp":3000,"asks":[["1.1000000","5.0000000"],["1.2000000","3.0000000"]],"bids":[["0.9000000","4.0000000"]]}`,
+		}, {
+			name:     "empty book without timestamps",
+			ob:       MakeOrderBook(testPair, []Order{}, []Order{}),
+			wantJSON: `{"base":"XLM","quote":"USDT","asks":[],"bids":[]}`,
+		},
+	}
+
+	for _, kase := range testCases {
+		t.Run(kase.name, func(t *testing.T) {
+			data, e := kase.ob.MarshalCompactJSON()
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.Equal(t, kase.wantJSON, string(data))
+
+			decoded, e := UnmarshalCompactJSON(data)
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.Equal(t, *testPair, *decoded.Pair())
+			assert.NoError(t, decoded.IsValid())
+			assertPriceLevels(t, kase.ob.Asks(), decoded.Asks())
+			assertPriceLevels(t, kase.ob.Bids(), decoded.Bids())
+			for _, o := range append(decoded.Asks(), decoded.Bids()...) {
+				assert.Equal(t, int64(3000), o.Timestamp.AsInt64())
+				assert.Equal(t, int8(7), o.Price.Precision())
+			}
+
+			// encoding the decoded book gives back the same JSON
+			reencoded, e := decoded.MarshalCompactJSON()
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.Equal(t, kase.wantJSON, string(reencoded))
+		})
+	}
+}
+
+func TestOrderBookCompactJSONSize(t *testing.T) {
+	asks := []Order{}
+	bids := []Order{}
+	for i := 0; i < 50; i++ {
+		asks = append(asks, makeTestOrder(OrderActionSell, 1.0+float64(i)*0.01, 10.0))
+		bids = append(bids, makeTestOrder(OrderActionBuy, 0.99-float64(i)*0.01, 10.0))
+	}
+	ob := MakeOrderBook(testPair, asks, bids)
+
+	compact, e := ob.MarshalCompactJSON()
+	if !assert.NoError(t, e) {
+		return
+	}
+
+	// verbose form with one object per order
+	type verboseOrder struct {
+		Pair      string `json:"pair"`
+		Action    string `json:"action"`
+		Type      string `json:"type"`
+		Price     string `json:"price"`
+		Volume    string `json:"volume"`
+		Timestamp string `json:"timestamp"`
+	}
+	verboseOrders := []verboseOrder{}
+	for _, o := range append(ob.Asks(), ob.Bids()...) {
+		verboseOrders = append(verboseOrders, verboseOrder{
+			Pair:      o.Pair.String(),
+			Action:    o.OrderAction.String(),
+			Type:      o.OrderType.String(),
+			Price:     o.Price.AsString(),
+			Volume:    o.Volume.AsString(),
+			Timestamp: nilString,
+		})
+	}
+	verbose, e := json.Marshal(verboseOrders)
+	if !assert.NoError(t, e) {
+		return
+	}
+
+	assert.True(t, len(compact)*2 < len(verbose), "compact form (%d bytes) should be less than half the size of the verbose form (%d bytes)", len(compact), len(verbose))
+}
+
+func TestUnmarshalCompactJSONErrors(t *testing.T) {
+	for _, data := range []string{
+		`not json`,
+		`{"base":"XLM","quote":"USDT","asks":[["abc","1.0"]],"bids":[]}`,
+		`{"base":"XLM","quote":"USDT","asks":[],"bids":[["1.0","abc"]]}`,
+	} {
+		_, e := UnmarshalCompactJSON([]byte(data))
+		assert.Error(t, e, data)
+	}
+}
+
+func TestPrecisionFromString(t *testing.T) {
+	testCases := []struct {
+		input         string
+		wantPrecision int8
+	}{
+		{input: "1", wantPrecision: 0},
+		{input: "1.25", wantPrecision: 2},
+		{input: "0.0000100", wantPrecision: 7},
+		{input: "1e-5", wantPrecision: 5},
+		{input: "1E-5", wantPrecision: 5},
+		{input: "1.25e-3", wantPrecision: 5},
+		{input: "1.5e3", wantPrecision: 0},
+		{input: "1.2345e+2", wantPrecision: 2},
+		{input: "1e-200", wantPrecision: 127},
+	}
+
+	for _, kase := range testCases {
+		t.Run(kase.input, func(t *testing.T) {
+			assert.Equal(t, kase.wantPrecision, precisionFromString(kase.input))
+		})
+	}
+
+	// levels in exponent notation keep their precision
+	ob, e := UnmarshalCompactJSON([]byte(`{"base":"XLM","quote":"USDT","asks":[["1.5e-5","2e3"]],"bids":[]}`))
+	if assert.NoError(t, e) {
+		assert.Equal(t, "0.000015", ob.Asks()[0].Price.AsString())
+		assert.Equal(t, "2000", ob.Asks()[0].Volume.AsString())
+	}
+}
+
+func TestOrderBookJSONRoundTrip(t *testing.T) {
+	asks := makeTestAsks(1.1, 5.0, 1.2, 3.0)
+	asks[0].Timestamp = MakeTimestamp(1500000000123)
+	asks[0].Owner = "GOWNER"
+	asks[1].Exchange = "kraken"
+	bids := makeTestBids(0.9, 4.0)
+	bids[0].OrderType = OrderTypeMarket
+
+	testCases := []struct {
+		name     string
+		ob       *OrderBook
+		wantJSON string
+	}{
+		{
+			name: "both sides",
+			ob:   MakeOrderBook(testPair, asks, bids),
+			wantJSON: `{"pair":{"base":"XLM","quote":"USDT"},` +
+				`"asks":[{"pair":{"base":"XLM","quote":"USDT"},"action":"sell","type":"limit","price":"1.1000000","volume":"5.0000000","timestamp":1500000000123,"owner":"GOWNER"},` +
+				`{"pair":{"base":"XLM","quote":"USDT"},"action":"sell","type":"limit","price":"1.2000000","volume":"3.0000000","exchange":"kraken"}],` +
+				`"bids":[{"pair":{"base":"XLM","quote":"USDT"},"action":"buy","type":"market","price":"0.9000000","volume":"4.0000000"}]}`,
+		}, {
+			name:     "empty book",
+			ob:       MakeOrderBook(testPair, []Order{}, []Order{}),
+			wantJSON: `{"pair":{"base":"XLM","quote":"USDT"},"asks":[],"bids":[]}`,
+		},
+	}
+
+	for _, kase := range testCases {
+		t.Run(kase.name, func(t *testing.T) {
+			data, e := json.Marshal(kase.ob)
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.Equal(t, kase.wantJSON, string(data))
+
+			var decoded OrderBook
+			if !assert.NoError(t, json.Unmarshal(data, &decoded)) {
+				return
+			}
+			assert.Equal(t, *kase.ob, decoded)
+		})
+	}
+
+	_, e := json.Marshal(MakeOrderBook(nil, []Order{}, []Order{}))
+	assert.Error(t, e)
+}
+
+func TestOrderAndTradeJSONRoundTrip(t *testing.T) {
+	order := makeTestOrder(OrderActionBuy, 0.25, 100.0)
+	order.Timestamp = MakeTimestamp(1500000000123)
+	data, e := json.Marshal(order)
+	if !assert.NoError(t, e) {
+		return
+	}
+	assert.Equal(t, `{"pair":{"base":"XLM","quote":"USDT"},"action":"buy","type":"limit","price":"0.2500000","volume":"100.0000000","timestamp":1500000000123}`, string(data))
+	var decodedOrder Order
+	if assert.NoError(t, json.Unmarshal(data, &decodedOrder)) {
+		assert.Equal(t, order, decodedOrder)
+	}
+
+	// nil fields are omitted and decode back to nil
+	var emptyOrder Order
+	if assert.NoError(t, json.Unmarshal([]byte(`{"action":"sell","type":"market"}`), &emptyOrder)) {
+		assert.Equal(t, Order{OrderAction: OrderActionSell, OrderType: OrderTypeMarket}, emptyOrder)
+	}
+
+	trade := Trade{
+		Order:         order,
+		TransactionID: MakeTransactionID("tx1"),
+		OrderID:       "order1",
+		Cost:          NumberFromFloat(25.0, 7),
+		Fee:           NumberFromFloat(0.01, 7),
+	}
+	data, e = json.Marshal(trade)
+	if !assert.NoError(t, e) {
+		return
+	}
+	assert.Equal(t, `{"pair":{"base":"XLM","quote":"USDT"},"action":"buy","type":"limit","price":"0.2500000","volume":"100.0000000","timestamp":1500000000123,`+
+		`"transaction_id":"tx1","order_id":"order1","cost":"25.0000000","fee":"0.0100000"}`, string(data))
+	var decodedTrade Trade
+	if assert.NoError(t, json.Unmarshal(data, &decodedTrade)) {
+		assert.Equal(t, trade, decodedTrade)
+	}
+}
+
+func TestOpenOrderJSONRoundTrip(t *testing.T) {
+	openOrder := OpenOrder{
+		Order:          makeTestOrder(OrderActionSell, 0.25, 100.0),
+		ID:             "order1",
+		StartTime:      MakeTimestamp(1500000000123),
+		VolumeExecuted: NumberFromFloat(40.0, 7),
+	}
+	data, e := json.Marshal(openOrder)
+	if !assert.NoError(t, e) {
+		return
+	}
+	assert.Equal(t, `{"pair":{"base":"XLM","quote":"USDT"},"action":"sell","type":"limit","price":"0.2500000","volume":"100.0000000",`+
+		`"id":"order1","start_time":1500000000123,"volume_executed":"40.0000000"}`, string(data))
+	var decoded OpenOrder
+	if assert.NoError(t, json.Unmarshal(data, &decoded)) {
+		assert.Equal(t, openOrder, decoded)
+	}
+
+	openOrder.ExpireTime = MakeTimestamp(1500000060123)
+	data, e = json.Marshal(openOrder)
+	if !assert.NoError(t, e) {
+		return
+	}
+	decoded = OpenOrder{}
+	if assert.NoError(t, json.Unmarshal(data, &decoded)) {
+		assert.Equal(t, openOrder, decoded)
+	}
+
+	assert.Error(t, json.Unmarshal([]byte(`{"action":"sell","type":"limit","volume_executed":"abc"}`), &decoded))
+}
+
+func TestOrderUnmarshalJSONErrors(t *testing.T) {
+	for _, data := range []string{
+		`not json`,
+		`{"action":"hold","type":"limit"}`,
+		`{"action":"buy","type":"stop-loss"}`,
+		`{"action":"buy","type":"stop","stop_price":"abc"}`,
+		`{"action":"buy","type":"limit","price":"abc"}`,
+		`{"action":"buy","type":"limit","volume":"abc"}`,
+	} {
+		var order Order
+		assert.Error(t, json.Unmarshal([]byte(data), &order), data)
+
+		var ob OrderBook
+		assert.Error(t, json.Unmarshal([]byte(`{"pair":{"base":"XLM","quote":"USDT"},"asks":[`+data+`],"bids":[]}`), &ob), data)
+	}
+}
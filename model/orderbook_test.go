@@ -0,0 +1,2111 @@
+package model
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testPair = &TradingPair{Base: XLM, Quote: USDT}
+
+func makeTestOrder(action OrderAction, price float64, volume float64) Order {
+	return Order{
+		Pair:        testPair,
+		OrderAction: action,
+		OrderType:   OrderTypeLimit,
+		Price:       NumberFromFloat(price, 7),
+		Volume:      NumberFromFloat(volume, 7),
+		Timestamp:   nil,
+	}
+}
+
+func makeTestAsks(priceVolumes ...float64) []Order {
+	return makeTestOrders(OrderActionSell, priceVolumes...)
+}
+
+func makeTestBids(priceVolumes ...float64) []Order {
+	return makeTestOrders(OrderActionBuy, priceVolumes...)
+}
+
+// makeTestOrders takes in a flat list of price, volume pairs
+func makeTestOrders(action OrderAction, priceVolumes ...float64) []Order {
+	orders := []Order{}
+	for i := 0; i+1 < len(priceVolumes); i += 2 {
+		orders = append(orders, makeTestOrder(action, priceVolumes[i], priceVolumes[i+1]))
+	}
+	return orders
+}
+
+// assertPriceLevels compares the price and volume of each order by its string representation
+func assertPriceLevels(t *testing.T, want []Order, actual []Order) {
+	if !assert.Equal(t, len(want), len(actual)) {
+		return
+	}
+	for i, o := range actual {
+		assert.Equal(t, want[i].Price.AsString(), o.Price.AsString(), "price at index %d", i)
+		assert.Equal(t, want[i].Volume.AsString(), o.Volume.AsString(), "volume at index %d", i)
+	}
+}
+
+func TestOrderActionFromString(t *testing.T) {
+	for _, action := range []OrderAction{OrderActionBuy, OrderActionSell} {
+		actual, e := OrderActionFromString(action.String())
+		if assert.NoError(t, e) {
+			assert.Equal(t, action, actual)
+		}
+		assert.Equal(t, action, action.Reverse().Reverse())
+		assert.NotEqual(t, action, action.Reverse())
+	}
+
+	for _, s := range []string{"", "Buy", "bid", "hold"} {
+		_, e := OrderActionFromString(s)
+		assert.Error(t, e, s)
+	}
+}
+
+func TestOrderTypeFromString(t *testing.T) {
+	for _, orderType := range []OrderType{OrderTypeMarket, OrderTypeLimit, OrderTypeStop, OrderTypeStopLimit} {
+		actual, e := OrderTypeFromString(orderType.String())
+		if assert.NoError(t, e) {
+			assert.Equal(t, orderType, actual)
+		}
+	}
+
+	for _, s := range []string{"", "Limit", "stop-loss", OrderType(5).String()} {
+		_, e := OrderTypeFromString(s)
+		assert.Error(t, e, s)
+	}
+}
+
+func TestOrderStopPrice(t *testing.T) {
+	assert.True(t, OrderTypeStop.IsStop())
+	assert.False(t, OrderTypeStop.IsStopLimit())
+	assert.True(t, OrderTypeStopLimit.IsStopLimit())
+	assert.False(t, OrderTypeLimit.IsStop())
+
+	order := makeTestOrder(OrderActionSell, 0.25, 100.0)
+	assert.Equal(t, "Order[pair=XLM/USDT, action=sell, type=limit, price=0.2500000, vol=100.0000000, ts=<nil>]", order.String())
+
+	order.OrderType = OrderTypeStopLimit
+	order.StopPrice = NumberFromFloat(0.24, 7)
+	assert.Equal(t, "Order[pair=XLM/USDT, action=sell, type=stop-limit, price=0.2500000, vol=100.0000000, stopPrice=0.2400000, ts=<nil>]", order.String())
+
+	clone := order.Clone()
+	if assert.True(t, order.Equals(clone)) {
+		clone.StopPrice = NumberFromFloat(0.23, 7)
+		assert.False(t, order.Equals(clone))
+		assert.Equal(t, "0.2400000", order.StopPrice.AsString())
+	}
+
+	data, e := json.Marshal(order)
+	if !assert.NoError(t, e) {
+		return
+	}
+	var decoded Order
+	if assert.NoError(t, json.Unmarshal(data, &decoded)) {
+		assert.True(t, order.Equals(decoded), string(data))
+	}
+}
+
+func TestOrderEquals(t *testing.T) {
+	order := makeTestOrder(OrderActionSell, 1.1, 5.0)
+	order.Timestamp = MakeTimestamp(1000)
+
+	testCases := []struct {
+		name     string
+		modifyFn func(o *Order)
+		want     bool
+	}{
+		{name: "same values", modifyFn: func(o *Order) {}, want: true},
+		{name: "different precision", modifyFn: func(o *Order) { o.Price = NumberFromFloat(1.1, 3) }, want: true},
+		{name: "different pair", modifyFn: func(o *Order) { o.Pair = &TradingPair{Base: XLM, Quote: BTC} }, want: false},
+		{name: "nil pair", modifyFn: func(o *Order) { o.Pair = nil }, want: false},
+		{name: "different action", modifyFn: func(o *Order) { o.OrderAction = OrderActionBuy }, want: false},
+		{name: "different type", modifyFn: func(o *Order) { o.OrderType = OrderTypeMarket }, want: false},
+		{name: "different price", modifyFn: func(o *Order) { o.Price = NumberFromFloat(1.2, 7) }, want: false},
+		{name: "nil volume", modifyFn: func(o *Order) { o.Volume = nil }, want: false},
+		{name: "different volume", modifyFn: func(o *Order) { o.Volume = NumberFromFloat(5.1, 7) }, want: false},
+		{name: "different timestamp", modifyFn: func(o *Order) { o.Timestamp = MakeTimestamp(2000) }, want: false},
+		{name: "nil timestamp", modifyFn: func(o *Order) { o.Timestamp = nil }, want: false},
+		{name: "different owner", modifyFn: func(o *Order) { o.Owner = "owner1" }, want: false},
+		{name: "different exchange", modifyFn: func(o *Order) { o.Exchange = "exchange1" }, want: false},
+	}
+
+	for _, kase := range testCases {
+		t.Run(kase.name, func(t *testing.T) {
+			// build the other order from fresh pointers so the comparison is not by pointer identity
+			other := makeTestOrder(OrderActionSell, 1.1, 5.0)
+			other.Pair = &TradingPair{Base: testPair.Base, Quote: testPair.Quote}
+			other.Timestamp = MakeTimestamp(1000)
+			kase.modifyFn(&other)
+			assert.Equal(t, kase.want, order.Equals(other))
+			assert.Equal(t, kase.want, other.Equals(order))
+		})
+	}
+}
+
+func TestOrderClone(t *testing.T) {
+	order := makeTestOrder(OrderActionBuy, 0.9, 4.0)
+	order.Timestamp = MakeTimestamp(1000)
+	order.Owner = "owner1"
+
+	clone := order.Clone()
+	assert.True(t, order.Equals(clone))
+	assert.Equal(t, order, clone)
+	assert.False(t, order.Pair == clone.Pair)
+	assert.False(t, order.Price == clone.Price)
+	assert.False(t, order.Volume == clone.Volume)
+	assert.False(t, order.Timestamp == clone.Timestamp)
+
+	*clone.Price = *NumberFromFloat(0.5, 7)
+	*clone.Timestamp = 2000
+	assert.Equal(t, 0.9, order.Price.AsFloat())
+	assert.Equal(t, int64(1000), order.Timestamp.AsInt64())
+
+	// nil fields stay nil
+	assert.Equal(t, Order{OrderAction: OrderActionSell}, Order{OrderAction: OrderActionSell}.Clone())
+}
+
+func TestOrderBookIsValid(t *testing.T) {
+	nilPriceAsk := makeTestOrder(OrderActionSell, 1.1, 5.0)
+	nilPriceAsk.Price = nil
+	nilVolumeBid := makeTestOrder(OrderActionBuy, 0.9, 5.0)
+	nilVolumeBid.Volume = nil
+
+	testCases := []struct {
+		name    string
+		ob      *OrderBook
+		wantErr bool
+	}{
+		{
+			name:    "valid",
+			ob:      MakeOrderBook(testPair, makeTestAsks(1.1, 5.0, 1.2, 3.0), makeTestBids(0.9, 5.0, 0.8, 2.0)),
+			wantErr: false,
+		}, {
+			name:    "empty",
+			ob:      MakeOrderBook(testPair, []Order{}, []Order{}),
+			wantErr: false,
+		}, {
+			name:    "nil pair",
+			ob:      MakeOrderBook(nil, makeTestAsks(1.1, 5.0), makeTestBids(0.9, 5.0)),
+			wantErr: true,
+		}, {
+			name:    "ask with buy action",
+			ob:      MakeOrderBook(testPair, makeTestBids(1.1, 5.0), makeTestBids(0.9, 5.0)),
+			wantErr: true,
+		}, {
+			name:    "bid with sell action",
+			ob:      MakeOrderBook(testPair, makeTestAsks(1.1, 5.0), makeTestAsks(0.9, 5.0)),
+			wantErr: true,
+		}, {
+			name:    "nil price",
+			ob:      MakeOrderBook(testPair, []Order{nilPriceAsk}, makeTestBids(0.9, 5.0)),
+			wantErr: true,
+		}, {
+			name:    "nil volume",
+			ob:      MakeOrderBook(testPair, makeTestAsks(1.1, 5.0), []Order{nilVolumeBid}),
+			wantErr: true,
+		}, {
+			name:    "asks descending",
+			ob:      MakeOrderBook(testPair, makeTestAsks(1.2, 5.0, 1.1, 3.0), makeTestBids(0.9, 5.0)),
+			wantErr: true,
+		}, {
+			name:    "bids ascending",
+			ob:      MakeOrderBook(testPair, makeTestAsks(1.1, 5.0), makeTestBids(0.8, 5.0, 0.9, 2.0)),
+			wantErr: true,
+		}, {
+			name:    "duplicate ask level",
+			ob:      MakeOrderBook(testPair, makeTestAsks(1.1, 5.0, 1.1, 3.0), makeTestBids(0.9, 5.0)),
+			wantErr: true,
+		}, {
+			name:    "duplicate bid level",
+			ob:      MakeOrderBook(testPair, makeTestAsks(1.1, 5.0), makeTestBids(0.9, 5.0, 0.9, 2.0)),
+			wantErr: true,
+		},
+	}
+
+	for _, kase := range testCases {
+		t.Run(kase.name, func(t *testing.T) {
+			e := kase.ob.IsValid()
+			if kase.wantErr {
+				assert.Error(t, e)
+				return
+			}
+			assert.NoError(t, e)
+		})
+	}
+}
+
+func TestOrderBookValidate(t *testing.T) {
+	otherPairAsk := makeTestOrder(OrderActionSell, 1.2, 5.0)
+	otherPairAsk.Pair = &TradingPair{Base: XLM, Quote: BTC}
+	nilPairBid := makeTestOrder(OrderActionBuy, 0.9, 5.0)
+	nilPairBid.Pair = nil
+
+	testCases := []struct {
+		name    string
+		ob      *OrderBook
+		wantErr bool
+	}{
+		{
+			name:    "sorted",
+			ob:      MakeOrderBook(testPair, makeTestAsks(1.1, 5.0, 1.2, 3.0), makeTestBids(0.9, 5.0, 0.8, 2.0)),
+			wantErr: false,
+		}, {
+			name:    "empty",
+			ob:      MakeOrderBook(testPair, []Order{}, []Order{}),
+			wantErr: false,
+		}, {
+			name:    "equal price levels",
+			ob:      MakeOrderBook(testPair, makeTestAsks(1.1, 5.0, 1.1, 3.0), makeTestBids(0.9, 5.0, 0.9, 2.0)),
+			wantErr: false,
+		}, {
+			name:    "nil pair",
+			ob:      MakeOrderBook(nil, makeTestAsks(1.1, 5.0), makeTestBids(0.9, 5.0)),
+			wantErr: true,
+		}, {
+			name:    "asks descending",
+			ob:      MakeOrderBook(testPair, makeTestAsks(1.2, 5.0, 1.1, 3.0), makeTestBids(0.9, 5.0)),
+			wantErr: true,
+		}, {
+			name:    "bids ascending",
+			ob:      MakeOrderBook(testPair, makeTestAsks(1.1, 5.0), makeTestBids(0.8, 5.0, 0.9, 2.0)),
+			wantErr: true,
+		}, {
+			name:    "order on another pair",
+			ob:      MakeOrderBook(testPair, append(makeTestAsks(1.1, 5.0), otherPairAsk), makeTestBids(0.9, 5.0)),
+			wantErr: true,
+		}, {
+			name:    "order without a pair",
+			ob:      MakeOrderBook(testPair, makeTestAsks(1.1, 5.0), []Order{nilPairBid}),
+			wantErr: true,
+		},
+	}
+
+	for _, kase := range testCases {
+		t.Run(kase.name, func(t *testing.T) {
+			e := kase.ob.Validate()
+			if kase.wantErr {
+				assert.Error(t, e)
+				return
+			}
+			assert.NoError(t, e)
+		})
+	}
+}
+
+func TestMakeOrderBookSorted(t *testing.T) {
+	asks := makeTestAsks(1.2, 3.0, 1.1, 5.0, 1.2, 1.0)
+	bids := makeTestBids(0.8, 2.0, 0.9, 5.0)
+	ob := MakeOrderBookSorted(testPair, asks, bids)
+	if !assert.NoError(t, ob.Validate()) {
+		return
+	}
+
+	assertPriceLevels(t, makeTestAsks(1.1, 5.0, 1.2, 3.0, 1.2, 1.0), ob.Asks())
+	assertPriceLevels(t, makeTestBids(0.9, 5.0, 0.8, 2.0), ob.Bids())
+	// the inputs are not modified
+	assert.Equal(t, 1.2, asks[0].Price.AsFloat())
+	assert.Equal(t, 0.8, bids[0].Price.AsFloat())
+}
+
+func TestMergeOrderBooks(t *testing.T) {
+	asksA := makeTestAsks(1.1, 5.0, 1.3, 2.0)
+	asksA[0].Exchange = "venueA"
+	asksA[0].Timestamp = MakeTimestamp(1000)
+	asksB := makeTestAsks(1.1, 3.0, 1.2, 4.0)
+	asksB[0].Exchange = "venueB"
+	asksB[0].Timestamp = MakeTimestamp(2000)
+	a := MakeOrderBook(testPair, asksA, makeTestBids(0.9, 4.0, 0.7, 1.0))
+	b := MakeOrderBook(testPair, asksB, makeTestBids(0.8, 2.0, 0.7, 3.0))
+
+	merged, e := MergeOrderBooks(a, b)
+	if !assert.NoError(t, e) {
+		return
+	}
+	assert.NoError(t, merged.Validate())
+	assertPriceLevels(t, makeTestAsks(1.1, 5.0, 1.1, 3.0, 1.2, 4.0, 1.3, 2.0), merged.Asks())
+	assertPriceLevels(t, makeTestBids(0.9, 4.0, 0.8, 2.0, 0.7, 1.0, 0.7, 3.0), merged.Bids())
+	assert.Equal(t, "venueA", merged.Asks()[0].Exchange)
+	assert.Equal(t, "venueB", merged.Asks()[1].Exchange)
+	// the inputs are not modified
+	assertPriceLevels(t, makeTestAsks(1.1, 5.0, 1.3, 2.0), a.Asks())
+
+	coalesced := merged.CoalesceLevels()
+	assert.NoError(t, coalesced.IsValid())
+	assertPriceLevels(t, makeTestAsks(1.1, 8.0, 1.2, 4.0, 1.3, 2.0), coalesced.Asks())
+	assertPriceLevels(t, makeTestBids(0.9, 4.0, 0.8, 2.0, 0.7, 4.0), coalesced.Bids())
+	assert.Equal(t, int64(2000), coalesced.Asks()[0].Timestamp.AsInt64())
+	assert.Equal(t, "", coalesced.Asks()[0].Exchange)
+	assert.Equal(t, 5.0, merged.Asks()[0].Volume.AsFloat(), "coalescing does not modify the merged book")
+
+	_, e = MergeOrderBooks(a, MakeOrderBook(&TradingPair{Base: XLM, Quote: BTC}, []Order{}, []Order{}))
+	assert.Error(t, e)
+	_, e = MergeOrderBooks(a, MakeOrderBook(nil, []Order{}, []Order{}))
+	assert.Error(t, e)
+	_, e = MergeOrderBooks(nil, b)
+	assert.Error(t, e)
+}
+
+func TestOrderBookPriceLaddersEqual(t *testing.T) {
+	ob := MakeOrderBook(testPair, makeTestAsks(1.1, 5.0, 1.2, 3.0), makeTestBids(0.9, 4.0, 0.8, 2.0))
+
+	testCases := []struct {
+		name  string
+		other *OrderBook
+		want  bool
+	}{
+		{
+			name:  "only volumes differ",
+			other: MakeOrderBook(testPair, makeTestAsks(1.1, 1.0, 1.2, 30.0), makeTestBids(0.9, 0.5, 0.8, 7.0)),
+			want:  true,
+		}, {
+			name:  "multiple orders on the same price level",
+			other: MakeOrderBook(testPair, makeTestAsks(1.1, 1.0, 1.1, 2.0, 1.2, 3.0), makeTestBids(0.9, 4.0, 0.8, 1.0, 0.8, 1.0)),
+			want:  true,
+		}, {
+			name:  "an ask price appears",
+			other: MakeOrderBook(testPair, makeTestAsks(1.1, 5.0, 1.15, 1.0, 1.2, 3.0), makeTestBids(0.9, 4.0, 0.8, 2.0)),
+			want:  false,
+		}, {
+			name:  "a bid price disappears",
+			other: MakeOrderBook(testPair, makeTestAsks(1.1, 5.0, 1.2, 3.0), makeTestBids(0.9, 4.0)),
+			want:  false,
+		}, {
+			name:  "a price moves",
+			other: MakeOrderBook(testPair, makeTestAsks(1.1, 5.0, 1.25, 3.0), makeTestBids(0.9, 4.0, 0.8, 2.0)),
+			want:  false,
+		}, {
+			name:  "nil",
+			other: nil,
+			want:  false,
+		},
+	}
+
+	for _, kase := range testCases {
+		t.Run(kase.name, func(t *testing.T) {
+			assert.Equal(t, kase.want, ob.PriceLaddersEqual(kase.other))
+			assert.Equal(t, kase.want, kase.other.PriceLaddersEqual(ob))
+		})
+	}
+}
+
+func TestOrderBookLevelGaps(t *testing.T) {
+	ob := MakeOrderBook(testPair, makeTestAsks(1.10, 5.0, 1.12, 3.0, 1.20, 1.0), makeTestBids(0.90, 5.0, 0.85, 2.0, 0.84, 4.0, 0.50, 1.0))
+
+	testCases := []struct {
+		name     string
+		action   OrderAction
+		wantGaps []float64
+	}{
+		{
+			name:     "asks",
+			action:   OrderActionSell,
+			wantGaps: []float64{0.02, 0.08},
+		}, {
+			name:     "bids",
+			action:   OrderActionBuy,
+			wantGaps: []float64{0.05, 0.01, 0.34},
+		},
+	}
+
+	for _, kase := range testCases {
+		t.Run(kase.name, func(t *testing.T) {
+			gaps := ob.LevelGaps(kase.action)
+			if !assert.Equal(t, len(kase.wantGaps), len(gaps)) {
+				return
+			}
+			for i, g := range gaps {
+				assert.InDelta(t, kase.wantGaps[i], g.AsFloat(), 1e-9, "gap at index %d", i)
+			}
+		})
+	}
+
+	empty := MakeOrderBook(testPair, makeTestAsks(1.10, 5.0), []Order{})
+	assert.Equal(t, 0, len(empty.LevelGaps(OrderActionSell)))
+	assert.Equal(t, 0, len(empty.LevelGaps(OrderActionBuy)))
+}
+
+func TestOrderBookTotalNotional(t *testing.T) {
+	testCases := []struct {
+		name         string
+		asks         []Order
+		bids         []Order
+		action       OrderAction
+		wantNotional float64
+		wantErr      bool
+	}{
+		{
+			name:         "asks",
+			asks:         makeTestAsks(1.0, 2.0, 1.5, 4.0),
+			action:       OrderActionSell,
+			wantNotional: 8.0,
+		}, {
+			name:         "very large volume and very small price",
+			asks:         makeTestAsks(0.0000001, 1e15),
+			action:       OrderActionSell,
+			wantNotional: 1e8,
+		}, {
+			// the product is below the precision of the price and volume
+			name:         "very small price and small volume",
+			bids:         makeTestBids(0.0000003, 0.2),
+			action:       OrderActionBuy,
+			wantNotional: 0.00000006,
+		}, {
+			name:         "very large price and volume",
+			bids:         makeTestBids(1e12, 1e15),
+			action:       OrderActionBuy,
+			wantNotional: 1e27,
+		}, {
+			name:    "out of range",
+			bids:    makeTestBids(1e300, 1e300),
+			action:  OrderActionBuy,
+			wantErr: true,
+		}, {
+			name:         "empty side",
+			action:       OrderActionSell,
+			wantNotional: 0.0,
+		},
+	}
+
+	for _, kase := range testCases {
+		t.Run(kase.name, func(t *testing.T) {
+			ob := MakeOrderBook(testPair, append([]Order{}, kase.asks...), append([]Order{}, kase.bids...))
+			actual, e := ob.TotalNotional(kase.action)
+			if kase.wantErr {
+				assert.Error(t, e)
+				return
+			}
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.True(t, actual.IsFinite())
+			assert.InDelta(t, kase.wantNotional, actual.AsFloat(), math.Max(kase.wantNotional*1e-12, floatEpsilon*floatEpsilon))
+		})
+	}
+}
+
+func TestOrderBookTotalVolumeAndValue(t *testing.T) {
+	ob := MakeOrderBook(testPair, makeTestAsks(1.0, 2.0, 1.5, 4.0), makeTestBids(0.9, 1.0, 0.8, 2.5, 0.5, 0.5))
+	assert.InDelta(t, 6.0, ob.TotalAskVolume().AsFloat(), floatEpsilon)
+	assert.InDelta(t, 8.0, ob.TotalAskValue().AsFloat(), floatEpsilon)
+	assert.InDelta(t, 4.0, ob.TotalBidVolume().AsFloat(), floatEpsilon)
+	assert.InDelta(t, 3.15, ob.TotalBidValue().AsFloat(), floatEpsilon)
+
+	// empty sides are zero and not nil
+	empty := MakeOrderBook(testPair, []Order{}, []Order{})
+	for _, actual := range []*Number{empty.TotalAskVolume(), empty.TotalAskValue(), empty.TotalBidVolume(), empty.TotalBidValue()} {
+		if assert.NotNil(t, actual) {
+			assert.Equal(t, 0.0, actual.AsFloat())
+		}
+	}
+}
+
+func TestOrderBookVWAP(t *testing.T) {
+	ob := MakeOrderBook(testPair, makeTestAsks(1.0, 2.0, 1.2, 3.0), makeTestBids(0.9, 4.0, 0.8, 1.0))
+
+	testCases := []struct {
+		name         string
+		action       OrderAction
+		targetVolume float64
+		wantPrice    float64
+		wantFilled   float64
+		wantErr      bool
+	}{
+		{
+			name:         "buy within the top level",
+			action:       OrderActionBuy,
+			targetVolume: 1.0,
+			wantPrice:    1.0,
+			wantFilled:   1.0,
+		}, {
+			name:         "buy across levels",
+			action:       OrderActionBuy,
+			targetVolume: 4.0,
+			wantPrice:    1.1, // (2.0 * 1.0 + 2.0 * 1.2) / 4.0
+			wantFilled:   4.0,
+		}, {
+			name:         "buy from a thin book",
+			action:       OrderActionBuy,
+			targetVolume: 10.0,
+			wantPrice:    1.12, // (2.0 * 1.0 + 3.0 * 1.2) / 5.0
+			wantFilled:   5.0,
+		}, {
+			name:         "sell across levels",
+			action:       OrderActionSell,
+			targetVolume: 5.0,
+			wantPrice:    0.88, // (4.0 * 0.9 + 1.0 * 0.8) / 5.0
+			wantFilled:   5.0,
+		}, {
+			name:         "zero target volume",
+			action:       OrderActionBuy,
+			targetVolume: 0.0,
+			wantErr:      true,
+		},
+	}
+
+	for _, kase := range testCases {
+		t.Run(kase.name, func(t *testing.T) {
+			avgPrice, filled, e := ob.VWAP(kase.action, NumberFromFloat(kase.targetVolume, 7))
+			if kase.wantErr {
+				assert.Error(t, e)
+				return
+			}
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.InDelta(t, kase.wantPrice, avgPrice.AsFloat(), floatEpsilon)
+			assert.InDelta(t, kase.wantFilled, filled.AsFloat(), floatEpsilon)
+		})
+	}
+
+	_, _, e := MakeOrderBook(testPair, []Order{}, makeTestBids(0.9, 4.0)).VWAP(OrderActionBuy, NumberFromFloat(1.0, 7))
+	assert.Error(t, e)
+	_, _, e = ob.VWAP(OrderActionBuy, nil)
+	assert.Error(t, e)
+}
+
+func TestOrderBookPriceForVolume(t *testing.T) {
+	ob := MakeOrderBook(testPair, makeTestAsks(1.0, 2.0, 1.2, 3.0), makeTestBids(0.9, 4.0, 0.8, 1.0))
+
+	testCases := []struct {
+		name         string
+		action       OrderAction
+		targetVolume float64
+		wantPrice    float64
+		wantErr      bool
+	}{
+		{
+			name:         "buy within the top level",
+			action:       OrderActionBuy,
+			targetVolume: 1.0,
+			wantPrice:    1.0,
+		}, {
+			name:         "buy the entire top level",
+			action:       OrderActionBuy,
+			targetVolume: 2.0,
+			wantPrice:    1.0,
+		}, {
+			name:         "buy across levels",
+			action:       OrderActionBuy,
+			targetVolume: 4.0,
+			wantPrice:    1.2,
+		}, {
+			name:         "sell across levels",
+			action:       OrderActionSell,
+			targetVolume: 5.0,
+			wantPrice:    0.8,
+		}, {
+			name:         "thin book",
+			action:       OrderActionBuy,
+			targetVolume: 5.1,
+			wantErr:      true,
+		}, {
+			name:         "zero target volume",
+			action:       OrderActionSell,
+			targetVolume: 0.0,
+			wantErr:      true,
+		},
+	}
+
+	for _, kase := range testCases {
+		t.Run(kase.name, func(t *testing.T) {
+			actual, e := ob.PriceForVolume(kase.action, NumberFromFloat(kase.targetVolume, 7))
+			if kase.wantErr {
+				assert.Error(t, e)
+				return
+			}
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.InDelta(t, kase.wantPrice, actual.AsFloat(), floatEpsilon)
+		})
+	}
+
+	_, e := MakeOrderBook(testPair, []Order{}, makeTestBids(0.9, 4.0)).PriceForVolume(OrderActionBuy, NumberFromFloat(1.0, 7))
+	assert.Error(t, e)
+	_, e = ob.PriceForVolume(OrderActionBuy, nil)
+	assert.Error(t, e)
+}
+
+func TestOrderBookSimulateFill(t *testing.T) {
+	ob := MakeOrderBook(testPair, makeTestAsks(1.0, 2.0, 1.2, 3.0), makeTestBids(0.9, 4.0, 0.8, 1.0))
+
+	testCases := []struct {
+		name       string
+		ob         *OrderBook
+		action     OrderAction
+		volume     *Number
+		wantFilled float64
+		// wantAvgPrice is 0 when nothing is filled
+		wantAvgPrice  float64
+		wantRemaining float64
+	}{
+		{
+			name:          "buy within the top level",
+			ob:            ob,
+			action:        OrderActionBuy,
+			volume:        NumberFromFloat(1.5, 7),
+			wantFilled:    1.5,
+			wantAvgPrice:  1.0,
+			wantRemaining: 0.0,
+		}, {
+			name:          "buy partially consuming the last level",
+			ob:            ob,
+			action:        OrderActionBuy,
+			volume:        NumberFromFloat(4.0, 7),
+			wantFilled:    4.0,
+			wantAvgPrice:  (2.0*1.0 + 2.0*1.2) / 4.0,
+			wantRemaining: 0.0,
+		}, {
+			name:          "buy exactly the whole side",
+			ob:            ob,
+			action:        OrderActionBuy,
+			volume:        NumberFromFloat(5.0, 7),
+			wantFilled:    5.0,
+			wantAvgPrice:  (2.0*1.0 + 3.0*1.2) / 5.0,
+			wantRemaining: 0.0,
+		}, {
+			name:          "sell more than the side",
+			ob:            ob,
+			action:        OrderActionSell,
+			volume:        NumberFromFloat(8.0, 7),
+			wantFilled:    5.0,
+			wantAvgPrice:  (4.0*0.9 + 1.0*0.8) / 5.0,
+			wantRemaining: 3.0,
+		}, {
+			name:          "empty side",
+			ob:            MakeOrderBook(testPair, []Order{}, []Order{}),
+			action:        OrderActionBuy,
+			volume:        NumberFromFloat(1.0, 7),
+			wantFilled:    0.0,
+			wantAvgPrice:  0.0,
+			wantRemaining: 1.0,
+		}, {
+			name:          "zero volume",
+			ob:            ob,
+			action:        OrderActionBuy,
+			volume:        NumberFromFloat(0.0, 7),
+			wantFilled:    0.0,
+			wantAvgPrice:  0.0,
+			wantRemaining: 0.0,
+		}, {
+			name:          "nil volume",
+			ob:            ob,
+			action:        OrderActionSell,
+			volume:        nil,
+			wantFilled:    0.0,
+			wantAvgPrice:  0.0,
+			wantRemaining: 0.0,
+		},
+	}
+
+	for _, kase := range testCases {
+		t.Run(kase.name, func(t *testing.T) {
+			filled, avgPrice, remaining := kase.ob.SimulateFill(kase.action, kase.volume)
+			assert.InDelta(t, kase.wantFilled, filled.AsFloat(), floatEpsilon)
+			assert.InDelta(t, kase.wantRemaining, remaining.AsFloat(), floatEpsilon)
+			if kase.wantAvgPrice == 0.0 {
+				assert.Nil(t, avgPrice)
+				return
+			}
+			if assert.NotNil(t, avgPrice) {
+				assert.InDelta(t, kase.wantAvgPrice, avgPrice.AsFloat(), 1e-7)
+			}
+		})
+	}
+}
+
+func TestOrderBookVolumeUpToPrice(t *testing.T) {
+	ob := MakeOrderBook(testPair, makeTestAsks(1.0, 2.0, 1.2, 3.0, 1.5, 4.0), makeTestBids(0.9, 4.0, 0.8, 1.0, 0.5, 2.0))
+
+	testCases := []struct {
+		name       string
+		ob         *OrderBook
+		action     OrderAction
+		limitPrice *Number
+		want       float64
+	}{
+		{name: "buy below the best ask", ob: ob, action: OrderActionBuy, limitPrice: NumberFromFloat(0.99, 7), want: 0.0},
+		{name: "buy at a level", ob: ob, action: OrderActionBuy, limitPrice: NumberFromFloat(1.2, 7), want: 5.0},
+		{name: "buy between levels", ob: ob, action: OrderActionBuy, limitPrice: NumberFromFloat(1.3, 7), want: 5.0},
+		{name: "buy the whole side", ob: ob, action: OrderActionBuy, limitPrice: NumberFromFloat(10.0, 7), want: 9.0},
+		{name: "sell above the best bid", ob: ob, action: OrderActionSell, limitPrice: NumberFromFloat(0.95, 7), want: 0.0},
+		{name: "sell at a level", ob: ob, action: OrderActionSell, limitPrice: NumberFromFloat(0.8, 7), want: 5.0},
+		{name: "sell the whole side", ob: ob, action: OrderActionSell, limitPrice: NumberFromFloat(0.1, 7), want: 7.0},
+		{name: "empty book", ob: MakeOrderBook(testPair, []Order{}, []Order{}), action: OrderActionBuy, limitPrice: NumberFromFloat(1.0, 7), want: 0.0},
+		{name: "nil limit price", ob: ob, action: OrderActionBuy, limitPrice: nil, want: 0.0},
+	}
+
+	for _, kase := range testCases {
+		t.Run(kase.name, func(t *testing.T) {
+			assert.InDelta(t, kase.want, kase.ob.VolumeUpToPrice(kase.action, kase.limitPrice).AsFloat(), floatEpsilon)
+		})
+	}
+}
+
+func TestOrderBookVwapExtremeMagnitudes(t *testing.T) {
+	// a very large volume at a very small price and the same volume at a larger price
+	ob := MakeOrderBook(testPair, makeTestAsks(0.0000001, 1e15, 0.0000003, 1e15), []Order{})
+	avgPrice, filled, e := ob.VWAP(OrderActionBuy, NumberFromFloat(2e15, 7))
+	if !assert.NoError(t, e) {
+		return
+	}
+	assert.Equal(t, 2e15, filled.AsFloat())
+	assert.Equal(t, "0.0000002", avgPrice.AsString())
+
+	ob = MakeOrderBook(testPair, makeTestAsks(1e300, 1e300), []Order{})
+	_, _, e = ob.VWAP(OrderActionBuy, NumberFromFloat(1e300, 7))
+	assert.Error(t, e)
+}
+
+func TestOrderBookEffectivePriceWithFee(t *testing.T) {
+	ob := MakeOrderBook(testPair, makeTestAsks(1.0, 5.0, 1.2, 5.0), makeTestBids(0.9, 5.0, 0.8, 5.0))
+
+	testCases := []struct {
+		name      string
+		action    OrderAction
+		volume    float64
+		feeRate   float64
+		wantPrice float64
+		wantErr   bool
+	}{
+		{
+			name:      "buy without fee",
+			action:    OrderActionBuy,
+			volume:    10.0,
+			feeRate:   0.0,
+			wantPrice: 1.1,
+		}, {
+			name:      "buy with fee",
+			action:    OrderActionBuy,
+			volume:    10.0,
+			feeRate:   0.01,
+			wantPrice: 1.111,
+		}, {
+			name:      "sell without fee",
+			action:    OrderActionSell,
+			volume:    10.0,
+			feeRate:   0.0,
+			wantPrice: 0.85,
+		}, {
+			name:      "sell with fee",
+			action:    OrderActionSell,
+			volume:    10.0,
+			feeRate:   0.01,
+			wantPrice: 0.8415,
+		}, {
+			name:      "buy top level only with fee",
+			action:    OrderActionBuy,
+			volume:    2.0,
+			feeRate:   0.002,
+			wantPrice: 1.002,
+		}, {
+			name:    "insufficient liquidity",
+			action:  OrderActionBuy,
+			volume:  20.0,
+			feeRate: 0.01,
+			wantErr: true,
+		},
+	}
+
+	for _, kase := range testCases {
+		t.Run(kase.name, func(t *testing.T) {
+			price, e := ob.EffectivePriceWithFee(kase.action, NumberFromFloat(kase.volume, 7), NumberFromFloat(kase.feeRate, 7))
+			if kase.wantErr {
+				assert.Error(t, e)
+				return
+			}
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.InDelta(t, kase.wantPrice, price.AsFloat(), 1e-7)
+		})
+	}
+}
+
+func TestOrderBookWeightedSpread(t *testing.T) {
+	ob := MakeOrderBook(testPair, makeTestAsks(1.0, 5.0, 1.2, 5.0), makeTestBids(0.9, 5.0, 0.8, 5.0))
+	topOfBook, ok := ob.TopOfBook()
+	if !assert.True(t, ok) {
+		return
+	}
+
+	testCases := []struct {
+		name       string
+		volume     float64
+		wantSpread float64
+		wantErr    bool
+	}{
+		{
+			name:       "small volume matches the top of book spread",
+			volume:     2.0,
+			wantSpread: topOfBook.Spread.AsFloat(),
+		}, {
+			name:       "large volume is wider than the top of book spread",
+			volume:     10.0,
+			wantSpread: 0.25,
+		}, {
+			name:    "volume exceeds depth",
+			volume:  11.0,
+			wantErr: true,
+		}, {
+			name:    "zero volume",
+			volume:  0.0,
+			wantErr: true,
+		},
+	}
+
+	for _, kase := range testCases {
+		t.Run(kase.name, func(t *testing.T) {
+			spread, e := ob.WeightedSpread(NumberFromFloat(kase.volume, 7))
+			if kase.wantErr {
+				assert.Error(t, e)
+				return
+			}
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.InDelta(t, kase.wantSpread, spread.AsFloat(), 1e-7)
+		})
+	}
+
+	_, e := MakeOrderBook(testPair, makeTestAsks(1.0, 5.0), []Order{}).WeightedSpread(NumberFromFloat(1.0, 7))
+	assert.Error(t, e)
+}
+
+func TestOrderBookExpectedFillTime(t *testing.T) {
+	ob := MakeOrderBook(testPair, makeTestAsks(1.1, 5.0, 1.2, 10.0, 1.3, 20.0), makeTestBids(0.9, 4.0, 0.8, 6.0))
+
+	testCases := []struct {
+		name      string
+		action    OrderAction
+		price     float64
+		volume    float64
+		tradeRate float64
+		wantOk    bool
+		wantTime  time.Duration
+	}{
+		{
+			name:      "sell at the front of the queue",
+			action:    OrderActionSell,
+			price:     1.05,
+			volume:    2.0,
+			tradeRate: 0.5,
+			wantOk:    true,
+			wantTime:  4 * time.Second,
+		}, {
+			name:      "sell deep in the queue",
+			action:    OrderActionSell,
+			price:     1.2,
+			volume:    2.0,
+			tradeRate: 0.5,
+			wantOk:    true,
+			wantTime:  34 * time.Second,
+		}, {
+			name:      "buy behind the top bid",
+			action:    OrderActionBuy,
+			price:     0.85,
+			volume:    1.0,
+			tradeRate: 2.0,
+			wantOk:    true,
+			wantTime:  2500 * time.Millisecond,
+		}, {
+			name:      "sell that crosses the spread",
+			action:    OrderActionSell,
+			price:     0.9,
+			volume:    2.0,
+			tradeRate: 0.5,
+			wantOk:    true,
+			wantTime:  0,
+		}, {
+			name:      "zero trade rate",
+			action:    OrderActionSell,
+			price:     1.2,
+			volume:    2.0,
+			tradeRate: 0.0,
+			wantOk:    false,
+		},
+	}
+
+	for _, kase := range testCases {
+		t.Run(kase.name, func(t *testing.T) {
+			fillTime, ok := ob.ExpectedFillTime(kase.action, NumberFromFloat(kase.price, 7), NumberFromFloat(kase.volume, 7), NumberFromFloat(kase.tradeRate, 7))
+			if !assert.Equal(t, kase.wantOk, ok) || !ok {
+				return
+			}
+			assert.InDelta(t, kase.wantTime.Seconds(), fillTime.Seconds(), 1e-6)
+		})
+	}
+}
+
+func TestOrderBookQueuePosition(t *testing.T) {
+	withTimestamp := func(o Order, ts int64) Order {
+		o.Timestamp = MakeTimestamp(ts)
+		return o
+	}
+	// the orders on the 1.1 level are not sorted by timestamp in the book
+	asks := []Order{
+		withTimestamp(makeTestOrder(OrderActionSell, 1.0, 7.0), 500),
+		withTimestamp(makeTestOrder(OrderActionSell, 1.1, 2.0), 3000),
+		withTimestamp(makeTestOrder(OrderActionSell, 1.1, 5.0), 1000),
+		withTimestamp(makeTestOrder(OrderActionSell, 1.1, 3.0), 2000),
+		withTimestamp(makeTestOrder(OrderActionSell, 1.2, 4.0), 100),
+	}
+	bids := makeTestBids(0.9, 1.0, 0.9, 2.0, 0.9, 3.0)
+	ob := MakeOrderBook(testPair, asks, bids)
+
+	testCases := []struct {
+		name            string
+		order           Order
+		wantOk          bool
+		wantVolumeAhead float64
+	}{
+		{
+			name:            "earliest order is at the front",
+			order:           asks[2],
+			wantOk:          true,
+			wantVolumeAhead: 0.0,
+		}, {
+			name:            "middle order",
+			order:           asks[3],
+			wantOk:          true,
+			wantVolumeAhead: 5.0,
+		}, {
+			name:            "latest order is at the back",
+			order:           asks[1],
+			wantOk:          true,
+			wantVolumeAhead: 8.0,
+		}, {
+			name:            "new order joins the back of the level",
+			order:           withTimestamp(makeTestOrder(OrderActionSell, 1.1, 1.0), 4000),
+			wantOk:          true,
+			wantVolumeAhead: 10.0,
+		}, {
+			name:            "without timestamps the order of the book is used",
+			order:           bids[2],
+			wantOk:          true,
+			wantVolumeAhead: 3.0,
+		}, {
+			name:   "without timestamps the order needs to be in the book",
+			order:  makeTestOrder(OrderActionBuy, 0.9, 4.0),
+			wantOk: false,
+		}, {
+			name:   "no level at the price",
+			order:  withTimestamp(makeTestOrder(OrderActionSell, 1.15, 1.0), 100),
+			wantOk: false,
+		},
+	}
+
+	for _, kase := range testCases {
+		t.Run(kase.name, func(t *testing.T) {
+			volumeAhead, ok := ob.QueuePosition(kase.order)
+			if !assert.Equal(t, kase.wantOk, ok) || !ok {
+				return
+			}
+			assert.InDelta(t, kase.wantVolumeAhead, volumeAhead.AsFloat(), 1e-7)
+		})
+	}
+}
+
+func TestOrderBookTopOfBook(t *testing.T) {
+	testCases := []struct {
+		name       string
+		ob         *OrderBook
+		wantOk     bool
+		wantSpread float64
+		wantMid    float64
+	}{
+		{
+			name:       "both sides",
+			ob:         MakeOrderBook(testPair, makeTestAsks(1.1, 5.0, 1.2, 3.0), makeTestBids(0.9, 4.0, 0.8, 2.0)),
+			wantOk:     true,
+			wantSpread: 0.2,
+			wantMid:    1.0,
+		}, {
+			name:   "empty asks",
+			ob:     MakeOrderBook(testPair, []Order{}, makeTestBids(0.9, 4.0)),
+			wantOk: false,
+		}, {
+			name:   "empty bids",
+			ob:     MakeOrderBook(testPair, makeTestAsks(1.1, 5.0), []Order{}),
+			wantOk: false,
+		},
+	}
+
+	for _, kase := range testCases {
+		t.Run(kase.name, func(t *testing.T) {
+			tob, ok := kase.ob.TopOfBook()
+			if !assert.Equal(t, kase.wantOk, ok) {
+				return
+			}
+			if !ok {
+				assert.Equal(t, TopOfBook{}, tob)
+				return
+			}
+
+			assert.Equal(t, kase.ob.TopBid().Price, tob.BestBid)
+			assert.Equal(t, kase.ob.TopAsk().Price, tob.BestAsk)
+			assert.Equal(t, kase.ob.TopBid().Volume, tob.BestBidVolume)
+			assert.Equal(t, kase.ob.TopAsk().Volume, tob.BestAskVolume)
+			assert.InDelta(t, kase.wantSpread, tob.Spread.AsFloat(), 1e-9)
+			assert.InDelta(t, kase.wantMid, tob.MidPrice.AsFloat(), 1e-9)
+		})
+	}
+}
+
+func TestOrderBookBestBidAndAsk(t *testing.T) {
+	ob := MakeOrderBook(testPair, makeTestAsks(1.1, 5.0, 1.2, 3.0), makeTestBids(0.9, 4.0, 0.8, 2.0))
+	bestBid, ok := ob.BestBid()
+	if assert.True(t, ok) {
+		assert.Equal(t, 0.9, bestBid.Price.AsFloat())
+		assert.Equal(t, 4.0, bestBid.Volume.AsFloat())
+	}
+	bestAsk, ok := ob.BestAsk()
+	if assert.True(t, ok) {
+		assert.Equal(t, 1.1, bestAsk.Price.AsFloat())
+		assert.Equal(t, 5.0, bestAsk.Volume.AsFloat())
+	}
+
+	empty := MakeOrderBook(testPair, []Order{}, []Order{})
+	bestBid, ok = empty.BestBid()
+	assert.False(t, ok)
+	assert.Nil(t, bestBid)
+	bestAsk, ok = empty.BestAsk()
+	assert.False(t, ok)
+	assert.Nil(t, bestAsk)
+}
+
+func TestOrderBookTopN(t *testing.T) {
+	ob := MakeOrderBook(testPair, makeTestAsks(1.1, 5.0, 1.2, 3.0, 1.3, 2.0), makeTestBids(0.9, 4.0, 0.8, 1.0))
+
+	testCases := []struct {
+		name     string
+		n        int
+		wantAsks []Order
+		wantBids []Order
+	}{
+		{name: "zero", n: 0, wantAsks: []Order{}, wantBids: []Order{}},
+		{name: "negative", n: -1, wantAsks: []Order{}, wantBids: []Order{}},
+		{name: "one level", n: 1, wantAsks: makeTestAsks(1.1, 5.0), wantBids: makeTestBids(0.9, 4.0)},
+		{name: "all bids", n: 2, wantAsks: makeTestAsks(1.1, 5.0, 1.2, 3.0), wantBids: makeTestBids(0.9, 4.0, 0.8, 1.0)},
+		{name: "more than available", n: 5, wantAsks: makeTestAsks(1.1, 5.0, 1.2, 3.0, 1.3, 2.0), wantBids: makeTestBids(0.9, 4.0, 0.8, 1.0)},
+	}
+
+	for _, kase := range testCases {
+		t.Run(kase.name, func(t *testing.T) {
+			top := ob.TopN(kase.n)
+			assert.Equal(t, ob.Pair(), top.Pair())
+			assertPriceLevels(t, kase.wantAsks, top.Asks())
+			assertPriceLevels(t, kase.wantBids, top.Bids())
+		})
+	}
+
+	// appending to a truncated side does not modify the original book
+	top := ob.TopN(1)
+	_ = append(top.Asks(), makeTestOrder(OrderActionSell, 2.0, 1.0))
+	assert.Equal(t, 1.2, ob.Asks()[1].Price.AsFloat())
+}
+
+func TestOrderBookClone(t *testing.T) {
+	ob := MakeOrderBook(testPair, makeTestAsks(1.1, 5.0, 1.2, 3.0), makeTestBids(0.9, 4.0, 0.8, 1.0))
+	clone := ob.Clone()
+	assert.Equal(t, ob, clone)
+
+	// sorting, trimming, and modifying the orders of the clone does not affect the original
+	sort.Slice(clone.asks, func(i int, j int) bool {
+		return clone.asks[i].Price.AsFloat() > clone.asks[j].Price.AsFloat()
+	})
+	clone.bids = clone.bids[:1]
+	clone.bids[0].Volume = NumberFromFloat(10.0, 5)
+	*clone.asks[1].Price = *NumberFromFloat(2.0, 5)
+	clone.pair.Base = "BTC"
+	assertPriceLevels(t, makeTestAsks(1.1, 5.0, 1.2, 3.0), ob.Asks())
+	assertPriceLevels(t, makeTestBids(0.9, 4.0, 0.8, 1.0), ob.Bids())
+	assert.Equal(t, XLM, ob.Pair().Base)
+
+	// empty sides stay empty
+	empty := MakeOrderBook(nil, nil, []Order{}).Clone()
+	assert.Nil(t, empty.Pair())
+	assert.Nil(t, empty.Asks())
+	assert.Equal(t, []Order{}, empty.Bids())
+}
+
+func TestOrderBookImbalance(t *testing.T) {
+	testCases := []struct {
+		name   string
+		ob     *OrderBook
+		levels int
+		want   float64
+	}{
+		{
+			name:   "balanced",
+			ob:     MakeOrderBook(testPair, makeTestAsks(1.1, 5.0, 1.2, 3.0), makeTestBids(0.9, 4.0, 0.8, 4.0)),
+			levels: 2,
+			want:   0.0,
+		}, {
+			name:   "bid heavy",
+			ob:     MakeOrderBook(testPair, makeTestAsks(1.1, 2.0, 1.2, 2.0), makeTestBids(0.9, 6.0, 0.8, 6.0)),
+			levels: 2,
+			want:   0.5,
+		}, {
+			name:   "ask heavy",
+			ob:     MakeOrderBook(testPair, makeTestAsks(1.1, 9.0), makeTestBids(0.9, 1.0)),
+			levels: 1,
+			want:   -0.8,
+		}, {
+			name:   "only the top levels count",
+			ob:     MakeOrderBook(testPair, makeTestAsks(1.1, 5.0, 1.2, 100.0), makeTestBids(0.9, 5.0)),
+			levels: 1,
+			want:   0.0,
+		}, {
+			name:   "no bids",
+			ob:     MakeOrderBook(testPair, makeTestAsks(1.1, 5.0), []Order{}),
+			levels: 3,
+			want:   -1.0,
+		}, {
+			name:   "empty book",
+			ob:     MakeOrderBook(testPair, []Order{}, []Order{}),
+			levels: 3,
+			want:   0.0,
+		}, {
+			name:   "zero levels",
+			ob:     MakeOrderBook(testPair, makeTestAsks(1.1, 5.0), makeTestBids(0.9, 1.0)),
+			levels: 0,
+			want:   0.0,
+		},
+	}
+
+	for _, kase := range testCases {
+		t.Run(kase.name, func(t *testing.T) {
+			assert.InDelta(t, kase.want, kase.ob.Imbalance(kase.levels), floatEpsilon)
+		})
+	}
+}
+
+func TestOrderBookSpreadAndMidPrice(t *testing.T) {
+	testCases := []struct {
+		name          string
+		ob            *OrderBook
+		wantErr       bool
+		wantSpread    float64
+		wantMid       float64
+		wantSpreadPct float64
+	}{
+		{
+			name:          "both sides",
+			ob:            MakeOrderBook(testPair, makeTestAsks(1.1, 5.0, 1.2, 3.0), makeTestBids(0.9, 4.0, 0.8, 2.0)),
+			wantSpread:    0.2,
+			wantMid:       1.0,
+			wantSpreadPct: 0.2,
+		}, {
+			name:          "tight spread",
+			ob:            MakeOrderBook(testPair, makeTestAsks(100.5, 1.0), makeTestBids(99.5, 1.0)),
+			wantSpread:    1.0,
+			wantMid:       100.0,
+			wantSpreadPct: 0.01,
+		}, {
+			name:    "empty asks",
+			ob:      MakeOrderBook(testPair, []Order{}, makeTestBids(0.9, 4.0)),
+			wantErr: true,
+		}, {
+			name:    "empty bids",
+			ob:      MakeOrderBook(testPair, makeTestAsks(1.1, 5.0), []Order{}),
+			wantErr: true,
+		},
+	}
+
+	for _, kase := range testCases {
+		t.Run(kase.name, func(t *testing.T) {
+			spread, e := kase.ob.Spread()
+			midPrice, e2 := kase.ob.MidPrice()
+			spreadPct, e3 := kase.ob.SpreadPct()
+			if kase.wantErr {
+				assert.Error(t, e)
+				assert.Error(t, e2)
+				assert.Error(t, e3)
+				return
+			}
+			if !assert.NoError(t, e) || !assert.NoError(t, e2) || !assert.NoError(t, e3) {
+				return
+			}
+			assert.InDelta(t, kase.wantSpread, spread.AsFloat(), 1e-9)
+			assert.InDelta(t, kase.wantMid, midPrice.AsFloat(), 1e-9)
+			assert.InDelta(t, kase.wantSpreadPct, spreadPct.AsFloat(), 1e-9)
+		})
+	}
+
+	// the mid price of a book with zero prices is zero, which has no spread as a fraction of the mid price
+	_, e := MakeOrderBook(testPair, makeTestAsks(0.0, 1.0), makeTestBids(0.0, 1.0)).SpreadPct()
+	assert.Error(t, e)
+}
+
+func TestOrderBookDropBelowMinNotional(t *testing.T) {
+	// notionals of the asks are 5.5, 1.2, 10.0 and of the bids are 4.5, 0.8, 9.0
+	ob := MakeOrderBook(testPair, makeTestAsks(1.1, 5.0, 1.2, 1.0, 1.25, 8.0), makeTestBids(0.9, 5.0, 0.8, 1.0, 0.75, 12.0))
+
+	testCases := []struct {
+		name     string
+		min      *Number
+		wantAsks []Order
+		wantBids []Order
+	}{
+		{
+			name:     "levels straddling the threshold",
+			min:      NumberFromFloat(5.0, 7),
+			wantAsks: makeTestAsks(1.1, 5.0, 1.25, 8.0),
+			wantBids: makeTestBids(0.75, 12.0),
+		}, {
+			name:     "level exactly at the threshold is kept",
+			min:      NumberFromFloat(4.5, 7),
+			wantAsks: makeTestAsks(1.1, 5.0, 1.25, 8.0),
+			wantBids: makeTestBids(0.9, 5.0, 0.75, 12.0),
+		}, {
+			name:     "threshold above all levels",
+			min:      NumberFromFloat(100.0, 7),
+			wantAsks: []Order{},
+			wantBids: []Order{},
+		}, {
+			name:     "nil threshold",
+			min:      nil,
+			wantAsks: ob.Asks(),
+			wantBids: ob.Bids(),
+		},
+	}
+
+	for _, kase := range testCases {
+		t.Run(kase.name, func(t *testing.T) {
+			filtered := ob.DropBelowMinNotional(kase.min)
+			assertPriceLevels(t, kase.wantAsks, filtered.Asks())
+			assertPriceLevels(t, kase.wantBids, filtered.Bids())
+		})
+	}
+
+	// original book is unchanged
+	assert.Equal(t, 3, len(ob.Asks()))
+	assert.Equal(t, 3, len(ob.Bids()))
+}
+
+func TestOrderBookCanonicalString(t *testing.T) {
+	asks := makeTestAsks(1.1, 5.0, 1.1, 2.0, 1.2, 3.0)
+	bids := makeTestBids(0.9, 4.0, 0.8, 1.0)
+	ob := MakeOrderBook(testPair, asks, bids)
+	want := "XLM/USDT|asks=1.1000000000@2.0000000000,1.1000000000@5.0000000000,1.2000000000@3.0000000000|bids=0.9000000000@4.0000000000,0.8000000000@1.0000000000"
+	assert.Equal(t, want, ob.CanonicalString())
+
+	// the same orders in a different order and with a different precision give the same canonical string
+	shuffled := MakeOrderBook(
+		testPair,
+		[]Order{asks[2], asks[0], asks[1]},
+		[]Order{
+			{Pair: testPair, OrderAction: OrderActionBuy, OrderType: OrderTypeLimit, Price: NumberFromFloat(0.8, 2), Volume: NumberFromFloat(1.0, 3)},
+			bids[0],
+		},
+	)
+	shuffled.asks[0].Timestamp = MakeTimestamp(1000)
+	assert.Equal(t, want, shuffled.CanonicalString())
+
+	// a different volume gives a different canonical string
+	changed := MakeOrderBook(testPair, makeTestAsks(1.1, 5.0, 1.1, 2.0, 1.2, 3.5), bids)
+	assert.NotEqual(t, want, changed.CanonicalString())
+
+	assert.Equal(t, "XLM/USDT|asks=|bids=", MakeOrderBook(testPair, []Order{}, []Order{}).CanonicalString())
+}
+
+func TestOrderBookDropOwn(t *testing.T) {
+	withOwner := func(o Order, owner string) Order {
+		o.Owner = owner
+		return o
+	}
+	asks := []Order{
+		withOwner(makeTestOrder(OrderActionSell, 1.1, 5.0), "me"),
+		withOwner(makeTestOrder(OrderActionSell, 1.1, 2.0), "market"),
+		makeTestOrder(OrderActionSell, 1.2, 3.0),
+	}
+	bids := []Order{
+		withOwner(makeTestOrder(OrderActionBuy, 0.9, 5.0), "market"),
+		withOwner(makeTestOrder(OrderActionBuy, 0.9, 1.0), "me2"),
+		withOwner(makeTestOrder(OrderActionBuy, 0.8, 4.0), "me"),
+	}
+	ob := MakeOrderBook(testPair, asks, bids)
+
+	cleaned := ob.DropOwn([]string{"me", "me2"})
+	assert.Equal(t, testPair, cleaned.Pair())
+	assert.Equal(t, []Order{asks[1], asks[2]}, cleaned.Asks())
+	assert.Equal(t, []Order{bids[0]}, cleaned.Bids())
+
+	// original book is unchanged
+	assert.Equal(t, asks, ob.Asks())
+	assert.Equal(t, bids, ob.Bids())
+
+	// no accounts drops nothing
+	assert.Equal(t, asks, ob.DropOwn([]string{}).Asks())
+	assert.Equal(t, bids, ob.DropOwn(nil).Bids())
+}
+
+func TestOrderBookDetectEvenlySpacedLayering(t *testing.T) {
+	testCases := []struct {
+		name      string
+		asks      []Order
+		bids      []Order
+		action    OrderAction
+		tolerance *Number
+		wantRun   []Order
+	}{
+		{
+			name:    "layered asks",
+			asks:    makeTestAsks(1.0, 3.0, 1.1, 5.0, 1.2, 5.0, 1.3, 5.0, 1.4, 5.0, 1.6, 5.0),
+			bids:    makeTestBids(0.9, 4.0),
+			action:  OrderActionSell,
+			wantRun: makeTestAsks(1.1, 5.0, 1.2, 5.0, 1.3, 5.0, 1.4, 5.0),
+		}, {
+			name:    "layered bids",
+			asks:    makeTestAsks(1.1, 4.0),
+			bids:    makeTestBids(0.9, 2.0, 0.85, 2.0, 0.8, 2.0, 0.7, 1.0),
+			action:  OrderActionBuy,
+			wantRun: makeTestBids(0.9, 2.0, 0.85, 2.0, 0.8, 2.0),
+		}, {
+			name:    "layered bids are not on the sell side",
+			asks:    makeTestAsks(1.1, 4.0),
+			bids:    makeTestBids(0.9, 2.0, 0.85, 2.0, 0.8, 2.0),
+			action:  OrderActionSell,
+			wantRun: nil,
+		}, {
+			name:    "normal book",
+			asks:    makeTestAsks(1.1, 4.0, 1.15, 2.5, 1.3, 7.0, 1.32, 7.0, 1.5, 7.0),
+			bids:    makeTestBids(0.9, 1.0, 0.8, 3.0),
+			action:  OrderActionSell,
+			wantRun: nil,
+		}, {
+			name:      "within the tolerance",
+			asks:      makeTestAsks(1.1, 5.0, 1.2, 5.01, 1.31, 4.99, 1.6, 5.0),
+			bids:      makeTestBids(0.9, 1.0),
+			action:    OrderActionSell,
+			tolerance: NumberFromFloat(0.02, 2),
+			wantRun:   makeTestAsks(1.1, 5.0, 1.2, 5.01, 1.31, 4.99),
+		}, {
+			name:    "outside the tolerance",
+			asks:    makeTestAsks(1.1, 5.0, 1.2, 5.01, 1.31, 4.99, 1.6, 5.0),
+			bids:    makeTestBids(0.9, 1.0),
+			action:  OrderActionSell,
+			wantRun: nil,
+		}, {
+			name:    "the same price level is not a run",
+			asks:    makeTestAsks(1.1, 5.0, 1.1, 5.0, 1.1, 5.0),
+			bids:    makeTestBids(0.9, 1.0),
+			action:  OrderActionSell,
+			wantRun: nil,
+		}, {
+			name:    "longest run wins",
+			asks:    makeTestAsks(1.0, 1.0, 1.1, 1.0, 1.2, 1.0, 1.25, 2.0, 1.3, 2.0, 1.35, 2.0, 1.4, 2.0),
+			bids:    makeTestBids(0.9, 1.0),
+			action:  OrderActionSell,
+			wantRun: makeTestAsks(1.25, 2.0, 1.3, 2.0, 1.35, 2.0, 1.4, 2.0),
+		}, {
+			name:    "empty side",
+			asks:    []Order{},
+			bids:    makeTestBids(0.9, 1.0),
+			action:  OrderActionSell,
+			wantRun: nil,
+		},
+	}
+
+	for _, kase := range testCases {
+		t.Run(kase.name, func(t *testing.T) {
+			ob := MakeOrderBook(testPair, kase.asks, kase.bids)
+			run, ok := ob.DetectEvenlySpacedLayering(kase.action, kase.tolerance)
+			if kase.wantRun == nil {
+				assert.False(t, ok)
+				assert.Nil(t, run)
+				return
+			}
+			if !assert.True(t, ok) {
+				return
+			}
+			assertPriceLevels(t, kase.wantRun, run)
+		})
+	}
+}
+
+func TestOrderBookMarketSpreadExcludingOwn(t *testing.T) {
+	withOwner := func(o Order, owner string) Order {
+		o.Owner = owner
+		return o
+	}
+	asks := []Order{
+		withOwner(makeTestOrder(OrderActionSell, 1.05, 5.0), "me"),
+		withOwner(makeTestOrder(OrderActionSell, 1.1, 2.0), "market"),
+		makeTestOrder(OrderActionSell, 1.2, 3.0),
+	}
+	bids := []Order{
+		withOwner(makeTestOrder(OrderActionBuy, 0.95, 5.0), "me"),
+		withOwner(makeTestOrder(OrderActionBuy, 0.9, 1.0), "me2"),
+		withOwner(makeTestOrder(OrderActionBuy, 0.8, 4.0), "market"),
+	}
+	ob := MakeOrderBook(testPair, asks, bids)
+
+	testCases := []struct {
+		name       string
+		accountIDs []string
+		wantSpread float64
+		wantOk     bool
+	}{
+		{
+			name:       "no own accounts",
+			accountIDs: nil,
+			wantSpread: 0.1,
+			wantOk:     true,
+		}, {
+			name:       "own top of book on both sides",
+			accountIDs: []string{"me"},
+			wantSpread: 0.2,
+			wantOk:     true,
+		}, {
+			name:       "own top two bids",
+			accountIDs: []string{"me", "me2"},
+			wantSpread: 0.3,
+			wantOk:     true,
+		}, {
+			name:       "unknown account",
+			accountIDs: []string{"other"},
+			wantSpread: 0.1,
+			wantOk:     true,
+		}, {
+			name:       "all bids are own",
+			accountIDs: []string{"me", "me2", "market"},
+			wantOk:     false,
+		},
+	}
+
+	for _, kase := range testCases {
+		t.Run(kase.name, func(t *testing.T) {
+			spread, ok := ob.MarketSpreadExcludingOwn(kase.accountIDs)
+			if !assert.Equal(t, kase.wantOk, ok) {
+				return
+			}
+			if !ok {
+				assert.Nil(t, spread)
+				return
+			}
+			assert.InDelta(t, kase.wantSpread, spread.AsFloat(), 1e-9)
+		})
+	}
+
+	// original book is unchanged
+	assert.Equal(t, asks, ob.Asks())
+	assert.Equal(t, bids, ob.Bids())
+}
+
+func TestOrderBookNormalizeVolumesToBase(t *testing.T) {
+	onExchange := func(o Order, exchange string) Order {
+		o.Exchange = exchange
+		return o
+	}
+	asks := []Order{
+		onExchange(makeTestOrder(OrderActionSell, 1.1, 5.0), "exchangeA"),
+		onExchange(makeTestOrder(OrderActionSell, 1.1, 200.0), "exchangeB"),
+		onExchange(makeTestOrder(OrderActionSell, 1.2, 3.0), "exchangeC"),
+	}
+	bids := []Order{
+		onExchange(makeTestOrder(OrderActionBuy, 0.9, 400.0), "exchangeB"),
+		makeTestOrder(OrderActionBuy, 0.9, 1.0),
+		onExchange(makeTestOrder(OrderActionBuy, 0.8, 4.0), "exchangeA"),
+	}
+	ob := MakeOrderBook(testPair, asks, bids)
+
+	// exchangeB quotes volume in hundredths of the base unit
+	normalized := ob.NormalizeVolumesToBase(map[string]*Number{
+		"exchangeA": NumberFromFloat(1.0, 7),
+		"exchangeB": NumberFromFloat(0.01, 7),
+	})
+
+	// the orders from exchangeC and the order with an empty exchange are dropped since they have no converter
+	assertPriceLevels(t, makeTestAsks(1.1, 5.0, 1.1, 2.0), normalized.Asks())
+	assertPriceLevels(t, makeTestBids(0.9, 4.0, 0.8, 4.0), normalized.Bids())
+	assert.Equal(t, "exchangeB", normalized.Asks()[1].Exchange)
+
+	// original book is unchanged
+	assert.Equal(t, asks, ob.Asks())
+	assert.Equal(t, bids, ob.Bids())
+}
+
+func TestOrderBookVolumeByExchange(t *testing.T) {
+	onExchange := func(o Order, exchange string) Order {
+		o.Exchange = exchange
+		return o
+	}
+	asks := []Order{
+		onExchange(makeTestOrder(OrderActionSell, 1.1, 5.0), "exchangeA"),
+		onExchange(makeTestOrder(OrderActionSell, 1.1, 2.0), "exchangeB"),
+		onExchange(makeTestOrder(OrderActionSell, 1.2, 3.0), "exchangeA"),
+		makeTestOrder(OrderActionSell, 1.3, 1.5),
+	}
+	bids := []Order{
+		onExchange(makeTestOrder(OrderActionBuy, 0.9, 4.0), "exchangeB"),
+		onExchange(makeTestOrder(OrderActionBuy, 0.8, 6.0), "exchangeB"),
+	}
+	ob := MakeOrderBook(testPair, asks, bids)
+
+	toFloats := func(volumes map[string]*Number) map[string]float64 {
+		m := map[string]float64{}
+		for exchange, v := range volumes {
+			m[exchange] = v.AsFloat()
+		}
+		return m
+	}
+	assert.Equal(t, map[string]float64{"exchangeA": 8.0, "exchangeB": 2.0, "": 1.5}, toFloats(ob.VolumeByExchange(OrderActionSell)))
+	assert.Equal(t, map[string]float64{"exchangeB": 10.0}, toFloats(ob.VolumeByExchange(OrderActionBuy)))
+	assert.Equal(t, map[string]*Number{}, MakeOrderBook(testPair, []Order{}, []Order{}).VolumeByExchange(OrderActionSell))
+}
+
+func TestOrderBookRouteOrder(t *testing.T) {
+	onExchange := func(o Order, exchange string) Order {
+		o.Exchange = exchange
+		return o
+	}
+	asks := []Order{
+		onExchange(makeTestOrder(OrderActionSell, 1.1, 5.0), "exchangeA"),
+		onExchange(makeTestOrder(OrderActionSell, 1.1, 2.0), "exchangeB"),
+		onExchange(makeTestOrder(OrderActionSell, 1.2, 3.0), "exchangeA"),
+		onExchange(makeTestOrder(OrderActionSell, 1.3, 4.0), "exchangeB"),
+	}
+	bids := []Order{
+		onExchange(makeTestOrder(OrderActionBuy, 0.9, 4.0), "exchangeB"),
+		onExchange(makeTestOrder(OrderActionBuy, 0.8, 6.0), "exchangeA"),
+	}
+	ob := MakeOrderBook(testPair, asks, bids)
+
+	testCases := []struct {
+		name          string
+		action        OrderAction
+		volume        float64
+		wantExchanges []string
+		wantPrices    []float64
+		wantVolumes   []float64
+	}{
+		{
+			name:          "buy within the best venue",
+			action:        OrderActionBuy,
+			volume:        4.0,
+			wantExchanges: []string{"exchangeA"},
+			wantPrices:    []float64{1.1},
+			wantVolumes:   []float64{4.0},
+		}, {
+			name:          "buy across two venues",
+			action:        OrderActionBuy,
+			volume:        11.0,
+			wantExchanges: []string{"exchangeA", "exchangeB"},
+			wantPrices:    []float64{1.2, 1.3},
+			wantVolumes:   []float64{8.0, 3.0},
+		}, {
+			name:          "sell across two venues",
+			action:        OrderActionSell,
+			volume:        10.0,
+			wantExchanges: []string{"exchangeB", "exchangeA"},
+			wantPrices:    []float64{0.9, 0.8},
+			wantVolumes:   []float64{4.0, 6.0},
+		},
+	}
+
+	for _, kase := range testCases {
+		t.Run(kase.name, func(t *testing.T) {
+			routes, e := ob.RouteOrder(kase.action, NumberFromFloat(kase.volume, 7))
+			if !assert.NoError(t, e) || !assert.Equal(t, len(kase.wantExchanges), len(routes)) {
+				return
+			}
+			total := 0.0
+			for i, route := range routes {
+				assert.Equal(t, kase.wantExchanges[i], route.Exchange)
+				assert.Equal(t, kase.action, route.OrderAction)
+				assert.Equal(t, NumberFromFloat(kase.wantPrices[i], 7).AsString(), route.Price.AsString())
+				assert.Equal(t, NumberFromFloat(kase.wantVolumes[i], 7).AsString(), route.Volume.AsString())
+				total += route.Volume.AsFloat()
+			}
+			assert.InDelta(t, kase.volume, total, floatEpsilon)
+		})
+	}
+
+	_, e := ob.RouteOrder(OrderActionBuy, NumberFromFloat(15.0, 7))
+	assert.Error(t, e)
+	_, e = ob.RouteOrder(OrderActionSell, NumberFromFloat(0.0, 7))
+	assert.Error(t, e)
+	_, e = MakeOrderBook(testPair, []Order{}, []Order{}).RouteOrder(OrderActionSell, NumberFromFloat(1.0, 7))
+	assert.Error(t, e)
+}
+
+func TestOrderBookQuantizePrices(t *testing.T) {
+	ob := MakeOrderBook(
+		testPair,
+		makeTestAsks(1.1234, 5.0, 1.1271, 3.0, 1.13, 2.0, 1.1452, 1.0),
+		makeTestBids(1.1199, 4.0, 1.1150, 2.0, 1.11, 1.0),
+	)
+
+	quantized := ob.QuantizePrices(NumberFromFloat(0.01, 7))
+
+	// asks rounded down toward the spread, the first two asks merge onto 1.12
+	wantAsks := makeTestAsks(1.12, 8.0, 1.13, 2.0, 1.14, 1.0)
+	// bids rounded up toward the spread, the first two bids merge onto 1.12
+	wantBids := makeTestBids(1.12, 6.0, 1.11, 1.0)
+
+	assertPriceLevels(t, wantAsks, quantized.Asks())
+	assertPriceLevels(t, wantBids, quantized.Bids())
+
+	// original book is not modified
+	assert.Equal(t, "1.1234000", ob.Asks()[0].Price.AsString())
+	assert.Equal(t, "5.0000000", ob.Asks()[0].Volume.AsString())
+	assert.Equal(t, 4, len(ob.Asks()))
+}
+
+func TestOrderBookPriceStats(t *testing.T) {
+	ob := MakeOrderBook(testPair, makeTestAsks(1.0, 2.0, 1.1, 3.0, 1.5, 5.0), makeTestBids(0.9, 1.0, 0.8, 1.0, 0.4, 2.0))
+
+	testCases := []struct {
+		name    string
+		action  OrderAction
+		wantMin float64
+		wantMax float64
+		wantAvg float64
+	}{
+		{
+			name:    "asks",
+			action:  OrderActionSell,
+			wantMin: 1.0,
+			wantMax: 1.5,
+			wantAvg: 1.28, // (2.0 + 3.3 + 7.5) / 10
+		}, {
+			name:    "bids",
+			action:  OrderActionBuy,
+			wantMin: 0.4,
+			wantMax: 0.9,
+			wantAvg: 0.625, // (0.9 + 0.8 + 0.8) / 4
+		},
+	}
+
+	for _, kase := range testCases {
+		t.Run(kase.name, func(t *testing.T) {
+			min, max, avg, ok := ob.PriceStats(kase.action)
+			if !assert.True(t, ok) {
+				return
+			}
+			assert.InDelta(t, kase.wantMin, min.AsFloat(), 1e-9)
+			assert.InDelta(t, kase.wantMax, max.AsFloat(), 1e-9)
+			assert.InDelta(t, kase.wantAvg, avg.AsFloat(), 1e-7)
+		})
+	}
+
+	empty := MakeOrderBook(testPair, []Order{}, makeTestBids(0.9, 1.0))
+	_, _, _, ok := empty.PriceStats(OrderActionSell)
+	assert.False(t, ok)
+}
+
+func TestOrderBookShiftPrices(t *testing.T) {
+	ob := MakeOrderBook(testPair, makeTestAsks(1.1, 5.0, 1.2, 3.0), makeTestBids(0.9, 4.0, 0.5, 2.0, 0.2, 1.0))
+
+	testCases := []struct {
+		name     string
+		delta    float64
+		wantAsks []Order
+		wantBids []Order
+	}{
+		{
+			name:     "shift up",
+			delta:    0.15,
+			wantAsks: makeTestAsks(1.25, 5.0, 1.35, 3.0),
+			wantBids: makeTestBids(1.05, 4.0, 0.65, 2.0, 0.35, 1.0),
+		}, {
+			name:     "shift down",
+			delta:    -0.1,
+			wantAsks: makeTestAsks(1.0, 5.0, 1.1, 3.0),
+			wantBids: makeTestBids(0.8, 4.0, 0.4, 2.0, 0.1, 1.0),
+		}, {
+			name:     "shift down drops non-positive prices",
+			delta:    -0.2,
+			wantAsks: makeTestAsks(0.9, 5.0, 1.0, 3.0),
+			wantBids: makeTestBids(0.7, 4.0, 0.3, 2.0),
+		},
+	}
+
+	for _, kase := range testCases {
+		t.Run(kase.name, func(t *testing.T) {
+			shifted := ob.ShiftPrices(NumberFromFloat(kase.delta, 7))
+			assert.NoError(t, shifted.IsValid())
+
+			assertPriceLevels(t, kase.wantAsks, shifted.Asks())
+			assertPriceLevels(t, kase.wantBids, shifted.Bids())
+		})
+	}
+
+	// original book is unchanged
+	assert.Equal(t, "1.1000000", ob.Asks()[0].Price.AsString())
+	assert.Equal(t, 3, len(ob.Bids()))
+}
+
+func TestOrderBookPriceAtVolumeFraction(t *testing.T) {
+	ob := MakeOrderBook(testPair, makeTestAsks(1.0, 2.0, 1.1, 3.0, 1.2, 5.0), makeTestBids(0.9, 6.0, 0.8, 1.0, 0.7, 1.0))
+
+	testCases := []struct {
+		name      string
+		action    OrderAction
+		fraction  float64
+		wantPrice float64
+		wantOk    bool
+	}{
+		{
+			name:      "asks at 0",
+			action:    OrderActionSell,
+			fraction:  0.0,
+			wantPrice: 1.0,
+			wantOk:    true,
+		}, {
+			name:      "asks at 0.5",
+			action:    OrderActionSell,
+			fraction:  0.5,
+			wantPrice: 1.1, // cumulative volume of 5.0 reaches exactly half of 10.0
+			wantOk:    true,
+		}, {
+			name:      "asks at 1.0",
+			action:    OrderActionSell,
+			fraction:  1.0,
+			wantPrice: 1.2,
+			wantOk:    true,
+		}, {
+			name:      "bids at 0",
+			action:    OrderActionBuy,
+			fraction:  0.0,
+			wantPrice: 0.9,
+			wantOk:    true,
+		}, {
+			name:      "bids at 0.5",
+			action:    OrderActionBuy,
+			fraction:  0.5,
+			wantPrice: 0.9, // top level holds 6.0 of the 8.0 total
+			wantOk:    true,
+		}, {
+			name:      "bids at 1.0",
+			action:    OrderActionBuy,
+			fraction:  1.0,
+			wantPrice: 0.7,
+			wantOk:    true,
+		}, {
+			name:     "negative fraction",
+			action:   OrderActionSell,
+			fraction: -0.1,
+			wantOk:   false,
+		}, {
+			name:     "fraction above 1",
+			action:   OrderActionBuy,
+			fraction: 1.1,
+			wantOk:   false,
+		},
+	}
+
+	for _, kase := range testCases {
+		t.Run(kase.name, func(t *testing.T) {
+			price, ok := ob.PriceAtVolumeFraction(kase.action, kase.fraction)
+			if !assert.Equal(t, kase.wantOk, ok) || !ok {
+				return
+			}
+			assert.InDelta(t, kase.wantPrice, price.AsFloat(), 1e-9)
+		})
+	}
+
+	empty := MakeOrderBook(testPair, []Order{}, []Order{})
+	_, ok := empty.PriceAtVolumeFraction(OrderActionSell, 0.5)
+	assert.False(t, ok)
+}
+
+// makeTestShapedBook makes a book with the same shape relative to the passed in mid price
+func makeTestShapedBook(mid float64) *OrderBook {
+	return MakeOrderBook(
+		testPair,
+		makeTestAsks(mid*1.001, 1.0, mid*1.004, 1.0, mid*1.03, 2.0),
+		makeTestBids(mid*0.999, 1.0, mid*0.9955, 2.0, mid*0.98, 5.0),
+	)
+}
+
+func TestOrderBookRelativeDepthWithinBps(t *testing.T) {
+	testCases := []struct {
+		name        string
+		ob          *OrderBook
+		bps         float64
+		wantBidFrac float64
+		wantAskFrac float64
+		wantOk      bool
+	}{
+		{
+			name:        "mid of 1",
+			ob:          makeTestShapedBook(1.0),
+			bps:         50,
+			wantBidFrac: 0.375,
+			wantAskFrac: 0.5,
+			wantOk:      true,
+		}, {
+			name:        "mid of 0.01",
+			ob:          makeTestShapedBook(0.01),
+			bps:         50,
+			wantBidFrac: 0.375,
+			wantAskFrac: 0.5,
+			wantOk:      true,
+		}, {
+			name:        "mid of 10000",
+			ob:          makeTestShapedBook(10000.0),
+			bps:         50,
+			wantBidFrac: 0.375,
+			wantAskFrac: 0.5,
+			wantOk:      true,
+		}, {
+			name:        "within the spread",
+			ob:          makeTestShapedBook(10000.0),
+			bps:         5,
+			wantBidFrac: 0.0,
+			wantAskFrac: 0.0,
+			wantOk:      true,
+		}, {
+			name:        "whole book",
+			ob:          makeTestShapedBook(0.01),
+			bps:         500,
+			wantBidFrac: 1.0,
+			wantAskFrac: 1.0,
+			wantOk:      true,
+		}, {
+			name:   "negative bps",
+			ob:     makeTestShapedBook(1.0),
+			bps:    -1,
+			wantOk: false,
+		}, {
+			name:   "empty side",
+			ob:     MakeOrderBook(testPair, makeTestAsks(1.1, 1.0), []Order{}),
+			bps:    50,
+			wantOk: false,
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			bidFrac, askFrac, ok := k.ob.RelativeDepthWithinBps(k.bps)
+			if !assert.Equal(t, k.wantOk, ok) || !ok {
+				return
+			}
+			assert.InDelta(t, k.wantBidFrac, bidFrac.AsFloat(), floatEpsilon)
+			assert.InDelta(t, k.wantAskFrac, askFrac.AsFloat(), floatEpsilon)
+		})
+	}
+
+	// the absolute figures that are normalized
+	ob := makeTestShapedBook(10000.0)
+	bidVolume, askVolume, ok := ob.LiquidityWithinBps(50)
+	if assert.True(t, ok) {
+		assert.Equal(t, 3.0, bidVolume.AsFloat())
+		assert.Equal(t, 2.0, askVolume.AsFloat())
+	}
+	assert.Equal(t, 8.0, ob.TotalVolume(OrderActionBuy).AsFloat())
+	assert.Equal(t, 4.0, ob.TotalVolume(OrderActionSell).AsFloat())
+	assert.Equal(t, 0.0, MakeOrderBook(testPair, []Order{}, []Order{}).TotalVolume(OrderActionSell).AsFloat())
+}
+
+func TestOrderBookFeeAdjusted(t *testing.T) {
+	ob := MakeOrderBook(testPair, makeTestAsks(1.0, 5.0, 1.2, 3.0), makeTestBids(0.9, 4.0, 0.5, 2.0))
+
+	testCases := []struct {
+		name     string
+		makerFee *Number
+		takerFee *Number
+		wantAsks []Order
+		wantBids []Order
+	}{
+		{
+			name:     "no fees",
+			makerFee: NumberFromFloat(0.0, 7),
+			takerFee: NumberFromFloat(0.0, 7),
+			wantAsks: makeTestAsks(1.0, 5.0, 1.2, 3.0),
+			wantBids: makeTestBids(0.9, 4.0, 0.5, 2.0),
+		}, {
+			name:     "nil fees",
+			wantAsks: makeTestAsks(1.0, 5.0, 1.2, 3.0),
+			wantBids: makeTestBids(0.9, 4.0, 0.5, 2.0),
+		}, {
+			name:     "asymmetric fees",
+			makerFee: NumberFromFloat(0.001, 7),
+			takerFee: NumberFromFloat(0.002, 7),
+			wantAsks: makeTestAsks(1.002, 5.0, 1.2024, 3.0),
+			wantBids: makeTestBids(0.8991, 4.0, 0.4995, 2.0),
+		}, {
+			name:     "nil maker fee",
+			takerFee: NumberFromFloat(0.002, 7),
+			wantAsks: makeTestAsks(1.002, 5.0, 1.2024, 3.0),
+			wantBids: makeTestBids(0.9, 4.0, 0.5, 2.0),
+		}, {
+			name:     "nil taker fee",
+			makerFee: NumberFromFloat(0.001, 7),
+			wantAsks: makeTestAsks(1.0, 5.0, 1.2, 3.0),
+			wantBids: makeTestBids(0.8991, 4.0, 0.4995, 2.0),
+		}, {
+			name:     "maker rebate",
+			makerFee: NumberFromFloat(-0.001, 7),
+			takerFee: NumberFromFloat(0.002, 7),
+			wantAsks: makeTestAsks(1.002, 5.0, 1.2024, 3.0),
+			wantBids: makeTestBids(0.9009, 4.0, 0.5005, 2.0),
+		},
+	}
+
+	for _, kase := range testCases {
+		t.Run(kase.name, func(t *testing.T) {
+			adjusted := ob.FeeAdjusted(kase.makerFee, kase.takerFee)
+			assert.Equal(t, testPair, adjusted.Pair())
+			assertPriceLevels(t, kase.wantAsks, adjusted.Asks())
+			assertPriceLevels(t, kase.wantBids, adjusted.Bids())
+		})
+	}
+
+	// original book is unchanged
+	assert.Equal(t, "1.0000000", ob.Asks()[0].Price.AsString())
+	assert.Equal(t, "0.9000000", ob.Bids()[0].Price.AsString())
+}
+
+func TestLadderOrders(t *testing.T) {
+	testCases := []struct {
+		name       string
+		action     OrderAction
+		start      float64
+		stepBps    float64
+		levels     int
+		wantPrices []float64
+		wantErr    bool
+	}{
+		{
+			name:       "sell ladder steps up",
+			action:     OrderActionSell,
+			start:      1.0,
+			stepBps:    50,
+			levels:     4,
+			wantPrices: []float64{1.0, 1.005, 1.01, 1.015},
+		}, {
+			name:       "buy ladder steps down",
+			action:     OrderActionBuy,
+			start:      2.0,
+			stepBps:    100,
+			levels:     3,
+			wantPrices: []float64{2.0, 1.98, 1.96},
+		}, {
+			name:       "buy ladder stops before non-positive prices",
+			action:     OrderActionBuy,
+			start:      1.0,
+			stepBps:    4000,
+			levels:     5,
+			wantPrices: []float64{1.0, 0.6, 0.2},
+		}, {
+			name:       "no levels",
+			action:     OrderActionSell,
+			start:      1.0,
+			stepBps:    50,
+			levels:     0,
+			wantPrices: []float64{},
+		}, {
+			name:    "zero step",
+			action:  OrderActionSell,
+			start:   1.0,
+			stepBps: 0,
+			levels:  3,
+			wantErr: true,
+		}, {
+			name:    "negative step",
+			action:  OrderActionBuy,
+			start:   1.0,
+			stepBps: -50,
+			levels:  3,
+			wantErr: true,
+		},
+	}
+
+	for _, kase := range testCases {
+		t.Run(kase.name, func(t *testing.T) {
+			size := NumberFromFloat(10.0, 7)
+			orders, e := LadderOrders(testPair, kase.action, NumberFromFloat(kase.start, 7), kase.stepBps, kase.levels, size)
+			if kase.wantErr {
+				assert.Error(t, e)
+				return
+			}
+			if !assert.NoError(t, e) {
+				return
+			}
+			if !assert.Equal(t, len(kase.wantPrices), len(orders)) {
+				return
+			}
+			for i, o := range orders {
+				assert.InDelta(t, kase.wantPrices[i], o.Price.AsFloat(), 1e-9, "price at index %d", i)
+				assert.Equal(t, size, o.Volume)
+				assert.Equal(t, kase.action, o.OrderAction)
+				assert.Equal(t, OrderTypeLimit, o.OrderType)
+				assert.Equal(t, testPair, o.Pair)
+			}
+
+			var ob *OrderBook
+			if kase.action.IsSell() {
+				ob = MakeOrderBook(testPair, orders, []Order{})
+			} else {
+				ob = MakeOrderBook(testPair, []Order{}, orders)
+			}
+			assert.NoError(t, ob.Validate())
+		})
+	}
+}
+
+func TestOpenOrderRemainingVolumeAndFillPercent(t *testing.T) {
+	testCases := []struct {
+		name           string
+		volume         float64
+		volumeExecuted *Number
+		wantRemaining  float64
+		wantFill       float64
+	}{
+		{name: "fresh order without executed volume", volume: 10.0, volumeExecuted: nil, wantRemaining: 10.0, wantFill: 0.0},
+		{name: "unfilled", volume: 10.0, volumeExecuted: NumberFromFloat(0.0, 7), wantRemaining: 10.0, wantFill: 0.0},
+		{name: "partially filled", volume: 10.0, volumeExecuted: NumberFromFloat(2.5, 7), wantRemaining: 7.5, wantFill: 0.25},
+		{name: "fully filled", volume: 10.0, volumeExecuted: NumberFromFloat(10.0, 7), wantRemaining: 0.0, wantFill: 1.0},
+		{name: "overfilled", volume: 10.0, volumeExecuted: NumberFromFloat(12.0, 7), wantRemaining: 0.0, wantFill: 1.0},
+		{name: "zero volume", volume: 0.0, volumeExecuted: nil, wantRemaining: 0.0, wantFill: 0.0},
+	}
+
+	for _, kase := range testCases {
+		t.Run(kase.name, func(t *testing.T) {
+			o := OpenOrder{
+				Order:          makeTestOrder(OrderActionSell, 1.1, kase.volume),
+				ID:             "order1",
+				VolumeExecuted: kase.volumeExecuted,
+			}
+			assert.InDelta(t, kase.wantRemaining, o.RemainingVolume().AsFloat(), floatEpsilon)
+			assert.InDelta(t, kase.wantFill, o.FillPercent(), floatEpsilon)
+		})
+	}
+}
+
+func TestOpenOrderIsExpired(t *testing.T) {
+	testCases := []struct {
+		name       string
+		expireTime *Timestamp
+		now        *Timestamp
+		want       bool
+	}{
+		{name: "never expires with a zero expire time", expireTime: MakeTimestamp(0), now: MakeTimestamp(5000), want: false},
+		{name: "never expires with a nil expire time", expireTime: nil, now: MakeTimestamp(5000), want: false},
+		{name: "expire time before now", expireTime: MakeTimestamp(4999), now: MakeTimestamp(5000), want: true},
+		{name: "expire time at now", expireTime: MakeTimestamp(5000), now: MakeTimestamp(5000), want: false},
+		{name: "expire time after now", expireTime: MakeTimestamp(5001), now: MakeTimestamp(5000), want: false},
+		{name: "nil now", expireTime: MakeTimestamp(4999), now: nil, want: false},
+	}
+
+	for _, kase := range testCases {
+		t.Run(kase.name, func(t *testing.T) {
+			o := OpenOrder{
+				Order:      makeTestOrder(OrderActionSell, 1.1, 5.0),
+				ID:         "order1",
+				ExpireTime: kase.expireTime,
+			}
+			assert.Equal(t, kase.want, o.IsExpired(kase.now))
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package model
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SafeOrderBook is an orderbook that is safe for concurrent use, which is updated one price level at a time and pushes snapshots of the book
+// to its subscribers whenever it changes, so strategies can react to changes in the book without polling
+type SafeOrderBook struct {
+	mutex sync.Mutex
+	book  *OrderBook
+	// minInterval is the minimum time between two snapshots pushed to subscribers, rapid changes within this interval are debounced
+	minInterval      time.Duration
+	subscribers      map[int]chan *OrderBook
+	nextSubscriberID int
+	lastPublished    time.Time
+	publishPending   bool
+}
+
+// MakeSafeOrderBook makes a SafeOrderBook starting from the passed in book, a minInterval of zero pushes a snapshot on every change
+func MakeSafeOrderBook(book *OrderBook, minInterval time.Duration) *SafeOrderBook {
+	return &SafeOrderBook{
+		book:        copyOrderBook(book),
+		minInterval: minInterval,
+		subscribers: map[int]chan *OrderBook{},
+	}
+}
+
+// copyOrderBook copies the lists of orders so the copy can be modified independently of the original book
+func copyOrderBook(book *OrderBook) *OrderBook {
+	return MakeOrderBook(book.pair, append([]Order{}, book.asks...), append([]Order{}, book.bids...))
+}
+
+// Snapshot returns a copy of the current state of the book
+func (s *SafeOrderBook) Snapshot() *OrderBook {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return copyOrderBook(s.book)
+}
+
+// Upsert replaces all the orders on the price level of the passed in order with the order, or inserts it as a new price level in sorted
+// order, on the side of the book with the action of the order
+func (s *SafeOrderBook) Upsert(order Order) error {
+	if order.Price == nil || order.Volume == nil {
+		return fmt.Errorf("order needs a non-nil price and volume: %s", order)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if order.OrderAction.IsSell() {
+		s.book.asks = upsertLevel(s.book.asks, order, OrderActionSell)
+	} else {
+		s.book.bids = upsertLevel(s.book.bids, order, OrderActionBuy)
+	}
+	s.publishChange()
+	return nil
+}
+
+// ApplyDelta applies the change to a single price level to the book, adds and updates are both treated as an Upsert of a limit order
+func (s *SafeOrderBook) ApplyDelta(delta BookDelta) error {
+	if delta.Type == BookDeltaTypeAdd || delta.Type == BookDeltaTypeUpdate {
+		return s.Upsert(Order{
+			Pair:        s.book.pair,
+			OrderAction: delta.OrderAction,
+			OrderType:   OrderTypeLimit,
+			Price:       delta.Price,
+			Volume:      delta.Volume,
+		})
+	} else if delta.Type != BookDeltaTypeRemove {
+		return fmt.Errorf("unrecognized book delta type: %s", delta.Type)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var removed bool
+	if delta.OrderAction.IsSell() {
+		s.book.asks, removed = removeLevel(s.book.asks, delta.Price)
+	} else {
+		s.book.bids, removed = removeLevel(s.book.bids, delta.Price)
+	}
+	if !removed {
+		return fmt.Errorf("cannot remove price level that is not in the book: %s", delta)
+	}
+	s.publishChange()
+	return nil
+}
+
+// upsertLevel replaces the orders on the price level of the order with the order, otherwise inserts the order keeping the side sorted
+// (asks ascending for sell, bids descending for buy)
+func upsertLevel(orders []Order, order Order, action OrderAction) []Order {
+	orders, _ = removeLevel(orders, order.Price)
+	idx := len(orders)
+	for i, o := range orders {
+		if (action.IsSell() && o.Price.AsFloat() > order.Price.AsFloat()) || (action.IsBuy() && o.Price.AsFloat() < order.Price.AsFloat()) {
+			idx = i
+			break
+		}
+	}
+
+	updated := append([]Order{}, orders[:idx]...)
+	updated = append(updated, order)
+	return append(updated, orders[idx:]...)
+}
+
+// removeLevel removes all the orders on the passed in price, returning false if there were none
+func removeLevel(orders []Order, price *Number) ([]Order, bool) {
+	kept := []Order{}
+	for _, o := range orders {
+		if o.Price.AsFloat() != price.AsFloat() {
+			kept = append(kept, o)
+		}
+	}
+	return kept, len(kept) != len(orders)
+}
+
+// Subscribe returns a channel that receives a snapshot of the book whenever it changes, along with a function to cancel the subscription
+// which closes the channel. The channel only holds the latest snapshot, so a slow subscriber skips intermediate snapshots instead of
+// blocking the updates to the book.
+func (s *SafeOrderBook) Subscribe() (<-chan *OrderBook, func()) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	id := s.nextSubscriberID
+	s.nextSubscriberID++
+	ch := make(chan *OrderBook, 1)
+	s.subscribers[id] = ch
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			s.mutex.Lock()
+			defer s.mutex.Unlock()
+			delete(s.subscribers, id)
+			close(ch)
+		})
+	}
+	return ch, cancel
+}
+
+// publishChange pushes a snapshot to the subscribers, or schedules it when the last snapshot was pushed within the minInterval.
+// It needs to be called while holding the mutex.
+func (s *SafeOrderBook) publishChange() {
+	if s.publishPending {
+		// the scheduled snapshot will include this change
+		return
+	}
+
+	wait := s.minInterval - time.Since(s.lastPublished)
+	if wait <= 0 {
+		s.publish()
+		return
+	}
+
+	s.publishPending = true
+	time.AfterFunc(wait, func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.publishPending = false
+		s.publish()
+	})
+}
+
+// publish sends the current snapshot to every subscriber, replacing any snapshot that was not yet received.
+// It needs to be called while holding the mutex.
+func (s *SafeOrderBook) publish() {
+	s.lastPublished = time.Now()
+	for _, ch := range s.subscribers {
+		select {
+		case <-ch:
+		default:
+		}
+		// does not block because the channel is buffered and was just drained while holding the mutex
+		ch <- copyOrderBook(s.book)
+	}
+}
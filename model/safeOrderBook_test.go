@@ -0,0 +1,94 @@
+package model
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSafeOrderBookApplyDelta(t *testing.T) {
+	s := MakeSafeOrderBook(MakeOrderBook(testPair, makeTestAsks(1.1, 5.0, 1.3, 3.0), makeTestBids(0.9, 4.0)), 0)
+
+	assert.NoError(t, s.ApplyDelta(makeTestDelta(BookDeltaTypeAdd, OrderActionSell, 1.2, 1.0)))
+	assert.NoError(t, s.ApplyDelta(makeTestDelta(BookDeltaTypeUpdate, OrderActionSell, 1.1, 6.0)))
+	assert.NoError(t, s.ApplyDelta(makeTestDelta(BookDeltaTypeAdd, OrderActionBuy, 0.95, 2.0)))
+	assert.NoError(t, s.ApplyDelta(makeTestDelta(BookDeltaTypeRemove, OrderActionSell, 1.3, 0.0)))
+	assert.NoError(t, s.Upsert(makeTestOrder(OrderActionBuy, 0.8, 1.0)))
+	assert.Error(t, s.ApplyDelta(makeTestDelta(BookDeltaTypeRemove, OrderActionBuy, 0.5, 0.0)))
+
+	snapshot := s.Snapshot()
+	assert.NoError(t, snapshot.IsValid())
+	assertPriceLevels(t, makeTestAsks(1.1, 6.0, 1.2, 1.0), snapshot.Asks())
+	assertPriceLevels(t, makeTestBids(0.95, 2.0, 0.9, 4.0, 0.8, 1.0), snapshot.Bids())
+}
+
+// waitForSnapshot reads snapshots until one has the wanted top ask volume, returning false on a timeout or when the channel is closed
+func waitForSnapshot(ch <-chan *OrderBook, wantTopAskVolume float64) (int, bool) {
+	received := 0
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case snapshot, ok := <-ch:
+			if !ok {
+				return received, false
+			}
+			received++
+			if snapshot.TopAsk().Volume.AsFloat() == wantTopAskVolume {
+				return received, true
+			}
+		case <-timeout:
+			return received, false
+		}
+	}
+}
+
+func TestSafeOrderBookSubscribe(t *testing.T) {
+	s := MakeSafeOrderBook(MakeOrderBook(testPair, makeTestAsks(1.1, 1.0), makeTestBids(0.9, 1.0)), 0)
+	ch1, cancel1 := s.Subscribe()
+	ch2, cancel2 := s.Subscribe()
+	defer cancel2()
+
+	var wg sync.WaitGroup
+	for i := 2; i <= 20; i++ {
+		wg.Add(1)
+		go func(volume float64) {
+			defer wg.Done()
+			assert.NoError(t, s.Upsert(makeTestOrder(OrderActionBuy, 0.9, volume)))
+		}(float64(i))
+	}
+	wg.Wait()
+	assert.NoError(t, s.Upsert(makeTestOrder(OrderActionSell, 1.1, 50.0)))
+
+	// both subscribers eventually see the latest state of the book
+	for _, ch := range []<-chan *OrderBook{ch1, ch2} {
+		_, ok := waitForSnapshot(ch, 50.0)
+		assert.True(t, ok)
+	}
+
+	// cancelling closes the channel of that subscriber only, and cancelling twice is safe
+	cancel1()
+	cancel1()
+	assert.NoError(t, s.Upsert(makeTestOrder(OrderActionSell, 1.1, 60.0)))
+	_, ok := <-ch1
+	assert.False(t, ok)
+	_, ok = waitForSnapshot(ch2, 60.0)
+	assert.True(t, ok)
+}
+
+func TestSafeOrderBookSubscribeDebounce(t *testing.T) {
+	s := MakeSafeOrderBook(MakeOrderBook(testPair, makeTestAsks(1.1, 1.0), makeTestBids(0.9, 1.0)), 200*time.Millisecond)
+	ch, cancel := s.Subscribe()
+	defer cancel()
+
+	numUpdates := 50
+	for i := 1; i <= numUpdates; i++ {
+		assert.NoError(t, s.Upsert(makeTestOrder(OrderActionSell, 1.1, float64(i))))
+	}
+
+	// the rapid changes are debounced into the first change and one scheduled snapshot with the latest state
+	received, ok := waitForSnapshot(ch, float64(numUpdates))
+	assert.True(t, ok)
+	assert.True(t, received < numUpdates, "received %d snapshots for %d updates", received, numUpdates)
+}
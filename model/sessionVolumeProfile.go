@@ -0,0 +1,144 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// SessionVolumeProfile accumulates the volume of the trades executed on a trading pair over a session by price bucket, which gives the
+// session VWAP and the volume-by-price profile of the session so strategies can benchmark their fills against the session VWAP. Unlike the
+// analytics on an OrderBook snapshot this is a time-series accumulation that is fed one trade at a time. It is not safe for concurrent use.
+type SessionVolumeProfile struct {
+	pair       *TradingPair
+	bucketSize float64
+
+	// volumeByBucket is keyed by the index of the bucket, i.e. floor(price / bucketSize)
+	volumeByBucket map[int64]float64
+	totalVolume    float64
+	totalNotional  float64
+	numTrades      int
+	// pricePrecision is the highest precision of the prices of the trades in the session, used as the precision of the VWAP
+	pricePrecision int8
+}
+
+// VolumeAtPrice is the volume executed within the price bucket that starts at Price (inclusive)
+type VolumeAtPrice struct {
+	Price  *Number
+	Volume *Number
+}
+
+// String is the stringer method
+func (v VolumeAtPrice) String() string {
+	return fmt.Sprintf("%s@%s", v.Volume.AsString(), v.Price.AsString())
+}
+
+// MakeSessionVolumeProfile makes an empty SessionVolumeProfile for the trades on the pair, bucketing the volume by price into buckets of the
+// passed in size which needs to be positive
+func MakeSessionVolumeProfile(pair *TradingPair, bucketSize *Number) (*SessionVolumeProfile, error) {
+	if pair == nil {
+		return nil, fmt.Errorf("pair cannot be nil")
+	}
+	if bucketSize == nil || bucketSize.AsFloat() <= 0 {
+		return nil, fmt.Errorf("bucketSize needs to be positive but was %v", bucketSize)
+	}
+
+	p := &SessionVolumeProfile{
+		pair:       pair,
+		bucketSize: bucketSize.AsFloat(),
+	}
+	p.Reset()
+	return p, nil
+}
+
+// Reset clears the accumulated volume to start a new session
+func (p *SessionVolumeProfile) Reset() {
+	p.volumeByBucket = map[int64]float64{}
+	p.totalVolume = 0
+	p.totalNotional = 0
+	p.numTrades = 0
+	p.pricePrecision = 0
+}
+
+// AddTrade accumulates the volume of an executed trade. Returns an error without changing the profile when the trade is on a different pair
+// or does not have a positive price and volume.
+func (p *SessionVolumeProfile) AddTrade(trade Trade) error {
+	if trade.Pair != nil && *trade.Pair != *p.pair {
+		return fmt.Errorf("trade is on pair %s but the profile is for pair %s", trade.Pair, p.pair)
+	}
+	if trade.Price == nil || trade.Price.AsFloat() <= 0 {
+		return fmt.Errorf("trade needs a positive price: %s", trade)
+	}
+	if trade.Volume == nil || trade.Volume.AsFloat() <= 0 {
+		return fmt.Errorf("trade needs a positive volume: %s", trade)
+	}
+
+	price := trade.Price.AsFloat()
+	volume := trade.Volume.AsFloat()
+	// the epsilon keeps a price on the boundary of a bucket in that bucket despite the float error of the division, e.g. 0.3 / 0.1
+	p.volumeByBucket[int64(math.Floor(price/p.bucketSize+floatEpsilon))] += volume
+	p.totalVolume += volume
+	p.totalNotional += price * volume
+	p.numTrades++
+	if trade.Price.Precision() > p.pricePrecision {
+		p.pricePrecision = trade.Price.Precision()
+	}
+	return nil
+}
+
+// NumTrades returns the number of trades accumulated in the session
+func (p *SessionVolumeProfile) NumTrades() int {
+	return p.numTrades
+}
+
+// TotalVolume returns the total base volume executed in the session
+func (p *SessionVolumeProfile) TotalVolume() *Number {
+	return NumberFromFloat(p.totalVolume, InternalCalculationsPrecision)
+}
+
+// VWAP returns the volume-weighted average price of the trades in the session, returns false when no trades were accumulated
+func (p *SessionVolumeProfile) VWAP() (*Number, bool) {
+	if p.totalVolume <= 0 {
+		return nil, false
+	}
+	return NumberFromFloat(p.totalNotional/p.totalVolume, p.pricePrecision), true
+}
+
+// BenchmarkBps returns the price improvement of a fill at the passed in price over the session VWAP in basis points, which is positive when
+// the fill is better than the VWAP (i.e. a buy below the VWAP or a sell above the VWAP). Returns an error when there is no session VWAP.
+func (p *SessionVolumeProfile) BenchmarkBps(action OrderAction, fillPrice *Number) (*Number, error) {
+	if fillPrice == nil {
+		return nil, fmt.Errorf("fillPrice cannot be nil")
+	}
+	if p.totalVolume <= 0 {
+		return nil, fmt.Errorf("there is no session VWAP because no trades were accumulated")
+	}
+
+	// use the unrounded VWAP so the benchmark is not skewed by the rounding of the VWAP
+	vwap := p.totalNotional / p.totalVolume
+	improvement := fillPrice.AsFloat() - vwap
+	if action.IsBuy() {
+		improvement = -improvement
+	}
+	return NumberFromFloat(improvement/vwap*10000, InternalCalculationsPrecision), nil
+}
+
+// Profile returns the volume executed in each price bucket that had any trades, sorted by price in ascending order
+func (p *SessionVolumeProfile) Profile() []VolumeAtPrice {
+	buckets := []int64{}
+	for bucket := range p.volumeByBucket {
+		buckets = append(buckets, bucket)
+	}
+	sort.Slice(buckets, func(i int, j int) bool {
+		return buckets[i] < buckets[j]
+	})
+
+	profile := []VolumeAtPrice{}
+	for _, bucket := range buckets {
+		profile = append(profile, VolumeAtPrice{
+			Price:  NumberFromFloat(float64(bucket)*p.bucketSize, InternalCalculationsPrecision),
+			Volume: NumberFromFloat(p.volumeByBucket[bucket], InternalCalculationsPrecision),
+		})
+	}
+	return profile
+}
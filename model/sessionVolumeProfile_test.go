@@ -0,0 +1,100 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func makeTestTrade(action OrderAction, price float64, volume float64) Trade {
+	return Trade{Order: makeTestOrder(action, price, volume)}
+}
+
+func TestSessionVolumeProfile(t *testing.T) {
+	p, e := MakeSessionVolumeProfile(testPair, NumberFromFloat(0.1, 1))
+	if !assert.NoError(t, e) {
+		return
+	}
+	_, ok := p.VWAP()
+	assert.False(t, ok)
+
+	testCases := []struct {
+		trade    Trade
+		wantVWAP float64
+	}{
+		{trade: makeTestTrade(OrderActionBuy, 1.0, 10.0), wantVWAP: 1.0},
+		{trade: makeTestTrade(OrderActionSell, 1.2, 10.0), wantVWAP: 1.1},
+		{trade: makeTestTrade(OrderActionBuy, 1.05, 20.0), wantVWAP: 1.075},
+		{trade: makeTestTrade(OrderActionSell, 0.3, 0.0), wantVWAP: 1.075}, // rejected because of the zero volume
+		{trade: makeTestTrade(OrderActionSell, 0.3, 10.0), wantVWAP: 0.92},
+	}
+	for i, kase := range testCases {
+		e := p.AddTrade(kase.trade)
+		assert.Equal(t, kase.trade.Volume.AsFloat() == 0, e != nil, "trade at index %d", i)
+
+		vwap, ok := p.VWAP()
+		if !assert.True(t, ok) {
+			return
+		}
+		assert.InDelta(t, kase.wantVWAP, vwap.AsFloat(), 1e-7, "trade at index %d", i)
+	}
+	assert.Equal(t, 4, p.NumTrades())
+	assert.InDelta(t, 50.0, p.TotalVolume().AsFloat(), 1e-9)
+
+	// the trade at 1.05 falls in the bucket of the trade at 1.0 and the trade at 0.3 is on the boundary of its bucket
+	profile := p.Profile()
+	if !assert.Equal(t, 3, len(profile)) {
+		return
+	}
+	for i, want := range []struct {
+		price  float64
+		volume float64
+	}{
+		{price: 0.3, volume: 10.0},
+		{price: 1.0, volume: 30.0},
+		{price: 1.2, volume: 10.0},
+	} {
+		assert.InDelta(t, want.price, profile[i].Price.AsFloat(), 1e-9, profile[i].String())
+		assert.InDelta(t, want.volume, profile[i].Volume.AsFloat(), 1e-9, profile[i].String())
+	}
+
+	// a buy below the VWAP and a sell above the VWAP are improvements over the VWAP
+	bps, e := p.BenchmarkBps(OrderActionBuy, NumberFromFloat(0.9108, 4))
+	if assert.NoError(t, e) {
+		assert.InDelta(t, 100.0, bps.AsFloat(), 1e-7)
+	}
+	bps, e = p.BenchmarkBps(OrderActionSell, NumberFromFloat(0.9108, 4))
+	if assert.NoError(t, e) {
+		assert.InDelta(t, -100.0, bps.AsFloat(), 1e-7)
+	}
+
+	p.Reset()
+	_, ok = p.VWAP()
+	assert.False(t, ok)
+	assert.Equal(t, 0, len(p.Profile()))
+	_, e = p.BenchmarkBps(OrderActionBuy, NumberFromFloat(1.0, 4))
+	assert.Error(t, e)
+}
+
+func TestSessionVolumeProfileErrors(t *testing.T) {
+	_, e := MakeSessionVolumeProfile(nil, NumberFromFloat(0.1, 1))
+	assert.Error(t, e)
+	for _, bucketSize := range []*Number{nil, NumberConstants.Zero, NumberFromFloat(-0.1, 1)} {
+		_, e = MakeSessionVolumeProfile(testPair, bucketSize)
+		assert.Error(t, e)
+	}
+
+	p, e := MakeSessionVolumeProfile(testPair, NumberFromFloat(0.1, 1))
+	if !assert.NoError(t, e) {
+		return
+	}
+	otherPair := &TradingPair{Base: testPair.Quote, Quote: testPair.Base}
+	otherPairTrade := makeTestTrade(OrderActionBuy, 1.0, 1.0)
+	otherPairTrade.Pair = otherPair
+	nilPriceTrade := makeTestTrade(OrderActionBuy, 1.0, 1.0)
+	nilPriceTrade.Price = nil
+	for _, trade := range []Trade{otherPairTrade, nilPriceTrade, makeTestTrade(OrderActionBuy, 0.0, 1.0)} {
+		assert.Error(t, p.AddTrade(trade))
+	}
+	assert.Equal(t, 0, p.NumTrades())
+}
@@ -57,6 +57,22 @@ func MakeMarketID(exchangeName string, baseAsset string, quoteAsset string) stri
 	return sha256Hash[0:marketIdHashLength]
 }
 
+// canonicalMarketID normalizes a market ID to the format of the market IDs made by MakeMarketID (a lowercase hex string of length
+// marketIdHashLength). When the normalized market ID does not have that format, e.g. when it was not made by MakeMarketID, it returns the
+// market ID as-is and false.
+func canonicalMarketID(marketID string) (string, bool) {
+	canonical := strings.ToLower(strings.TrimSpace(marketID))
+	if len(canonical) != marketIdHashLength {
+		return marketID, false
+	}
+	for _, c := range canonical {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return marketID, false
+		}
+	}
+	return canonical, true
+}
+
 // makeTradingMarket makes a market along with the ID field
 func makeTradingMarket(exchangeName string, baseAsset string, quoteAsset string) *tradingMarket {
 	sha256HashPrefix := MakeMarketID(exchangeName, baseAsset, quoteAsset)
@@ -60,3 +60,26 @@ func TestMarketID(t *testing.T) {
 		})
 	}
 }
+
+func TestCanonicalMarketID(t *testing.T) {
+	testCases := []struct {
+		marketID  string
+		wantID    string
+		wantValid bool
+	}{
+		{marketID: "96eda0a6ec", wantID: "96eda0a6ec", wantValid: true},
+		{marketID: "96EDA0A6EC", wantID: "96eda0a6ec", wantValid: true},
+		{marketID: " 96eda0a6ec\n", wantID: "96eda0a6ec", wantValid: true},
+		{marketID: "96eda0a6e", wantID: "96eda0a6e", wantValid: false},
+		{marketID: "kraken_XLM_USD", wantID: "kraken_XLM_USD", wantValid: false},
+		{marketID: " 96EDA0A6EG", wantID: " 96EDA0A6EG", wantValid: false},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.marketID, func(t *testing.T) {
+			marketID, valid := canonicalMarketID(k.marketID)
+			assert.Equal(t, k.wantID, marketID)
+			assert.Equal(t, k.wantValid, valid)
+		})
+	}
+}
@@ -3,6 +3,7 @@ package plugins
 import (
 	"database/sql"
 	"fmt"
+	"math"
 	"regexp"
 	"strconv"
 	"strings"
@@ -25,6 +26,8 @@ var filterMap = map[string]func(f *FilterFactory, configInput string) (SubmitFil
 	"volume":    filterVolume,
 	"price":     filterPrice,
 	"priceFeed": filterPriceFeed,
+	"priceBand": filterPriceBand,
+	"minSpread": filterMinSpread,
 }
 
 // FilterFactory is a struct that handles creating all the filters
@@ -35,6 +38,8 @@ type FilterFactory struct {
 	BaseAsset      hProtocol.Asset
 	QuoteAsset     hProtocol.Asset
 	DB             *sql.DB
+	// VolumeQueryCache is optionally shared by all the volume filters made by this factory, nil disables it
+	VolumeQueryCache *VolumeQueryCache
 }
 
 // MakeFilter is the function that makes the required filters
@@ -54,10 +59,11 @@ func (f *FilterFactory) MakeFilter(configInput string) (SubmitFilter, error) {
 }
 
 func filterVolume(f *FilterFactory, configInput string) (SubmitFilter, error) {
-	config, e := makeVolumeFilterConfig(configInput)
+	config, e := ParseVolumeFilterConfigValue(configInput)
 	if e != nil {
 		return nil, fmt.Errorf("could not make VolumeFilterConfig for configInput (%s): %s", configInput, e)
 	}
+	config.SharedQueryCache = f.VolumeQueryCache
 
 	return makeFilterVolume(
 		configInput,
@@ -87,11 +93,17 @@ func makeRawVolumeFilterConfig(
 	}
 }
 
-func makeVolumeFilterConfig(configInput string) (*VolumeFilterConfig, error) {
+// ParseVolumeFilterConfigValue parses the config value of a volume filter, which has the form
+// "volume/daily[:modifier[:modifier]]/side/units/amount/mode", e.g. "volume/daily:market_ids=[4c19915f47]/sell/base/3500.0/exact", into
+// a validated config where the side is "sell" or "buy", the units are "base" or "quote", and the amount is the cap in those units
+func ParseVolumeFilterConfigValue(configInput string) (*VolumeFilterConfig, error) {
 	parts := strings.Split(configInput, "/")
 	if len(parts) != 6 {
 		return nil, fmt.Errorf("invalid input (%s), needs 6 parts separated by the delimiter (/)", configInput)
 	}
+	if parts[0] != "volume" {
+		return nil, fmt.Errorf("invalid input (%s), the first part needs to be \"volume\"", configInput)
+	}
 
 	mode, e := parseVolumeFilterMode(parts[5])
 	if e != nil {
@@ -124,19 +136,31 @@ func makeVolumeFilterConfig(configInput string) (*VolumeFilterConfig, error) {
 		return nil, fmt.Errorf("invalid input (%s), the second part needs to be \"daily\" and can have only one modifier \"market_ids\" like so 'daily:market_ids=[4c19915f47,db4531d586]'", configInput)
 	}
 
-	if parts[2] != "sell" {
-		return nil, fmt.Errorf("invalid input (%s), the third part needs to be \"sell\"", configInput)
+	if parts[2] != volumeFilterActionSell && parts[2] != volumeFilterActionBuy {
+		return nil, fmt.Errorf("invalid input (%s), the third part needs to be \"sell\" or \"buy\"", configInput)
+	}
+	if parts[3] != volumeFilterUnitsBase && parts[3] != volumeFilterUnitsQuote {
+		return nil, fmt.Errorf("invalid input (%s), the fourth part needs to be \"base\" or \"quote\"", configInput)
 	}
 	limit, e := strconv.ParseFloat(parts[4], 64)
 	if e != nil {
-		return nil, fmt.Errorf("could not parse the fourth part as a float value from config value (%s): %s", configInput, e)
+		return nil, fmt.Errorf("could not parse the fifth part as a float value from config value (%s): %s", configInput, e)
+	}
+	if math.IsNaN(limit) || math.IsInf(limit, 0) {
+		return nil, fmt.Errorf("invalid input (%s), the fifth part needs to be a finite number", configInput)
 	}
-	if parts[3] == "base" {
+	config.action = parts[2]
+	config.units = parts[3]
+	if config.action == volumeFilterActionBuy {
+		if config.units == volumeFilterUnitsBase {
+			config.BuyBaseAssetCapInBaseUnits = &limit
+		} else {
+			config.BuyBaseAssetCapInQuoteUnits = &limit
+		}
+	} else if config.units == volumeFilterUnitsBase {
 		config.SellBaseAssetCapInBaseUnits = &limit
-	} else if parts[3] == "quote" {
-		config.SellBaseAssetCapInQuoteUnits = &limit
 	} else {
-		return nil, fmt.Errorf("invalid input (%s), the third part needs to be \"base\" or \"quote\"", configInput)
+		config.SellBaseAssetCapInQuoteUnits = &limit
 	}
 
 	if e = config.Validate(); e != nil {
@@ -259,3 +283,76 @@ func filterPriceFeed(f *FilterFactory, configInput string) (SubmitFilter, error)
 
 	return filter, nil
 }
+
+func filterPriceBand(f *FilterFactory, configInput string) (SubmitFilter, error) {
+	// parts[0] = "priceBand", parts[1] = bandType, and then either parts[2] = minPrice, parts[3] = maxPrice for the "fixed" bandType, or
+	// parts[2] = bandPercent, parts[3] = feedDataType, parts[4] = feedURL which can have more "/" chars for the "reference" bandType
+	parts := strings.Split(configInput, "/")
+	if len(parts) < 4 {
+		return nil, fmt.Errorf("\"priceBand\" filter needs at least 4 parts separated by the '/' delimiter (priceBand/fixed/<minPrice>/<maxPrice> or priceBand/reference/<bandPercent>/<feedDataType>/<feedURL>) but we received %s", configInput)
+	}
+
+	var config PriceBandFilterConfig
+	if parts[1] == "fixed" {
+		if len(parts) != 4 {
+			return nil, fmt.Errorf("invalid input (%s), the \"fixed\" price band needs 4 parts separated by the delimiter (/)", configInput)
+		}
+		minPrice, e := strconv.ParseFloat(parts[2], 64)
+		if e != nil {
+			return nil, fmt.Errorf("could not parse the third part as a float value from config value (%s): %s", configInput, e)
+		}
+		maxPrice, e := strconv.ParseFloat(parts[3], 64)
+		if e != nil {
+			return nil, fmt.Errorf("could not parse the fourth part as a float value from config value (%s): %s", configInput, e)
+		}
+		config = PriceBandFilterConfig{MinPrice: &minPrice, MaxPrice: &maxPrice}
+	} else if parts[1] == "reference" {
+		if len(parts) < 5 {
+			return nil, fmt.Errorf("invalid input (%s), the \"reference\" price band needs at least 5 parts separated by the delimiter (/)", configInput)
+		}
+		bandPercent, e := strconv.ParseFloat(parts[2], 64)
+		if e != nil {
+			return nil, fmt.Errorf("could not parse the third part as a float value from config value (%s): %s", configInput, e)
+		}
+		feedURL := strings.Join(parts[4:], "/")
+		pf, e := MakePriceFeed(parts[3], feedURL)
+		if e != nil {
+			return nil, fmt.Errorf("could not make price feed for config input string '%s': %s", configInput, e)
+		}
+		config = PriceBandFilterConfig{BandPercent: &bandPercent, ReferencePriceFeed: pf}
+	} else {
+		return nil, fmt.Errorf("invalid price band type in second argument (%s), needs to be either 'fixed' or 'reference'", configInput)
+	}
+
+	filter, e := MakeFilterPriceBand(f.BaseAsset, f.QuoteAsset, &config)
+	if e != nil {
+		return nil, fmt.Errorf("could not make price band filter for config input string '%s': %s", configInput, e)
+	}
+	return filter, nil
+}
+
+func filterMinSpread(f *FilterFactory, configInput string) (SubmitFilter, error) {
+	// parts[0] = "minSpread", parts[1] = minSpread, and an optional parts[2] = "reprice" to re-price the ops instead of dropping them
+	parts := strings.Split(configInput, "/")
+	if len(parts) != 2 && len(parts) != 3 {
+		return nil, fmt.Errorf("\"minSpread\" filter needs 2 or 3 parts separated by the '/' delimiter (minSpread/<minSpread> or minSpread/<minSpread>/reprice) but we received %s", configInput)
+	}
+
+	minSpread, e := strconv.ParseFloat(parts[1], 64)
+	if e != nil {
+		return nil, fmt.Errorf("could not parse the second part as a float value from config value (%s): %s", configInput, e)
+	}
+	reprice := false
+	if len(parts) == 3 {
+		if parts[2] != "reprice" {
+			return nil, fmt.Errorf("invalid third argument (%s), can only be 'reprice'", configInput)
+		}
+		reprice = true
+	}
+
+	filter, e := MakeFilterMinSpread(f.BaseAsset, f.QuoteAsset, &MinSpreadFilterConfig{MinSpread: minSpread, Reprice: reprice})
+	if e != nil {
+		return nil, fmt.Errorf("could not make min spread filter for config input string '%s': %s", configInput, e)
+	}
+	return filter, nil
+}
@@ -129,7 +129,7 @@ func TestAddModifierToConfig(t *testing.T) {
 	}
 }
 
-func TestMakeVolumeFilterConfig(t *testing.T) {
+func TestParseVolumeFilterConfigValue(t *testing.T) {
 	testCases := []struct {
 		configInput string
 		wantError   error
@@ -141,6 +141,8 @@ func TestMakeVolumeFilterConfig(t *testing.T) {
 				SellBaseAssetCapInBaseUnits:  pointy.Float64(3500.0),
 				SellBaseAssetCapInQuoteUnits: nil,
 				mode:                         volumeFilterModeExact,
+				action:                       "sell",
+				units:                        "base",
 				additionalMarketIDs:          nil,
 				optionalAccountIDs:           nil,
 			},
@@ -150,6 +152,8 @@ func TestMakeVolumeFilterConfig(t *testing.T) {
 				SellBaseAssetCapInBaseUnits:  nil,
 				SellBaseAssetCapInQuoteUnits: pointy.Float64(1000.0),
 				mode:                         volumeFilterModeIgnore,
+				action:                       "sell",
+				units:                        "quote",
 				additionalMarketIDs:          nil,
 				optionalAccountIDs:           nil,
 			},
@@ -159,33 +163,55 @@ func TestMakeVolumeFilterConfig(t *testing.T) {
 				SellBaseAssetCapInBaseUnits:  pointy.Float64(3500.0),
 				SellBaseAssetCapInQuoteUnits: nil,
 				mode:                         volumeFilterModeExact,
+				action:                       "sell",
+				units:                        "base",
 				additionalMarketIDs:          []string{"4c19915f47", "db4531d586"},
 				optionalAccountIDs:           nil,
 			},
 		}, {
-			configInput: "volume/daily:account_ids=[account1,account2]/sell/base/3500.0/exact",
+			configInput: "volume/daily:account_ids=[GAZVMWLVURCPWMUAP4P3ZAIY6VJTJG5MU5B4VIL4XGVCZAGWUMDCGORF,GDUHXCT6SNAFC7LUZ6YMRYBGQX4JLB45LBEP3KBUSAQLOWQ5P4BDOMO2]/sell/base/3500.0/exact",
 			wantConfig: &VolumeFilterConfig{
 				SellBaseAssetCapInBaseUnits:  pointy.Float64(3500.0),
 				SellBaseAssetCapInQuoteUnits: nil,
 				mode:                         volumeFilterModeExact,
+				action:                       "sell",
+				units:                        "base",
 				additionalMarketIDs:          nil,
-				optionalAccountIDs:           []string{"account1", "account2"},
+				optionalAccountIDs:           []string{testAccountID1, testAccountID2},
 			},
 		}, {
-			configInput: "volume/daily:market_ids=[4c19915f47,db4531d586]:account_ids=[account1,account2]/sell/base/3500.0/exact",
+			configInput: "volume/daily:market_ids=[4c19915f47,db4531d586]:account_ids=[GAZVMWLVURCPWMUAP4P3ZAIY6VJTJG5MU5B4VIL4XGVCZAGWUMDCGORF,GDUHXCT6SNAFC7LUZ6YMRYBGQX4JLB45LBEP3KBUSAQLOWQ5P4BDOMO2]/sell/base/3500.0/exact",
 			wantConfig: &VolumeFilterConfig{
 				SellBaseAssetCapInBaseUnits:  pointy.Float64(3500.0),
 				SellBaseAssetCapInQuoteUnits: nil,
 				mode:                         volumeFilterModeExact,
+				action:                       "sell",
+				units:                        "base",
 				additionalMarketIDs:          []string{"4c19915f47", "db4531d586"},
-				optionalAccountIDs:           []string{"account1", "account2"},
+				optionalAccountIDs:           []string{testAccountID1, testAccountID2},
+			},
+		}, {
+			configInput: "volume/daily/buy/base/2000.0/exact",
+			wantConfig: &VolumeFilterConfig{
+				BuyBaseAssetCapInBaseUnits: pointy.Float64(2000.0),
+				mode:                       volumeFilterModeExact,
+				action:                     "buy",
+				units:                      "base",
+			},
+		}, {
+			configInput: "volume/daily/buy/quote/1000.0/ignore",
+			wantConfig: &VolumeFilterConfig{
+				BuyBaseAssetCapInQuoteUnits: pointy.Float64(1000.0),
+				mode:                        volumeFilterModeIgnore,
+				action:                      "buy",
+				units:                       "quote",
 			},
 		},
 	}
 
 	for _, k := range testCases {
 		t.Run(k.configInput, func(t *testing.T) {
-			actual, e := makeVolumeFilterConfig(k.configInput)
+			actual, e := ParseVolumeFilterConfigValue(k.configInput)
 			if !assert.NoError(t, e) {
 				return
 			}
@@ -194,6 +220,25 @@ func TestMakeVolumeFilterConfig(t *testing.T) {
 	}
 }
 
+func TestParseVolumeFilterConfigValueErrors(t *testing.T) {
+	for _, configInput := range []string{
+		"volume/daily/hold/base/3500.0/exact",
+		"volume/daily/buy/other/3500.0/exact",
+		"volume/daily/sell/other/3500.0/exact",
+		"volume/daily:account_ids=[account1]/sell/base/3500.0/exact",
+		"volume/daily/sell/base/-1.0/exact",
+		"volume/daily/sell/base/NaN/exact",
+		"volume/daily/sell/base/abc/exact",
+		"volume/daily/sell/base/3500.0/other",
+		"volume/weekly/sell/base/3500.0/exact",
+		"price/daily/sell/base/3500.0/exact",
+		"volume/daily/sell/base/3500.0",
+	} {
+		_, e := ParseVolumeFilterConfigValue(configInput)
+		assert.Error(t, e, configInput)
+	}
+}
+
 func assertVolumeFilterConfigEqual(t *testing.T, want *VolumeFilterConfig, actual *VolumeFilterConfig) {
 	if want == nil {
 		assert.Nil(t, actual)
@@ -202,8 +247,12 @@ func assertVolumeFilterConfigEqual(t *testing.T, want *VolumeFilterConfig, actua
 	} else {
 		assert.Equal(t, want.SellBaseAssetCapInBaseUnits, actual.SellBaseAssetCapInBaseUnits)
 		assert.Equal(t, want.SellBaseAssetCapInQuoteUnits, actual.SellBaseAssetCapInQuoteUnits)
+		assert.Equal(t, want.BuyBaseAssetCapInBaseUnits, actual.BuyBaseAssetCapInBaseUnits)
+		assert.Equal(t, want.BuyBaseAssetCapInQuoteUnits, actual.BuyBaseAssetCapInQuoteUnits)
 		assert.Equal(t, want.mode, actual.mode)
 		assert.Equal(t, want.additionalMarketIDs, actual.additionalMarketIDs)
 		assert.Equal(t, want.optionalAccountIDs, actual.optionalAccountIDs)
+		assert.Equal(t, want.action, actual.action)
+		assert.Equal(t, want.units, actual.units)
 	}
 }
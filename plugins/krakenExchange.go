@@ -35,6 +35,24 @@ type krakenExchange struct {
 	isSimulated              bool // will simulate add and cancel orders if this is true
 }
 
+// krakenOrderTypeMap maps the order types of kraken that are not in the common order type strings, the stop-loss and take-profit orders are
+// triggered at their trigger price and settle-position closes a margin position at the market
+var krakenOrderTypeMap = map[string]model.OrderType{
+	"stop-loss":         model.OrderTypeStop,
+	"take-profit":       model.OrderTypeStop,
+	"stop-loss-limit":   model.OrderTypeStopLimit,
+	"take-profit-limit": model.OrderTypeStopLimit,
+	"settle-position":   model.OrderTypeMarket,
+}
+
+// krakenOrderTypeFromString converts the order type of a kraken order or trade to the corresponding OrderType
+func krakenOrderTypeFromString(s string) (model.OrderType, error) {
+	if orderType, ok := krakenOrderTypeMap[s]; ok {
+		return orderType, nil
+	}
+	return model.OrderTypeFromString(s)
+}
+
 type asset2Address2Key map[model.Asset]map[string]string
 
 func (m asset2Address2Key) getKey(asset model.Asset, address string) (string, error) {
@@ -257,12 +275,22 @@ func (k *krakenExchange) GetOpenOrders(pairs []*model.TradingPair) (map[model.Tr
 			return nil, fmt.Errorf("open orders are listed with repeated base/quote pairs for %s", *pair)
 		}
 
+		orderAction, e := model.OrderActionFromString(o.Description.Type)
+		if e != nil {
+			log.Printf("skipping open order %s in krakenExchange#GetOpenOrders, could not parse the order action: %s\n", ID, e)
+			continue
+		}
+		orderType, e := krakenOrderTypeFromString(o.Description.OrderType)
+		if e != nil {
+			log.Printf("skipping open order %s in krakenExchange#GetOpenOrders, could not parse the order type: %s\n", ID, e)
+			continue
+		}
 		orderConstraints := k.GetOrderConstraints(pair)
 		m[*pair] = append(m[*pair], model.OpenOrder{
 			Order: model.Order{
 				Pair:        pair,
-				OrderAction: model.OrderActionFromString(o.Description.Type),
-				OrderType:   model.OrderTypeFromString(o.Description.OrderType),
+				OrderAction: orderAction,
+				OrderType:   orderType,
 				Price:       model.MustNumberFromString(o.Description.PrimaryPrice, orderConstraints.PricePrecision),
 				Volume:      model.MustNumberFromString(o.Volume, orderConstraints.VolumePrecision),
 				Timestamp:   model.MakeTimestamp(int64(o.OpenTime)),
@@ -487,11 +515,19 @@ func (k *krakenExchange) getTradeHistoryFromEndAscLimit50(tradingPair model.Trad
 		}
 
 		if *pair == tradingPair {
+			orderAction, e := model.OrderActionFromString(_type)
+			if e != nil {
+				return nil, fmt.Errorf("error parsing order action of trade '%s' in krakenExchange#getTradeHistoryFromEndAscLimit50: %s", _txid, e)
+			}
+			orderType, e := krakenOrderTypeFromString(_ordertype)
+			if e != nil {
+				return nil, fmt.Errorf("error parsing order type of trade '%s' in krakenExchange#getTradeHistoryFromEndAscLimit50: %s", _txid, e)
+			}
 			res.Trades = append(res.Trades, model.Trade{
 				Order: model.Order{
 					Pair:        pair,
-					OrderAction: model.OrderActionFromString(_type),
-					OrderType:   model.OrderTypeFromString(_ordertype),
+					OrderAction: orderAction,
+					OrderType:   orderType,
 					Price:       model.MustNumberFromString(_price, orderConstraints.PricePrecision),
 					Volume:      model.MustNumberFromString(_vol, orderConstraints.VolumePrecision),
 					Timestamp:   ts,
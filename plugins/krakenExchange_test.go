@@ -492,3 +492,34 @@ func TestWithdrawFunds(t *testing.T) {
 	fmt.Printf("refid=%v\n", result.WithdrawalID)
 	assert.Fail(t, "force fail")
 }
+
+func TestKrakenOrderTypeFromString(t *testing.T) {
+	testCases := []struct {
+		input   string
+		want    model.OrderType
+		wantErr bool
+	}{
+		{input: "market", want: model.OrderTypeMarket},
+		{input: "limit", want: model.OrderTypeLimit},
+		{input: "stop-loss", want: model.OrderTypeStop},
+		{input: "take-profit", want: model.OrderTypeStop},
+		{input: "stop-loss-limit", want: model.OrderTypeStopLimit},
+		{input: "take-profit-limit", want: model.OrderTypeStopLimit},
+		{input: "settle-position", want: model.OrderTypeMarket},
+		{input: "trailing-stop", wantErr: true},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.input, func(t *testing.T) {
+			orderType, e := krakenOrderTypeFromString(k.input)
+			if k.wantErr {
+				assert.Error(t, e)
+				return
+			}
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.Equal(t, k.want, orderType)
+		})
+	}
+}
@@ -47,7 +47,7 @@ func (c *MaxPriceFilterConfig) String() string {
 }
 
 func (f *maxPriceFilter) Apply(ops []txnbuild.Operation, sellingOffers []hProtocol.Offer, buyingOffers []hProtocol.Offer) ([]txnbuild.Operation, error) {
-	ops, e := filterOps(f.name, f.baseAsset, f.quoteAsset, sellingOffers, buyingOffers, ops, f.maxPriceFilterFn)
+	ops, e := filterOps(f.name, f.baseAsset, f.quoteAsset, sellingOffers, buyingOffers, ops, f.maxPriceFilterFn, nil, nil)
 	if e != nil {
 		return nil, fmt.Errorf("could not apply filter: %s", e)
 	}
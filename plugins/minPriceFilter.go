@@ -47,7 +47,7 @@ func (c *MinPriceFilterConfig) String() string {
 }
 
 func (f *minPriceFilter) Apply(ops []txnbuild.Operation, sellingOffers []hProtocol.Offer, buyingOffers []hProtocol.Offer) ([]txnbuild.Operation, error) {
-	ops, e := filterOps(f.name, f.baseAsset, f.quoteAsset, sellingOffers, buyingOffers, ops, f.minPriceFilterFn)
+	ops, e := filterOps(f.name, f.baseAsset, f.quoteAsset, sellingOffers, buyingOffers, ops, f.minPriceFilterFn, nil, nil)
 	if e != nil {
 		return nil, fmt.Errorf("could not apply filter: %s", e)
 	}
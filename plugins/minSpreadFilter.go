@@ -0,0 +1,189 @@
+package plugins
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	hProtocol "github.com/stellar/go/protocols/horizon"
+	"github.com/stellar/go/txnbuild"
+	"github.com/stellar/kelp/support/utils"
+)
+
+// MinSpreadFilterConfig keeps our own bids and asks at least MinSpread apart so that we do not trade against ourselves
+type MinSpreadFilterConfig struct {
+	// MinSpread is the minimum spread between our top bid and our top ask as a fraction of the top bid, e.g. 0.001 is a spread of 0.1%
+	MinSpread float64
+	// Reprice moves a sell op that is too close to our top bid up to the minimum spread instead of dropping the ops on both sides, the buy ops
+	// are kept as-is since the re-priced asks are at least MinSpread above every bid
+	Reprice bool
+}
+
+type minSpreadFilter struct {
+	name       string
+	config     *MinSpreadFilterConfig
+	baseAsset  hProtocol.Asset
+	quoteAsset hProtocol.Asset
+}
+
+// MakeFilterMinSpread makes a submit filter that drops or re-prices the ops that would cross our own offers on the opposite side or be
+// within the minimum spread of them
+func MakeFilterMinSpread(baseAsset hProtocol.Asset, quoteAsset hProtocol.Asset, config *MinSpreadFilterConfig) (SubmitFilter, error) {
+	e := config.Validate()
+	if e != nil {
+		return nil, fmt.Errorf("invalid min spread filter config: %s", e)
+	}
+
+	return &minSpreadFilter{
+		name:       "minSpreadFilter",
+		config:     config,
+		baseAsset:  baseAsset,
+		quoteAsset: quoteAsset,
+	}, nil
+}
+
+var _ SubmitFilter = &minSpreadFilter{}
+
+// Validate ensures validity
+func (c *MinSpreadFilterConfig) Validate() error {
+	// a MinSpread of 0 would still allow a bid and an ask at the same price, which trade against each other
+	if c.MinSpread <= 0 {
+		return fmt.Errorf("MinSpread needs to be positive but was %f", c.MinSpread)
+	}
+	return nil
+}
+
+// String is the stringer method
+func (c *MinSpreadFilterConfig) String() string {
+	return fmt.Sprintf("MinSpreadFilterConfig[MinSpread=%f, Reprice=%v]", c.MinSpread, c.Reprice)
+}
+
+// Apply checks every op and existing offer against the top price of the opposite side of our book as it would be after this batch, i.e.
+// the non-delete ops in the batch together with the existing offers that are not updated by the batch. When both sides are too close then
+// the ops on both sides are dropped since neither side is given priority, or only the asks are re-priced up to the minimum spread above the
+// top bid when using Reprice, which keeps the spread at the minimum instead of moving both sides away from each other.
+func (f *minSpreadFilter) Apply(ops []txnbuild.Operation, sellingOffers []hProtocol.Offer, buyingOffers []hProtocol.Offer) ([]txnbuild.Operation, error) {
+	topBidPrice, topAskPrice, e := topPricesAfterOps(f.baseAsset, f.quoteAsset, ops, sellingOffers, buyingOffers)
+	if e != nil {
+		return nil, fmt.Errorf("could not compute the top prices of our offers: %s", e)
+	}
+	log.Printf("minSpreadFilter: topBidPrice=%s, topAskPrice=%s\n", utils.CheckedFloatPtr(topBidPrice), utils.CheckedFloatPtr(topAskPrice))
+
+	innerFn := func(op *txnbuild.ManageSellOffer) (*txnbuild.ManageSellOffer, error) {
+		return minSpreadFilterFn(f.baseAsset, f.quoteAsset, f.config, topBidPrice, topAskPrice, op)
+	}
+	ops, e = filterOps(f.name, f.baseAsset, f.quoteAsset, sellingOffers, buyingOffers, ops, innerFn, nil, nil)
+	if e != nil {
+		return nil, fmt.Errorf("could not apply filter: %s", e)
+	}
+	return ops, nil
+}
+
+// topPricesAfterOps returns the top bid and top ask prices (in quote units per base unit) of our offers after the ops are applied, a nil
+// price means that side of the book is empty
+func topPricesAfterOps(
+	baseAsset hProtocol.Asset,
+	quoteAsset hProtocol.Asset,
+	ops []txnbuild.Operation,
+	sellingOffers []hProtocol.Offer,
+	buyingOffers []hProtocol.Offer,
+) (*float64, *float64, error) {
+	var topBidPrice *float64
+	var topAskPrice *float64
+	updateTop := func(isSell bool, price float64) {
+		if isSell && (topAskPrice == nil || price < *topAskPrice) {
+			topAskPrice = &price
+		} else if !isSell && (topBidPrice == nil || price > *topBidPrice) {
+			topBidPrice = &price
+		}
+	}
+
+	for _, op := range ops {
+		mso, ok := op.(*txnbuild.ManageSellOffer)
+		if !ok || mso.Amount == "0" {
+			continue
+		}
+		isSell, e := utils.IsSelling(baseAsset, quoteAsset, mso.Selling, mso.Buying)
+		if e != nil {
+			return nil, nil, fmt.Errorf("error when running the isSelling check for offer '%+v': %s", *mso, e)
+		}
+		sellPrice, e := strconv.ParseFloat(mso.Price, 64)
+		if e != nil {
+			return nil, nil, fmt.Errorf("could not convert price (%s) to float: %s", mso.Price, e)
+		}
+		if !isSell {
+			if sellPrice <= 0 {
+				return nil, nil, fmt.Errorf("the price of the buy op needs to be positive but was %s", mso.Price)
+			}
+			// invert price when buying
+			sellPrice = 1 / sellPrice
+		}
+		updateTop(isSell, sellPrice)
+	}
+
+	updatedOfferIDs := ignoreOfferIDs(ops)
+	for _, offer := range sellingOffers {
+		if !updatedOfferIDs[offer.ID] {
+			updateTop(true, float64(offer.PriceR.N)/float64(offer.PriceR.D))
+		}
+	}
+	for _, offer := range buyingOffers {
+		if !updatedOfferIDs[offer.ID] && offer.PriceR.N > 0 {
+			// invert price when buying
+			updateTop(false, float64(offer.PriceR.D)/float64(offer.PriceR.N))
+		}
+	}
+	return topBidPrice, topAskPrice, nil
+}
+
+func minSpreadFilterFn(
+	baseAsset hProtocol.Asset,
+	quoteAsset hProtocol.Asset,
+	config *MinSpreadFilterConfig,
+	topBidPrice *float64,
+	topAskPrice *float64,
+	op *txnbuild.ManageSellOffer,
+) (*txnbuild.ManageSellOffer, error) {
+	isSell, e := utils.IsSelling(baseAsset, quoteAsset, op.Selling, op.Buying)
+	if e != nil {
+		return nil, fmt.Errorf("error when running the isSelling check for offer '%+v': %s", *op, e)
+	}
+
+	sellPrice, e := strconv.ParseFloat(op.Price, 64)
+	if e != nil {
+		return nil, fmt.Errorf("could not convert price (%s) to float: %s", op.Price, e)
+	}
+
+	if !isSell {
+		if topAskPrice == nil || config.Reprice {
+			// the asks are re-priced to be at least the min spread above the top bid, so the bids do not need to move
+			return op, nil
+		}
+		if sellPrice <= 0 {
+			return nil, fmt.Errorf("the price of the buy op needs to be positive but was %s", op.Price)
+		}
+		// invert price when buying
+		price := 1 / sellPrice
+		maxPrice := *topAskPrice / (1 + config.MinSpread)
+		if price <= maxPrice {
+			return op, nil
+		}
+		log.Printf("minSpreadFilter: buying, op price %.7f is within the min spread of the topAskPrice %.7f (maxPrice=%.7f), dropping op\n", price, *topAskPrice, maxPrice)
+		return nil, nil
+	}
+
+	if topBidPrice == nil {
+		return op, nil
+	}
+	minPrice := *topBidPrice * (1 + config.MinSpread)
+	if sellPrice >= minPrice {
+		return op, nil
+	}
+	log.Printf("minSpreadFilter: selling, op price %.7f is within the min spread of the topBidPrice %.7f (minPrice=%.7f), reprice=%v\n", sellPrice, *topBidPrice, minPrice, config.Reprice)
+	if !config.Reprice {
+		return nil, nil
+	}
+	newOp := *op
+	newOp.Price = fmt.Sprintf("%.7f", minPrice)
+	return &newOp, nil
+}
@@ -0,0 +1,131 @@
+package plugins
+
+import (
+	"fmt"
+	"testing"
+
+	hProtocol "github.com/stellar/go/protocols/horizon"
+	"github.com/stellar/go/txnbuild"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/stellar/kelp/support/utils"
+)
+
+func makeMinSpreadTestOffer(id int64, isSell bool, priceN int32, priceD int32, amount string) hProtocol.Offer {
+	offer := hProtocol.Offer{
+		ID:      id,
+		Seller:  "seller",
+		Selling: testBuyBaseAsset,
+		Buying:  utils.NativeAsset,
+		Amount:  amount,
+		PriceR:  hProtocol.Price{N: priceN, D: priceD},
+		Price:   fmt.Sprintf("%.7f", float64(priceN)/float64(priceD)),
+	}
+	if !isSell {
+		offer.Selling, offer.Buying = offer.Buying, offer.Selling
+	}
+	return offer
+}
+
+func TestMinSpreadFilterApply(t *testing.T) {
+	deleteBuyOp := makeBuyOp("1.0000000", "0")
+	deleteBuyOp.OfferID = 2
+	sellOffer := makeMinSpreadTestOffer(1, true, 21, 20, "10.0000000")
+	deleteSellOp := convertOffer2MSO(sellOffer)
+	deleteSellOp.Amount = "0"
+	repricedSellOp := convertOffer2MSO(sellOffer)
+	repricedSellOp.Price = "1.1000000"
+
+	testCases := []struct {
+		name          string
+		reprice       bool
+		ops           []txnbuild.Operation
+		sellingOffers []hProtocol.Offer
+		buyingOffers  []hProtocol.Offer
+		wantOps       []txnbuild.Operation
+	}{
+		{
+			name:    "outside of the min spread",
+			ops:     []txnbuild.Operation{makeSellBaseOp("1.2", "10.0"), makeBuyOp("1.0", "10.0")},
+			wantOps: []txnbuild.Operation{makeSellBaseOp("1.2", "10.0"), makeBuyOp("1.0", "10.0")},
+		}, {
+			name:    "crossed ops are dropped",
+			ops:     []txnbuild.Operation{makeSellBaseOp("0.9", "10.0"), makeBuyOp("1.0", "10.0")},
+			wantOps: []txnbuild.Operation{},
+		}, {
+			name:    "crossed ops are re-priced",
+			reprice: true,
+			ops:     []txnbuild.Operation{makeSellBaseOp("0.9", "10.0"), makeBuyOp("1.0", "10.0")},
+			wantOps: []txnbuild.Operation{makeSellBaseOp("1.1000000", "10.0"), makeBuyOp("1.0", "10.0")},
+		}, {
+			name:         "deleted offer is not counted",
+			ops:          []txnbuild.Operation{makeSellBaseOp("1.05", "10.0"), deleteBuyOp},
+			buyingOffers: []hProtocol.Offer{makeMinSpreadTestOffer(2, false, 1, 1, "10.0000000")},
+			wantOps:      []txnbuild.Operation{makeSellBaseOp("1.05", "10.0"), deleteBuyOp},
+		}, {
+			name:          "existing offer within the min spread is deleted",
+			ops:           []txnbuild.Operation{makeBuyOp("1.0", "10.0")},
+			sellingOffers: []hProtocol.Offer{sellOffer},
+			wantOps:       []txnbuild.Operation{deleteSellOp},
+		}, {
+			name:          "existing offer within the min spread is re-priced and the bids are kept",
+			reprice:       true,
+			ops:           []txnbuild.Operation{makeBuyOp("1.0", "10.0")},
+			sellingOffers: []hProtocol.Offer{sellOffer},
+			wantOps:       []txnbuild.Operation{makeBuyOp("1.0", "10.0"), repricedSellOp},
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			f, e := MakeFilterMinSpread(testBuyBaseAsset, utils.NativeAsset, &MinSpreadFilterConfig{MinSpread: 0.1, Reprice: k.reprice})
+			if !assert.NoError(t, e) {
+				return
+			}
+			actual, e := f.Apply(k.ops, k.sellingOffers, k.buyingOffers)
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.Equal(t, k.wantOps, actual)
+		})
+	}
+}
+
+func TestMakeFilterMinSpread(t *testing.T) {
+	factory := &FilterFactory{BaseAsset: testBuyBaseAsset, QuoteAsset: utils.NativeAsset}
+	ops := []txnbuild.Operation{makeSellBaseOp("1.05", "10.0"), makeBuyOp("1.0", "10.0")}
+
+	testCases := []struct {
+		configInput string
+		wantOps     []txnbuild.Operation
+	}{
+		{configInput: "minSpread/0.01", wantOps: ops},
+		{configInput: "minSpread/0.1", wantOps: []txnbuild.Operation{}},
+		{configInput: "minSpread/0.1/reprice", wantOps: []txnbuild.Operation{makeSellBaseOp("1.1000000", "10.0"), makeBuyOp("1.0", "10.0")}},
+	}
+	for _, k := range testCases {
+		t.Run(k.configInput, func(t *testing.T) {
+			f, e := factory.MakeFilter(k.configInput)
+			if !assert.NoError(t, e) {
+				return
+			}
+			actual, e := f.Apply(ops, []hProtocol.Offer{}, []hProtocol.Offer{})
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.Equal(t, k.wantOps, actual)
+		})
+	}
+
+	for _, configInput := range []string{
+		"minSpread",
+		"minSpread/a",
+		"minSpread/0",
+		"minSpread/-0.1",
+		"minSpread/0.1/drop",
+		"minSpread/0.1/reprice/extra",
+	} {
+		_, e := factory.MakeFilter(configInput)
+		assert.Error(t, e, configInput)
+	}
+}
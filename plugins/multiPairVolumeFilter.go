@@ -0,0 +1,237 @@
+package plugins
+
+import (
+	"fmt"
+	"log"
+
+	hProtocol "github.com/stellar/go/protocols/horizon"
+	"github.com/stellar/go/txnbuild"
+	"github.com/stellar/kelp/support/utils"
+)
+
+// multiPairVolumeFilter applies a separate volume filter for each trading pair of a multi-pair bot, so one filter instance can cover a
+// portfolio where every pair has its own caps and its own daily volume (OTB)
+type multiPairVolumeFilter struct {
+	name    string
+	filters []*volumeFilter
+}
+
+var _ SubmitFilter = &multiPairVolumeFilter{}
+
+// MakeMultiPairVolumeFilter makes a SubmitFilter that routes the ops and offers of each trading pair to the volume filter of that pair, where
+// filters are the volume filters made for each pair by the filter factory. Every pair can only have one filter.
+func MakeMultiPairVolumeFilter(filters ...SubmitFilter) (SubmitFilter, error) {
+	if len(filters) == 0 {
+		return nil, fmt.Errorf("need at least one volume filter")
+	}
+
+	volumeFilters := []*volumeFilter{}
+	for i, filter := range filters {
+		f, ok := filter.(*volumeFilter)
+		if !ok {
+			return nil, fmt.Errorf("filter at index %d is not a volume filter but was '%T'", i, filter)
+		}
+		for _, existing := range volumeFilters {
+			if existing.isOnPair(f.baseAsset, f.quoteAsset) {
+				return nil, fmt.Errorf("filter at index %d is on the same pair (%s/%s) as an earlier filter", i, utils.Asset2String(f.baseAsset), utils.Asset2String(f.quoteAsset))
+			}
+		}
+		volumeFilters = append(volumeFilters, f)
+	}
+
+	return &multiPairVolumeFilter{
+		name:    "multiPairVolumeFilter",
+		filters: volumeFilters,
+	}, nil
+}
+
+// isOnPair returns true if the two assets are the base and quote assets of the filter (in either order)
+func (f *volumeFilter) isOnPair(a hProtocol.Asset, b hProtocol.Asset) bool {
+	return (isSameAsset(f.baseAsset, a) && isSameAsset(f.quoteAsset, b)) || (isSameAsset(f.baseAsset, b) && isSameAsset(f.quoteAsset, a))
+}
+
+// isSameAsset compares the assets by their code and issuer, since the type of a credit asset only depends on the length of the code
+func isSameAsset(a hProtocol.Asset, b hProtocol.Asset) bool {
+	if a.Type == utils.Native || b.Type == utils.Native {
+		return a.Type == b.Type
+	}
+	return a.Code == b.Code && a.Issuer == b.Issuer
+}
+
+// Apply filters each op in place with the volume filter of its trading pair, so the order of the ops is kept across the pairs. Like
+// filterOps, the deletes of the existing offers whose update was dropped are moved to the start of the ops, and the existing offers that are
+// not updated by any op are re-checked against the caps of their pair after the ops (instead of being interleaved with the ops by price).
+// The "scale" mode cannot scale the ops of a pair since the ops are filtered one at a time, so it trims the ops like the "exact" mode. The
+// filters of pairs without any ops or offers are not applied. Returns an error if an op or offer is not on the pair of any of the filters.
+func (f *multiPairVolumeFilter) Apply(ops []txnbuild.Operation, sellingOffers []hProtocol.Offer, buyingOffers []hProtocol.Offer) ([]txnbuild.Operation, error) {
+	// the index of the filter of each op, -1 for the ops that are not a ManageSellOffer
+	filterIdxs := make([]int, len(ops))
+	hasOps := make([]bool, len(f.filters))
+	opsByFilter := make([][]txnbuild.Operation, len(f.filters))
+	for i, op := range ops {
+		filterIdxs[i] = -1
+		mso, ok := op.(*txnbuild.ManageSellOffer)
+		if !ok {
+			continue
+		}
+
+		idx, e := f.filterIndex(utils.Asset2Asset2(mso.Selling), utils.Asset2Asset2(mso.Buying))
+		if e != nil {
+			return nil, fmt.Errorf("could not route op (%s): %s", opString(mso), e)
+		}
+		filterIdxs[i] = idx
+		hasOps[idx] = true
+		opsByFilter[idx] = append(opsByFilter[idx], op)
+	}
+
+	sellingOffersByFilter, e := f.splitOffers(sellingOffers)
+	if e != nil {
+		return nil, fmt.Errorf("could not route selling offers: %s", e)
+	}
+	buyingOffersByFilter, e := f.splitOffers(buyingOffers)
+	if e != nil {
+		return nil, fmt.Errorf("could not route buying offers: %s", e)
+	}
+
+	batches := make([]*volumeFilterBatch, len(f.filters))
+	for i, filter := range f.filters {
+		if !hasOps[i] && len(sellingOffersByFilter[i]) == 0 && len(buyingOffersByFilter[i]) == 0 {
+			continue
+		}
+
+		batches[i], e = filter.loadBatch()
+		if e != nil {
+			return nil, fmt.Errorf("could not load the volume filter batch for pair %s: %s", filter.pairString(), e)
+		}
+		if batches[i] != nil {
+			e = filter.excludePendingOnBook(batches[i], sellingOffersByFilter[i])
+			if e != nil {
+				return nil, fmt.Errorf("could not exclude the pending volume on the book for pair %s: %s", filter.pairString(), e)
+			}
+			e = filter.restoreTBB(batches[i], sellingOffersByFilter[i])
+			if e != nil {
+				return nil, fmt.Errorf("could not restore the persisted TBB for pair %s: %s", filter.pairString(), e)
+			}
+			e = batches[i].countDeletedOffers(opsByFilter[i], sellingOffersByFilter[i])
+			if e != nil {
+				return nil, fmt.Errorf("could not count the deleted offers for pair %s: %s", filter.pairString(), e)
+			}
+		}
+	}
+
+	offerMap := makeOfferMap(append(append([]hProtocol.Offer{}, sellingOffers...), buyingOffers...))
+	numOpsByFilter := make([]int, len(f.filters))
+	deleteOps := []txnbuild.Operation{}
+	filteredOps := []txnbuild.Operation{}
+	// processedOps are the ops that were not truncated by the MaxOpsGuard, the existing offers of the truncated ops need to be re-checked
+	processedOps := []txnbuild.Operation{}
+	for i, op := range ops {
+		idx := filterIdxs[i]
+		if idx == -1 {
+			processedOps = append(processedOps, op)
+			filteredOps = append(filteredOps, op)
+			continue
+		}
+		filter := f.filters[idx]
+		batch := batches[idx]
+		if batch == nil {
+			processedOps = append(processedOps, op)
+			if filter.passesOnQueryFailure(op) {
+				filteredOps = append(filteredOps, op)
+			}
+			continue
+		}
+
+		numOpsByFilter[idx]++
+		if guard := filter.config.MaxOpsGuard; guard != nil && numOpsByFilter[idx] > guard.MaxOps {
+			if !guard.Truncate {
+				return nil, fmt.Errorf("filter \"%s\" for pair %s received more than the max of %d ops", filter.name, filter.pairString(), guard.MaxOps)
+			}
+			continue
+		}
+		processedOps = append(processedOps, op)
+		mso := op.(*txnbuild.ManageSellOffer)
+		if filter.config.MonitorOnly {
+			filteredOps = append(filteredOps, op)
+			continue
+		}
+		if mso.Amount == "0" {
+			e = batch.freeDeletedOffer(mso)
+			if e != nil {
+				return nil, fmt.Errorf("could not free the deleted offer for pair %s: %s", filter.pairString(), e)
+			}
+		}
+
+		newOpToPrepend, newOpToAppend, _, e := runInnerFilterFn(*mso, batch.filterOp, fetchOfferAsOpByID(mso.OfferID, offerMap), *mso)
+		if e != nil {
+			return nil, fmt.Errorf("could not apply volume filter for pair %s: %s", filter.pairString(), e)
+		}
+		if newOpToPrepend != nil {
+			deleteOps = append(deleteOps, newOpToPrepend)
+		}
+		if newOpToAppend != nil {
+			filteredOps = append(filteredOps, newOpToAppend)
+		}
+	}
+
+	updatedOfferIDs := ignoreOfferIDs(processedOps)
+	for i, filter := range f.filters {
+		batch := batches[i]
+		if batch == nil || filter.config.MonitorOnly {
+			continue
+		}
+
+		for _, offers := range [][]hProtocol.Offer{sellingOffersByFilter[i], buyingOffersByFilter[i]} {
+			filteredOps, e = handleRemainingOffers(&filterCounter{}, offers, updatedOfferIDs, filteredOps, batch.filterOp)
+			if e != nil {
+				return nil, fmt.Errorf("could not re-check the existing offers for pair %s: %s", filter.pairString(), e)
+			}
+		}
+		log.Printf("multiPairVolumeFilter: cycle summary for pair %s, dropped ops by reason: %s\n", filter.pairString(), summarizeDropReasons(batch.dropCounts))
+		filter.persistTBB(batch)
+		filter.holdPendingVolume(batch)
+	}
+	return append(deleteOps, filteredOps...), nil
+}
+
+// PostSubmit records the pending volume of the filter of each pair, see volumeFilter.PostSubmit
+func (f *multiPairVolumeFilter) PostSubmit() error {
+	for _, filter := range f.filters {
+		e := filter.PostSubmit()
+		if e != nil {
+			return fmt.Errorf("could not record the pending volume for pair %s: %s", filter.pairString(), e)
+		}
+	}
+	return nil
+}
+
+// pairString returns the base and quote assets of the filter for the logs
+func (f *volumeFilter) pairString() string {
+	return fmt.Sprintf("%s/%s", utils.Asset2String(f.baseAsset), utils.Asset2String(f.quoteAsset))
+}
+
+// filterIndex returns the index of the filter on the pair of the passed in assets
+func (f *multiPairVolumeFilter) filterIndex(selling hProtocol.Asset, buying hProtocol.Asset) (int, error) {
+	for i, filter := range f.filters {
+		if filter.isOnPair(selling, buying) {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("no volume filter for the pair of assets %s and %s", utils.Asset2String(selling), utils.Asset2String(buying))
+}
+
+// splitOffers returns the offers for each filter, keeping the order of the offers
+func (f *multiPairVolumeFilter) splitOffers(offers []hProtocol.Offer) ([][]hProtocol.Offer, error) {
+	offersByFilter := make([][]hProtocol.Offer, len(f.filters))
+	for i := range offersByFilter {
+		offersByFilter[i] = []hProtocol.Offer{}
+	}
+	for _, offer := range offers {
+		idx, e := f.filterIndex(offer.Selling, offer.Buying)
+		if e != nil {
+			return nil, fmt.Errorf("could not route offer %d: %s", offer.ID, e)
+		}
+		offersByFilter[idx] = append(offersByFilter[idx], offer)
+	}
+	return offersByFilter, nil
+}
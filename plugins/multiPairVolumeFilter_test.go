@@ -0,0 +1,130 @@
+package plugins
+
+import (
+	"testing"
+
+	"github.com/openlyinc/pointy"
+	hProtocol "github.com/stellar/go/protocols/horizon"
+	"github.com/stellar/go/txnbuild"
+	"github.com/stellar/kelp/queries"
+	"github.com/stretchr/testify/assert"
+)
+
+func makeTestPairVolumeFilter(quoteCode string, capInBaseUnits float64, baseVol float64) *volumeFilter {
+	config := makeRawVolumeFilterConfig(pointy.Float64(capInBaseUnits), nil, volumeFilterModeExact, []string{}, []string{})
+	f := makeTestVolumeFilter(config, &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: baseVol, QuoteVol: 0.0}})
+	f.quoteAsset = hProtocol.Asset{Type: "credit_alphanum4", Code: quoteCode, Issuer: "GISSUER"}
+	return f
+}
+
+func makeTestPairSellOffer(quoteCode string, amount string) *txnbuild.ManageSellOffer {
+	op := makeManageSellOffer("2.0", amount)
+	op.Buying = txnbuild.CreditAsset{Code: quoteCode, Issuer: "GISSUER"}
+	return op
+}
+
+func TestMultiPairVolumeFilterApply(t *testing.T) {
+	// the USD pair has a headroom of 2 and the EUR pair has a headroom of 10
+	usdFilter := makeTestPairVolumeFilter("USD", 10.0, 8.0)
+	eurFilter := makeTestPairVolumeFilter("EUR", 10.0, 0.0)
+	f, e := MakeMultiPairVolumeFilter(usdFilter, eurFilter)
+	if !assert.NoError(t, e) {
+		return
+	}
+
+	payment := &txnbuild.Payment{Destination: "destination", Amount: "1.0", Asset: txnbuild.NativeAsset{}}
+	ops := []txnbuild.Operation{
+		makeTestPairSellOffer("EUR", "5.0"),
+		makeTestPairSellOffer("USD", "5.0"),
+		payment,
+		makeTestPairSellOffer("EUR", "4.0"),
+		makeTestPairSellOffer("USD", "1.0"),
+	}
+	actual, e := f.Apply(ops, []hProtocol.Offer{}, []hProtocol.Offer{})
+	if !assert.NoError(t, e) {
+		return
+	}
+
+	// each pair is capped independently and the ops keep their original order
+	assert.Equal(t, []txnbuild.Operation{
+		makeTestPairSellOffer("EUR", "5.0"),
+		makeTestPairSellOffer("USD", "2.0000000"),
+		payment,
+		makeTestPairSellOffer("EUR", "4.0"),
+	}, actual)
+	assert.Equal(t, 1, len(usdFilter.dailyVolumeByDateQuery.(*mockDailyVolumeQuery).calledArgs))
+	assert.Equal(t, 1, len(eurFilter.dailyVolumeByDateQuery.(*mockDailyVolumeQuery).calledArgs))
+
+	// a resting offer of a pair without any ops is re-checked against the caps of its pair after the ops
+	usdOffer := makeSellingOffer(7, 2, 1, "3.0000000")
+	usdOffer.Buying = hProtocol.Asset{Type: "credit_alphanum4", Code: "USD", Issuer: "GISSUER"}
+	actual, e = f.Apply([]txnbuild.Operation{makeTestPairSellOffer("EUR", "1.0")}, []hProtocol.Offer{usdOffer}, []hProtocol.Offer{})
+	if !assert.NoError(t, e) {
+		return
+	}
+	trimmedOffer := convertOffer2MSO(usdOffer)
+	trimmedOffer.Amount = "2.0000000"
+	assert.Equal(t, []txnbuild.Operation{makeTestPairSellOffer("EUR", "1.0"), trimmedOffer}, actual)
+
+	// an op on a pair without a filter is an error
+	_, e = f.Apply([]txnbuild.Operation{makeTestPairSellOffer("GBP", "1.0")}, []hProtocol.Offer{}, []hProtocol.Offer{})
+	assert.Error(t, e)
+}
+
+func TestMultiPairVolumeFilterApplyTruncatedUpdate(t *testing.T) {
+	// the USD pair has a headroom of 2 and only processes the first op
+	usdFilter := makeTestPairVolumeFilter("USD", 10.0, 8.0)
+	usdFilter.config.MaxOpsGuard = &MaxOpsGuard{MaxOps: 1, Truncate: true}
+	f, e := MakeMultiPairVolumeFilter(usdFilter)
+	if !assert.NoError(t, e) {
+		return
+	}
+
+	usdOffer := makeSellingOffer(7, 2, 1, "3.0000000")
+	usdOffer.Buying = hProtocol.Asset{Type: "credit_alphanum4", Code: "USD", Issuer: "GISSUER"}
+	updateOp := makeTestPairSellOffer("USD", "5.0")
+	updateOp.OfferID = 7
+	actual, e := f.Apply([]txnbuild.Operation{makeTestPairSellOffer("USD", "1.0"), updateOp}, []hProtocol.Offer{usdOffer}, []hProtocol.Offer{})
+	if !assert.NoError(t, e) {
+		return
+	}
+
+	// the update of the existing offer was truncated so the existing offer is re-checked against the remaining headroom
+	trimmedOffer := convertOffer2MSO(usdOffer)
+	trimmedOffer.Amount = "1.0000000"
+	assert.Equal(t, []txnbuild.Operation{makeTestPairSellOffer("USD", "1.0"), trimmedOffer}, actual)
+}
+
+func TestMultiPairVolumeFilterApplyCountDeletesAsFreeingCap(t *testing.T) {
+	// the USD pair has a headroom of 6 and the deleted offer counts 4 base units until its delete op
+	usdFilter := makeTestPairVolumeFilter("USD", 10.0, 4.0)
+	usdFilter.config.CountDeletesAsFreeingCap = true
+	f, e := MakeMultiPairVolumeFilter(usdFilter)
+	if !assert.NoError(t, e) {
+		return
+	}
+
+	usdOffer := makeSellingOffer(7, 2, 1, "4.0000000")
+	usdOffer.Buying = hProtocol.Asset{Type: "credit_alphanum4", Code: "USD", Issuer: "GISSUER"}
+	deleteOp := makeTestPairSellOffer("USD", "0")
+	deleteOp.OfferID = 7
+	ops := []txnbuild.Operation{makeTestPairSellOffer("USD", "3.0"), deleteOp, makeTestPairSellOffer("USD", "3.0")}
+	actual, e := f.Apply(ops, []hProtocol.Offer{usdOffer}, []hProtocol.Offer{})
+	if !assert.NoError(t, e) {
+		return
+	}
+
+	// the first op is trimmed to the remaining headroom of 2 and the delete restores the headroom for the op after it
+	assert.Equal(t, []txnbuild.Operation{makeTestPairSellOffer("USD", "2.0000000"), deleteOp, makeTestPairSellOffer("USD", "3.0")}, actual)
+}
+
+func TestMakeMultiPairVolumeFilter(t *testing.T) {
+	_, e := MakeMultiPairVolumeFilter()
+	assert.Error(t, e)
+
+	_, e = MakeMultiPairVolumeFilter(makeTestPairVolumeFilter("USD", 10.0, 0.0), makeTestPairVolumeFilter("USD", 20.0, 0.0))
+	assert.Error(t, e)
+
+	_, e = MakeMultiPairVolumeFilter(makeTestPairVolumeFilter("USD", 10.0, 0.0), &multiPairVolumeFilter{})
+	assert.Error(t, e)
+}
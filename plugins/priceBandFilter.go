@@ -0,0 +1,151 @@
+package plugins
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	hProtocol "github.com/stellar/go/protocols/horizon"
+	"github.com/stellar/go/txnbuild"
+	"github.com/stellar/kelp/api"
+	"github.com/stellar/kelp/support/utils"
+)
+
+// PriceBandFilterConfig is the band of prices (in quote units per base unit) outside of which the ops are dropped, which guards against
+// fat-finger or oracle-glitch prices. The band is either fixed between MinPrice and MaxPrice (either of which can be nil to leave that side
+// of the band open), or is BandPercent above and below the price of the ReferencePriceFeed fetched on every call to Apply.
+type PriceBandFilterConfig struct {
+	MinPrice *float64
+	MaxPrice *float64
+	// BandPercent is the width of the band on each side of the reference price in percent, e.g. 5.0 keeps the prices within 5% of it
+	BandPercent        *float64
+	ReferencePriceFeed api.PriceFeed
+}
+
+type priceBandFilter struct {
+	name       string
+	config     *PriceBandFilterConfig
+	baseAsset  hProtocol.Asset
+	quoteAsset hProtocol.Asset
+}
+
+// MakeFilterPriceBand makes a submit filter that drops the ops with a price outside of the band
+func MakeFilterPriceBand(baseAsset hProtocol.Asset, quoteAsset hProtocol.Asset, config *PriceBandFilterConfig) (SubmitFilter, error) {
+	e := config.Validate()
+	if e != nil {
+		return nil, fmt.Errorf("invalid price band filter config: %s", e)
+	}
+
+	return &priceBandFilter{
+		name:       "priceBandFilter",
+		config:     config,
+		baseAsset:  baseAsset,
+		quoteAsset: quoteAsset,
+	}, nil
+}
+
+var _ SubmitFilter = &priceBandFilter{}
+
+// Validate ensures validity
+func (c *PriceBandFilterConfig) Validate() error {
+	isFixed := c.MinPrice != nil || c.MaxPrice != nil
+	isRelative := c.BandPercent != nil || c.ReferencePriceFeed != nil
+	if isFixed == isRelative {
+		return fmt.Errorf("needs either a MinPrice and/or MaxPrice, or a BandPercent with a ReferencePriceFeed")
+	}
+
+	if isFixed {
+		if c.MinPrice != nil && *c.MinPrice < 0 {
+			return fmt.Errorf("MinPrice needs to be non-negative but was %f", *c.MinPrice)
+		}
+		if c.MaxPrice != nil && *c.MaxPrice <= 0 {
+			return fmt.Errorf("MaxPrice needs to be positive but was %f", *c.MaxPrice)
+		}
+		if c.MinPrice != nil && c.MaxPrice != nil && *c.MinPrice > *c.MaxPrice {
+			return fmt.Errorf("MinPrice (%f) needs to be less than or equal to MaxPrice (%f)", *c.MinPrice, *c.MaxPrice)
+		}
+		return nil
+	}
+
+	if c.BandPercent == nil || c.ReferencePriceFeed == nil {
+		return fmt.Errorf("needs both a BandPercent and a ReferencePriceFeed")
+	}
+	if *c.BandPercent <= 0 || *c.BandPercent >= 100 {
+		return fmt.Errorf("BandPercent needs to be within (0, 100) but was %f", *c.BandPercent)
+	}
+	return nil
+}
+
+// String is the stringer method
+func (c *PriceBandFilterConfig) String() string {
+	return fmt.Sprintf("PriceBandFilterConfig[MinPrice=%s, MaxPrice=%s, BandPercent=%s, hasReferencePriceFeed=%v]",
+		utils.CheckedFloatPtr(c.MinPrice), utils.CheckedFloatPtr(c.MaxPrice), utils.CheckedFloatPtr(c.BandPercent), c.ReferencePriceFeed != nil)
+}
+
+func (f *priceBandFilter) Apply(ops []txnbuild.Operation, sellingOffers []hProtocol.Offer, buyingOffers []hProtocol.Offer) ([]txnbuild.Operation, error) {
+	minPrice, maxPrice, e := f.band()
+	if e != nil {
+		return nil, fmt.Errorf("could not compute the price band: %s", e)
+	}
+
+	innerFn := func(op *txnbuild.ManageSellOffer) (*txnbuild.ManageSellOffer, error) {
+		return priceBandFilterFn(f.baseAsset, f.quoteAsset, minPrice, maxPrice, op)
+	}
+	ops, e = filterOps(f.name, f.baseAsset, f.quoteAsset, sellingOffers, buyingOffers, ops, innerFn, nil, nil)
+	if e != nil {
+		return nil, fmt.Errorf("could not apply filter: %s", e)
+	}
+	return ops, nil
+}
+
+// band returns the min and max prices of the band for this cycle, where a nil price leaves that side of the band open
+func (f *priceBandFilter) band() (*float64, *float64, error) {
+	if f.config.ReferencePriceFeed == nil {
+		return f.config.MinPrice, f.config.MaxPrice, nil
+	}
+
+	referencePrice, e := f.config.ReferencePriceFeed.GetPrice()
+	if e != nil {
+		return nil, nil, fmt.Errorf("could not get price from the reference priceFeed: %s", e)
+	}
+	if referencePrice <= 0 {
+		return nil, nil, fmt.Errorf("the reference price needs to be positive but was %f", referencePrice)
+	}
+	minPrice := referencePrice * (1 - *f.config.BandPercent/100)
+	maxPrice := referencePrice * (1 + *f.config.BandPercent/100)
+	log.Printf("priceBandFilter: referencePrice=%.10f, band=[%.10f, %.10f]\n", referencePrice, minPrice, maxPrice)
+	return &minPrice, &maxPrice, nil
+}
+
+func priceBandFilterFn(
+	baseAsset hProtocol.Asset,
+	quoteAsset hProtocol.Asset,
+	minPrice *float64,
+	maxPrice *float64,
+	op *txnbuild.ManageSellOffer,
+) (*txnbuild.ManageSellOffer, error) {
+	isSell, e := utils.IsSelling(baseAsset, quoteAsset, op.Selling, op.Buying)
+	if e != nil {
+		return nil, fmt.Errorf("error when running the isSelling check for offer '%+v': %s", *op, e)
+	}
+
+	sellPrice, e := strconv.ParseFloat(op.Price, 64)
+	if e != nil {
+		return nil, fmt.Errorf("could not convert price (%s) to float: %s", op.Price, e)
+	}
+	// the price of a buy op is in base units per quote unit so it is inverted to compare it to the band
+	price := sellPrice
+	if !isSell {
+		if sellPrice <= 0 {
+			return nil, fmt.Errorf("the price of the buy op needs to be positive but was %s", op.Price)
+		}
+		price = 1 / sellPrice
+	}
+
+	if (minPrice != nil && price < *minPrice) || (maxPrice != nil && price > *maxPrice) {
+		log.Printf("priceBandFilter: dropping op with isSell=%v, price=%.10f outside of the band [%s, %s]\n",
+			isSell, price, utils.CheckedFloatPtr(minPrice), utils.CheckedFloatPtr(maxPrice))
+		return nil, nil
+	}
+	return op, nil
+}
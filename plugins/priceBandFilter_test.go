@@ -0,0 +1,170 @@
+package plugins
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/openlyinc/pointy"
+	hProtocol "github.com/stellar/go/protocols/horizon"
+	"github.com/stellar/go/txnbuild"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/stellar/kelp/support/utils"
+)
+
+func TestPriceBandFilterConfigValidate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		config  PriceBandFilterConfig
+		wantErr bool
+	}{
+		{
+			name:   "fixed band",
+			config: PriceBandFilterConfig{MinPrice: pointy.Float64(0.5), MaxPrice: pointy.Float64(2.0)},
+		}, {
+			name:   "only a min price",
+			config: PriceBandFilterConfig{MinPrice: pointy.Float64(0.5)},
+		}, {
+			name:   "reference band",
+			config: PriceBandFilterConfig{BandPercent: pointy.Float64(5.0), ReferencePriceFeed: &mockUSDPriceFeed{price: 1.0}},
+		}, {
+			name:    "empty",
+			config:  PriceBandFilterConfig{},
+			wantErr: true,
+		}, {
+			name:    "fixed and reference band",
+			config:  PriceBandFilterConfig{MinPrice: pointy.Float64(0.5), BandPercent: pointy.Float64(5.0), ReferencePriceFeed: &mockUSDPriceFeed{price: 1.0}},
+			wantErr: true,
+		}, {
+			name:    "min price above max price",
+			config:  PriceBandFilterConfig{MinPrice: pointy.Float64(2.0), MaxPrice: pointy.Float64(0.5)},
+			wantErr: true,
+		}, {
+			name:    "zero max price",
+			config:  PriceBandFilterConfig{MaxPrice: pointy.Float64(0.0)},
+			wantErr: true,
+		}, {
+			name:    "band percent without a reference price feed",
+			config:  PriceBandFilterConfig{BandPercent: pointy.Float64(5.0)},
+			wantErr: true,
+		}, {
+			name:    "band percent of 100",
+			config:  PriceBandFilterConfig{BandPercent: pointy.Float64(100.0), ReferencePriceFeed: &mockUSDPriceFeed{price: 1.0}},
+			wantErr: true,
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			e := k.config.Validate()
+			if k.wantErr {
+				assert.Error(t, e)
+			} else {
+				assert.NoError(t, e)
+			}
+		})
+	}
+}
+
+func TestPriceBandFilterApply(t *testing.T) {
+	ops := []txnbuild.Operation{
+		makeSellBaseOp("0.4", "10.0"),
+		makeSellBaseOp("1.0", "10.0"),
+		makeSellBaseOp("2.5", "10.0"),
+		makeBuyOp("1.0", "10.0"),  // buys at a price of 1.0
+		makeBuyOp("0.25", "10.0"), // buys at a price of 4.0
+	}
+	testCases := []struct {
+		name    string
+		config  *PriceBandFilterConfig
+		wantOps []txnbuild.Operation
+	}{
+		{
+			name:    "fixed band",
+			config:  &PriceBandFilterConfig{MinPrice: pointy.Float64(0.5), MaxPrice: pointy.Float64(2.0)},
+			wantOps: []txnbuild.Operation{ops[1], ops[3]},
+		}, {
+			name:    "open max price",
+			config:  &PriceBandFilterConfig{MinPrice: pointy.Float64(0.5)},
+			wantOps: []txnbuild.Operation{ops[1], ops[2], ops[3], ops[4]},
+		}, {
+			name:    "reference band",
+			config:  &PriceBandFilterConfig{BandPercent: pointy.Float64(50.0), ReferencePriceFeed: &mockUSDPriceFeed{price: 2.0}},
+			wantOps: []txnbuild.Operation{ops[1], ops[2], ops[3]},
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			f, e := MakeFilterPriceBand(testBuyBaseAsset, utils.NativeAsset, k.config)
+			if !assert.NoError(t, e) {
+				return
+			}
+			actual, e := f.Apply(ops, []hProtocol.Offer{}, []hProtocol.Offer{})
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.Equal(t, k.wantOps, actual)
+		})
+	}
+
+	// the reference price is fetched once per call and a failure fails the call
+	pf := &mockUSDPriceFeed{price: 2.0}
+	f, e := MakeFilterPriceBand(testBuyBaseAsset, utils.NativeAsset, &PriceBandFilterConfig{BandPercent: pointy.Float64(50.0), ReferencePriceFeed: pf})
+	if !assert.NoError(t, e) {
+		return
+	}
+	_, e = f.Apply(ops, []hProtocol.Offer{}, []hProtocol.Offer{})
+	assert.NoError(t, e)
+	assert.Equal(t, 1, pf.numCalls)
+	pf.err = fmt.Errorf("feed unavailable")
+	_, e = f.Apply(ops, []hProtocol.Offer{}, []hProtocol.Offer{})
+	assert.Error(t, e)
+	pf.err = nil
+	pf.price = 0.0
+	_, e = f.Apply(ops, []hProtocol.Offer{}, []hProtocol.Offer{})
+	assert.Error(t, e)
+
+	_, e = MakeFilterPriceBand(testBuyBaseAsset, utils.NativeAsset, &PriceBandFilterConfig{})
+	assert.Error(t, e)
+}
+
+func TestMakeFilterPriceBand(t *testing.T) {
+	factory := &FilterFactory{BaseAsset: testBuyBaseAsset, QuoteAsset: utils.NativeAsset}
+	ops := []txnbuild.Operation{makeSellBaseOp("0.4", "10.0"), makeSellBaseOp("1.0", "10.0"), makeSellBaseOp("1.2", "10.0")}
+
+	testCases := []struct {
+		configInput string
+		wantOps     []txnbuild.Operation
+	}{
+		{configInput: "priceBand/fixed/0.5/2.0", wantOps: []txnbuild.Operation{ops[1], ops[2]}},
+		{configInput: "priceBand/reference/10.0/fixed/1.0", wantOps: []txnbuild.Operation{ops[1]}},
+	}
+	for _, k := range testCases {
+		t.Run(k.configInput, func(t *testing.T) {
+			f, e := factory.MakeFilter(k.configInput)
+			if !assert.NoError(t, e) {
+				return
+			}
+			actual, e := f.Apply(ops, []hProtocol.Offer{}, []hProtocol.Offer{})
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.Equal(t, k.wantOps, actual)
+		})
+	}
+
+	for _, configInput := range []string{
+		"priceBand/fixed/0.5",
+		"priceBand/fixed/0.5/2.0/3.0",
+		"priceBand/fixed/a/2.0",
+		"priceBand/fixed/2.0/0.5",
+		"priceBand/reference/10.0/fixed",
+		"priceBand/reference/a/fixed/1.0",
+		"priceBand/reference/0.0/fixed/1.0",
+		"priceBand/other/0.5/2.0",
+	} {
+		_, e := factory.MakeFilter(configInput)
+		assert.Error(t, e, configInput)
+	}
+}
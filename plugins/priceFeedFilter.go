@@ -60,7 +60,7 @@ func MakeFilterPriceFeed(baseAsset hProtocol.Asset, quoteAsset hProtocol.Asset,
 var _ SubmitFilter = &priceFeedFilter{}
 
 func (f *priceFeedFilter) Apply(ops []txnbuild.Operation, sellingOffers []hProtocol.Offer, buyingOffers []hProtocol.Offer) ([]txnbuild.Operation, error) {
-	ops, e := filterOps(f.name, f.baseAsset, f.quoteAsset, sellingOffers, buyingOffers, ops, f.priceFeedFilterFn)
+	ops, e := filterOps(f.name, f.baseAsset, f.quoteAsset, sellingOffers, buyingOffers, ops, f.priceFeedFilterFn, nil, nil)
 	if e != nil {
 		return nil, fmt.Errorf("could not apply filter: %s", e)
 	}
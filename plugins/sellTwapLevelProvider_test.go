@@ -69,6 +69,9 @@ func makeTestSellTwapLevelProvider2(
 	minChildOrderSizePercentOfParent float64,
 ) *sellTwapLevelProvider {
 	startPf, _ := newFixedFeed("10.0")
+	sellBaseFilter := volumeFilter{
+		config: &VolumeFilterConfig{action: volumeFilterActionSell, units: volumeFilterUnitsBase},
+	}
 	offset := rateOffset{
 		percent:      0.0,
 		absolute:     0.0,
@@ -79,13 +82,13 @@ func makeTestSellTwapLevelProvider2(
 		offset,
 		model.MakeOrderConstraints(7, 7, 0.1),
 		[7]volumeFilter{
-			volumeFilter{configValue: "/sell/base/"},
-			volumeFilter{configValue: "/sell/base/"},
-			volumeFilter{configValue: "/sell/base/"},
-			volumeFilter{configValue: "/sell/base/"},
-			volumeFilter{configValue: "/sell/base/"},
-			volumeFilter{configValue: "/sell/base/"},
-			volumeFilter{configValue: "/sell/base/"}},
+			sellBaseFilter,
+			sellBaseFilter,
+			sellBaseFilter,
+			sellBaseFilter,
+			sellBaseFilter,
+			sellBaseFilter,
+			sellBaseFilter},
 		numHoursToSell,
 		parentBucketSizeSeconds,
 		0.05,
@@ -19,6 +19,12 @@ type SubmitFilter interface {
 	) ([]txnbuild.Operation, error)
 }
 
+// PostSubmitFilter is a SubmitFilter that needs to know when the ops returned by its last Apply were submitted successfully
+type PostSubmitFilter interface {
+	SubmitFilter
+	PostSubmit() error
+}
+
 // filterFn returns a non-nil op to indicate the op that we want to append to the update. the newOp can do one of the following:
 //     - modify an existing offer
 //     - create a new offer
@@ -29,6 +35,36 @@ type SubmitFilter interface {
 // the existing offer. i.e. if filterFn returns a nil newOp value then we will "drop" that operation or delete the existing offer.
 type filterFn func(op *txnbuild.ManageSellOffer) (newOp *txnbuild.ManageSellOffer, e error)
 
+// deleteFn is notified of each delete op (with an amount of "0") in the passed in ops when filterOps reaches it, in the same order as the
+// filterFn is run on the other ops. Delete ops are never dropped so the filterFn is not run on them, this allows a filter to update its
+// state for the ops that follow the delete op.
+type deleteFn func(op *txnbuild.ManageSellOffer) error
+
+// MaxOpsGuard protects filterOps against runaway op lists by limiting the number of ops that can be passed in
+type MaxOpsGuard struct {
+	MaxOps int
+	// Truncate processes only the first MaxOps ops (which are in priority order) and logs a warning, otherwise we return an error
+	Truncate bool
+}
+
+// String is the stringer method
+func (g *MaxOpsGuard) String() string {
+	return fmt.Sprintf("MaxOpsGuard[MaxOps=%d, Truncate=%v]", g.MaxOps, g.Truncate)
+}
+
+// apply returns the list of ops that should be processed, a nil guard allows all ops
+func (g *MaxOpsGuard) apply(filterName string, ops []txnbuild.Operation) ([]txnbuild.Operation, error) {
+	if g == nil || len(ops) <= g.MaxOps {
+		return ops, nil
+	}
+
+	if !g.Truncate {
+		return nil, fmt.Errorf("filter \"%s\" received %d ops which exceeds the max of %d ops", filterName, len(ops), g.MaxOps)
+	}
+	log.Printf("warning: filter \"%s\" received %d ops which exceeds the max of %d ops, only processing the first %d ops\n", filterName, len(ops), g.MaxOps, g.MaxOps)
+	return ops[:g.MaxOps], nil
+}
+
 type filterCounter struct {
 	idx         int
 	kept        uint8
@@ -117,7 +153,14 @@ func filterOps(
 	buyingOffers []hProtocol.Offer,
 	ops []txnbuild.Operation,
 	fn filterFn,
+	onDelete deleteFn,
+	guard *MaxOpsGuard,
 ) ([]txnbuild.Operation, error) {
+	ops, e := guard.apply(filterName, ops)
+	if e != nil {
+		return nil, fmt.Errorf("could not apply max ops guard: %s", e)
+	}
+
 	ignoreOfferIds := ignoreOfferIDs(ops)
 	offerMap := makeOfferMap(append(sellingOffers, buyingOffers...))
 	opCounter := filterCounter{}
@@ -159,6 +202,12 @@ func filterOps(
 				continue
 			}
 			originalOfferAsOp := fetchOfferAsOpByID(opToTransform.OfferID, offerMap)
+			if onDelete != nil && opToTransform.Amount == "0" {
+				e = onDelete(opToTransform)
+				if e != nil {
+					return nil, fmt.Errorf("error while running delete fn: %s", e)
+				}
+			}
 
 			newOpToPrepend, newOpToAppend, incrementValues, e := runInnerFilterFn(
 				*opToTransform, // pass copy
@@ -193,7 +242,7 @@ func filterOps(
 	}
 
 	// convert all remaining buy and sell offers to delete offers
-	filteredOps, e := handleRemainingOffers(
+	filteredOps, e = handleRemainingOffers(
 		&sellCounter,
 		sellingOffers,
 		ignoreOfferIds,
@@ -0,0 +1,65 @@
+package plugins
+
+import (
+	"fmt"
+	"testing"
+
+	hProtocol "github.com/stellar/go/protocols/horizon"
+	"github.com/stellar/go/txnbuild"
+	"github.com/stellar/kelp/support/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func keepAllFilterFn(op *txnbuild.ManageSellOffer) (*txnbuild.ManageSellOffer, error) {
+	return op, nil
+}
+
+func TestFilterOpsMaxOpsGuard(t *testing.T) {
+	ops := []txnbuild.Operation{}
+	for i := 0; i < 5; i++ {
+		ops = append(ops, makeManageSellOffer(fmt.Sprintf("%d.0", i+1), "10.0"))
+	}
+
+	testCases := []struct {
+		name    string
+		guard   *MaxOpsGuard
+		wantOps []txnbuild.Operation
+		wantErr bool
+	}{
+		{
+			name:    "nil guard",
+			guard:   nil,
+			wantOps: ops,
+		}, {
+			name:    "under limit",
+			guard:   &MaxOpsGuard{MaxOps: 10, Truncate: false},
+			wantOps: ops,
+		}, {
+			name:    "at limit",
+			guard:   &MaxOpsGuard{MaxOps: 5, Truncate: false},
+			wantOps: ops,
+		}, {
+			name:    "over limit, error policy",
+			guard:   &MaxOpsGuard{MaxOps: 3, Truncate: false},
+			wantErr: true,
+		}, {
+			name:    "over limit, truncate policy",
+			guard:   &MaxOpsGuard{MaxOps: 3, Truncate: true},
+			wantOps: ops[:3],
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			actual, e := filterOps("testFilter", utils.NativeAsset, utils.NativeAsset, []hProtocol.Offer{}, []hProtocol.Offer{}, ops, keepAllFilterFn, nil, k.guard)
+			if k.wantErr {
+				assert.Error(t, e)
+				return
+			}
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.Equal(t, k.wantOps, actual)
+		})
+	}
+}
@@ -1,17 +1,24 @@
 package plugins
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
+	"math"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	hProtocol "github.com/stellar/go/protocols/horizon"
+	"github.com/stellar/go/strkey"
 	"github.com/stellar/go/txnbuild"
+	"github.com/stellar/kelp/api"
 	"github.com/stellar/kelp/model"
 	"github.com/stellar/kelp/queries"
+	"github.com/stellar/kelp/support/logger"
 	"github.com/stellar/kelp/support/postgresdb"
 	"github.com/stellar/kelp/support/utils"
 )
@@ -22,6 +29,17 @@ type volumeFilterMode string
 const (
 	volumeFilterModeExact  volumeFilterMode = "exact"
 	volumeFilterModeIgnore volumeFilterMode = "ignore"
+	// volumeFilterModeScale multiplies the amount of every sell op in the batch by the same factor so the total hits the cap, instead of
+	// fully keeping the earlier ops and dropping the later ones
+	volumeFilterModeScale volumeFilterMode = "scale"
+)
+
+// the sides and the units of the cap in the config value of a volume filter
+const (
+	volumeFilterActionSell = "sell"
+	volumeFilterActionBuy  = "buy"
+	volumeFilterUnitsBase  = "base"
+	volumeFilterUnitsQuote = "quote"
 )
 
 func parseVolumeFilterMode(mode string) (volumeFilterMode, error) {
@@ -29,25 +47,581 @@ func parseVolumeFilterMode(mode string) (volumeFilterMode, error) {
 		return volumeFilterModeExact, nil
 	} else if mode == string(volumeFilterModeIgnore) {
 		return volumeFilterModeIgnore, nil
+	} else if mode == string(volumeFilterModeScale) {
+		return volumeFilterModeScale, nil
 	}
 	return volumeFilterModeExact, fmt.Errorf("invalid input mode '%s'", mode)
 }
 
+// trimsOps returns true if the mode trims the amount of an op that exceeds a cap instead of dropping it, which the scale mode does for any
+// op that still exceeds a cap after the ops were scaled (e.g. in ApplyStream, which does not scale the ops)
+func (m volumeFilterMode) trimsOps() bool {
+	return m == volumeFilterModeExact || m == volumeFilterModeScale
+}
+
 // VolumeFilterConfig ensures that any one constraint that is hit will result in deleting all offers and pausing until limits are no longer constrained
 type VolumeFilterConfig struct {
+	// SellBaseAssetCapInBaseUnits and SellBaseAssetCapInQuoteUnits cap the volume sold per day. Any combination of the caps in this config can
+	// be set (at least one unless MonitorOnly), each cap is enforced independently so the first cap that is hit drops or trims the op.
+	SellBaseAssetCapInBaseUnits  *float64
+	SellBaseAssetCapInQuoteUnits *float64
+	// SellBaseAssetCapInUSD optionally caps the USD value of the base units sold per day, using the price of the base asset from the
+	// PriceFeed. The volume sold earlier in the day is valued at the current price and not at the price at which it was sold. It needs the
+	// PriceFeed.
+	SellBaseAssetCapInUSD *float64
+	// PriceFeed returns the price of one unit of the base asset in USD for the SellBaseAssetCapInUSD, e.g. one of the feeds made with
+	// MakePriceFeed. It is called once per batch and the price is used for all the ops in the batch.
+	PriceFeed api.PriceFeed
+	// BuyBaseAssetCapInBaseUnits optionally caps the base units bought per day, using the daily volume of the buy trades. Buy ops are dropped
+	// (as unsupported) when there is no buy cap. It cannot be combined with CustomVolumeSQL, which only returns the volume sold.
+	BuyBaseAssetCapInBaseUnits *float64
+	// BuyBaseAssetCapInQuoteUnits optionally caps the quote units spent per day on buying the base asset, with the same restrictions as
+	// the BuyBaseAssetCapInBaseUnits
+	BuyBaseAssetCapInQuoteUnits *float64
+	// SellBaseAssetCapInBaseUnitsWeekly and SellBaseAssetCapInQuoteUnitsWeekly optionally cap the volume sold in the ISO week (starting on
+	// Monday at midnight UTC) of the date, and the monthly caps do the same for the calendar month. They apply on top of the daily caps and
+	// the tightest cap binds, where the headroom left in a longer window is converted to a cap on the daily volume. They cannot be combined
+	// with CustomVolumeSQL, RollingTradeCount, WindowDuration, ResetTimeUTC, or MinFillPriceToCount.
+	SellBaseAssetCapInBaseUnitsWeekly   *float64
+	SellBaseAssetCapInQuoteUnitsWeekly  *float64
+	SellBaseAssetCapInBaseUnitsMonthly  *float64
+	SellBaseAssetCapInQuoteUnitsMonthly *float64
+	mode                                volumeFilterMode
+	additionalMarketIDs                 []string
+	optionalAccountIDs                  []string
+	// action and units are the side (volumeFilterActionSell or volumeFilterActionBuy) and the units (volumeFilterUnitsBase or
+	// volumeFilterUnitsQuote) of the cap in the config value, empty when the config was not made by ParseVolumeFilterConfigValue
+	action string
+	units  string
+	// MonitorOnly allows an otherwise empty config (no caps) so the filter only computes and reports volume without dropping or trimming any ops
+	MonitorOnly bool
+	// MaxOpsGuard optionally limits the number of ops that this filter will process in a single Apply call
+	MaxOpsGuard *MaxOpsGuard
+	// StateStore optionally persists the TBB (to-be-booked) accumulator at the end of every batch, keyed by the date and market, and restores
+	// it on the first batch after a restart on the same date so a restart does not transiently over-permit. Only the part of the persisted
+	// TBB that is not reflected in the resting sellingOffers or in the volume executed since the batch (i.e. volume that was placed and
+	// executed but not yet recorded in the daily volume) is restored since the resting offers are re-counted by every batch. A persisted TBB
+	// from an earlier date is ignored, which resets it on the rollover of the date. The volume of each account of the AccountCaps is not
+	// persisted.
+	StateStore VolumeFilterStateStore
+	// CountDeletesAsFreeingCap is for placed-based accounting, where the volume of the resting sell offers counts toward the caps until the
+	// offers are canceled. When set, the unsold volume of each resting sell offer that is deleted by an op of the batch keeps counting toward
+	// the caps until the delete op is reached, which then frees it as headroom for the ops after it (without counting toward or freeing the
+	// MaxBatchVolume). When not set, the deleted offers are left out of the batch altogether so their volume never counts. There is no separate
+	// CountPlacedTowardCap setting since the volume of the placed offers is already counted by re-checking the resting offers in every batch
+	// (and restored by the StateStore after a restart), so this only changes when the volume of a deleted offer stops counting. It is not used
+	// by ApplyStream or DryRun, which do not count the resting offers.
+	CountDeletesAsFreeingCap bool
+	// RecordPendingVolume records the volume sold by the last batch as the pending volume of the market on the date once its ops were
+	// submitted (see PostSubmit), replacing the pending volume recorded for the previous batch, and adds the pending volume of the markets
+	// to the settled volume of the trades in the daily volume query. This keeps the volume that was executed but is not yet in the trades
+	// table from being double-spent within the settlement lag. The recorded volume includes the offers that stay on the book, which the next
+	// batch of this filter takes out of the pending volume since it re-counts its resting offers, so only the pending volume of the offers
+	// that are no longer on the book counts (the pending volume recorded before a restart counts in full until it is replaced). It cannot be
+	// used with CustomVolumeSQL, RollingTradeCount, or WindowDuration.
+	RecordPendingVolume bool
+	// CapSchedule optionally overrides the caps above during specific times of the day, overlapping entries resolve to the tightest cap
+	CapSchedule []VolumeCapScheduleEntry
+	// ScheduleLocation is the timezone in which the CapSchedule times of day are specified, defaults to UTC when nil
+	ScheduleLocation *time.Location
+	// CustomVolumeSQL optionally replaces the default daily volume query on the trades table, see queries.MakeDailyVolumeWithCustomQuery.
+	// The market IDs and account IDs are not used when this is set, so the SQL is responsible for selecting the correct rows.
+	CustomVolumeSQL string
+	// CustomVolumeSQLArgs are passed to the CustomVolumeSQL as the parameters following the date ($2, $3, etc.)
+	CustomVolumeSQLArgs []interface{}
+	// MinFillPriceToCount optionally excludes fills at or below this price (in quote units) from the daily volume, to ignore wash-like dust
+	// fills. Note that this changes what the OTB (on-the-books) volume represents: it is the volume executed above this price and not the
+	// total executed volume, so the caps only limit the volume above this price. It is not used when CustomVolumeSQL is set.
+	MinFillPriceToCount *float64
+	// RollingTradeCount optionally caps the volume of the most recent RollingTradeCount executed trades instead of the volume of the day,
+	// regardless of when those trades were executed, zero disables it. The query sorts the trades by time on every cycle, which is more
+	// expensive than the daily volume query on a large trades table without an index on (market_id, date_utc). It cannot be combined with
+	// CustomVolumeSQL or MinFillPriceToCount.
+	RollingTradeCount int
+	// WindowDuration optionally caps the volume of the trades executed in the trailing window ending at the current time (e.g. 24h) instead
+	// of the volume of the calendar day, so the caps do not reset at midnight. Zero keeps the calendar day. It cannot be combined with
+	// RollingTradeCount, CustomVolumeSQL, or MinFillPriceToCount.
+	WindowDuration time.Duration
+	// MaxMarketIDs is the max number of market IDs (including the market of this filter) that one filter aggregates in its query, because
+	// a long IN-list of market IDs makes the query slow. Defaults to defaultMaxMarketIDs when zero, it can be raised when the trades table
+	// is indexed on market_id.
+	MaxMarketIDs int
+	// ResetUTCOffset shifts the UTC time by a fixed offset before computing the date of the daily volume, so "today" can be defined for a
+	// different timezone without needing the tz database (e.g. -5h resets the day at midnight UTC-5). It does not adjust for daylight saving
+	// time. The daily volume query buckets the trades by the same shifted day.
+	ResetUTCOffset time.Duration
+	// ResetTimeUTC optionally starts the day of the daily volume at this time after midnight UTC (e.g. 22h for a business day that starts at
+	// 17:00 EST), where the day is identified by the UTC date on which it starts. The daily volume query buckets the trades by the same
+	// shifted day. Values outside [0, 24h) wrap around, e.g. 26h is the same as 2h and -2h is the same as 22h. The
+	// reset time is fixed in UTC, so it does not follow daylight saving time: the 17:00 EST reset (22h) happens at 18:00 local time during
+	// EDT, and needs to be changed to 21h at the DST transition to stay at 17:00 local time. It cannot be combined with the ResetUTCOffset,
+	// UseDatabaseTradingDate, CustomVolumeSQL, RollingTradeCount, or WindowDuration.
+	ResetTimeUTC time.Duration
+	// UseDatabaseTradingDate queries the database for the current trading date (using the same date bucketing as the daily volume query)
+	// on every cycle instead of computing it from the local clock, so the bot's notion of "today" is aligned with the database. It cannot
+	// be combined with a ResetUTCOffset.
+	UseDatabaseTradingDate bool
+	// QuoteCapFeeRate optionally makes the SellBaseAssetCapInQuoteUnits inclusive of fees by adding an estimated fee at this rate to the
+	// projected quote value of every op, so the cap reflects the true cash flow. The daily volume from the trades table does not include fees.
+	QuoteCapFeeRate *float64
+	// DecisionHook is optionally called for every op after the volume filter computes its result (and updated the TBB accumulator), in the
+	// order of the ops, so it can keep, drop, or change the amount of the op. The TBB accumulator is then corrected to reflect the result of
+	// the hook so subsequent ops see it. The hook is allowed to increase the amount beyond what the caps allow, which logs a warning.
+	DecisionHook DecisionHook
+	// MaxBatchVolume optionally limits the total base units sold by the ops in a single batch (one Apply call), independent of the daily cap.
+	// It is enforced after the daily caps are checked, using the same mode (exact trims the op that crosses the limit, ignore drops it).
+	MaxBatchVolume *float64
+	// MinSellBaseAmount optionally drops a sell op when a cap trims its amount below this many base units, instead of submitting a dust
+	// offer that the exchange would reject. Ops that are not trimmed are kept regardless of their amount.
+	MinSellBaseAmount *float64
+	// TraceOps logs the complete op (including the source account and assets) before and after the filter modifies it, i.e. when it trims
+	// the amount, rounds the price, or drops the op, which helps debug why a specific op was modified. It is off by default because it bloats
+	// the logs and includes account data.
+	TraceOps bool
+	// TraceLogger is the logger used by TraceOps, defaults to the basic logger when nil
+	TraceLogger logger.Logger
+	// AuditSink optionally receives a record of every op that the filter drops or trims in Apply and ApplyStream (but not in DryRun), which
+	// is meant to be retained as the record of the decisions unlike the logs. Apply fails when the record cannot be written.
+	AuditSink AuditSink
+	// PricePrecisionGuard optionally rounds or drops ops with a price that has more precision than the exchange supports, nil disables it
+	PricePrecisionGuard *PricePrecisionGuard
+	// MaxStaleBeforeBlock optionally bounds the age of the daily volume pre-fetched by the background refresh, Apply queries the daily volume
+	// synchronously (within the QueryTimeout) instead of using a pre-fetched value that is older than this (e.g. when the refresh is stalled),
+	// zero disables the bound
+	MaxStaleBeforeBlock time.Duration
+	// MinQueryInterval optionally rate-limits the volume queries to at most one execution of each query per interval (based on the clock of
+	// the filter) regardless of how often Apply is called, by reusing the last result of the query for the same date within the interval.
+	// Any volume traded since the last execution of a query is not counted toward the caps until the query runs again. Zero disables it.
+	MinQueryInterval time.Duration
+	// QueryTimeout is the deadline for all the volume queries of a single call to Apply, ApplyStream, or DryRun, after which the call fails
+	// like any other query failure instead of blocking on a stalled database connection. Defaults to defaultVolumeQueryTimeout when zero.
+	QueryTimeout time.Duration
+	// SharedQueryCache optionally shares the results of the daily volume queries with the other filters using the same cache, so chained
+	// filters on the same market reuse the result of a single query until it expires. It is not used with CustomVolumeSQL, RollingTradeCount,
+	// or WindowDuration, and is set by the FilterFactory.
+	SharedQueryCache *VolumeQueryCache
+	// QueryCircuitBreaker optionally makes repeated failures of the daily volume query result in a sticky degraded state, nil disables it
+	QueryCircuitBreaker *QueryCircuitBreakerConfig
+	// OnQueryErrorBehavior is what the filter does with the ops of a batch when a volume query fails without a QueryCircuitBreaker, which
+	// defaults to QueryErrorBehaviorFail when empty
+	OnQueryErrorBehavior QueryErrorBehavior
+	// AlertCapInBaseUnits and AlertCapInQuoteUnits are optional alert-only thresholds below the enforcing caps, which emit a VolumeCapAlert
+	// when the daily volume (OTB + TBB) crosses them but never drop or trim any ops
+	AlertCapInBaseUnits  *float64
+	AlertCapInQuoteUnits *float64
+	// OnAlert is optionally called with every VolumeCapAlert, in addition to logging it, so the alert can be forwarded as an event or metric
+	OnAlert VolumeCapAlertHandler
+	// OnCapHit is optionally called whenever a sell op would take the daily volume beyond the base or the quote cap, before the op is trimmed
+	// or dropped, so operators can be notified without parsing the logs
+	OnCapHit CapHitHandler
+	// MaxNetPositionInBaseUnits optionally caps the net position in base units (long or short) that the ops of a batch can take the account
+	// to, assuming that every kept op is fully executed: sell ops reduce the position and buy ops increase it. Ops that would push the net
+	// position beyond +/-MaxNetPositionInBaseUnits are dropped or trimmed based on the mode, except when they reduce the absolute position.
+	// Buy ops are supported without a buy cap when this is set. It needs the NetPosition callback.
+	MaxNetPositionInBaseUnits *float64
+	// NetPosition is called at the start of every batch to get the current net position used with the MaxNetPositionInBaseUnits
+	NetPosition NetPositionFn
+	// IncludeFlippedMarketIDs also counts the volume of the flipped market of this filter (where the quote asset is the base asset) so the
+	// volume is not undercounted when the same market is tracked under both orderings of the assets. A buy on the flipped market sells the
+	// base asset of this filter, and its base and quote volumes are swapped to be denominated in the assets of this filter. It only includes
+	// the flipped market of the trading pair of this filter and not of the additionalMarketIDs. It cannot be combined with CustomVolumeSQL,
+	// RollingTradeCount, or MinFillPriceToCount since the prices of the flipped market are inverted.
+	IncludeFlippedMarketIDs bool
+	// AccountCaps optionally caps the volume sold per day by each of the accounts (keyed by the account ID) on top of the caps above, so a
+	// pool of accounts that trades on the same markets can enforce a separate limit for each trader. The account of an op is its source
+	// account, ops of an account that is not in AccountCaps are only limited by the caps above. The volume of each account is queried
+	// separately from the optionalAccountIDs. The "scale" mode does not scale the ops to fit the account caps. It cannot be combined with CustomVolumeSQL, RollingTradeCount, WindowDuration, or IncludeFlippedMarketIDs.
+	AccountCaps map[string]AccountVolumeCap
+	// DefaultAccountID is the account of the ops that do not have a source account, which is the account of the transaction, and needs to
+	// be in the AccountCaps. Ops without a source account are not limited by the AccountCaps when this is empty.
+	DefaultAccountID string
+}
+
+// AccountVolumeCap is the daily cap of the volume sold by a single account of the AccountCaps, at least one of the caps needs to be set
+type AccountVolumeCap struct {
+	SellBaseAssetCapInBaseUnits  *float64
+	SellBaseAssetCapInQuoteUnits *float64
+}
+
+// String is the stringer method
+func (c AccountVolumeCap) String() string {
+	return fmt.Sprintf("AccountVolumeCap[SellBaseAssetCapInBaseUnits=%s, SellBaseAssetCapInQuoteUnits=%s]",
+		utils.CheckedFloatPtr(c.SellBaseAssetCapInBaseUnits), utils.CheckedFloatPtr(c.SellBaseAssetCapInQuoteUnits))
+}
+
+// accountSellCap is the cap of the account of an op along with the volume sold by that account
+type accountSellCap struct {
+	accountID string
+	cap       AccountVolumeCap
+	// otb is the volume sold by the account on the books and tbb accumulates the volume of the kept ops of the account in the batch
+	otb *queries.DailyVolume
+	tbb *queries.DailyVolume
+}
+
+// limit returns the amount of the sell op that fits in the caps of the account, and false when the op needs to be dropped. The reason is
+// the account cap that trimmed or dropped the op, DropReasonNone when the op fits in the caps.
+func (c *accountSellCap) limit(amount float64, priceInclFee float64, mode volumeFilterMode) (float64, bool, VolumeFilterDropReason) {
+	newAmount := amount
+	reason := DropReasonNone
+	if c.cap.SellBaseAssetCapInBaseUnits != nil {
+		projectedSoldInBaseUnits := c.otb.BaseVol + c.tbb.BaseVol + newAmount
+		if projectedSoldInBaseUnits > *c.cap.SellBaseAssetCapInBaseUnits {
+			remaining := *c.cap.SellBaseAssetCapInBaseUnits - c.otb.BaseVol - c.tbb.BaseVol
+			log.Printf("volumeFilter: selling (account '%s', base units), amount=%.8f, projectedSoldInBaseUnits %.7f > %.7f (SellBaseAssetCapInBaseUnits of the account)", c.accountID, newAmount, projectedSoldInBaseUnits, *c.cap.SellBaseAssetCapInBaseUnits)
+			if !mode.trimsOps() || remaining <= 0 {
+				return 0, false, DropReasonAccountBaseCap
+			}
+			newAmount = remaining
+			reason = DropReasonAccountBaseCap
+		}
+	}
+	if c.cap.SellBaseAssetCapInQuoteUnits != nil {
+		projectedSoldInQuoteUnits := c.otb.QuoteVol + c.tbb.QuoteVol + newAmount*priceInclFee
+		if projectedSoldInQuoteUnits > *c.cap.SellBaseAssetCapInQuoteUnits {
+			remaining := (*c.cap.SellBaseAssetCapInQuoteUnits - c.otb.QuoteVol - c.tbb.QuoteVol) / priceInclFee
+			log.Printf("volumeFilter: selling (account '%s', quote units), amount=%.8f, projectedSoldInQuoteUnits %.7f > %.7f (SellBaseAssetCapInQuoteUnits of the account)", c.accountID, newAmount, projectedSoldInQuoteUnits, *c.cap.SellBaseAssetCapInQuoteUnits)
+			if !mode.trimsOps() || remaining <= 0 {
+				return 0, false, DropReasonAccountQuoteCap
+			}
+			newAmount = remaining
+			reason = DropReasonAccountQuoteCap
+		}
+	}
+	return newAmount, true, reason
+}
+
+// defaultMaxMarketIDs is the default value of MaxMarketIDs
+const defaultMaxMarketIDs = 10
+
+// defaultVolumeQueryTimeout is the QueryTimeout used when the config does not set one
+const defaultVolumeQueryTimeout = 10 * time.Second
+
+// checkNumMarketIDs returns an error when there are more marketIDs than the MaxMarketIDs
+func (c *VolumeFilterConfig) checkNumMarketIDs(marketIDs []string) error {
+	maxMarketIDs := c.MaxMarketIDs
+	if maxMarketIDs == 0 {
+		maxMarketIDs = defaultMaxMarketIDs
+	}
+	if len(marketIDs) > maxMarketIDs {
+		return fmt.Errorf("the filter aggregates %d market IDs which exceeds the max of %d, consider splitting the markets into multiple filters or raising MaxMarketIDs if the trades table is indexed on market_id", len(marketIDs), maxMarketIDs)
+	}
+	return nil
+}
+
+// VolumeCapAlert is emitted when the daily volume crosses an alert cap, at most once per cap in each batch
+type VolumeCapAlert struct {
+	Date string
+	// Units is the unit of the alert cap that was crossed, either "base" or "quote"
+	Units         string
+	AlertCap      float64
+	ProjectedSold float64
+}
+
+// String is the stringer method
+func (a VolumeCapAlert) String() string {
+	return fmt.Sprintf("VolumeCapAlert[Date=%s, Units=%s, AlertCap=%.7f, ProjectedSold=%.7f]", a.Date, a.Units, a.AlertCap, a.ProjectedSold)
+}
+
+// VolumeCapAlertHandler receives the alerts of the volume filter
+type VolumeCapAlertHandler func(alert VolumeCapAlert)
+
+// CapHitHandler receives the market ID of the filter, the units of the sell cap that was hit ("base" or "quote"), the projected volume
+// sold including the op, and the cap
+type CapHitHandler func(marketID string, cap string, projected float64, limit float64)
+
+// maxResetUTCOffset is the largest offset from UTC of any timezone
+const maxResetUTCOffset = 14 * time.Hour
+
+// resetTimeOfDay returns the ResetTimeUTC wrapped into the range [0, 24h)
+func (c *VolumeFilterConfig) resetTimeOfDay() time.Duration {
+	day := 24 * time.Hour
+	resetTime := c.ResetTimeUTC % day
+	if resetTime < 0 {
+		resetTime += day
+	}
+	return resetTime
+}
+
+// queryDayStart returns the start of the day of the daily volume queries relative to midnight UTC, which is either the ResetTimeUTC or the
+// opposite of the ResetUTCOffset since they cannot be combined, e.g. a ResetUTCOffset of -5h starts the day at 05:00 UTC
+func (c *VolumeFilterConfig) queryDayStart() time.Duration {
+	return c.resetTimeOfDay() - c.ResetUTCOffset
+}
+
+// VolumeCapScheduleEntry defines the caps that are active during a time-of-day range [Start, End), where Start and End are offsets from midnight.
+// If End is before Start then the range wraps around midnight, Start and End cannot be equal.
+type VolumeCapScheduleEntry struct {
+	Start                        time.Duration
+	End                          time.Duration
 	SellBaseAssetCapInBaseUnits  *float64
 	SellBaseAssetCapInQuoteUnits *float64
-	mode                         volumeFilterMode
-	additionalMarketIDs          []string
-	optionalAccountIDs           []string
-	// buyBaseAssetCapInBaseUnits   *float64
-	// buyBaseAssetCapInQuoteUnits  *float64
+}
+
+// String is the stringer method
+func (s VolumeCapScheduleEntry) String() string {
+	return fmt.Sprintf("VolumeCapScheduleEntry[Start=%s, End=%s, SellBaseAssetCapInBaseUnits=%s, SellBaseAssetCapInQuoteUnits=%s]",
+		s.Start, s.End, utils.CheckedFloatPtr(s.SellBaseAssetCapInBaseUnits), utils.CheckedFloatPtr(s.SellBaseAssetCapInQuoteUnits))
+}
+
+// isActive returns true if the passed in time of day (offset from midnight) falls within this entry
+func (s VolumeCapScheduleEntry) isActive(timeOfDay time.Duration) bool {
+	if s.Start <= s.End {
+		return timeOfDay >= s.Start && timeOfDay < s.End
+	}
+	// wraps around midnight
+	return timeOfDay >= s.Start || timeOfDay < s.End
+}
+
+func (s VolumeCapScheduleEntry) validate() error {
+	if s.Start < 0 || s.Start >= 24*time.Hour {
+		return fmt.Errorf("start time of day (%s) needs to be within [0, 24h)", s.Start)
+	}
+	if s.End < 0 || s.End >= 24*time.Hour {
+		return fmt.Errorf("end time of day (%s) needs to be within [0, 24h)", s.End)
+	}
+	if s.Start == s.End {
+		return fmt.Errorf("start time of day (%s) needs to be different from the end time of day since the window would be empty", s.Start)
+	}
+	if s.SellBaseAssetCapInBaseUnits == nil && s.SellBaseAssetCapInQuoteUnits == nil {
+		return fmt.Errorf("needs at least one cap to be set")
+	}
+	return nil
+}
+
+// activeSellCaps returns the sell caps that apply at the passed in time, using the tightest cap of all active schedule entries
+// and falling back to the top-level caps when no schedule entry is active
+func (c *VolumeFilterConfig) activeSellCaps(now time.Time) (*float64, *float64) {
+	location := c.ScheduleLocation
+	if location == nil {
+		location = time.UTC
+	}
+	localNow := now.In(location)
+	midnight := time.Date(localNow.Year(), localNow.Month(), localNow.Day(), 0, 0, 0, 0, location)
+	timeOfDay := localNow.Sub(midnight)
+
+	var capInBase *float64
+	var capInQuote *float64
+	foundActive := false
+	for _, entry := range c.CapSchedule {
+		if !entry.isActive(timeOfDay) {
+			continue
+		}
+		foundActive = true
+		capInBase = minFloatPtr(capInBase, entry.SellBaseAssetCapInBaseUnits)
+		capInQuote = minFloatPtr(capInQuote, entry.SellBaseAssetCapInQuoteUnits)
+	}
+
+	if !foundActive {
+		return c.SellBaseAssetCapInBaseUnits, c.SellBaseAssetCapInQuoteUnits
+	}
+	return capInBase, capInQuote
+}
+
+// minFloatPtr returns the smaller of the two values where nil means unbounded
+func minFloatPtr(a *float64, b *float64) *float64 {
+	if a == nil {
+		return b
+	}
+	if b == nil || *a <= *b {
+		return a
+	}
+	return b
+}
+
+// names of the windows of the sell caps, which are logged to state the window that constrained an op
+const (
+	capWindowDaily   = "daily"
+	capWindowWeekly  = "weekly"
+	capWindowMonthly = "monthly"
+)
+
+// periodSellCap is a sell cap over a window that is longer than the day, along with the query for the volume sold in that window
+type periodSellCap struct {
+	window     string
+	capInBase  *float64
+	capInQuote *float64
+	query      api.Query
+	// flippedQuery fetches the volume bought on the flipped market when IncludeFlippedMarketIDs is set, nil otherwise
+	flippedQuery api.Query
+}
+
+// tighterCap returns the tighter of the daily cap and the cap over a longer window along with the window of the returned cap. The cap over
+// the longer window is converted to a cap on the daily volume by adding the headroom left in that window to the volume sold today.
+func tighterCap(dailyCap *float64, window string, periodCap *float64, periodWindow string, soldInPeriod float64, soldToday float64) (*float64, string) {
+	if periodCap == nil {
+		return dailyCap, window
+	}
+	dailyEquivalentCap := soldToday + *periodCap - soldInPeriod
+	if dailyCap == nil || dailyEquivalentCap < *dailyCap {
+		return &dailyEquivalentCap, periodWindow
+	}
+	return dailyCap, window
+}
+
+// capWindow returns the window of a sell cap, which defaults to the daily window
+func capWindow(window string) string {
+	if window == "" {
+		return capWindowDaily
+	}
+	return window
+}
+
+// QueryCircuitBreakerConfig configures the circuit breaker on the daily volume query. After FailureThreshold consecutive query failures
+// the breaker opens and the filter applies the failure policy on every cycle until a query succeeds again, which closes the breaker.
+// Failures before the breaker opens return an error (fail-closed), which is the same behavior as when there is no circuit breaker.
+type QueryCircuitBreakerConfig struct {
+	FailureThreshold int
+	// FailOpen passes all ops through unchanged while the breaker is open, otherwise the filter keeps returning an error (fail-closed)
+	FailOpen bool
+}
+
+// String is the stringer method
+func (c *QueryCircuitBreakerConfig) String() string {
+	return fmt.Sprintf("QueryCircuitBreakerConfig[FailureThreshold=%d, FailOpen=%v]", c.FailureThreshold, c.FailOpen)
+}
+
+// QueryErrorBehavior is the behavior of the volume filter when a volume query fails
+type QueryErrorBehavior string
+
+// these are the possible values of the OnQueryErrorBehavior
+const (
+	// QueryErrorBehaviorFail returns the error of the query so no ops are submitted in the cycle
+	QueryErrorBehaviorFail QueryErrorBehavior = "fail"
+	// QueryErrorBehaviorBlockAll conservatively drops all ops except for the deletes of existing offers, which do not add any volume
+	QueryErrorBehaviorBlockAll QueryErrorBehavior = "blockAll"
+	// QueryErrorBehaviorAllowAll passes all ops through unchanged without checking them against the caps
+	QueryErrorBehaviorAllowAll QueryErrorBehavior = "allowAll"
+)
+
+// maxPricePrecision is the max number of decimal places supported by the price of an op
+const maxPricePrecision = 7
+
+// PricePrecisionGuard protects against submitting ops with a price that has more decimal places than Precision, which the exchange rejects
+type PricePrecisionGuard struct {
+	Precision int8
+	// Round rounds the price to Precision decimal places, otherwise the op is dropped
+	Round bool
+}
+
+// String is the stringer method
+func (g *PricePrecisionGuard) String() string {
+	return fmt.Sprintf("PricePrecisionGuard[Precision=%d, Round=%v]", g.Precision, g.Round)
+}
+
+// apply rounds the price of the op in place when it exceeds the precision, returning false if the op should be dropped instead
+func (g *PricePrecisionGuard) apply(op *txnbuild.ManageSellOffer) (bool, error) {
+	if g == nil || pricePrecision(op.Price) <= int(g.Precision) {
+		return true, nil
+	}
+
+	if !g.Round {
+		log.Printf("volumeFilter: dropping op because the price (%s) has more than %d decimal places\n", op.Price, g.Precision)
+		return false, nil
+	}
+	price, e := strconv.ParseFloat(op.Price, 64)
+	if e != nil {
+		return false, fmt.Errorf("could not convert price (%s) to float: %s", op.Price, e)
+	}
+	roundedPrice := strconv.FormatFloat(price, 'f', int(g.Precision), 64)
+	if rounded, _ := strconv.ParseFloat(roundedPrice, 64); rounded <= 0 {
+		log.Printf("volumeFilter: dropping op because the price (%s) rounds to zero at %d decimal places\n", op.Price, g.Precision)
+		return false, nil
+	}
+	log.Printf("volumeFilter: rounded the price (%s) of the op to %d decimal places: %s\n", op.Price, g.Precision, roundedPrice)
+	op.Price = roundedPrice
+	return true, nil
+}
+
+// pricePrecision returns the number of significant decimal places in the price string, ignoring trailing zeros
+func pricePrecision(price string) int {
+	idx := strings.Index(price, ".")
+	if idx == -1 {
+		return 0
+	}
+	return len(strings.TrimRight(price[idx+1:], "0"))
+}
+
+// VolumeFilterDropReason is the reason code for why volumeFilterFn dropped an op
+type VolumeFilterDropReason string
+
+// these are the reasons for which an op can be dropped
+const (
+	DropReasonNone              VolumeFilterDropReason = ""
+	DropReasonBaseCap           VolumeFilterDropReason = "base-cap"
+	DropReasonQuoteCap          VolumeFilterDropReason = "quote-cap"
+	DropReasonUSDCap            VolumeFilterDropReason = "usd-cap"
+	DropReasonMaxBatchVolume    VolumeFilterDropReason = "max-batch-volume"
+	DropReasonBuyBaseCap        VolumeFilterDropReason = "buy-base-cap"
+	DropReasonBuyQuoteCap       VolumeFilterDropReason = "buy-quote-cap"
+	DropReasonBuySide           VolumeFilterDropReason = "buy-side-unsupported"
+	DropReasonDecisionHook      VolumeFilterDropReason = "decision-hook"
+	DropReasonPricePrecision    VolumeFilterDropReason = "price-precision"
+	DropReasonNetPosition       VolumeFilterDropReason = "net-position"
+	DropReasonMinSellBaseAmount VolumeFilterDropReason = "min-sell-base-amount"
+	DropReasonAccountBaseCap    VolumeFilterDropReason = "account-base-cap"
+	DropReasonAccountQuoteCap   VolumeFilterDropReason = "account-quote-cap"
+)
+
+// NetPositionFn returns the current net position of the account in base units, which is positive when long and negative when short
+type NetPositionFn func() (float64, error)
+
+// DecisionHook can override the result proposed by the volume filter for an op. It receives a copy of the op as it was passed into the
+// filter and the proposed result, and returns the result to use, where a nil return value keeps the proposed result.
+type DecisionHook func(op *txnbuild.ManageSellOffer, proposed *VolumeFilterResult) *VolumeFilterResult
+
+// VolumeFilterResult is the structured result of running volumeFilterFn on a single op
+type VolumeFilterResult struct {
+	// Op is the op to keep (possibly with a trimmed amount), nil when the op was dropped
+	Op         *txnbuild.ManageSellOffer
+	DropReason VolumeFilterDropReason
+	// TrimReason is the constraint that trimmed the amount of the kept op, DropReasonNone when the amount was not trimmed
+	TrimReason VolumeFilterDropReason
+}
+
+// dropped is a convenience to make the result of a dropped op
+func dropped(reason VolumeFilterDropReason) VolumeFilterResult {
+	return VolumeFilterResult{Op: nil, DropReason: reason}
+}
+
+// summarizeDropReasons returns a deterministic summary of the number of ops dropped for each reason, sorted by reason
+func summarizeDropReasons(dropCounts map[VolumeFilterDropReason]int) string {
+	reasons := []string{}
+	for reason := range dropCounts {
+		reasons = append(reasons, string(reason))
+	}
+	sort.Strings(reasons)
+
+	parts := []string{}
+	for _, reason := range reasons {
+		parts = append(parts, fmt.Sprintf("%s=%d", reason, dropCounts[VolumeFilterDropReason(reason)]))
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
 }
 
 type limitParameters struct {
 	sellBaseAssetCapInBaseUnits  *float64
 	sellBaseAssetCapInQuoteUnits *float64
-	mode                         volumeFilterMode
+	// sellBaseAssetCapInUSD is checked using the baseUSDPrice, which is the price of the base asset in USD fetched at the start of the batch
+	sellBaseAssetCapInUSD       *float64
+	baseUSDPrice                float64
+	buyBaseAssetCapInBaseUnits  *float64
+	buyBaseAssetCapInQuoteUnits *float64
+	maxNetPositionInBaseUnits   *float64
+	// sellBaseCapWindow and sellQuoteCapWindow are the windows of the sell caps above, which is the daily window when empty
+	sellBaseCapWindow         string
+	sellQuoteCapWindow        string
+	mode                      volumeFilterMode
+	maxBatchVolumeInBaseUnits *float64
+	minSellBaseAmount         *float64
+	// onCapHit is called with the units, projected volume sold, and cap whenever an op would exceed a sell cap, nil when there is no OnCapHit
+	onCapHit func(cap string, projected float64, limit float64)
+	// accountCap is the cap of the account of the op being filtered, nil when the account of the op does not have a cap
+	accountCap *accountSellCap
+	// quoteFeeRate scales the price by (1 + quoteFeeRate) when projecting the quote value of an op, zero for fee-exclusive quote caps
+	quoteFeeRate float64
+	// batchStartTbbBase is the value of the TBB (to-be-booked) sold base units at the start of the batch, which is non-zero when the persisted
+	// TBB is restored
+	batchStartTbbBase float64
 }
 
 type volumeFilter struct {
@@ -56,7 +630,101 @@ type volumeFilter struct {
 	baseAsset              hProtocol.Asset
 	quoteAsset             hProtocol.Asset
 	config                 *VolumeFilterConfig
-	dailyVolumeByDateQuery *queries.DailyVolumeByDate
+	dailyVolumeByDateQuery api.Query
+	// dailyBuyVolumeByDateQuery fetches the volume bought when there is a buy cap, nil otherwise
+	dailyBuyVolumeByDateQuery api.Query
+	// flippedDailyVolumeByDateQuery and flippedDailyBuyVolumeByDateQuery fetch the volume on the flipped market when IncludeFlippedMarketIDs
+	// is set, where the volume bought on the flipped market is the volume sold by this filter and vice versa, nil otherwise
+	flippedDailyVolumeByDateQuery    api.Query
+	flippedDailyBuyVolumeByDateQuery api.Query
+	// periodCaps are the sell caps over the windows that are longer than the day
+	periodCaps []periodSellCap
+	// dailyVolumeByAccountQuery fetches the volume sold by each of the accounts of the AccountCaps, nil when there are no AccountCaps
+	dailyVolumeByAccountQuery api.Query
+	// marketID is the market of this filter, used as the key of the persisted state
+	marketID string
+	// tbbRestored is set once the persisted TBB was considered by the first batch
+	tbbRestored bool
+	// currentTradingDateQuery is used to fetch the current date when UseDatabaseTradingDate is set, nil otherwise
+	currentTradingDateQuery api.Query
+	// nowFn is the clock used by the filter, defaults to time.Now when nil
+	nowFn func() time.Time
+	// consecutiveQueryFailures and breakerOpen hold the state of the QueryCircuitBreaker
+	consecutiveQueryFailures int
+	breakerOpen              bool
+	// queryCache holds the last result of each query that is reused within the MinQueryInterval, nil when there is no MinQueryInterval
+	queryCache *queryResultCache
+	// sharedCacheKeys holds the key in the SharedQueryCache of each daily volume query, nil when there is no SharedQueryCache
+	sharedCacheKeys map[api.Query]string
+	// refresher holds the state of the background refresh of the daily volume, nil when it is not running
+	refresher *dailyVolumeRefresher
+	// pending holds the volume of the RecordPendingVolume, nil when the pending volume is not recorded
+	pending *pendingVolumeState
+}
+
+// pendingVolumeState holds the volume sold by the last batch until its ops were submitted and the volume that was last recorded as the
+// pending volume of the market, it is safe for concurrent use since the ops can be submitted asynchronously
+type pendingVolumeState struct {
+	mutex       sync.Mutex
+	unsubmitted *pendingVolume
+	recorded    *pendingVolume
+}
+
+// pendingVolume is the volume sold by a batch on the date along with the settled volume (excluding the pending volume recorded by the filter)
+// that the batch was checked against
+type pendingVolume struct {
+	date         string
+	baseVol      float64
+	quoteVol     float64
+	settledBase  float64
+	settledQuote float64
+}
+
+// pendingVolumeRecorder is a volume query that can record the pending volume that it adds to the settled volume, like the
+// queries.DailyVolumeByDate
+type pendingVolumeRecorder interface {
+	RecordPendingVolume(ctx context.Context, marketID string, dateUTC string, baseVol float64, quoteVol float64) error
+}
+
+// queryResultCache holds the last result of each query, it is safe for concurrent use since CurrentDailyVolume can run concurrently with Apply
+type queryResultCache struct {
+	mutex   sync.Mutex
+	results map[api.Query]*cachedQueryResult
+}
+
+// get returns the last result of the query
+func (c *queryResultCache) get(q api.Query) (*cachedQueryResult, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	cached, ok := c.results[q]
+	return cached, ok
+}
+
+// put replaces the last result of the query
+func (c *queryResultCache) put(q api.Query, cached *cachedQueryResult) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.results[q] = cached
+}
+
+// dailyVolumeRefresher holds the daily volume that is pre-fetched by the background refresh
+type dailyVolumeRefresher struct {
+	mutex          sync.Mutex
+	prefetched     *queries.DailyVolume
+	prefetchedDate string
+	// prefetchedAt is the time at which the pre-fetched value was fetched, based on the clock of the filter
+	prefetchedAt time.Time
+	stop         chan struct{}
+	done         chan struct{}
+}
+
+// cachedQueryResult is the result of a query for a date along with the time at which the query was executed
+type cachedQueryResult struct {
+	dateString string
+	result     interface{}
+	queriedAt  time.Time
 }
 
 // makeFilterVolume makes a submit filter that limits orders placed based on the daily volume traded
@@ -70,6 +738,11 @@ func makeFilterVolume(
 	db *sql.DB,
 	config *VolumeFilterConfig,
 ) (SubmitFilter, error) {
+	e := config.Validate()
+	if e != nil {
+		return nil, fmt.Errorf("invalid volume filter config: %s", e)
+	}
+
 	// use assetDisplayFn to make baseAssetString and quoteAssetString because it is issuer independent for non-sdex exchanges keeping a consistent marketID
 	baseAssetString, e := assetDisplayFn(tradingPair.Base)
 	if e != nil {
@@ -80,153 +753,2327 @@ func makeFilterVolume(
 		return nil, fmt.Errorf("could not convert quote asset (%s) from trading pair via the passed in assetDisplayFn: %s", string(tradingPair.Quote), e)
 	}
 
-	marketID := MakeMarketID(exchangeName, baseAssetString, quoteAssetString)
-	marketIDs := utils.Dedupe(append([]string{marketID}, config.additionalMarketIDs...))
-	dailyVolumeByDateQuery, e := queries.MakeDailyVolumeByDateForMarketIdsAction(db, marketIDs, "sell", config.optionalAccountIDs)
-	if e != nil {
-		return nil, fmt.Errorf("could not make daily volume by date Query: %s", e)
+	// the market IDs in the format of MakeMarketID are canonicalized so the additional market IDs match the buckets of the market IDs made by
+	// MakeMarketID, the other market IDs are kept as-is
+	marketID, _ := canonicalMarketID(MakeMarketID(exchangeName, baseAssetString, quoteAssetString))
+	marketIDs := []string{marketID}
+	for _, additionalMarketID := range config.additionalMarketIDs {
+		canonical, ok := canonicalMarketID(additionalMarketID)
+		if !ok {
+			log.Printf("warning: volumeFilter: the additional market ID '%s' does not match the format of a market ID (%d lowercase hex characters), it may not match any trades\n",
+				additionalMarketID, marketIdHashLength)
+		}
+		marketIDs = append(marketIDs, canonical)
+	}
+	marketIDs = utils.Dedupe(marketIDs)
+	if config.CustomVolumeSQL == "" {
+		e = config.checkNumMarketIDs(marketIDs)
+		if e != nil {
+			return nil, fmt.Errorf("invalid market IDs: %s", e)
+		}
+	}
+
+	var dailyVolumeByDateQuery api.Query
+	if config.CustomVolumeSQL != "" {
+		dailyVolumeByDateQuery, e = queries.MakeDailyVolumeWithCustomQuery(db, config.CustomVolumeSQL, config.CustomVolumeSQLArgs...)
+		if e != nil {
+			return nil, fmt.Errorf("could not make daily volume by custom Query: %s", e)
+		}
+	} else if config.RollingTradeCount > 0 {
+		dailyVolumeByDateQuery, e = queries.MakeRecentTradesVolumeForMarketIdsAction(db, marketIDs, "sell", config.optionalAccountIDs, config.RollingTradeCount)
+		if e != nil {
+			return nil, fmt.Errorf("could not make recent trades volume Query: %s", e)
+		}
+	} else if config.WindowDuration > 0 {
+		dailyVolumeByDateQuery, e = queries.MakeVolumeInWindowForMarketIdsAction(db, marketIDs, "sell", config.optionalAccountIDs)
+		if e != nil {
+			return nil, fmt.Errorf("could not make volume in window Query: %s", e)
+		}
+	} else {
+		dailyVolumeByDateQuery, e = queries.MakeDailyVolumeByShiftedDateForMarketIdsAction(db, marketIDs, "sell", config.optionalAccountIDs, config.MinFillPriceToCount, config.queryDayStart(), config.RecordPendingVolume)
+		if e != nil {
+			return nil, fmt.Errorf("could not make daily volume by date Query: %s", e)
+		}
+	}
+
+	var dailyBuyVolumeByDateQuery api.Query
+	if config.hasBuyCap() {
+		if config.RollingTradeCount > 0 {
+			dailyBuyVolumeByDateQuery, e = queries.MakeRecentTradesVolumeForMarketIdsAction(db, marketIDs, "buy", config.optionalAccountIDs, config.RollingTradeCount)
+		} else if config.WindowDuration > 0 {
+			dailyBuyVolumeByDateQuery, e = queries.MakeVolumeInWindowForMarketIdsAction(db, marketIDs, "buy", config.optionalAccountIDs)
+		} else {
+			dailyBuyVolumeByDateQuery, e = queries.MakeDailyVolumeByShiftedDateForMarketIdsAction(db, marketIDs, "buy", config.optionalAccountIDs, config.MinFillPriceToCount, config.queryDayStart(), false)
+		}
+		if e != nil {
+			return nil, fmt.Errorf("could not make daily buy volume Query: %s", e)
+		}
+	}
+
+	// the volume bought on the flipped market is the volume sold by this filter and vice versa
+	flippedMarketIDs := []string{MakeMarketID(exchangeName, quoteAssetString, baseAssetString)}
+	var flippedDailyVolumeByDateQuery api.Query
+	var flippedDailyBuyVolumeByDateQuery api.Query
+	if config.IncludeFlippedMarketIDs {
+		flippedDailyVolumeByDateQuery, flippedDailyBuyVolumeByDateQuery, e = makeFlippedVolumeQueries(db, flippedMarketIDs, config)
+		if e != nil {
+			return nil, fmt.Errorf("could not make the volume Queries on the flipped market: %s", e)
+		}
+	}
+
+	var periodCaps []periodSellCap
+	if config.SellBaseAssetCapInBaseUnitsWeekly != nil || config.SellBaseAssetCapInQuoteUnitsWeekly != nil {
+		weeklyQuery, e := queries.MakeWeeklyVolumeByDateForMarketIdsAction(db, marketIDs, "sell", config.optionalAccountIDs)
+		if e != nil {
+			return nil, fmt.Errorf("could not make weekly volume Query: %s", e)
+		}
+		var flippedWeeklyQuery api.Query
+		if config.IncludeFlippedMarketIDs {
+			flippedWeeklyQuery, e = queries.MakeWeeklyVolumeByDateForMarketIdsAction(db, flippedMarketIDs, "buy", config.optionalAccountIDs)
+			if e != nil {
+				return nil, fmt.Errorf("could not make weekly volume Query on the flipped market: %s", e)
+			}
+		}
+		periodCaps = append(periodCaps, periodSellCap{
+			window:       capWindowWeekly,
+			capInBase:    config.SellBaseAssetCapInBaseUnitsWeekly,
+			capInQuote:   config.SellBaseAssetCapInQuoteUnitsWeekly,
+			query:        weeklyQuery,
+			flippedQuery: flippedWeeklyQuery,
+		})
+	}
+	if config.SellBaseAssetCapInBaseUnitsMonthly != nil || config.SellBaseAssetCapInQuoteUnitsMonthly != nil {
+		monthlyQuery, e := queries.MakeMonthlyVolumeByDateForMarketIdsAction(db, marketIDs, "sell", config.optionalAccountIDs)
+		if e != nil {
+			return nil, fmt.Errorf("could not make monthly volume Query: %s", e)
+		}
+		var flippedMonthlyQuery api.Query
+		if config.IncludeFlippedMarketIDs {
+			flippedMonthlyQuery, e = queries.MakeMonthlyVolumeByDateForMarketIdsAction(db, flippedMarketIDs, "buy", config.optionalAccountIDs)
+			if e != nil {
+				return nil, fmt.Errorf("could not make monthly volume Query on the flipped market: %s", e)
+			}
+		}
+		periodCaps = append(periodCaps, periodSellCap{
+			window:       capWindowMonthly,
+			capInBase:    config.SellBaseAssetCapInBaseUnitsMonthly,
+			capInQuote:   config.SellBaseAssetCapInQuoteUnitsMonthly,
+			query:        monthlyQuery,
+			flippedQuery: flippedMonthlyQuery,
+		})
+	}
+
+	var dailyVolumeByAccountQuery api.Query
+	if len(config.AccountCaps) > 0 {
+		accountIDs := []string{}
+		for accountID := range config.AccountCaps {
+			accountIDs = append(accountIDs, accountID)
+		}
+		sort.Strings(accountIDs)
+		dailyVolumeByAccountQuery, e = queries.MakeDailyVolumeByAccountForMarketIdsAction(db, marketIDs, "sell", accountIDs, config.MinFillPriceToCount, config.queryDayStart())
+		if e != nil {
+			return nil, fmt.Errorf("could not make daily volume by account Query: %s", e)
+		}
+	}
+
+	var queryCache *queryResultCache
+	if config.MinQueryInterval > 0 {
+		queryCache = &queryResultCache{results: map[api.Query]*cachedQueryResult{}}
+	}
+
+	var sharedCacheKeys map[api.Query]string
+	if config.SharedQueryCache != nil && config.CustomVolumeSQL == "" && config.RollingTradeCount == 0 && config.WindowDuration == 0 {
+		sharedCacheKeys = map[api.Query]string{}
+		// the daily volume including the pending volume is not shared with the filters that query only the settled volume
+		if !config.RecordPendingVolume {
+			sharedCacheKeys[dailyVolumeByDateQuery] = volumeQueryCacheKey("sell", marketIDs, config.optionalAccountIDs, config.MinFillPriceToCount, config.queryDayStart())
+		}
+		if dailyBuyVolumeByDateQuery != nil {
+			sharedCacheKeys[dailyBuyVolumeByDateQuery] = volumeQueryCacheKey("buy", marketIDs, config.optionalAccountIDs, config.MinFillPriceToCount, config.queryDayStart())
+		}
+		if flippedDailyVolumeByDateQuery != nil {
+			sharedCacheKeys[flippedDailyVolumeByDateQuery] = volumeQueryCacheKey("buy", flippedMarketIDs, config.optionalAccountIDs, nil, config.queryDayStart())
+		}
+		if flippedDailyBuyVolumeByDateQuery != nil {
+			sharedCacheKeys[flippedDailyBuyVolumeByDateQuery] = volumeQueryCacheKey("sell", flippedMarketIDs, config.optionalAccountIDs, nil, config.queryDayStart())
+		}
+	}
+
+	var pending *pendingVolumeState
+	if config.RecordPendingVolume {
+		pending = &pendingVolumeState{}
 	}
 
-	// TODO DS Validate the config, to have exactly one asset cap defined; a valid mode; non-nil market IDs; and non-nil optional account IDs.
+	var currentTradingDateQuery api.Query
+	if config.UseDatabaseTradingDate {
+		currentTradingDateQuery, e = queries.MakeCurrentTradingDate(db)
+		if e != nil {
+			return nil, fmt.Errorf("could not make current trading date Query: %s", e)
+		}
+	}
 
 	return &volumeFilter{
-		name:                   "volumeFilter",
-		configValue:            configValue,
-		baseAsset:              baseAsset,
-		quoteAsset:             quoteAsset,
-		config:                 config,
-		dailyVolumeByDateQuery: dailyVolumeByDateQuery,
+		name:                             "volumeFilter",
+		configValue:                      configValue,
+		baseAsset:                        baseAsset,
+		quoteAsset:                       quoteAsset,
+		config:                           config,
+		dailyVolumeByDateQuery:           dailyVolumeByDateQuery,
+		dailyBuyVolumeByDateQuery:        dailyBuyVolumeByDateQuery,
+		flippedDailyVolumeByDateQuery:    flippedDailyVolumeByDateQuery,
+		flippedDailyBuyVolumeByDateQuery: flippedDailyBuyVolumeByDateQuery,
+		periodCaps:                       periodCaps,
+		dailyVolumeByAccountQuery:        dailyVolumeByAccountQuery,
+		marketID:                         marketID,
+		currentTradingDateQuery:          currentTradingDateQuery,
+		queryCache:                       queryCache,
+		sharedCacheKeys:                  sharedCacheKeys,
+		pending:                          pending,
 	}, nil
 }
 
+// makeFlippedVolumeQueries makes the queries for the volume bought and sold on the flipped market, where the query of the volume bought on
+// the flipped market is the one for the volume sold by the filter. The buy query is nil when there is no buy cap.
+func makeFlippedVolumeQueries(db *sql.DB, flippedMarketIDs []string, config *VolumeFilterConfig) (api.Query, api.Query, error) {
+	makeQuery := func(action string) (api.Query, error) {
+		if config.WindowDuration > 0 {
+			return queries.MakeVolumeInWindowForMarketIdsAction(db, flippedMarketIDs, action, config.optionalAccountIDs)
+		}
+		return queries.MakeDailyVolumeByShiftedDateForMarketIdsAction(db, flippedMarketIDs, action, config.optionalAccountIDs, nil, config.queryDayStart(), false)
+	}
+
+	soldQuery, e := makeQuery("buy")
+	if e != nil {
+		return nil, nil, fmt.Errorf("could not make the Query for the volume sold: %s", e)
+	}
+	if !config.hasBuyCap() {
+		return soldQuery, nil, nil
+	}
+	boughtQuery, e := makeQuery("sell")
+	if e != nil {
+		return nil, nil, fmt.Errorf("could not make the Query for the volume bought: %s", e)
+	}
+	return soldQuery, boughtQuery, nil
+}
+
 var _ SubmitFilter = &volumeFilter{}
 
 // Validate ensures validity
 func (c *VolumeFilterConfig) Validate() error {
-	if c.isEmpty() {
+	if c.isEmpty() && !c.MonitorOnly {
 		return fmt.Errorf("the volumeFilterConfig was empty")
 	}
+	if _, e := parseVolumeFilterMode(string(c.mode)); e != nil {
+		return fmt.Errorf("invalid mode: %s", e)
+	}
+	for _, capValue := range []struct {
+		name  string
+		value *float64
+	}{
+		{name: "SellBaseAssetCapInBaseUnits", value: c.SellBaseAssetCapInBaseUnits},
+		{name: "SellBaseAssetCapInQuoteUnits", value: c.SellBaseAssetCapInQuoteUnits},
+		{name: "SellBaseAssetCapInUSD", value: c.SellBaseAssetCapInUSD},
+		{name: "BuyBaseAssetCapInBaseUnits", value: c.BuyBaseAssetCapInBaseUnits},
+		{name: "BuyBaseAssetCapInQuoteUnits", value: c.BuyBaseAssetCapInQuoteUnits},
+		{name: "SellBaseAssetCapInBaseUnitsWeekly", value: c.SellBaseAssetCapInBaseUnitsWeekly},
+		{name: "SellBaseAssetCapInQuoteUnitsWeekly", value: c.SellBaseAssetCapInQuoteUnitsWeekly},
+		{name: "SellBaseAssetCapInBaseUnitsMonthly", value: c.SellBaseAssetCapInBaseUnitsMonthly},
+		{name: "SellBaseAssetCapInQuoteUnitsMonthly", value: c.SellBaseAssetCapInQuoteUnitsMonthly},
+	} {
+		if capValue.value != nil && *capValue.value < 0 {
+			return fmt.Errorf("%s needs to be non-negative but was %f", capValue.name, *capValue.value)
+		}
+	}
+	for i, marketID := range c.additionalMarketIDs {
+		if strings.TrimSpace(marketID) == "" {
+			return fmt.Errorf("the additional market ID at index %d was empty", i)
+		}
+	}
+	for i, accountID := range c.optionalAccountIDs {
+		if _, e := strkey.Decode(strkey.VersionByteAccountID, accountID); e != nil {
+			return fmt.Errorf("the account ID '%s' at index %d is not a valid Stellar account ID: %s", accountID, i, e)
+		}
+	}
+	for accountID, accountCap := range c.AccountCaps {
+		if _, e := strkey.Decode(strkey.VersionByteAccountID, accountID); e != nil {
+			return fmt.Errorf("the account ID '%s' of the AccountCaps is not a valid Stellar account ID: %s", accountID, e)
+		}
+		if accountCap.SellBaseAssetCapInBaseUnits == nil && accountCap.SellBaseAssetCapInQuoteUnits == nil {
+			return fmt.Errorf("the cap of the account '%s' needs at least one of the caps to be set", accountID)
+		}
+		if (accountCap.SellBaseAssetCapInBaseUnits != nil && *accountCap.SellBaseAssetCapInBaseUnits < 0) ||
+			(accountCap.SellBaseAssetCapInQuoteUnits != nil && *accountCap.SellBaseAssetCapInQuoteUnits < 0) {
+			return fmt.Errorf("the caps of the account '%s' need to be non-negative (%s)", accountID, accountCap)
+		}
+	}
+	if _, ok := c.AccountCaps[c.DefaultAccountID]; c.DefaultAccountID != "" && !ok {
+		return fmt.Errorf("the DefaultAccountID '%s' needs to be in the AccountCaps", c.DefaultAccountID)
+	}
+	if len(c.AccountCaps) > 0 && (c.CustomVolumeSQL != "" || c.RollingTradeCount > 0 || c.WindowDuration > 0 || c.IncludeFlippedMarketIDs) {
+		return fmt.Errorf("cannot use AccountCaps with CustomVolumeSQL, RollingTradeCount, WindowDuration, or IncludeFlippedMarketIDs")
+	}
+	for i, entry := range c.CapSchedule {
+		if e := entry.validate(); e != nil {
+			return fmt.Errorf("invalid cap schedule entry at index %d (%s): %s", i, entry, e)
+		}
+	}
+	if c.ResetUTCOffset < -maxResetUTCOffset || c.ResetUTCOffset > maxResetUTCOffset {
+		return fmt.Errorf("ResetUTCOffset needs to be within +/-%s but was %s", maxResetUTCOffset, c.ResetUTCOffset)
+	}
+	if c.MaxMarketIDs < 0 {
+		return fmt.Errorf("MaxMarketIDs needs to be non-negative but was %d", c.MaxMarketIDs)
+	}
+	if c.RollingTradeCount < 0 {
+		return fmt.Errorf("RollingTradeCount needs to be non-negative but was %d", c.RollingTradeCount)
+	}
+	if c.RollingTradeCount > 0 && (c.CustomVolumeSQL != "" || c.MinFillPriceToCount != nil) {
+		return fmt.Errorf("cannot use RollingTradeCount (%d) with CustomVolumeSQL or MinFillPriceToCount", c.RollingTradeCount)
+	}
+	if c.WindowDuration < 0 {
+		return fmt.Errorf("WindowDuration needs to be non-negative but was %s", c.WindowDuration)
+	}
+	if c.WindowDuration > 0 && (c.RollingTradeCount > 0 || c.CustomVolumeSQL != "" || c.MinFillPriceToCount != nil) {
+		return fmt.Errorf("cannot use WindowDuration (%s) with RollingTradeCount, CustomVolumeSQL, or MinFillPriceToCount", c.WindowDuration)
+	}
+	if c.MaxNetPositionInBaseUnits != nil && *c.MaxNetPositionInBaseUnits <= 0 {
+		return fmt.Errorf("MaxNetPositionInBaseUnits needs to be positive but was %f", *c.MaxNetPositionInBaseUnits)
+	}
+	if c.MaxNetPositionInBaseUnits != nil && c.NetPosition == nil {
+		return fmt.Errorf("MaxNetPositionInBaseUnits needs a NetPosition callback")
+	}
+	if c.SellBaseAssetCapInUSD != nil && c.PriceFeed == nil {
+		return fmt.Errorf("SellBaseAssetCapInUSD needs a PriceFeed")
+	}
+	if c.hasPeriodCap() && (c.CustomVolumeSQL != "" || c.RollingTradeCount > 0 || c.WindowDuration > 0 || c.queryDayStart() != 0 || c.MinFillPriceToCount != nil) {
+		return fmt.Errorf("cannot use a weekly or monthly cap with CustomVolumeSQL, RollingTradeCount, WindowDuration, ResetTimeUTC, ResetUTCOffset, or MinFillPriceToCount")
+	}
+	if c.hasBuyCap() && c.CustomVolumeSQL != "" {
+		return fmt.Errorf("cannot use a buy cap with CustomVolumeSQL")
+	}
+	if c.RecordPendingVolume && (c.CustomVolumeSQL != "" || c.RollingTradeCount > 0 || c.WindowDuration > 0) {
+		return fmt.Errorf("cannot use RecordPendingVolume with CustomVolumeSQL, RollingTradeCount, or WindowDuration")
+	}
+	if c.IncludeFlippedMarketIDs && (c.CustomVolumeSQL != "" || c.RollingTradeCount > 0 || c.MinFillPriceToCount != nil) {
+		return fmt.Errorf("cannot use IncludeFlippedMarketIDs with CustomVolumeSQL, RollingTradeCount, or MinFillPriceToCount")
+	}
+	if c.resetTimeOfDay() != 0 && (c.ResetUTCOffset != 0 || c.UseDatabaseTradingDate || c.CustomVolumeSQL != "" || c.RollingTradeCount > 0 || c.WindowDuration > 0) {
+		return fmt.Errorf("cannot use a ResetTimeUTC (%s) with ResetUTCOffset, UseDatabaseTradingDate, CustomVolumeSQL, RollingTradeCount, or WindowDuration", c.ResetTimeUTC)
+	}
+	if c.UseDatabaseTradingDate && c.ResetUTCOffset != 0 {
+		return fmt.Errorf("cannot use a ResetUTCOffset (%s) with UseDatabaseTradingDate", c.ResetUTCOffset)
+	}
+	if c.QuoteCapFeeRate != nil && (*c.QuoteCapFeeRate < 0 || *c.QuoteCapFeeRate >= 1) {
+		return fmt.Errorf("QuoteCapFeeRate needs to be within [0, 1) but was %f", *c.QuoteCapFeeRate)
+	}
+	if c.MinQueryInterval < 0 {
+		return fmt.Errorf("MinQueryInterval needs to be non-negative but was %s", c.MinQueryInterval)
+	}
+	if c.MaxStaleBeforeBlock < 0 {
+		return fmt.Errorf("MaxStaleBeforeBlock needs to be non-negative but was %s", c.MaxStaleBeforeBlock)
+	}
+	if c.QueryTimeout < 0 {
+		return fmt.Errorf("QueryTimeout needs to be non-negative but was %s", c.QueryTimeout)
+	}
+	if c.MaxBatchVolume != nil && *c.MaxBatchVolume <= 0 {
+		return fmt.Errorf("MaxBatchVolume needs to be positive but was %f", *c.MaxBatchVolume)
+	}
+	if c.MinSellBaseAmount != nil && *c.MinSellBaseAmount < 0 {
+		return fmt.Errorf("MinSellBaseAmount needs to be non-negative but was %f", *c.MinSellBaseAmount)
+	}
+	if c.MinFillPriceToCount != nil && *c.MinFillPriceToCount < 0 {
+		return fmt.Errorf("MinFillPriceToCount needs to be non-negative but was %f", *c.MinFillPriceToCount)
+	}
+	if c.PricePrecisionGuard != nil && (c.PricePrecisionGuard.Precision < 0 || c.PricePrecisionGuard.Precision > maxPricePrecision) {
+		return fmt.Errorf("the precision of the price precision guard needs to be within [0, %d] (%s)", maxPricePrecision, c.PricePrecisionGuard)
+	}
+	if e := checkAlertCap("AlertCapInBaseUnits", c.AlertCapInBaseUnits, c.SellBaseAssetCapInBaseUnits); e != nil {
+		return e
+	}
+	if e := checkAlertCap("AlertCapInQuoteUnits", c.AlertCapInQuoteUnits, c.SellBaseAssetCapInQuoteUnits); e != nil {
+		return e
+	}
+	if c.QueryCircuitBreaker != nil && c.QueryCircuitBreaker.FailureThreshold <= 0 {
+		return fmt.Errorf("the failure threshold of the query circuit breaker needs to be positive (%s)", c.QueryCircuitBreaker)
+	}
+	switch c.OnQueryErrorBehavior {
+	case "", QueryErrorBehaviorFail, QueryErrorBehaviorBlockAll, QueryErrorBehaviorAllowAll:
+	default:
+		return fmt.Errorf("invalid OnQueryErrorBehavior '%s', needs to be one of '%s', '%s', or '%s'",
+			c.OnQueryErrorBehavior, QueryErrorBehaviorFail, QueryErrorBehaviorBlockAll, QueryErrorBehaviorAllowAll)
+	}
+	if c.QueryCircuitBreaker != nil && c.OnQueryErrorBehavior != "" && c.OnQueryErrorBehavior != QueryErrorBehaviorFail {
+		return fmt.Errorf("cannot use an OnQueryErrorBehavior of '%s' with a QueryCircuitBreaker", c.OnQueryErrorBehavior)
+	}
 	return nil
 }
 
-// String is the stringer method
-func (c *VolumeFilterConfig) String() string {
-	return fmt.Sprintf("VolumeFilterConfig[SellBaseAssetCapInBaseUnits=%s, SellBaseAssetCapInQuoteUnits=%s, mode=%s, additionalMarketIDs=%v, optionalAccountIDs=%v]",
-		utils.CheckedFloatPtr(c.SellBaseAssetCapInBaseUnits), utils.CheckedFloatPtr(c.SellBaseAssetCapInQuoteUnits), c.mode, c.additionalMarketIDs, c.optionalAccountIDs)
+// checkAlertCap ensures that the alert cap is positive and does not exceed the enforcing cap in the same units, since it would never be crossed
+func checkAlertCap(name string, alertCap *float64, enforcingCap *float64) error {
+	if alertCap == nil {
+		return nil
+	}
+	if *alertCap <= 0 {
+		return fmt.Errorf("%s needs to be positive but was %f", name, *alertCap)
+	}
+	if enforcingCap != nil && *alertCap > *enforcingCap {
+		return fmt.Errorf("%s (%f) cannot be above the enforcing cap (%f)", name, *alertCap, *enforcingCap)
+	}
+	return nil
 }
 
-func (f *volumeFilter) Apply(ops []txnbuild.Operation, sellingOffers []hProtocol.Offer, buyingOffers []hProtocol.Offer) ([]txnbuild.Operation, error) {
-	dateString := time.Now().UTC().Format(postgresdb.DateFormatString)
-	// TODO do for buying base and also for flipped marketIDs
-	queryResult, e := f.dailyVolumeByDateQuery.QueryRow(dateString)
-	if e != nil {
-		return nil, fmt.Errorf("could not load dailyValuesByDate for today (%s): %s", dateString, e)
+// now returns the current time based on the clock of the filter
+func (f *volumeFilter) now() time.Time {
+	if f.nowFn == nil {
+		return time.Now()
 	}
-	dailyValuesBaseSold, ok := queryResult.(*queries.DailyVolume)
-	if !ok {
-		return nil, fmt.Errorf("incorrect type returned from DailyVolumeByDate query, expecting '*queries.DailyVolume' but was '%T'", queryResult)
+	return f.nowFn()
+}
+
+// dateString returns the date of the daily volume at the passed in time, shifted by the ResetUTCOffset or the ResetTimeUTC
+func (f *volumeFilter) dateString(now time.Time) string {
+	return now.UTC().Add(-f.config.queryDayStart()).Format(postgresdb.DateFormatString)
+}
+
+// currentDateString returns the date of the daily volume, which is reported by the database when UseDatabaseTradingDate is set and is
+// otherwise computed from the passed in time
+func (f *volumeFilter) currentDateString(ctx context.Context, now time.Time) (string, error) {
+	if f.currentTradingDateQuery == nil {
+		return f.dateString(now), nil
+	}
+
+	queryResult, e := queryRowContext(ctx, f.currentTradingDateQuery)
+	if e != nil {
+		return "", fmt.Errorf("could not load the current trading date: %s", e)
+	}
+	dateString, ok := queryResult.(string)
+	if !ok {
+		return "", fmt.Errorf("incorrect type returned from CurrentTradingDate query, expecting 'string' but was '%T'", queryResult)
+	}
+	if localDate := f.dateString(now); dateString != localDate {
+		log.Printf("volumeFilter: the trading date reported by the database (%s) differs from the date of the local clock (%s), using the date from the database\n", dateString, localDate)
+	}
+	return dateString, nil
+}
+
+// String is the stringer method
+func (c *VolumeFilterConfig) String() string {
+	return fmt.Sprintf("VolumeFilterConfig[SellBaseAssetCapInBaseUnits=%s, SellBaseAssetCapInQuoteUnits=%s, BuyBaseAssetCapInBaseUnits=%s, BuyBaseAssetCapInQuoteUnits=%s, SellBaseAssetCapInBaseUnitsWeekly=%s, SellBaseAssetCapInQuoteUnitsWeekly=%s, SellBaseAssetCapInBaseUnitsMonthly=%s, SellBaseAssetCapInQuoteUnitsMonthly=%s, mode=%s, additionalMarketIDs=%v, optionalAccountIDs=%v, MonitorOnly=%v]",
+		utils.CheckedFloatPtr(c.SellBaseAssetCapInBaseUnits), utils.CheckedFloatPtr(c.SellBaseAssetCapInQuoteUnits), utils.CheckedFloatPtr(c.BuyBaseAssetCapInBaseUnits), utils.CheckedFloatPtr(c.BuyBaseAssetCapInQuoteUnits),
+		utils.CheckedFloatPtr(c.SellBaseAssetCapInBaseUnitsWeekly), utils.CheckedFloatPtr(c.SellBaseAssetCapInQuoteUnitsWeekly), utils.CheckedFloatPtr(c.SellBaseAssetCapInBaseUnitsMonthly), utils.CheckedFloatPtr(c.SellBaseAssetCapInQuoteUnitsMonthly), c.mode, c.additionalMarketIDs, c.optionalAccountIDs, c.MonitorOnly)
+}
+
+func (f *volumeFilter) Apply(ops []txnbuild.Operation, sellingOffers []hProtocol.Offer, buyingOffers []hProtocol.Offer) ([]txnbuild.Operation, error) {
+	ops, _, e := f.apply(ops, sellingOffers, buyingOffers)
+	return ops, e
+}
+
+// ApplyWithCancellations runs the filter like Apply and separates the result into the kept ops and the cancel ops (deletes of existing
+// offers). When a daily cap is breached in the batch, i.e. an op was dropped by a daily cap or the daily volume reached a daily cap, the
+// cancel ops include a delete for every offer in sellingOffers and buyingOffers so the caller can pull the resting liquidity and pause until
+// the caps are no longer constrained, and the kept ops do not include any ops that create or update offers. Otherwise the cancel ops only
+// include the deletes of the existing offers that the filter dropped.
+func (f *volumeFilter) ApplyWithCancellations(ops []txnbuild.Operation, sellingOffers []hProtocol.Offer, buyingOffers []hProtocol.Offer) (keptOps []txnbuild.Operation, cancelOps []txnbuild.Operation, err error) {
+	filteredOps, batch, e := f.apply(ops, sellingOffers, buyingOffers)
+	if e != nil {
+		return nil, nil, e
+	}
+
+	breached := batch != nil && batch.capBreached()
+	keptOps = []txnbuild.Operation{}
+	cancelOps = []txnbuild.Operation{}
+	for _, op := range filteredOps {
+		mso, ok := op.(*txnbuild.ManageSellOffer)
+		if ok && breached {
+			// the ops that update existing offers are superseded by the delete of all the offers and no new offers are created while paused
+			continue
+		}
+		if ok && mso.OfferID != 0 && mso.Amount == "0" {
+			cancelOps = append(cancelOps, op)
+			continue
+		}
+		keptOps = append(keptOps, op)
+	}
+
+	if breached {
+		for _, offer := range append(append([]hProtocol.Offer{}, sellingOffers...), buyingOffers...) {
+			deleteOp := convertOffer2MSO(offer)
+			deleteOp.Amount = "0"
+			cancelOps = append(cancelOps, deleteOp)
+		}
+		log.Printf("volumeFilter: a daily cap was breached, cancelling all %d existing offers\n", len(cancelOps))
+	}
+	return keptOps, cancelOps, nil
+}
+
+// apply runs the filter on the ops and returns the filtered ops along with the batch, which is nil when the ops were not filtered as a batch
+// (i.e. with MonitorOnly or when failing open after a query failure)
+func (f *volumeFilter) apply(ops []txnbuild.Operation, sellingOffers []hProtocol.Offer, buyingOffers []hProtocol.Offer) ([]txnbuild.Operation, *volumeFilterBatch, error) {
+	ctx, cancel := f.queryContext()
+	defer cancel()
+	now := f.now()
+	dateString, dailyValuesBaseSold, e := f.loadDailyVolume(ctx, now, true)
+	if e != nil {
+		ops, e = f.handleQueryFailure(ops, e)
+		return ops, nil, e
+	}
+
+	if f.config.MonitorOnly {
+		e = f.logMonitoredVolume(dailyValuesBaseSold, ops)
+		if e != nil {
+			return nil, nil, fmt.Errorf("could not log monitored volume: %s", e)
+		}
+		return ops, nil, nil
+	}
+
+	dailyValuesBaseBought, e := f.queryDailyBuyVolume(ctx, now, dateString)
+	if e != nil {
+		ops, e = f.handleQueryFailure(ops, e)
+		return ops, nil, e
+	}
+	periodValuesBaseSold, e := f.queryPeriodVolumes(ctx, dateString)
+	if e != nil {
+		ops, e = f.handleQueryFailure(ops, e)
+		return ops, nil, e
+	}
+	accountValuesBaseSold, e := f.queryAccountVolumes(ctx, dateString)
+	if e != nil {
+		ops, e = f.handleQueryFailure(ops, e)
+		return ops, nil, e
+	}
+	baseUSDPrice, e := f.fetchBaseUSDPrice()
+	if e != nil {
+		ops, e = f.handleQueryFailure(ops, e)
+		return ops, nil, e
+	}
+	batch := f.makeBatch(now, dateString, dailyValuesBaseSold, dailyValuesBaseBought, periodValuesBaseSold, accountValuesBaseSold, baseUSDPrice)
+	e = batch.loadNetPosition()
+	if e != nil {
+		return nil, nil, e
+	}
+	e = f.excludePendingOnBook(batch, sellingOffers)
+	if e != nil {
+		return nil, nil, e
+	}
+	e = f.restoreTBB(batch, sellingOffers)
+	if e != nil {
+		return nil, nil, e
+	}
+	e = batch.countDeletedOffers(ops, sellingOffers)
+	if e != nil {
+		return nil, nil, fmt.Errorf("could not count the deleted offers: %s", e)
+	}
+	if f.config.mode == volumeFilterModeScale {
+		ops, e = batch.scaleSellOps(ops, sellingOffers)
+		if e != nil {
+			return nil, nil, fmt.Errorf("could not scale sell ops: %s", e)
+		}
+	}
+
+	ops, e = filterOps(f.name, f.baseAsset, f.quoteAsset, sellingOffers, buyingOffers, ops, batch.filterOp, batch.freeDeletedOffer, f.config.MaxOpsGuard)
+	if e != nil {
+		return nil, nil, fmt.Errorf("could not apply filter: %s", e)
+	}
+	log.Printf("volumeFilter: cycle summary, dropped ops by reason: %s\n", summarizeDropReasons(batch.dropCounts))
+	f.persistTBB(batch)
+	f.holdPendingVolume(batch)
+	return ops, batch, nil
+}
+
+// ApplyStream is the streaming version of Apply that processes the ops as they arrive on the ops channel while maintaining the TBB
+// accumulator, and sends the kept and trimmed ops to the out channel. It returns once the ops channel is closed and does not close the out
+// channel. This suits pipelines that produce ops incrementally since the ops are never materialized into a list.
+//
+// The sellingOffers and buyingOffers are only used to look up the existing offer that an op updates or deletes, which means that:
+//   - existing offers that are not touched by any op are not re-checked against the caps (Apply deletes them if they exceed the caps)
+//   - the delete op for a dropped update of an existing offer is sent in place instead of being moved to the start of the ops
+//   - the "scale" mode cannot scale the ops since they are not known upfront, so it trims the ops like the "exact" mode
+//   - the CountDeletesAsFreeingCap is not used since the deleted offers are not known upfront either
+//
+// so the output matches Apply when the ops delete or update existing offers before placing new ones. The MaxOpsGuard is applied to the
+// number of ops received, note that an error is returned after the ops before the limit have already been sent. On an error the remaining
+// ops are drained (and dropped) until the ops channel is closed before returning, so the producer is never blocked.
+func (f *volumeFilter) ApplyStream(ops <-chan txnbuild.Operation, out chan<- txnbuild.Operation, sellingOffers []hProtocol.Offer, buyingOffers []hProtocol.Offer) error {
+	defer func() {
+		for range ops {
+		}
+	}()
+
+	batch, e := f.loadBatch()
+	if e != nil {
+		return e
+	}
+	if batch == nil {
+		for op := range ops {
+			if f.passesOnQueryFailure(op) {
+				out <- op
+			}
+		}
+		return nil
+	}
+	e = f.excludePendingOnBook(batch, sellingOffers)
+	if e != nil {
+		return e
+	}
+	e = f.restoreTBB(batch, sellingOffers)
+	if e != nil {
+		return e
+	}
+
+	offerMap := makeOfferMap(append(sellingOffers, buyingOffers...))
+	guard := f.config.MaxOpsGuard
+	numOps := 0
+	for op := range ops {
+		numOps++
+		if guard != nil && numOps > guard.MaxOps {
+			if !guard.Truncate {
+				return fmt.Errorf("filter \"%s\" received more than the max of %d ops", f.name, guard.MaxOps)
+			}
+			// keep draining the ops so the producer is not blocked
+			continue
+		}
+
+		mso, ok := op.(*txnbuild.ManageSellOffer)
+		if !ok || f.config.MonitorOnly {
+			out <- op
+			continue
+		}
+
+		newOpToPrepend, newOpToAppend, _, e := runInnerFilterFn(*mso, batch.filterOp, fetchOfferAsOpByID(mso.OfferID, offerMap), *mso)
+		if e != nil {
+			return fmt.Errorf("error while running inner filter function: %s", e)
+		}
+		if newOpToPrepend != nil {
+			out <- newOpToPrepend
+		}
+		if newOpToAppend != nil {
+			out <- newOpToAppend
+		}
+	}
+
+	if guard != nil && numOps > guard.MaxOps {
+		log.Printf("warning: filter \"%s\" received %d ops which exceeds the max of %d ops, only processed the first %d ops\n", f.name, numOps, guard.MaxOps, guard.MaxOps)
+	}
+	log.Printf("volumeFilter: stream summary, processed %d ops, dropped ops by reason: %s\n", numOps, summarizeDropReasons(batch.dropCounts))
+	f.persistTBB(batch)
+	f.holdPendingVolume(batch)
+	return nil
+}
+
+// loadBatch queries the volumes and makes the batch for filtering the ops one at a time in the order that they arrive, as opposed to filtering
+// the whole list of ops at once like Apply. The returned batch is nil when a query failed and the ops are let through according to
+// passesOnQueryFailure.
+func (f *volumeFilter) loadBatch() (*volumeFilterBatch, error) {
+	ctx, cancel := f.queryContext()
+	defer cancel()
+	now := f.now()
+	dateString, dailyValuesBaseSold, e := f.loadDailyVolume(ctx, now, true)
+	var dailyValuesBaseBought *queries.DailyVolume
+	if e == nil {
+		dailyValuesBaseBought, e = f.queryDailyBuyVolume(ctx, now, dateString)
+	}
+	var periodValuesBaseSold []*queries.DailyVolume
+	if e == nil {
+		periodValuesBaseSold, e = f.queryPeriodVolumes(ctx, dateString)
+	}
+	var accountValuesBaseSold map[string]*queries.DailyVolume
+	if e == nil {
+		accountValuesBaseSold, e = f.queryAccountVolumes(ctx, dateString)
+	}
+	var baseUSDPrice *float64
+	if e == nil {
+		baseUSDPrice, e = f.fetchBaseUSDPrice()
+	}
+	if e != nil {
+		_, e = f.handleQueryFailure(nil, e)
+		return nil, e
+	}
+
+	batch := f.makeBatch(now, dateString, dailyValuesBaseSold, dailyValuesBaseBought, periodValuesBaseSold, accountValuesBaseSold, baseUSDPrice)
+	e = batch.loadNetPosition()
+	if e != nil {
+		return nil, e
+	}
+	return batch, nil
+}
+
+// passesOnQueryFailure returns true if the op is let through when failing open after a query failure, which only lets through the deletes
+// when blocking all ops
+func (f *volumeFilter) passesOnQueryFailure(op txnbuild.Operation) bool {
+	return f.config.OnQueryErrorBehavior != QueryErrorBehaviorBlockAll || isDeleteOp(op)
+}
+
+// loadDailyVolume fetches the date and the daily volume sold on that date, using the pre-fetched value when available, and updates the state
+// of the query circuit breaker on a successful query when updateBreaker is set, which is shared by the calls that check the caps and the
+// calls that only report on them
+func (f *volumeFilter) loadDailyVolume(ctx context.Context, now time.Time, updateBreaker bool) (string, *queries.DailyVolume, error) {
+	dateString, e := f.currentDateString(ctx, now)
+	if e != nil {
+		return "", nil, e
+	}
+	dailyValuesBaseSold, isPrefetched := f.prefetchedDailyVolume(now, dateString)
+	if !isPrefetched {
+		dailyValuesBaseSold, e = f.queryDailyVolume(ctx, now, dateString)
+		if e != nil {
+			return "", nil, e
+		}
+		if updateBreaker {
+			f.handleQuerySuccess()
+		}
 	}
 
 	log.Printf("dailyValuesByDate for today (%s): baseSoldUnits = %.8f %s, quoteCostUnits = %.8f %s (%s)\n",
 		dateString, dailyValuesBaseSold.BaseVol, utils.Asset2String(f.baseAsset), dailyValuesBaseSold.QuoteVol, utils.Asset2String(f.quoteAsset), f.config)
+	return dateString, dailyValuesBaseSold, nil
+}
+
+// restoreTBB adds the persisted TBB on the same date to the batch, only on the first batch after the filter is created (i.e. after a restart)
+// since the TBB of every later batch is computed from its own ops and the resting offers. The volume of the resting sellingOffers and the
+// volume executed since the TBB was persisted are taken out of the persisted TBB since the batch counts them already, so only the volume that
+// was placed and executed but is not yet in the daily volume is restored. A persisted TBB from an earlier date is ignored, which resets it
+// on the rollover of the date. Errors of the StateStore are logged since the filter can proceed without the persisted state.
+func (f *volumeFilter) restoreTBB(b *volumeFilterBatch, sellingOffers []hProtocol.Offer) error {
+	if f.config.StateStore == nil || f.tbbRestored {
+		return nil
+	}
+	f.tbbRestored = true
+
+	persisted, e := f.config.StateStore.LoadTBB(f.marketID)
+	if e != nil {
+		log.Printf("warning: volumeFilter: could not load the persisted TBB for market '%s', starting from an empty TBB: %s\n", f.marketID, e)
+		return nil
+	}
+	if persisted == nil || persisted.Date != b.dateString {
+		return nil
+	}
+
+	restingBase, restingQuote, e := b.offersVolume(sellingOffers)
+	if e != nil {
+		return e
+	}
+	executedBase := *b.dailyOTB.SellBaseAssetCapInBaseUnits - persisted.OTBBaseUnits
+	executedQuote := *b.dailyOTB.SellBaseAssetCapInQuoteUnits - persisted.OTBQuoteUnits
+	restoredBase := math.Max(0, persisted.SellBaseUnits-restingBase-executedBase)
+	restoredQuote := math.Max(0, persisted.SellQuoteUnits-restingQuote-executedQuote)
+
+	log.Printf("volumeFilter: restored the persisted TBB for market '%s' on date %s: sellBaseUnits = %.8f (of %.8f), sellQuoteUnits = %.8f (of %.8f)\n",
+		f.marketID, persisted.Date, restoredBase, persisted.SellBaseUnits, restoredQuote, persisted.SellQuoteUnits)
+	// the restored volume was placed in an earlier batch so it does not count toward the MaxBatchVolume
+	b.freeCap(-restoredBase, -restoredQuote)
+	return nil
+}
+
+// persistTBB saves the TBB at the end of the batch along with the daily volume that it was checked against, errors are logged since the filter
+// can proceed without the persisted state
+func (f *volumeFilter) persistTBB(b *volumeFilterBatch) {
+	if f.config.StateStore == nil {
+		return
+	}
+
+	e := f.config.StateStore.SaveTBB(f.marketID, PersistedTBB{
+		Date:           b.dateString,
+		SellBaseUnits:  *b.dailyTBB.SellBaseAssetCapInBaseUnits,
+		SellQuoteUnits: *b.dailyTBB.SellBaseAssetCapInQuoteUnits,
+		OTBBaseUnits:   *b.dailyOTB.SellBaseAssetCapInBaseUnits,
+		OTBQuoteUnits:  *b.dailyOTB.SellBaseAssetCapInQuoteUnits,
+	})
+	if e != nil {
+		log.Printf("warning: volumeFilter: could not persist the TBB for market '%s': %s\n", f.marketID, e)
+	}
+}
+
+// excludePendingOnBook takes the part of the pending volume that was last recorded by the filter that is on the book in the resting
+// sellingOffers (or was settled since) out of the daily volume of the batch when using RecordPendingVolume, since the batch re-counts the
+// resting offers. The pending volume recorded on an earlier date or before a restart is not known to the filter so it is not changed.
+func (f *volumeFilter) excludePendingOnBook(b *volumeFilterBatch, sellingOffers []hProtocol.Offer) error {
+	if f.pending == nil {
+		return nil
+	}
+	f.pending.mutex.Lock()
+	recorded := f.pending.recorded
+	f.pending.mutex.Unlock()
+
+	otbBase := *b.dailyOTB.SellBaseAssetCapInBaseUnits
+	otbQuote := *b.dailyOTB.SellBaseAssetCapInQuoteUnits
+	if recorded == nil || recorded.date != b.dateString {
+		b.settledOTB = &queries.DailyVolume{BaseVol: otbBase, QuoteVol: otbQuote}
+		return nil
+	}
+
+	restingBase, restingQuote, e := b.offersVolume(sellingOffers)
+	if e != nil {
+		return e
+	}
+	settledBase := otbBase - recorded.baseVol
+	settledQuote := otbQuote - recorded.quoteVol
+	pendingBase := math.Max(0, recorded.baseVol-restingBase-(settledBase-recorded.settledBase))
+	pendingQuote := math.Max(0, recorded.quoteVol-restingQuote-(settledQuote-recorded.settledQuote))
+	log.Printf("volumeFilter: pending volume of market '%s' that is not on the book: sellBaseUnits = %.8f (of %.8f), sellQuoteUnits = %.8f (of %.8f)\n",
+		f.marketID, pendingBase, recorded.baseVol, pendingQuote, recorded.quoteVol)
+
+	b.settledOTB = &queries.DailyVolume{BaseVol: settledBase, QuoteVol: settledQuote}
+	// replace the values instead of updating them in place since the daily volume can be cached
+	dailyBase := settledBase + pendingBase
+	dailyQuote := settledQuote + pendingQuote
+	b.dailyOTB.SellBaseAssetCapInBaseUnits = &dailyBase
+	b.dailyOTB.SellBaseAssetCapInQuoteUnits = &dailyQuote
+	return nil
+}
+
+// holdPendingVolume keeps the volume sold by the batch until PostSubmit records it, replacing the volume of an earlier batch that was not
+// submitted
+func (f *volumeFilter) holdPendingVolume(b *volumeFilterBatch) {
+	if f.pending == nil {
+		return
+	}
+
+	f.pending.mutex.Lock()
+	defer f.pending.mutex.Unlock()
+	f.pending.unsubmitted = &pendingVolume{
+		date:         b.dateString,
+		baseVol:      *b.dailyTBB.SellBaseAssetCapInBaseUnits,
+		quoteVol:     *b.dailyTBB.SellBaseAssetCapInQuoteUnits,
+		settledBase:  b.settledOTB.BaseVol,
+		settledQuote: b.settledOTB.QuoteVol,
+	}
+}
 
-	// daily on-the-books
-	dailyOTB := &VolumeFilterConfig{
-		SellBaseAssetCapInBaseUnits:  &dailyValuesBaseSold.BaseVol,
-		SellBaseAssetCapInQuoteUnits: &dailyValuesBaseSold.QuoteVol,
+// PostSubmit records the volume sold by the last batch as the pending volume of the market when using RecordPendingVolume, it should be
+// called once the ops returned by Apply (or ApplyStream) were submitted successfully
+func (f *volumeFilter) PostSubmit() error {
+	if f.pending == nil {
+		return nil
 	}
-	// daily to-be-booked starts out as empty and accumulates the values of the operations
+	f.pending.mutex.Lock()
+	submitted := f.pending.unsubmitted
+	f.pending.unsubmitted = nil
+	f.pending.mutex.Unlock()
+	if submitted == nil {
+		return nil
+	}
+
+	recorder, ok := f.dailyVolumeByDateQuery.(pendingVolumeRecorder)
+	if !ok {
+		return fmt.Errorf("the %s query of market '%s' cannot record the pending volume", f.dailyVolumeByDateQuery.Name(), f.marketID)
+	}
+	ctx, cancel := f.queryContext()
+	defer cancel()
+	e := recorder.RecordPendingVolume(ctx, f.marketID, submitted.date, submitted.baseVol, submitted.quoteVol)
+	if e != nil {
+		return fmt.Errorf("could not record the pending volume for market '%s': %s", f.marketID, e)
+	}
+
+	f.pending.mutex.Lock()
+	defer f.pending.mutex.Unlock()
+	f.pending.recorded = submitted
+	return nil
+}
+
+// volumeFilterBatch holds the state of the volume filter over the ops of a single batch
+type volumeFilterBatch struct {
+	f          *volumeFilter
+	dateString string
+	// dailyOTB is the daily on-the-books volume
+	dailyOTB *VolumeFilterConfig
+	// dailyTBB is the daily to-be-booked volume sold which starts out as empty and accumulates the values of the sell operations, only the
+	// sell fields are used
+	dailyTBB *VolumeFilterConfig
+	// dailyBuyTBB is the separate to-be-booked volume bought that accumulates the values of the buy operations, only the buy fields are used
+	dailyBuyTBB *VolumeFilterConfig
+	lp          limitParameters
+	dropCounts  map[VolumeFilterDropReason]int
+	// alerted holds the units of the alert caps that were already crossed in this batch
+	alerted map[string]bool
+	// netPosition is the projected net position in base units including the kept ops, nil when there is no MaxNetPositionInBaseUnits
+	netPosition *float64
+	// accountOTB is the daily volume sold by each account of the AccountCaps and accountTBB accumulates the sold volume of the kept ops of
+	// each account, both are empty when there are no AccountCaps
+	accountOTB map[string]*queries.DailyVolume
+	accountTBB map[string]*queries.DailyVolume
+	// deletedOffers holds the unsold volume of the resting sell offers that count toward the caps until their delete op is reached, empty
+	// unless CountDeletesAsFreeingCap is set
+	deletedOffers map[int64]*queries.DailyVolume
+	// settledOTB is the daily volume sold excluding the pending volume recorded by the filter, nil unless RecordPendingVolume is set
+	settledOTB *queries.DailyVolume
+}
+
+// makeBatch makes the state for a new batch with the caps that are active at the passed in time, tightened by the caps over the longer
+// windows using the volume sold in each of the f.periodCaps
+func (f *volumeFilter) makeBatch(
+	now time.Time,
+	dateString string,
+	dailyValuesBaseSold *queries.DailyVolume,
+	dailyValuesBaseBought *queries.DailyVolume,
+	periodValuesBaseSold []*queries.DailyVolume,
+	accountValuesBaseSold map[string]*queries.DailyVolume,
+	baseUSDPrice *float64,
+) *volumeFilterBatch {
 	dailyTbbSellBase := 0.0
 	dailyTbbSellQuote := 0.0
-	dailyTBB := &VolumeFilterConfig{
-		SellBaseAssetCapInBaseUnits:  &dailyTbbSellBase,
-		SellBaseAssetCapInQuoteUnits: &dailyTbbSellQuote,
+	dailyTbbBuyBase := 0.0
+	dailyTbbBuyQuote := 0.0
+
+	sellCapInBaseUnits, sellCapInQuoteUnits := f.config.activeSellCaps(now)
+	if len(f.config.CapSchedule) > 0 {
+		log.Printf("volumeFilter: active caps from schedule at %s: sellBaseAssetCapInBaseUnits = %s, sellBaseAssetCapInQuoteUnits = %s\n",
+			now.Format(time.RFC3339), utils.CheckedFloatPtr(sellCapInBaseUnits), utils.CheckedFloatPtr(sellCapInQuoteUnits))
+	}
+
+	sellBaseCapWindow := capWindowDaily
+	sellQuoteCapWindow := capWindowDaily
+	for i, pc := range f.periodCaps {
+		soldInPeriod := periodValuesBaseSold[i]
+		sellCapInBaseUnits, sellBaseCapWindow = tighterCap(sellCapInBaseUnits, sellBaseCapWindow, pc.capInBase, pc.window, soldInPeriod.BaseVol, dailyValuesBaseSold.BaseVol)
+		sellCapInQuoteUnits, sellQuoteCapWindow = tighterCap(sellCapInQuoteUnits, sellQuoteCapWindow, pc.capInQuote, pc.window, soldInPeriod.QuoteVol, dailyValuesBaseSold.QuoteVol)
+	}
+	if len(f.periodCaps) > 0 {
+		log.Printf("volumeFilter: binding caps on the daily volume including the longer windows: sellBaseAssetCapInBaseUnits = %s (%s window), sellBaseAssetCapInQuoteUnits = %s (%s window)\n",
+			utils.CheckedFloatPtr(sellCapInBaseUnits), sellBaseCapWindow, utils.CheckedFloatPtr(sellCapInQuoteUnits), sellQuoteCapWindow)
+	}
+
+	quoteFeeRate := 0.0
+	if f.config.QuoteCapFeeRate != nil {
+		quoteFeeRate = *f.config.QuoteCapFeeRate
+	}
+	var sellCapInUSD *float64
+	usdPrice := 0.0
+	if baseUSDPrice != nil {
+		sellCapInUSD = f.config.SellBaseAssetCapInUSD
+		usdPrice = *baseUSDPrice
+	}
+	var onCapHit func(cap string, projected float64, limit float64)
+	if f.config.OnCapHit != nil {
+		onCapHit = func(cap string, projected float64, limit float64) {
+			f.config.OnCapHit(f.marketID, cap, projected, limit)
+		}
+	}
+
+	return &volumeFilterBatch{
+		f:          f,
+		dateString: dateString,
+		dailyOTB: &VolumeFilterConfig{
+			SellBaseAssetCapInBaseUnits:  &dailyValuesBaseSold.BaseVol,
+			SellBaseAssetCapInQuoteUnits: &dailyValuesBaseSold.QuoteVol,
+			BuyBaseAssetCapInBaseUnits:   &dailyValuesBaseBought.BaseVol,
+			BuyBaseAssetCapInQuoteUnits:  &dailyValuesBaseBought.QuoteVol,
+		},
+		dailyTBB: &VolumeFilterConfig{
+			SellBaseAssetCapInBaseUnits:  &dailyTbbSellBase,
+			SellBaseAssetCapInQuoteUnits: &dailyTbbSellQuote,
+		},
+		dailyBuyTBB: &VolumeFilterConfig{
+			BuyBaseAssetCapInBaseUnits:  &dailyTbbBuyBase,
+			BuyBaseAssetCapInQuoteUnits: &dailyTbbBuyQuote,
+		},
+		lp: limitParameters{
+			sellBaseAssetCapInBaseUnits:  sellCapInBaseUnits,
+			sellBaseAssetCapInQuoteUnits: sellCapInQuoteUnits,
+			sellBaseAssetCapInUSD:        sellCapInUSD,
+			baseUSDPrice:                 usdPrice,
+			buyBaseAssetCapInBaseUnits:   f.config.BuyBaseAssetCapInBaseUnits,
+			buyBaseAssetCapInQuoteUnits:  f.config.BuyBaseAssetCapInQuoteUnits,
+			maxNetPositionInBaseUnits:    f.config.MaxNetPositionInBaseUnits,
+			sellBaseCapWindow:            sellBaseCapWindow,
+			sellQuoteCapWindow:           sellQuoteCapWindow,
+			mode:                         f.config.mode,
+			maxBatchVolumeInBaseUnits:    f.config.MaxBatchVolume,
+			minSellBaseAmount:            f.config.MinSellBaseAmount,
+			onCapHit:                     onCapHit,
+			quoteFeeRate:                 quoteFeeRate,
+		},
+		dropCounts:    map[VolumeFilterDropReason]int{},
+		alerted:       map[string]bool{},
+		accountOTB:    accountValuesBaseSold,
+		accountTBB:    map[string]*queries.DailyVolume{},
+		deletedOffers: map[int64]*queries.DailyVolume{},
+	}
+}
+
+// accountSellCap returns the cap of the account of the op with the volume sold by the account, nil when the account does not have a cap
+func (b *volumeFilterBatch) accountSellCap(op *txnbuild.ManageSellOffer) *accountSellCap {
+	accountID := b.f.config.DefaultAccountID
+	if op.SourceAccount != nil {
+		accountID = op.SourceAccount.GetAccountID()
+	}
+	accountCap, ok := b.f.config.AccountCaps[accountID]
+	if !ok {
+		return nil
+	}
+
+	otb := b.accountOTB[accountID]
+	if otb == nil {
+		otb = &queries.DailyVolume{BaseVol: 0.0, QuoteVol: 0.0}
+	}
+	tbb := b.accountTBB[accountID]
+	if tbb == nil {
+		tbb = &queries.DailyVolume{BaseVol: 0.0, QuoteVol: 0.0}
+		b.accountTBB[accountID] = tbb
+	}
+	return &accountSellCap{
+		accountID: accountID,
+		cap:       accountCap,
+		otb:       otb,
+		tbb:       tbb,
 	}
+}
+
+// offersVolume returns the base and quote volume of the sellingOffers, where the quote volume includes the QuoteCapFeeRate
+func (b *volumeFilterBatch) offersVolume(sellingOffers []hProtocol.Offer) (float64, float64, error) {
+	base := 0.0
+	quote := 0.0
+	for _, offer := range sellingOffers {
+		amount, e := strconv.ParseFloat(offer.Amount, 64)
+		if e != nil {
+			return 0, 0, fmt.Errorf("could not convert amount (%s) of offer %d to float: %s", offer.Amount, offer.ID, e)
+		}
+		base += amount
+		quote += amount * utils.GetPrice(offer) * (1 + b.lp.quoteFeeRate)
+	}
+	return base, quote, nil
+}
+
+// freeCap gives the subsequent ops in the batch additional headroom by subtracting the freed volume from the to-be-booked amounts, the
+// freed volume does not count toward the MaxBatchVolume
+func (b *volumeFilterBatch) freeCap(freedBase float64, freedQuote float64) {
+	*b.dailyTBB.SellBaseAssetCapInBaseUnits -= freedBase
+	*b.dailyTBB.SellBaseAssetCapInQuoteUnits -= freedQuote
+	b.lp.batchStartTbbBase -= freedBase
+}
+
+// countDeletedOffers adds the unsold volume of the resting sellingOffers that are deleted by the ops to the TBB when CountDeletesAsFreeingCap
+// is set, so the volume counts toward the caps until freeDeletedOffer is called with the delete op. The ops past the MaxOpsGuard are not
+// processed so their deletes are skipped, the existing offers of those ops are re-checked against the caps instead.
+func (b *volumeFilterBatch) countDeletedOffers(ops []txnbuild.Operation, sellingOffers []hProtocol.Offer) error {
+	if !b.f.config.CountDeletesAsFreeingCap {
+		return nil
+	}
+	if guard := b.f.config.MaxOpsGuard; guard != nil && len(ops) > guard.MaxOps {
+		ops = ops[:guard.MaxOps]
+	}
+
+	offerMap := makeOfferMap(sellingOffers)
+	for _, op := range ops {
+		if !isDeleteOp(op) {
+			continue
+		}
+		offer, ok := offerMap[op.(*txnbuild.ManageSellOffer).OfferID]
+		if !ok {
+			continue
+		}
+		amount, e := strconv.ParseFloat(offer.Amount, 64)
+		if e != nil {
+			return fmt.Errorf("could not convert amount (%s) of offer %d to float: %s", offer.Amount, offer.ID, e)
+		}
+		volume := &queries.DailyVolume{
+			BaseVol:  amount,
+			QuoteVol: amount * utils.GetPrice(offer) * (1 + b.lp.quoteFeeRate),
+		}
+		b.deletedOffers[offer.ID] = volume
+		// the offer was placed in an earlier batch so it does not count toward the MaxBatchVolume
+		b.freeCap(-volume.BaseVol, -volume.QuoteVol)
+	}
+	return nil
+}
+
+// freeDeletedOffer is the deleteFn that frees the volume counted by countDeletedOffers for the offer deleted by the op
+func (b *volumeFilterBatch) freeDeletedOffer(op *txnbuild.ManageSellOffer) error {
+	volume, ok := b.deletedOffers[op.OfferID]
+	if !ok {
+		return nil
+	}
+	delete(b.deletedOffers, op.OfferID)
+	log.Printf("volumeFilter: delete op for offer %d frees sellBaseUnits = %.8f, sellQuoteUnits = %.8f\n", op.OfferID, volume.BaseVol, volume.QuoteVol)
+	b.freeCap(volume.BaseVol, volume.QuoteVol)
+	return nil
+}
+
+// scaleSellOps is the pre-pass of the "scale" mode that multiplies the amount of every sell op by the same factor when the sell ops of the
+// batch would exceed the sell caps or the MaxBatchVolume, so the total of the scaled ops hits the tightest of the caps. The headroom used by
+// the resting sellingOffers that are not updated by any op is reserved since those are re-checked against the caps as well. The returned
+// list has the same length and order as the passed in ops, the ops that are not scaled are returned as-is.
+func (b *volumeFilterBatch) scaleSellOps(ops []txnbuild.Operation, sellingOffers []hProtocol.Offer) ([]txnbuild.Operation, error) {
+	reservedBase := 0.0
+	reservedQuote := 0.0
+	ignoreOfferIds := ignoreOfferIDs(ops)
+	for _, offer := range sellingOffers {
+		if ignoreOfferIds[offer.ID] {
+			continue
+		}
+		amount, e := strconv.ParseFloat(offer.Amount, 64)
+		if e != nil {
+			return nil, fmt.Errorf("could not convert amount (%s) of offer %d to float: %s", offer.Amount, offer.ID, e)
+		}
+		reservedBase += amount
+		reservedQuote += amount * utils.GetPrice(offer) * (1 + b.lp.quoteFeeRate)
+	}
+
+	sumBase := 0.0
+	sumQuote := 0.0
+	isScalable := make([]bool, len(ops))
+	for i, op := range ops {
+		mso, ok := op.(*txnbuild.ManageSellOffer)
+		if !ok || mso.Amount == "0" {
+			continue
+		}
+		isSell, e := utils.IsSelling(b.f.baseAsset, b.f.quoteAsset, mso.Selling, mso.Buying)
+		if e != nil {
+			return nil, fmt.Errorf("error when running the isSelling check for offer '%+v': %s", *mso, e)
+		}
+		if !isSell {
+			continue
+		}
+		price, e := strconv.ParseFloat(mso.Price, 64)
+		if e != nil {
+			return nil, fmt.Errorf("could not convert price (%s) to float: %s", mso.Price, e)
+		}
+		amount, e := strconv.ParseFloat(mso.Amount, 64)
+		if e != nil {
+			return nil, fmt.Errorf("could not convert amount (%s) to float: %s", mso.Amount, e)
+		}
+		isScalable[i] = true
+		sumBase += amount
+		sumQuote += amount * price * (1 + b.lp.quoteFeeRate)
+	}
+	if sumBase <= 0 {
+		return ops, nil
+	}
+
+	factor := 1.0
+	if b.lp.sellBaseAssetCapInBaseUnits != nil {
+		headroom := *b.lp.sellBaseAssetCapInBaseUnits - *b.dailyOTB.SellBaseAssetCapInBaseUnits - *b.dailyTBB.SellBaseAssetCapInBaseUnits - reservedBase
+		factor = math.Min(factor, headroom/sumBase)
+	}
+	if b.lp.sellBaseAssetCapInQuoteUnits != nil && sumQuote > 0 {
+		headroom := *b.lp.sellBaseAssetCapInQuoteUnits - *b.dailyOTB.SellBaseAssetCapInQuoteUnits - *b.dailyTBB.SellBaseAssetCapInQuoteUnits - reservedQuote
+		factor = math.Min(factor, headroom/sumQuote)
+	}
+	if b.lp.sellBaseAssetCapInUSD != nil {
+		headroom := *b.lp.sellBaseAssetCapInUSD/b.lp.baseUSDPrice - *b.dailyOTB.SellBaseAssetCapInBaseUnits - *b.dailyTBB.SellBaseAssetCapInBaseUnits - reservedBase
+		factor = math.Min(factor, headroom/sumBase)
+	}
+	if b.lp.maxBatchVolumeInBaseUnits != nil {
+		headroom := *b.lp.maxBatchVolumeInBaseUnits - (*b.dailyTBB.SellBaseAssetCapInBaseUnits - b.lp.batchStartTbbBase) - reservedBase
+		factor = math.Min(factor, headroom/sumBase)
+	}
+	if factor >= 1 {
+		return ops, nil
+	}
+	if factor <= 0 {
+		// there is no headroom to scale into so leave it to the per-op filter to drop the ops
+		log.Printf("volumeFilter: scale mode, no headroom left for the sell ops of the batch (sumBase=%.7f, sumQuote=%.7f)\n", sumBase, sumQuote)
+		return ops, nil
+	}
+	log.Printf("volumeFilter: scale mode, scaling the sell ops of the batch by factor %.7f (sumBase=%.7f, sumQuote=%.7f)\n", factor, sumBase, sumQuote)
+
+	scaledOps := make([]txnbuild.Operation, len(ops))
+	for i, op := range ops {
+		scaledOps[i] = op
+		if !isScalable[i] {
+			continue
+		}
+		mso := op.(*txnbuild.ManageSellOffer)
+		amount, e := strconv.ParseFloat(mso.Amount, 64)
+		if e != nil {
+			return nil, fmt.Errorf("could not convert amount (%s) to float: %s", mso.Amount, e)
+		}
+		// round down so the total of the scaled ops never exceeds the cap
+		newAmount := math.Floor(amount*factor*1e7) / 1e7
+		if newAmount <= 0 {
+			continue
+		}
+		opCopy := *mso
+		opCopy.Amount = fmt.Sprintf("%.7f", newAmount)
+		scaledOps[i] = &opCopy
+	}
+	return scaledOps, nil
+}
+
+// filterOp is the filterFn that runs the volume filter on a single op of the batch and writes the decision to the AuditSink
+func (b *volumeFilterBatch) filterOp(op *txnbuild.ManageSellOffer) (*txnbuild.ManageSellOffer, error) {
+	// the op is modified in place so keep a copy of the op before it is filtered
+	original := *op
+	result, e := b.filterOpResult(op)
+	if e != nil {
+		return nil, e
+	}
+
+	if b.f.config.AuditSink != nil {
+		record, isDecision, e := b.makeAuditRecord(&original, result)
+		if e != nil {
+			return nil, fmt.Errorf("could not make audit record: %s", e)
+		}
+		if isDecision {
+			e = b.f.config.AuditSink.WriteDecision(record)
+			if e != nil {
+				return nil, fmt.Errorf("could not write audit record: %s", e)
+			}
+		}
+	}
+	return result.Op, nil
+}
+
+// makeAuditRecord makes the audit record of the result of filtering the original op, returns false when the op was kept as-is
+func (b *volumeFilterBatch) makeAuditRecord(original *txnbuild.ManageSellOffer, result VolumeFilterResult) (AuditRecord, bool, error) {
+	originalAmount, e := strconv.ParseFloat(original.Amount, 64)
+	if e != nil {
+		return AuditRecord{}, false, fmt.Errorf("could not convert amount (%s) to float: %s", original.Amount, e)
+	}
+	isSell, e := utils.IsSelling(b.f.baseAsset, b.f.quoteAsset, original.Selling, original.Buying)
+	if e != nil {
+		return AuditRecord{}, false, fmt.Errorf("error when running the isSelling check for offer '%+v': %s", *original, e)
+	}
+	side := "buy"
+	if isSell {
+		side = "sell"
+	}
+
+	record := AuditRecord{
+		Timestamp:      b.f.now().UTC(),
+		MarketID:       b.f.marketID,
+		Date:           b.dateString,
+		OfferID:        original.OfferID,
+		Side:           side,
+		Price:          original.Price,
+		OriginalAmount: originalAmount,
+	}
+	if result.Op == nil {
+		record.Action = VolumeFilterOpActionDropped
+		record.Constraint = result.DropReason
+		return record, true, nil
+	}
+
+	finalAmount, e := strconv.ParseFloat(result.Op.Amount, 64)
+	if e != nil {
+		return AuditRecord{}, false, fmt.Errorf("could not convert amount (%s) to float: %s", result.Op.Amount, e)
+	}
+	if finalAmount >= originalAmount {
+		return AuditRecord{}, false, nil
+	}
+	record.Action = VolumeFilterOpActionTrimmed
+	record.Constraint = result.TrimReason
+	record.FinalAmount = finalAmount
+	return record, true, nil
+}
+
+// filterOpResult runs the volume filter on a single op of the batch and returns the structured result, tracing the op when it is modified
+func (b *volumeFilterBatch) filterOpResult(op *txnbuild.ManageSellOffer) (VolumeFilterResult, error) {
+	if !b.f.config.TraceOps {
+		return b.runFilterOp(op)
+	}
+
+	// the op is modified in place so keep a copy of the op before it is filtered
+	before := *op
+	result, e := b.runFilterOp(op)
+	if e != nil {
+		return VolumeFilterResult{}, e
+	}
+	if result.Op == nil || result.Op.Price != before.Price || result.Op.Amount != before.Amount {
+		l := b.f.config.TraceLogger
+		if l == nil {
+			l = logger.MakeBasicLogger()
+		}
+		l.Info(fmt.Sprintf("volumeFilter: trace: op modified (dropReason=%s), before: %s, after: %s", result.DropReason, traceOpString(&before), traceOpString(result.Op)))
+	}
+	return result, nil
+}
+
+// traceOpString returns the complete representation of the op for the trace logs
+func traceOpString(op *txnbuild.ManageSellOffer) string {
+	if op == nil {
+		return "<nil>"
+	}
+	sourceAccount := "<nil>"
+	if op.SourceAccount != nil {
+		sourceAccount = op.SourceAccount.GetAccountID()
+	}
+	return fmt.Sprintf("ManageSellOffer[offerID=%d, price=%s, amount=%s, selling=%s, buying=%s, sourceAccount=%s]",
+		op.OfferID, op.Price, op.Amount, utils.Asset2String(utils.Asset2Asset2(op.Selling)), utils.Asset2String(utils.Asset2Asset2(op.Buying)), sourceAccount)
+}
+
+// runFilterOp runs the price precision guard, the volume filter, and the decision hook on a single op of the batch
+func (b *volumeFilterBatch) runFilterOp(op *txnbuild.ManageSellOffer) (VolumeFilterResult, error) {
+	keep, e := b.f.config.PricePrecisionGuard.apply(op)
+	if e != nil {
+		return VolumeFilterResult{}, fmt.Errorf("could not apply price precision guard: %s", e)
+	}
+	if !keep {
+		b.dropCounts[DropReasonPricePrecision]++
+		return dropped(DropReasonPricePrecision), nil
+	}
+
+	keep, e = b.applyNetPositionLimit(op)
+	if e != nil {
+		return VolumeFilterResult{}, fmt.Errorf("could not apply net position limit: %s", e)
+	}
+	if !keep {
+		b.dropCounts[DropReasonNetPosition]++
+		return dropped(DropReasonNetPosition), nil
+	}
+
+	original := *op
+	lp := b.lp
+	lp.accountCap = b.accountSellCap(op)
+	result, e := volumeFilterFn(b.dailyOTB, b.dailyTBB, b.dailyBuyTBB, op, b.f.baseAsset, b.f.quoteAsset, lp)
+	if e != nil {
+		return VolumeFilterResult{}, e
+	}
+	if b.f.config.DecisionHook != nil {
+		result, e = applyDecisionHook(b.f.config.DecisionHook, &original, result, b.dailyOTB, b.dailyTBB, b.dailyBuyTBB, b.f.baseAsset, b.f.quoteAsset, lp)
+		if e != nil {
+			return VolumeFilterResult{}, fmt.Errorf("could not apply decision hook: %s", e)
+		}
+	}
+	if result.Op == nil {
+		b.dropCounts[result.DropReason]++
+	} else {
+		b.checkAlertCaps()
+		e = b.addToNetPosition(result.Op)
+		if e != nil {
+			return VolumeFilterResult{}, e
+		}
+	}
+	return result, nil
+}
+
+// loadNetPosition sets the starting net position of the batch from the NetPosition callback when there is a MaxNetPositionInBaseUnits
+func (b *volumeFilterBatch) loadNetPosition() error {
+	if b.lp.maxNetPositionInBaseUnits == nil {
+		return nil
+	}
+
+	netPosition, e := b.f.config.NetPosition()
+	if e != nil {
+		return fmt.Errorf("could not get the current net position: %s", e)
+	}
+	log.Printf("volumeFilter: current net position = %.8f %s, maxNetPositionInBaseUnits = %.8f\n", netPosition, utils.Asset2String(b.f.baseAsset), *b.lp.maxNetPositionInBaseUnits)
+	b.netPosition = &netPosition
+	return nil
+}
+
+// netPositionChange returns the signed change in the net position (in base units) if the op is fully executed, a buy op sells the quote
+// asset so its amount is in quote units and its price is in base units per quote unit
+func (b *volumeFilterBatch) netPositionChange(op *txnbuild.ManageSellOffer) (float64, error) {
+	isSell, e := utils.IsSelling(b.f.baseAsset, b.f.quoteAsset, op.Selling, op.Buying)
+	if e != nil {
+		return 0, fmt.Errorf("error when running the isSelling check for offer '%+v': %s", *op, e)
+	}
+	amount, e := strconv.ParseFloat(op.Amount, 64)
+	if e != nil {
+		return 0, fmt.Errorf("could not convert amount (%s) to float: %s", op.Amount, e)
+	}
+	if isSell {
+		return -amount, nil
+	}
+
+	price, e := strconv.ParseFloat(op.Price, 64)
+	if e != nil {
+		return 0, fmt.Errorf("could not convert price (%s) to float: %s", op.Price, e)
+	}
+	return amount * price, nil
+}
+
+// applyNetPositionLimit trims the amount of the op in place when the projected net position would exceed the MaxNetPositionInBaseUnits
+// in exact mode, returning false if the op should be dropped instead
+func (b *volumeFilterBatch) applyNetPositionLimit(op *txnbuild.ManageSellOffer) (bool, error) {
+	if b.netPosition == nil {
+		return true, nil
+	}
+
+	change, e := b.netPositionChange(op)
+	if e != nil {
+		return false, e
+	}
+	maxPosition := *b.lp.maxNetPositionInBaseUnits
+	projected := *b.netPosition + change
+	if math.Abs(projected) <= maxPosition || math.Abs(projected) < math.Abs(*b.netPosition) {
+		return true, nil
+	}
+
+	// the change that takes the position exactly to the limit on the side that the op moves toward
+	allowedChange := maxPosition - *b.netPosition
+	if change < 0 {
+		allowedChange = -maxPosition - *b.netPosition
+	}
+	if !b.lp.mode.trimsOps() || allowedChange/change <= 0 {
+		log.Printf("volumeFilter: dropping op because the projected net position (%.7f) exceeds the max of +/-%.7f\n", projected, maxPosition)
+		return false, nil
+	}
+
+	amount, e := strconv.ParseFloat(op.Amount, 64)
+	if e != nil {
+		return false, fmt.Errorf("could not convert amount (%s) to float: %s", op.Amount, e)
+	}
+	op.Amount = fmt.Sprintf("%.7f", amount*allowedChange/change)
+	log.Printf("volumeFilter: trimmed the amount of the op to %s because the projected net position (%.7f) exceeds the max of +/-%.7f\n", op.Amount, projected, maxPosition)
+	return true, nil
+}
+
+// addToNetPosition updates the projected net position of the batch with the kept op
+func (b *volumeFilterBatch) addToNetPosition(op *txnbuild.ManageSellOffer) error {
+	if b.netPosition == nil {
+		return nil
+	}
+
+	change, e := b.netPositionChange(op)
+	if e != nil {
+		return e
+	}
+	*b.netPosition += change
+	return nil
+}
+
+// capReachedTolerance allows for the rounding of trimmed amounts to 7 decimal places when checking whether the daily volume reached a cap
+const capReachedTolerance = 1e-7
+
+// capBreached returns true when an op of the batch was dropped by a daily cap or when the daily volume (OTB + TBB) reached a daily cap
+func (b *volumeFilterBatch) capBreached() bool {
+	for _, reason := range []VolumeFilterDropReason{DropReasonBaseCap, DropReasonQuoteCap, DropReasonUSDCap, DropReasonBuyBaseCap, DropReasonBuyQuoteCap} {
+		if b.dropCounts[reason] > 0 {
+			return true
+		}
+	}
+
+	reached := func(cap *float64, otb *float64, tbb *float64) bool {
+		return cap != nil && *otb+*tbb >= *cap-capReachedTolerance
+	}
+	return reached(b.lp.sellBaseAssetCapInBaseUnits, b.dailyOTB.SellBaseAssetCapInBaseUnits, b.dailyTBB.SellBaseAssetCapInBaseUnits) ||
+		reached(b.lp.sellBaseAssetCapInQuoteUnits, b.dailyOTB.SellBaseAssetCapInQuoteUnits, b.dailyTBB.SellBaseAssetCapInQuoteUnits) ||
+		reached(b.lp.buyBaseAssetCapInBaseUnits, b.dailyOTB.BuyBaseAssetCapInBaseUnits, b.dailyBuyTBB.BuyBaseAssetCapInBaseUnits) ||
+		reached(b.lp.buyBaseAssetCapInQuoteUnits, b.dailyOTB.BuyBaseAssetCapInQuoteUnits, b.dailyBuyTBB.BuyBaseAssetCapInQuoteUnits) ||
+		(b.lp.sellBaseAssetCapInUSD != nil && (*b.dailyOTB.SellBaseAssetCapInBaseUnits+*b.dailyTBB.SellBaseAssetCapInBaseUnits)*b.lp.baseUSDPrice >= *b.lp.sellBaseAssetCapInUSD-capReachedTolerance)
+}
+
+// checkAlertCaps emits an alert for each alert cap that the daily volume crossed, unless it was already emitted in this batch
+func (b *volumeFilterBatch) checkAlertCaps() {
+	b.checkAlertCap("base", b.f.config.AlertCapInBaseUnits, *b.dailyOTB.SellBaseAssetCapInBaseUnits+*b.dailyTBB.SellBaseAssetCapInBaseUnits)
+	b.checkAlertCap("quote", b.f.config.AlertCapInQuoteUnits, *b.dailyOTB.SellBaseAssetCapInQuoteUnits+*b.dailyTBB.SellBaseAssetCapInQuoteUnits)
+}
+
+func (b *volumeFilterBatch) checkAlertCap(units string, alertCap *float64, projectedSold float64) {
+	if alertCap == nil || projectedSold <= *alertCap || b.alerted[units] {
+		return
+	}
+	b.alerted[units] = true
+
+	alert := VolumeCapAlert{
+		Date:          b.dateString,
+		Units:         units,
+		AlertCap:      *alertCap,
+		ProjectedSold: projectedSold,
+	}
+	log.Printf("warning: volumeFilter: the daily volume crossed the alert cap, ops are not affected: %s\n", alert)
+	if b.f.config.OnAlert != nil {
+		b.f.config.OnAlert(alert)
+	}
+}
+
+// VolumeFilterOpAction is what the volume filter did with an op in a dry run
+type VolumeFilterOpAction string
+
+// these are the actions that the volume filter can take on an op
+const (
+	VolumeFilterOpActionKept    VolumeFilterOpAction = "kept"
+	VolumeFilterOpActionTrimmed VolumeFilterOpAction = "trimmed"
+	VolumeFilterOpActionDropped VolumeFilterOpAction = "dropped"
+)
+
+// VolumeFilterOpDiff annotates an op in a dry run with the op that the volume filter would produce
+type VolumeFilterOpDiff struct {
+	Unfiltered txnbuild.Operation
+	// Filtered is nil when the op would be dropped
+	Filtered   txnbuild.Operation
+	Action     VolumeFilterOpAction
+	DropReason VolumeFilterDropReason
+	// TrimmedAmount is the amount that would be removed from the op, which is the full amount of a dropped op
+	TrimmedAmount float64
+}
+
+// String is the stringer method
+func (d VolumeFilterOpDiff) String() string {
+	s := fmt.Sprintf("VolumeFilterOpDiff[action=%s, unfiltered=%s, filtered=%s", d.Action, opString(d.Unfiltered), opString(d.Filtered))
+	if d.Action == VolumeFilterOpActionTrimmed {
+		s += fmt.Sprintf(", trimmedAmount=%.7f", d.TrimmedAmount)
+	} else if d.Action == VolumeFilterOpActionDropped {
+		s += fmt.Sprintf(", trimmedAmount=%.7f, dropReason=%s", d.TrimmedAmount, d.DropReason)
+	}
+	return s + "]"
+}
+
+// opString returns a short representation of the op for the dry run output
+func opString(op txnbuild.Operation) string {
+	if op == nil {
+		return "<nil>"
+	}
+	if mso, ok := op.(*txnbuild.ManageSellOffer); ok {
+		return fmt.Sprintf("ManageSellOffer[offerID=%d, price=%s, amount=%s]", mso.OfferID, mso.Price, mso.Amount)
+	}
+	return fmt.Sprintf("%T", op)
+}
+
+// DryRun returns each op of the batch side by side with the op that this filter would produce, annotated with whether it would be kept,
+// trimmed (and by how much), or dropped (and why), which is meant for a "what would happen" report when validating a new cap before
+// enforcing it. It does not mutate the ops or the state of the filter, and does not call the OnCapHit, OnAlert, DecisionHook, or AuditSink,
+// so the report is the result of the caps alone. Only the passed in ops are considered, i.e. the existing offers are not re-checked against
+// the caps.
+func (f *volumeFilter) DryRun(ops []txnbuild.Operation) ([]VolumeFilterOpDiff, error) {
+	ctx, cancel := f.queryContext()
+	defer cancel()
+	now := f.now()
+	dateString, dailyValuesBaseSold, e := f.loadDailyVolume(ctx, now, false)
+	if e != nil {
+		return nil, fmt.Errorf("could not load daily volume: %s", e)
+	}
+	dailyValuesBaseBought, e := f.queryDailyBuyVolume(ctx, now, dateString)
+	if e != nil {
+		return nil, fmt.Errorf("could not query daily buy volume: %s", e)
+	}
+	periodValuesBaseSold, e := f.queryPeriodVolumes(ctx, dateString)
+	if e != nil {
+		return nil, fmt.Errorf("could not query weekly and monthly volume: %s", e)
+	}
+	accountValuesBaseSold, e := f.queryAccountVolumes(ctx, dateString)
+	if e != nil {
+		return nil, fmt.Errorf("could not query daily volume by account: %s", e)
+	}
+	baseUSDPrice, e := f.fetchBaseUSDPrice()
+	if e != nil {
+		return nil, fmt.Errorf("could not fetch the USD price of the base asset: %s", e)
+	}
+	// the batch is made from a copy of the filter without the callbacks so a dry run has no side effects
+	config := *f.config
+	config.OnCapHit = nil
+	config.OnAlert = nil
+	config.DecisionHook = nil
+	config.AuditSink = nil
+	dryRunFilter := *f
+	dryRunFilter.config = &config
+	// copy the daily volume because the batch references it
+	dailyVolume := *dailyValuesBaseSold
+	batch := dryRunFilter.makeBatch(now, dateString, &dailyVolume, dailyValuesBaseBought, periodValuesBaseSold, accountValuesBaseSold, baseUSDPrice)
+	e = batch.loadNetPosition()
+	if e != nil {
+		return nil, e
+	}
+
+	scaledOps := ops
+	if f.config.mode == volumeFilterModeScale {
+		scaledOps, e = batch.scaleSellOps(ops, nil)
+		if e != nil {
+			return nil, fmt.Errorf("could not scale sell ops: %s", e)
+		}
+	}
+
+	diffs := []VolumeFilterOpDiff{}
+	for i, op := range ops {
+		mso, ok := scaledOps[i].(*txnbuild.ManageSellOffer)
+		// delete operations should never be dropped
+		if !ok || mso.Amount == "0" || f.config.MonitorOnly {
+			diffs = append(diffs, VolumeFilterOpDiff{Unfiltered: op, Filtered: op, Action: VolumeFilterOpActionKept})
+			continue
+		}
+
+		opCopy := *mso
+		result, e := batch.filterOpResult(&opCopy)
+		if e != nil {
+			return nil, fmt.Errorf("could not run filter on op (%s): %s", opString(mso), e)
+		}
+		// the trimmed amount is measured against the unfiltered op so the scaling of the "scale" mode shows up as a trim
+		unfilteredAmount := op.(*txnbuild.ManageSellOffer).Amount
+		amount, e := strconv.ParseFloat(unfilteredAmount, 64)
+		if e != nil {
+			return nil, fmt.Errorf("could not convert amount (%s) to float: %s", unfilteredAmount, e)
+		}
 
-	innerFn := func(op *txnbuild.ManageSellOffer) (*txnbuild.ManageSellOffer, error) {
-		limitParameters := limitParameters{
-			sellBaseAssetCapInBaseUnits:  f.config.SellBaseAssetCapInBaseUnits,
-			sellBaseAssetCapInQuoteUnits: f.config.SellBaseAssetCapInQuoteUnits,
-			mode:                         f.config.mode,
+		if result.Op == nil {
+			diffs = append(diffs, VolumeFilterOpDiff{
+				Unfiltered:    op,
+				Filtered:      nil,
+				Action:        VolumeFilterOpActionDropped,
+				DropReason:    result.DropReason,
+				TrimmedAmount: amount,
+			})
+			continue
 		}
-		return volumeFilterFn(dailyOTB, dailyTBB, op, f.baseAsset, f.quoteAsset, limitParameters)
-	}
-	ops, e = filterOps(f.name, f.baseAsset, f.quoteAsset, sellingOffers, buyingOffers, ops, innerFn)
-	if e != nil {
-		return nil, fmt.Errorf("could not apply filter: %s", e)
+
+		newAmount, e := strconv.ParseFloat(result.Op.Amount, 64)
+		if e != nil {
+			return nil, fmt.Errorf("could not convert amount (%s) to float: %s", result.Op.Amount, e)
+		}
+		action := VolumeFilterOpActionKept
+		if newAmount < amount {
+			action = VolumeFilterOpActionTrimmed
+		}
+		diffs = append(diffs, VolumeFilterOpDiff{
+			Unfiltered:    op,
+			Filtered:      result.Op,
+			Action:        action,
+			TrimmedAmount: amount - newAmount,
+		})
 	}
-	return ops, nil
+	return diffs, nil
 }
 
-func volumeFilterFn(dailyOTB *VolumeFilterConfig, dailyTBBAccumulator *VolumeFilterConfig, op *txnbuild.ManageSellOffer, baseAsset hProtocol.Asset, quoteAsset hProtocol.Asset, lp limitParameters) (*txnbuild.ManageSellOffer, error) {
+// volumeFilterFn filters a single op against the caps, where the sell ops accumulate into the sell fields of the dailyTBBAccumulator and the
+// buy ops accumulate into the buy fields of the separate dailyBuyTBBAccumulator so the two sides never share an accumulator
+func volumeFilterFn(
+	dailyOTB *VolumeFilterConfig,
+	dailyTBBAccumulator *VolumeFilterConfig,
+	dailyBuyTBBAccumulator *VolumeFilterConfig,
+	op *txnbuild.ManageSellOffer,
+	baseAsset hProtocol.Asset,
+	quoteAsset hProtocol.Asset,
+	lp limitParameters,
+) (VolumeFilterResult, error) {
 	isSell, e := utils.IsSelling(baseAsset, quoteAsset, op.Selling, op.Buying)
 	if e != nil {
-		return nil, fmt.Errorf("error when running the isSelling check for offer '%+v': %s", *op, e)
+		return VolumeFilterResult{}, fmt.Errorf("error when running the isSelling check for offer '%+v': %s", *op, e)
 	}
 
 	sellPrice, e := strconv.ParseFloat(op.Price, 64)
 	if e != nil {
-		return nil, fmt.Errorf("could not convert price (%s) to float: %s", op.Price, e)
+		return VolumeFilterResult{}, fmt.Errorf("could not convert price (%s) to float: %s", op.Price, e)
 	}
 
 	amountValueUnitsBeingSold, e := strconv.ParseFloat(op.Amount, 64)
 	if e != nil {
-		return nil, fmt.Errorf("could not convert amount (%s) to float: %s", op.Amount, e)
+		return VolumeFilterResult{}, fmt.Errorf("could not convert amount (%s) to float: %s", op.Amount, e)
 	}
 
 	if isSell {
+		// the quote value of the op includes the estimated fee when the quote cap is fee-inclusive
+		sellPriceInclFee := sellPrice * (1 + lp.quoteFeeRate)
 		opToReturn := op
 		newAmountBeingSold := amountValueUnitsBeingSold
+		trimReason := DropReasonNone
 		var keepSellingBase bool
 		var keepSellingQuote bool
 		if lp.sellBaseAssetCapInBaseUnits != nil {
 			projectedSoldInBaseUnits := *dailyOTB.SellBaseAssetCapInBaseUnits + *dailyTBBAccumulator.SellBaseAssetCapInBaseUnits + amountValueUnitsBeingSold
 			keepSellingBase = projectedSoldInBaseUnits <= *lp.sellBaseAssetCapInBaseUnits
+			if !keepSellingBase && lp.onCapHit != nil {
+				lp.onCapHit("base", projectedSoldInBaseUnits, *lp.sellBaseAssetCapInBaseUnits)
+			}
 			newAmountString := ""
-			if lp.mode == volumeFilterModeExact && !keepSellingBase {
+			if lp.mode.trimsOps() && !keepSellingBase {
 				newAmount := *lp.sellBaseAssetCapInBaseUnits - *dailyOTB.SellBaseAssetCapInBaseUnits - *dailyTBBAccumulator.SellBaseAssetCapInBaseUnits
 				if newAmount > 0 {
 					newAmountBeingSold = newAmount
 					opToReturn.Amount = fmt.Sprintf("%.7f", newAmountBeingSold)
 					keepSellingBase = true
+					trimReason = DropReasonBaseCap
 					newAmountString = ", newAmountString = " + opToReturn.Amount
 				}
 			}
-			log.Printf("volumeFilter:  selling (base units), price=%.8f amount=%.8f, keep = (projectedSoldInBaseUnits) %.7f <= %.7f (config.SellBaseAssetCapInBaseUnits): keepSellingBase = %v%s", sellPrice, amountValueUnitsBeingSold, projectedSoldInBaseUnits, *lp.sellBaseAssetCapInBaseUnits, keepSellingBase, newAmountString)
+			log.Printf("volumeFilter:  selling (base units), price=%.8f amount=%.8f, keep = (projectedSoldInBaseUnits) %.7f <= %.7f (config.SellBaseAssetCapInBaseUnits, %s window): keepSellingBase = %v%s", sellPrice, amountValueUnitsBeingSold, projectedSoldInBaseUnits, *lp.sellBaseAssetCapInBaseUnits, capWindow(lp.sellBaseCapWindow), keepSellingBase, newAmountString)
 		} else {
 			keepSellingBase = true
 		}
 
 		if lp.sellBaseAssetCapInQuoteUnits != nil {
-			projectedSoldInQuoteUnits := *dailyOTB.SellBaseAssetCapInQuoteUnits + *dailyTBBAccumulator.SellBaseAssetCapInQuoteUnits + (newAmountBeingSold * sellPrice)
+			projectedSoldInQuoteUnits := *dailyOTB.SellBaseAssetCapInQuoteUnits + *dailyTBBAccumulator.SellBaseAssetCapInQuoteUnits + (newAmountBeingSold * sellPriceInclFee)
 			keepSellingQuote = projectedSoldInQuoteUnits <= *lp.sellBaseAssetCapInQuoteUnits
+			if !keepSellingQuote && lp.onCapHit != nil {
+				lp.onCapHit("quote", projectedSoldInQuoteUnits, *lp.sellBaseAssetCapInQuoteUnits)
+			}
 			newAmountString := ""
-			if lp.mode == volumeFilterModeExact && !keepSellingQuote {
-				newAmount := (*lp.sellBaseAssetCapInQuoteUnits - *dailyOTB.SellBaseAssetCapInQuoteUnits - *dailyTBBAccumulator.SellBaseAssetCapInQuoteUnits) / sellPrice
+			if lp.mode.trimsOps() && !keepSellingQuote {
+				newAmount := (*lp.sellBaseAssetCapInQuoteUnits - *dailyOTB.SellBaseAssetCapInQuoteUnits - *dailyTBBAccumulator.SellBaseAssetCapInQuoteUnits) / sellPriceInclFee
 				if newAmount > 0 {
 					newAmountBeingSold = newAmount
 					opToReturn.Amount = fmt.Sprintf("%.7f", newAmountBeingSold)
 					keepSellingQuote = true
+					trimReason = DropReasonQuoteCap
 					newAmountString = ", newAmountString = " + opToReturn.Amount
 				}
 			}
-			log.Printf("volumeFilter: selling (quote units), price=%.8f amount=%.8f, keep = (projectedSoldInQuoteUnits) %.7f <= %.7f (config.SellBaseAssetCapInQuoteUnits): keepSellingQuote = %v%s", sellPrice, amountValueUnitsBeingSold, projectedSoldInQuoteUnits, *lp.sellBaseAssetCapInQuoteUnits, keepSellingQuote, newAmountString)
+			log.Printf("volumeFilter: selling (quote units), price=%.8f amount=%.8f, keep = (projectedSoldInQuoteUnits) %.7f <= %.7f (config.SellBaseAssetCapInQuoteUnits, %s window): keepSellingQuote = %v%s", sellPrice, amountValueUnitsBeingSold, projectedSoldInQuoteUnits, *lp.sellBaseAssetCapInQuoteUnits, capWindow(lp.sellQuoteCapWindow), keepSellingQuote, newAmountString)
 		} else {
 			keepSellingQuote = true
 		}
 
-		if keepSellingBase && keepSellingQuote {
+		var keepSellingUSD bool
+		if lp.sellBaseAssetCapInUSD != nil {
+			projectedSoldInUSD := (*dailyOTB.SellBaseAssetCapInBaseUnits + *dailyTBBAccumulator.SellBaseAssetCapInBaseUnits + newAmountBeingSold) * lp.baseUSDPrice
+			keepSellingUSD = projectedSoldInUSD <= *lp.sellBaseAssetCapInUSD
+			if !keepSellingUSD && lp.onCapHit != nil {
+				lp.onCapHit("usd", projectedSoldInUSD, *lp.sellBaseAssetCapInUSD)
+			}
+			newAmountString := ""
+			if lp.mode.trimsOps() && !keepSellingUSD {
+				newAmount := *lp.sellBaseAssetCapInUSD/lp.baseUSDPrice - *dailyOTB.SellBaseAssetCapInBaseUnits - *dailyTBBAccumulator.SellBaseAssetCapInBaseUnits
+				if newAmount > 0 {
+					newAmountBeingSold = newAmount
+					opToReturn.Amount = fmt.Sprintf("%.7f", newAmountBeingSold)
+					keepSellingUSD = true
+					trimReason = DropReasonUSDCap
+					newAmountString = ", newAmountString = " + opToReturn.Amount
+				}
+			}
+			log.Printf("volumeFilter: selling (USD), price=%.8f amount=%.8f baseUSDPrice=%.8f, keep = (projectedSoldInUSD) %.7f <= %.7f (config.SellBaseAssetCapInUSD): keepSellingUSD = %v%s", sellPrice, amountValueUnitsBeingSold, lp.baseUSDPrice, projectedSoldInUSD, *lp.sellBaseAssetCapInUSD, keepSellingUSD, newAmountString)
+		} else {
+			keepSellingUSD = true
+		}
+
+		keepSellingAccount := true
+		var accountCapReason VolumeFilterDropReason
+		if keepSellingBase && keepSellingQuote && keepSellingUSD && lp.accountCap != nil {
+			var accountAmount float64
+			accountAmount, keepSellingAccount, accountCapReason = lp.accountCap.limit(newAmountBeingSold, sellPriceInclFee, lp.mode)
+			if keepSellingAccount && accountCapReason != DropReasonNone {
+				newAmountBeingSold = accountAmount
+				opToReturn.Amount = fmt.Sprintf("%.7f", newAmountBeingSold)
+				trimReason = accountCapReason
+			}
+		}
+
+		keepSellingBatch := true
+		if keepSellingBase && keepSellingQuote && keepSellingUSD && keepSellingAccount && lp.maxBatchVolumeInBaseUnits != nil {
+			batchSoldInBaseUnits := *dailyTBBAccumulator.SellBaseAssetCapInBaseUnits - lp.batchStartTbbBase
+			projectedBatchSoldInBaseUnits := batchSoldInBaseUnits + newAmountBeingSold
+			keepSellingBatch = projectedBatchSoldInBaseUnits <= *lp.maxBatchVolumeInBaseUnits
+			newAmountString := ""
+			if lp.mode.trimsOps() && !keepSellingBatch {
+				newAmount := *lp.maxBatchVolumeInBaseUnits - batchSoldInBaseUnits
+				if newAmount > 0 {
+					newAmountBeingSold = newAmount
+					opToReturn.Amount = fmt.Sprintf("%.7f", newAmountBeingSold)
+					keepSellingBatch = true
+					trimReason = DropReasonMaxBatchVolume
+					newAmountString = ", newAmountString = " + opToReturn.Amount
+				}
+			}
+			log.Printf("volumeFilter: selling (batch), price=%.8f amount=%.8f, keep = (projectedBatchSoldInBaseUnits) %.7f <= %.7f (config.MaxBatchVolume): keepSellingBatch = %v%s", sellPrice, amountValueUnitsBeingSold, projectedBatchSoldInBaseUnits, *lp.maxBatchVolumeInBaseUnits, keepSellingBatch, newAmountString)
+		}
+
+		if keepSellingBase && keepSellingQuote && keepSellingUSD && keepSellingAccount && keepSellingBatch && trimReason != DropReasonNone && lp.minSellBaseAmount != nil && newAmountBeingSold < *lp.minSellBaseAmount {
+			log.Printf("volumeFilter: selling, price=%.8f amount=%.8f, dropping op because the amount trimmed by the %s (%.7f) is less than the config.MinSellBaseAmount (%.7f)", sellPrice, amountValueUnitsBeingSold, trimReason, newAmountBeingSold, *lp.minSellBaseAmount)
+			return dropped(DropReasonMinSellBaseAmount), nil
+		}
+
+		if keepSellingBase && keepSellingQuote && keepSellingUSD && keepSellingAccount && keepSellingBatch {
 			// update the dailyTBB to include the additional amounts so they can be used in the calculation of the next operation
 			*dailyTBBAccumulator.SellBaseAssetCapInBaseUnits += newAmountBeingSold
-			*dailyTBBAccumulator.SellBaseAssetCapInQuoteUnits += (newAmountBeingSold * sellPrice)
-			return opToReturn, nil
+			*dailyTBBAccumulator.SellBaseAssetCapInQuoteUnits += (newAmountBeingSold * sellPriceInclFee)
+			if lp.accountCap != nil {
+				lp.accountCap.tbb.BaseVol += newAmountBeingSold
+				lp.accountCap.tbb.QuoteVol += (newAmountBeingSold * sellPriceInclFee)
+			}
+			return VolumeFilterResult{Op: opToReturn, DropReason: DropReasonNone, TrimReason: trimReason}, nil
+		}
+
+		// we don't want to keep it so return the dropped command with the first constraint that was hit
+		if !keepSellingBase {
+			return dropped(DropReasonBaseCap), nil
+		} else if !keepSellingQuote {
+			return dropped(DropReasonQuoteCap), nil
+		} else if !keepSellingUSD {
+			return dropped(DropReasonUSDCap), nil
+		} else if !keepSellingAccount {
+			return dropped(accountCapReason), nil
+		}
+		return dropped(DropReasonMaxBatchVolume), nil
+	}
+
+	if lp.buyBaseAssetCapInBaseUnits == nil && lp.buyBaseAssetCapInQuoteUnits == nil {
+		if lp.maxNetPositionInBaseUnits != nil {
+			// the buy op is only bounded by the net position, which is checked by the batch
+			return VolumeFilterResult{Op: op, DropReason: DropReasonNone}, nil
+		}
+		return dropped(DropReasonBuySide), nil
+	}
+
+	// a buy op sells the quote asset, so the amount is the quote units spent and the price is in base units per quote unit
+	opToReturn := op
+	newAmountBeingSpent := amountValueUnitsBeingSold
+	trimReason := DropReasonNone
+	var keepBuyingBase bool
+	var keepBuyingQuote bool
+	if lp.buyBaseAssetCapInBaseUnits != nil {
+		projectedBoughtInBaseUnits := *dailyOTB.BuyBaseAssetCapInBaseUnits + *dailyBuyTBBAccumulator.BuyBaseAssetCapInBaseUnits + (newAmountBeingSpent * sellPrice)
+		keepBuyingBase = projectedBoughtInBaseUnits <= *lp.buyBaseAssetCapInBaseUnits
+		newAmountString := ""
+		if lp.mode.trimsOps() && !keepBuyingBase {
+			newAmount := (*lp.buyBaseAssetCapInBaseUnits - *dailyOTB.BuyBaseAssetCapInBaseUnits - *dailyBuyTBBAccumulator.BuyBaseAssetCapInBaseUnits) / sellPrice
+			if newAmount > 0 {
+				newAmountBeingSpent = newAmount
+				opToReturn.Amount = fmt.Sprintf("%.7f", newAmountBeingSpent)
+				keepBuyingBase = true
+				trimReason = DropReasonBuyBaseCap
+				newAmountString = ", newAmountString = " + opToReturn.Amount
+			}
+		}
+		log.Printf("volumeFilter: buying (base units), price=%.8f amount=%.8f, keep = (projectedBoughtInBaseUnits) %.7f <= %.7f (config.BuyBaseAssetCapInBaseUnits): keepBuyingBase = %v%s", sellPrice, amountValueUnitsBeingSold, projectedBoughtInBaseUnits, *lp.buyBaseAssetCapInBaseUnits, keepBuyingBase, newAmountString)
+	} else {
+		keepBuyingBase = true
+	}
+
+	if lp.buyBaseAssetCapInQuoteUnits != nil {
+		projectedSpentInQuoteUnits := *dailyOTB.BuyBaseAssetCapInQuoteUnits + *dailyBuyTBBAccumulator.BuyBaseAssetCapInQuoteUnits + newAmountBeingSpent
+		keepBuyingQuote = projectedSpentInQuoteUnits <= *lp.buyBaseAssetCapInQuoteUnits
+		newAmountString := ""
+		if lp.mode.trimsOps() && !keepBuyingQuote {
+			newAmount := *lp.buyBaseAssetCapInQuoteUnits - *dailyOTB.BuyBaseAssetCapInQuoteUnits - *dailyBuyTBBAccumulator.BuyBaseAssetCapInQuoteUnits
+			if newAmount > 0 {
+				newAmountBeingSpent = newAmount
+				opToReturn.Amount = fmt.Sprintf("%.7f", newAmountBeingSpent)
+				keepBuyingQuote = true
+				trimReason = DropReasonBuyQuoteCap
+				newAmountString = ", newAmountString = " + opToReturn.Amount
+			}
 		}
+		log.Printf("volumeFilter: buying (quote units), price=%.8f amount=%.8f, keep = (projectedSpentInQuoteUnits) %.7f <= %.7f (config.BuyBaseAssetCapInQuoteUnits): keepBuyingQuote = %v%s", sellPrice, amountValueUnitsBeingSold, projectedSpentInQuoteUnits, *lp.buyBaseAssetCapInQuoteUnits, keepBuyingQuote, newAmountString)
 	} else {
-		// TODO buying side
+		keepBuyingQuote = true
+	}
+
+	if !keepBuyingBase {
+		return dropped(DropReasonBuyBaseCap), nil
+	} else if !keepBuyingQuote {
+		return dropped(DropReasonBuyQuoteCap), nil
+	}
+	// update the buy accumulator, which is separate from the sell accumulator, so they can be used in the calculation of the next operation
+	*dailyBuyTBBAccumulator.BuyBaseAssetCapInBaseUnits += (newAmountBeingSpent * sellPrice)
+	*dailyBuyTBBAccumulator.BuyBaseAssetCapInQuoteUnits += newAmountBeingSpent
+	return VolumeFilterResult{Op: opToReturn, DropReason: DropReasonNone, TrimReason: trimReason}, nil
+}
+
+// applyDecisionHook calls the hook with the proposed result and corrects the TBB accumulator of the side of the op by the difference in the
+// amount sold or bought
+func applyDecisionHook(
+	hook DecisionHook,
+	original *txnbuild.ManageSellOffer,
+	proposed VolumeFilterResult,
+	dailyOTB *VolumeFilterConfig,
+	dailyTBBAccumulator *VolumeFilterConfig,
+	dailyBuyTBBAccumulator *VolumeFilterConfig,
+	baseAsset hProtocol.Asset,
+	quoteAsset hProtocol.Asset,
+	lp limitParameters,
+) (VolumeFilterResult, error) {
+	opCopy := *original
+	overridden := hook(&opCopy, &proposed)
+	if overridden == nil {
+		return proposed, nil
+	}
+
+	result := *overridden
+	if result.Op == nil && result.DropReason == DropReasonNone {
+		result.DropReason = DropReasonDecisionHook
+	} else if result.Op != nil {
+		result.DropReason = DropReasonNone
+		if proposed.Op == nil || result.Op.Amount != proposed.Op.Amount {
+			result.TrimReason = DropReasonDecisionHook
+		}
+	}
+
+	isSell, e := utils.IsSelling(baseAsset, quoteAsset, original.Selling, original.Buying)
+	if e != nil {
+		return VolumeFilterResult{}, fmt.Errorf("error when running the isSelling check for offer '%+v': %s", *original, e)
+	}
+
+	proposedAmount, _, e := amountAndPrice(proposed.Op)
+	if e != nil {
+		return VolumeFilterResult{}, fmt.Errorf("could not parse proposed op: %s", e)
+	}
+	newAmount, newPrice, e := amountAndPrice(result.Op)
+	if e != nil {
+		return VolumeFilterResult{}, fmt.Errorf("could not parse op returned by decision hook: %s", e)
+	}
+	proposedPrice := newPrice
+	if proposed.Op != nil {
+		_, proposedPrice, _ = amountAndPrice(proposed.Op)
+	}
+
+	if !isSell {
+		// the amount of a buy op is the quote units spent and the price is in base units per quote unit, the buy accumulator is only
+		// populated when there is a buy cap
+		if dailyBuyTBBAccumulator != nil && dailyBuyTBBAccumulator.BuyBaseAssetCapInBaseUnits != nil {
+			*dailyBuyTBBAccumulator.BuyBaseAssetCapInBaseUnits += newAmount*newPrice - proposedAmount*proposedPrice
+			*dailyBuyTBBAccumulator.BuyBaseAssetCapInQuoteUnits += newAmount - proposedAmount
+		}
+		return result, nil
+	}
+
+	priceMultiplier := 1 + lp.quoteFeeRate
+	*dailyTBBAccumulator.SellBaseAssetCapInBaseUnits += newAmount - proposedAmount
+	*dailyTBBAccumulator.SellBaseAssetCapInQuoteUnits += (newAmount*newPrice - proposedAmount*proposedPrice) * priceMultiplier
+	if lp.accountCap != nil {
+		lp.accountCap.tbb.BaseVol += newAmount - proposedAmount
+		lp.accountCap.tbb.QuoteVol += (newAmount*newPrice - proposedAmount*proposedPrice) * priceMultiplier
+	}
+
+	if newAmount > proposedAmount {
+		projectedBase := *dailyOTB.SellBaseAssetCapInBaseUnits + *dailyTBBAccumulator.SellBaseAssetCapInBaseUnits
+		projectedQuote := *dailyOTB.SellBaseAssetCapInQuoteUnits + *dailyTBBAccumulator.SellBaseAssetCapInQuoteUnits
+		if (lp.sellBaseAssetCapInBaseUnits != nil && projectedBase > *lp.sellBaseAssetCapInBaseUnits) ||
+			(lp.sellBaseAssetCapInQuoteUnits != nil && projectedQuote > *lp.sellBaseAssetCapInQuoteUnits) ||
+			(lp.sellBaseAssetCapInUSD != nil && projectedBase*lp.baseUSDPrice > *lp.sellBaseAssetCapInUSD) {
+			log.Printf("warning: volumeFilter: decision hook increased the amount from %.7f to %.7f which breaches the cap, projectedSoldInBaseUnits = %.7f (cap = %s), projectedSoldInQuoteUnits = %.7f (cap = %s)\n",
+				proposedAmount, newAmount, projectedBase, utils.CheckedFloatPtr(lp.sellBaseAssetCapInBaseUnits), projectedQuote, utils.CheckedFloatPtr(lp.sellBaseAssetCapInQuoteUnits))
+		}
+	}
+	return result, nil
+}
+
+// amountAndPrice parses the amount and price of the op, where a nil op has a zero amount and price
+func amountAndPrice(op *txnbuild.ManageSellOffer) (float64, float64, error) {
+	if op == nil {
+		return 0, 0, nil
+	}
+
+	amount, e := strconv.ParseFloat(op.Amount, 64)
+	if e != nil {
+		return 0, 0, fmt.Errorf("could not convert amount (%s) to float: %s", op.Amount, e)
+	}
+	price, e := strconv.ParseFloat(op.Price, 64)
+	if e != nil {
+		return 0, 0, fmt.Errorf("could not convert price (%s) to float: %s", op.Price, e)
+	}
+	return amount, price, nil
+}
+
+// queryDailyVolume runs the daily volume query for the passed in date, or for the window ending at now when there is a WindowDuration
+func (f *volumeFilter) queryDailyVolume(ctx context.Context, now time.Time, dateString string) (*queries.DailyVolume, error) {
+	if f.config.RollingTradeCount > 0 {
+		// the volume of the recent trades does not depend on the date
+		queryResult, e := f.queryRow(ctx, f.dailyVolumeByDateQuery, dateString)
+		if e != nil {
+			return nil, fmt.Errorf("could not load the volume of the %d most recent trades: %s", f.config.RollingTradeCount, e)
+		}
+		return toDailyVolume(queryResult)
+	}
+	if f.config.WindowDuration > 0 {
+		queryResult, e := f.queryRow(ctx, f.dailyVolumeByDateQuery, dateString, now.Add(-f.config.WindowDuration), now)
+		if e != nil {
+			return nil, fmt.Errorf("could not load the volume of the %s window ending at %s: %s", f.config.WindowDuration, now.Format(time.RFC3339), e)
+		}
+		dailyVolume, e := toDailyVolume(queryResult)
+		if e != nil {
+			return nil, e
+		}
+		return f.addFlippedVolume(ctx, f.flippedDailyVolumeByDateQuery, dailyVolume, dateString, now.Add(-f.config.WindowDuration), now)
+	}
+
+	queryResult, e := f.queryRow(ctx, f.dailyVolumeByDateQuery, dateString, dateString)
+	if e != nil {
+		return nil, fmt.Errorf("could not load dailyValuesByDate for date (%s): %s", dateString, e)
+	}
+	dailyVolume, e := toDailyVolume(queryResult)
+	if e != nil {
+		return nil, e
+	}
+	return f.addFlippedVolume(ctx, f.flippedDailyVolumeByDateQuery, dailyVolume, dateString, dateString)
+}
+
+// addFlippedVolume adds the volume of the flipped market fetched with the query and args to the volume, where the base and quote volumes of
+// the flipped market are swapped to be denominated in the assets of this filter. Returns the volume as-is when the query is nil.
+func (f *volumeFilter) addFlippedVolume(ctx context.Context, flippedQuery api.Query, volume *queries.DailyVolume, dateString string, args ...interface{}) (*queries.DailyVolume, error) {
+	if flippedQuery == nil {
+		return volume, nil
+	}
+
+	queryResult, e := f.queryRow(ctx, flippedQuery, dateString, args...)
+	if e != nil {
+		return nil, fmt.Errorf("could not load the volume of the flipped market for date (%s): %s", dateString, e)
+	}
+	flippedVolume, e := toDailyVolume(queryResult)
+	if e != nil {
+		return nil, e
+	}
+	log.Printf("volumeFilter: volume on the flipped market for date (%s): baseVolume = %.8f %s, quoteVolume = %.8f %s\n",
+		dateString, flippedVolume.BaseVol, utils.Asset2String(f.quoteAsset), flippedVolume.QuoteVol, utils.Asset2String(f.baseAsset))
+	// make a new value since the volume can be the cached result of a query
+	return &queries.DailyVolume{
+		BaseVol:  volume.BaseVol + flippedVolume.QuoteVol,
+		QuoteVol: volume.QuoteVol + flippedVolume.BaseVol,
+	}, nil
+}
+
+// queryDailyBuyVolume fetches the volume bought on the date (or in the window ending at now), which is zero when there is no buy cap since
+// the volume bought is not needed
+func (f *volumeFilter) queryDailyBuyVolume(ctx context.Context, now time.Time, dateString string) (*queries.DailyVolume, error) {
+	if f.dailyBuyVolumeByDateQuery == nil {
+		return &queries.DailyVolume{BaseVol: 0.0, QuoteVol: 0.0}, nil
+	}
+
+	args := []interface{}{dateString}
+	if f.config.RollingTradeCount > 0 {
+		args = []interface{}{}
+	} else if f.config.WindowDuration > 0 {
+		args = []interface{}{now.Add(-f.config.WindowDuration), now}
+	}
+	queryResult, e := f.queryRow(ctx, f.dailyBuyVolumeByDateQuery, dateString, args...)
+	if e != nil {
+		return nil, fmt.Errorf("could not load the daily buy volume for date (%s): %s", dateString, e)
+	}
+	dailyVolume, e := toDailyVolume(queryResult)
+	if e != nil {
+		return nil, e
+	}
+	return f.addFlippedVolume(ctx, f.flippedDailyBuyVolumeByDateQuery, dailyVolume, dateString, args...)
+}
+
+// queryPeriodVolumes fetches the volume sold in the window of each of the f.periodCaps containing the date, in the same order
+func (f *volumeFilter) queryPeriodVolumes(ctx context.Context, dateString string) ([]*queries.DailyVolume, error) {
+	periodValues := []*queries.DailyVolume{}
+	for _, pc := range f.periodCaps {
+		queryResult, e := f.queryRow(ctx, pc.query, dateString, dateString)
+		if e != nil {
+			return nil, fmt.Errorf("could not load the %s volume for date (%s): %s", pc.window, dateString, e)
+		}
+		periodVolume, e := toDailyVolume(queryResult)
+		if e != nil {
+			return nil, e
+		}
+		periodVolume, e = f.addFlippedVolume(ctx, pc.flippedQuery, periodVolume, dateString, dateString)
+		if e != nil {
+			return nil, fmt.Errorf("could not load the %s volume for date (%s): %s", pc.window, dateString, e)
+		}
+		log.Printf("volumeFilter: %s volume containing date (%s): baseSoldUnits = %.8f %s, quoteCostUnits = %.8f %s\n",
+			pc.window, dateString, periodVolume.BaseVol, utils.Asset2String(f.baseAsset), periodVolume.QuoteVol, utils.Asset2String(f.quoteAsset))
+		periodValues = append(periodValues, periodVolume)
+	}
+	return periodValues, nil
+}
+
+// fetchBaseUSDPrice fetches the price of the base asset in USD from the PriceFeed, nil when there is no SellBaseAssetCapInUSD
+func (f *volumeFilter) fetchBaseUSDPrice() (*float64, error) {
+	if f.config.SellBaseAssetCapInUSD == nil {
+		return nil, nil
+	}
+
+	price, e := f.config.PriceFeed.GetPrice()
+	if e != nil {
+		return nil, fmt.Errorf("could not fetch the USD price of the base asset from the PriceFeed: %s", e)
+	}
+	if price <= 0 {
+		return nil, fmt.Errorf("the USD price of the base asset from the PriceFeed needs to be positive but was %f", price)
+	}
+	log.Printf("volumeFilter: USD price of the base asset (%s) = %.8f\n", utils.Asset2String(f.baseAsset), price)
+	return &price, nil
+}
+
+// queryAccountVolumes fetches the volume sold on the date by each of the accounts of the AccountCaps, nil when there are no AccountCaps
+func (f *volumeFilter) queryAccountVolumes(ctx context.Context, dateString string) (map[string]*queries.DailyVolume, error) {
+	if f.dailyVolumeByAccountQuery == nil {
+		return nil, nil
+	}
+
+	queryResult, e := f.queryRow(ctx, f.dailyVolumeByAccountQuery, dateString, dateString)
+	if e != nil {
+		return nil, fmt.Errorf("could not load the daily volume by account for date (%s): %s", dateString, e)
+	}
+	accountVolumes, ok := queryResult.(map[string]*queries.DailyVolume)
+	if !ok {
+		return nil, fmt.Errorf("incorrect type returned from DailyVolumeByAccount query, expecting 'map[string]*queries.DailyVolume' but was '%T'", queryResult)
+	}
+	for accountID, volume := range accountVolumes {
+		log.Printf("volumeFilter: daily volume of account '%s' on date (%s): baseSoldUnits = %.8f %s, quoteCostUnits = %.8f %s\n",
+			accountID, dateString, volume.BaseVol, utils.Asset2String(f.baseAsset), volume.QuoteVol, utils.Asset2String(f.quoteAsset))
+	}
+	return accountVolumes, nil
+}
+
+// queryContext makes the context with the deadline of the QueryTimeout that bounds the volume queries of a single batch
+func (f *volumeFilter) queryContext() (context.Context, context.CancelFunc) {
+	timeout := f.config.QueryTimeout
+	if timeout == 0 {
+		timeout = defaultVolumeQueryTimeout
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// queryRow runs the query with the args until the ctx is done, reusing the last result of the query for the same date when the query was
+// executed within the MinQueryInterval or when it is in the SharedQueryCache. Queries that do not support a context are only run when the
+// ctx is not yet done.
+func (f *volumeFilter) queryRow(ctx context.Context, q api.Query, dateString string, args ...interface{}) (interface{}, error) {
+	now := f.now()
+	if f.queryCache != nil {
+		cached, ok := f.queryCache.get(q)
+		if ok && cached.dateString == dateString && now.Sub(cached.queriedAt) < f.config.MinQueryInterval {
+			log.Printf("volumeFilter: reusing the result of the %s query executed at %s, which is within the MinQueryInterval (%s)\n",
+				q.Name(), cached.queriedAt.Format(time.RFC3339), f.config.MinQueryInterval)
+			return cached.result, nil
+		}
+	}
+
+	sharedCacheKey, isShared := f.sharedCacheKeys[q]
+	sharedCacheKey = sharedCacheKey + "|date=" + dateString
+	if isShared {
+		if result, ok := f.config.SharedQueryCache.get(sharedCacheKey, now); ok {
+			log.Printf("volumeFilter: reusing the result of the %s query from the shared query cache\n", q.Name())
+			return result, nil
+		}
+	}
+
+	if e := ctx.Err(); e != nil {
+		return nil, fmt.Errorf("could not run the %s query since the volume queries exceeded the QueryTimeout: %s", q.Name(), e)
+	}
+	queryResult, e := queryRowContext(ctx, q, args...)
+	if e != nil {
+		return nil, e
+	}
+	if dailyVolume, ok := queryResult.(*queries.DailyVolume); isShared && ok {
+		f.config.SharedQueryCache.put(sharedCacheKey, dailyVolume, now)
+	}
+	if f.queryCache != nil {
+		f.queryCache.put(q, &cachedQueryResult{
+			dateString: dateString,
+			result:     queryResult,
+			queriedAt:  now,
+		})
+	}
+	return queryResult, nil
+}
+
+// queryRowContext runs the query with the args until the ctx is done when the query supports a context, and otherwise runs it without the ctx
+func queryRowContext(ctx context.Context, q api.Query, args ...interface{}) (interface{}, error) {
+	if cq, ok := q.(api.ContextQuery); ok {
+		return cq.QueryRowContext(ctx, args...)
+	}
+	return q.QueryRow(args...)
+}
+
+// toDailyVolume converts the result of the daily volume query
+func toDailyVolume(queryResult interface{}) (*queries.DailyVolume, error) {
+	dailyVolume, ok := queryResult.(*queries.DailyVolume)
+	if !ok {
+		return nil, fmt.Errorf("incorrect type returned from DailyVolumeByDate query, expecting '*queries.DailyVolume' but was '%T'", queryResult)
+	}
+	return dailyVolume, nil
+}
+
+// RemainingCapForDate returns the remaining caps on the passed in date (in the postgresdb.DateFormatString format), computed from the volume
+// sold on that date and the configured caps, which is useful for post-mortems on past dates. A remaining cap is nil when the corresponding
+// cap is not configured, and is zero when the volume sold exceeded the cap. This does not take the CapSchedule into account.
+func (f *volumeFilter) RemainingCapForDate(date string) (baseRemaining *float64, quoteRemaining *float64, err error) {
+	if f.config.RollingTradeCount > 0 {
+		return nil, nil, fmt.Errorf("cannot compute the remaining cap for a date when using RollingTradeCount (%d)", f.config.RollingTradeCount)
+	}
+	if f.config.WindowDuration > 0 {
+		return nil, nil, fmt.Errorf("cannot compute the remaining cap for a date when using WindowDuration (%s)", f.config.WindowDuration)
+	}
+	if _, e := time.Parse(postgresdb.DateFormatString, date); e != nil {
+		return nil, nil, fmt.Errorf("invalid date '%s', expected format '%s': %s", date, postgresdb.DateFormatString, e)
+	}
+
+	ctx, cancel := f.queryContext()
+	defer cancel()
+	dailyVolume, e := f.queryDailyVolume(ctx, time.Time{}, date)
+	if e != nil {
+		return nil, nil, fmt.Errorf("could not query daily volume: %s", e)
+	}
+
+	remaining := func(cap *float64, sold float64) *float64 {
+		if cap == nil {
+			return nil
+		}
+		r := math.Max(*cap-sold, 0)
+		return &r
+	}
+	return remaining(f.config.SellBaseAssetCapInBaseUnits, dailyVolume.BaseVol), remaining(f.config.SellBaseAssetCapInQuoteUnits, dailyVolume.QuoteVol), nil
+}
+
+// CurrentDailyVolume returns the volume sold today that Apply checks the caps against, i.e. the pre-fetched volume when the background
+// refresh has one for today, otherwise the result of the same query that Apply runs (for the window ending now when using WindowDuration,
+// or the most recent trades when using RollingTradeCount). It does not update the state of the query circuit breaker.
+func (f *volumeFilter) CurrentDailyVolume() (*queries.DailyVolume, error) {
+	ctx, cancel := f.queryContext()
+	defer cancel()
+	_, dailyVolume, e := f.loadDailyVolume(ctx, f.now(), false)
+	if e != nil {
+		return nil, fmt.Errorf("could not query daily volume: %s", e)
+	}
+	// return a copy so the caller cannot modify the pre-fetched or cached volume
+	result := *dailyVolume
+	return &result, nil
+}
+
+// StartBackgroundRefresh starts a goroutine that fetches the daily volume right away and then once every interval, so Apply can use the
+// pre-fetched value instead of blocking on the query. The pre-fetched value can be stale by up to one interval (longer if refreshes fail),
+// so any volume traded since the last refresh is not counted toward the caps. A pre-fetched value is only used on the date that it was
+// fetched for, Apply falls back to querying synchronously after the date rolls over until the next refresh completes.
+// StartBackgroundRefresh and Stop should be called from the same goroutine that calls Apply.
+func (f *volumeFilter) StartBackgroundRefresh(interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("the refresh interval needs to be positive but was %s", interval)
+	}
+	if f.refresher != nil {
+		return fmt.Errorf("background refresh was already started")
+	}
+
+	f.refresher = &dailyVolumeRefresher{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go f.runBackgroundRefresh(f.refresher, interval)
+	log.Printf("volumeFilter: started background refresh of the daily volume with interval %s\n", interval)
+	return nil
+}
+
+// Stop stops the background refresh and waits for it to exit, it is a no-op if the background refresh was not started
+func (f *volumeFilter) Stop() {
+	if f.refresher == nil {
+		return
+	}
+
+	close(f.refresher.stop)
+	<-f.refresher.done
+	f.refresher = nil
+	log.Printf("volumeFilter: stopped background refresh of the daily volume\n")
+}
+
+func (f *volumeFilter) runBackgroundRefresh(r *dailyVolumeRefresher, interval time.Duration) {
+	defer close(r.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		f.refreshDailyVolume(r)
+
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refreshDailyVolume fetches the daily volume and saves it as the pre-fetched value, failures are logged and keep the previous value
+func (f *volumeFilter) refreshDailyVolume(r *dailyVolumeRefresher) {
+	ctx, cancel := f.queryContext()
+	defer cancel()
+	now := f.now()
+	dateString, e := f.currentDateString(ctx, now)
+	if e != nil {
+		log.Printf("volumeFilter: background refresh of the daily volume failed, keeping the previous value: %s\n", e)
+		return
+	}
+	dailyVolume, e := f.queryDailyVolume(ctx, now, dateString)
+	if e != nil {
+		log.Printf("volumeFilter: background refresh of the daily volume failed, keeping the previous value: %s\n", e)
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.prefetched = dailyVolume
+	r.prefetchedDate = dateString
+	r.prefetchedAt = now
+}
+
+// prefetchedDailyVolume returns the pre-fetched daily volume if the background refresh is running and it was fetched for the passed in date,
+// and is not older than the MaxStaleBeforeBlock at the passed in time
+func (f *volumeFilter) prefetchedDailyVolume(now time.Time, dateString string) (*queries.DailyVolume, bool) {
+	if f.refresher == nil {
+		return nil, false
+	}
+
+	f.refresher.mutex.Lock()
+	defer f.refresher.mutex.Unlock()
+	if f.refresher.prefetched == nil || f.refresher.prefetchedDate != dateString {
+		return nil, false
+	}
+	if age := now.Sub(f.refresher.prefetchedAt); f.config.MaxStaleBeforeBlock > 0 && age > f.config.MaxStaleBeforeBlock {
+		log.Printf("volumeFilter: the pre-fetched daily volume is %s old which exceeds the MaxStaleBeforeBlock of %s, querying synchronously\n", age, f.config.MaxStaleBeforeBlock)
+		return nil, false
+	}
+	return f.refresher.prefetched, true
+}
+
+// handleQueryFailure updates the state of the circuit breaker after a failed query and applies the failure policy if the breaker is open, or
+// applies the OnQueryErrorBehavior when there is no circuit breaker
+func (f *volumeFilter) handleQueryFailure(ops []txnbuild.Operation, queryErr error) ([]txnbuild.Operation, error) {
+	breaker := f.config.QueryCircuitBreaker
+	if breaker == nil {
+		return f.applyQueryErrorBehavior(ops, queryErr)
+	}
+
+	f.consecutiveQueryFailures++
+	if !f.breakerOpen && f.consecutiveQueryFailures >= breaker.FailureThreshold {
+		f.breakerOpen = true
+		log.Printf("volumeFilter: opened query circuit breaker after %d consecutive query failures (%s)\n", f.consecutiveQueryFailures, breaker)
+	}
+
+	if !f.breakerOpen {
+		return nil, queryErr
+	}
+
+	if breaker.FailOpen {
+		log.Printf("volumeFilter: query circuit breaker is open (consecutiveQueryFailures = %d), failing open and passing through all %d ops: %s\n", f.consecutiveQueryFailures, len(ops), queryErr)
+		return ops, nil
+	}
+	return nil, fmt.Errorf("query circuit breaker is open (consecutiveQueryFailures = %d), failing closed: %s", f.consecutiveQueryFailures, queryErr)
+}
+
+// applyQueryErrorBehavior returns the ops to submit after a failed query according to the OnQueryErrorBehavior
+func (f *volumeFilter) applyQueryErrorBehavior(ops []txnbuild.Operation, queryErr error) ([]txnbuild.Operation, error) {
+	switch f.config.OnQueryErrorBehavior {
+	case QueryErrorBehaviorAllowAll:
+		log.Printf("volumeFilter: volume query failed, allowing all %d ops through (OnQueryErrorBehavior = %s): %s\n", len(ops), f.config.OnQueryErrorBehavior, queryErr)
+		return ops, nil
+	case QueryErrorBehaviorBlockAll:
+		deleteOps := []txnbuild.Operation{}
+		for _, op := range ops {
+			if isDeleteOp(op) {
+				deleteOps = append(deleteOps, op)
+			}
+		}
+		log.Printf("volumeFilter: volume query failed, blocking all ops except for %d of the %d ops that delete offers (OnQueryErrorBehavior = %s): %s\n",
+			len(deleteOps), len(ops), f.config.OnQueryErrorBehavior, queryErr)
+		return deleteOps, nil
+	default:
+		return nil, queryErr
+	}
+}
+
+// isDeleteOp returns true if the op deletes an existing offer
+func isDeleteOp(op txnbuild.Operation) bool {
+	mso, ok := op.(*txnbuild.ManageSellOffer)
+	return ok && mso.OfferID != 0 && mso.Amount == "0"
+}
+
+// handleQuerySuccess resets the circuit breaker after a successful query
+func (f *volumeFilter) handleQuerySuccess() {
+	if f.breakerOpen {
+		log.Printf("volumeFilter: closed query circuit breaker after a successful query, there were %d consecutive query failures\n", f.consecutiveQueryFailures)
+	}
+	f.consecutiveQueryFailures = 0
+	f.breakerOpen = false
+}
+
+// logMonitoredVolume logs the volume that would be on the books if all the sell ops were to be executed, without modifying any ops
+func (f *volumeFilter) logMonitoredVolume(dailyValuesBaseSold *queries.DailyVolume, ops []txnbuild.Operation) error {
+	tbbSellBase := 0.0
+	tbbSellQuote := 0.0
+	for _, op := range ops {
+		mso, ok := op.(*txnbuild.ManageSellOffer)
+		if !ok {
+			continue
+		}
+
+		isSell, e := utils.IsSelling(f.baseAsset, f.quoteAsset, mso.Selling, mso.Buying)
+		if e != nil {
+			return fmt.Errorf("error when running the isSelling check for offer '%+v': %s", *mso, e)
+		}
+		if !isSell {
+			continue
+		}
+
+		sellPrice, e := strconv.ParseFloat(mso.Price, 64)
+		if e != nil {
+			return fmt.Errorf("could not convert price (%s) to float: %s", mso.Price, e)
+		}
+		amount, e := strconv.ParseFloat(mso.Amount, 64)
+		if e != nil {
+			return fmt.Errorf("could not convert amount (%s) to float: %s", mso.Amount, e)
+		}
+		tbbSellBase += amount
+		tbbSellQuote += amount * sellPrice
 	}
 
-	// we don't want to keep it so return the dropped command
-	return nil, nil
+	log.Printf("volumeFilter: monitor only, passing through all %d ops; projected baseSoldUnits = %.8f %s, projected quoteCostUnits = %.8f %s\n",
+		len(ops), dailyValuesBaseSold.BaseVol+tbbSellBase, utils.Asset2String(f.baseAsset), dailyValuesBaseSold.QuoteVol+tbbSellQuote, utils.Asset2String(f.quoteAsset))
+	return nil
 }
 
 // String is the Stringer method
@@ -236,7 +3083,7 @@ func (f *volumeFilter) String() string {
 
 // isBase returns true if the filter is on the amount of the base asset sold, false otherwise
 func (f *volumeFilter) isSellingBase() bool {
-	return strings.Contains(f.configValue, "/sell/base/")
+	return f.config != nil && f.config.action == volumeFilterActionSell && f.config.units == volumeFilterUnitsBase
 }
 
 func (f *volumeFilter) mustGetBaseAssetCapInBaseUnits() (float64, error) {
@@ -254,11 +3101,37 @@ func (c *VolumeFilterConfig) isEmpty() bool {
 	if c.SellBaseAssetCapInQuoteUnits != nil {
 		return false
 	}
-	// if buyBaseAssetCapInBaseUnits != nil {
-	// 	return false
-	// }
-	// if buyBaseAssetCapInQuoteUnits != nil {
-	// 	return false
-	// }
+	if c.SellBaseAssetCapInUSD != nil {
+		return false
+	}
+	if len(c.CapSchedule) > 0 {
+		return false
+	}
+	if c.MaxBatchVolume != nil {
+		return false
+	}
+	if c.hasBuyCap() {
+		return false
+	}
+	if c.hasPeriodCap() {
+		return false
+	}
+	if c.MaxNetPositionInBaseUnits != nil {
+		return false
+	}
+	if len(c.AccountCaps) > 0 {
+		return false
+	}
 	return true
 }
+
+// hasBuyCap returns true when the buy side is capped in either the base or the quote units
+func (c *VolumeFilterConfig) hasBuyCap() bool {
+	return c.BuyBaseAssetCapInBaseUnits != nil || c.BuyBaseAssetCapInQuoteUnits != nil
+}
+
+// hasPeriodCap returns true when the volume sold is capped over the weekly or the monthly window
+func (c *VolumeFilterConfig) hasPeriodCap() bool {
+	return c.SellBaseAssetCapInBaseUnitsWeekly != nil || c.SellBaseAssetCapInQuoteUnitsWeekly != nil ||
+		c.SellBaseAssetCapInBaseUnitsMonthly != nil || c.SellBaseAssetCapInQuoteUnitsMonthly != nil
+}
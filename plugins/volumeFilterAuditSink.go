@@ -0,0 +1,64 @@
+package plugins
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// AuditRecord is the record of a single op that the volume filter dropped or trimmed
+type AuditRecord struct {
+	Timestamp time.Time `json:"timestamp"`
+	MarketID  string    `json:"market_id"`
+	// Date is the date of the daily volume that the op was checked against
+	Date    string               `json:"date"`
+	OfferID int64                `json:"offer_id"`
+	Side    string               `json:"side"`
+	Price   string               `json:"price"`
+	Action  VolumeFilterOpAction `json:"action"`
+	// Constraint is the constraint that dropped or trimmed the op
+	Constraint     VolumeFilterDropReason `json:"constraint"`
+	OriginalAmount float64                `json:"original_amount"`
+	// FinalAmount is the amount of the op after it was trimmed, zero when the op was dropped
+	FinalAmount float64 `json:"final_amount"`
+}
+
+// AuditSink receives the decisions of the volume filter so they can be retained, which is separate from the logs and metrics
+type AuditSink interface {
+	WriteDecision(record AuditRecord) error
+}
+
+// jsonLinesAuditSink appends every record as a line of JSON to the writer
+type jsonLinesAuditSink struct {
+	mutex  sync.Mutex
+	writer io.Writer
+}
+
+var _ AuditSink = &jsonLinesAuditSink{}
+
+// MakeJSONLinesAuditSink makes an AuditSink that appends every record as a line of JSON to the passed in writer, e.g. a file opened with
+// os.O_APPEND. It is safe to share between filters.
+func MakeJSONLinesAuditSink(writer io.Writer) AuditSink {
+	return &jsonLinesAuditSink{
+		writer: writer,
+	}
+}
+
+// WriteDecision impl.
+func (s *jsonLinesAuditSink) WriteDecision(record AuditRecord) error {
+	data, e := json.Marshal(record)
+	if e != nil {
+		return fmt.Errorf("could not marshal audit record: %s", e)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	// write the line in a single call so concurrent writers to the same file do not interleave the records
+	_, e = s.writer.Write(append(data, '\n'))
+	if e != nil {
+		return fmt.Errorf("could not write audit record: %s", e)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package plugins
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/openlyinc/pointy"
+	hProtocol "github.com/stellar/go/protocols/horizon"
+	"github.com/stellar/go/txnbuild"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/stellar/kelp/queries"
+)
+
+type failingWriter struct{}
+
+func (w failingWriter) Write(p []byte) (int, error) {
+	return 0, fmt.Errorf("disk full")
+}
+
+func readAuditRecords(t *testing.T, buf *bytes.Buffer) []AuditRecord {
+	records := []AuditRecord{}
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		var record AuditRecord
+		if !assert.NoError(t, json.Unmarshal(scanner.Bytes(), &record)) {
+			return nil
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestJSONLinesAuditSink(t *testing.T) {
+	buf := &bytes.Buffer{}
+	sink := MakeJSONLinesAuditSink(buf)
+	record := AuditRecord{
+		Timestamp:      time.Date(2020, 1, 21, 12, 0, 0, 0, time.UTC),
+		MarketID:       "market1",
+		Date:           "2020/01/21",
+		OfferID:        5,
+		Side:           "sell",
+		Price:          "2.0",
+		Action:         VolumeFilterOpActionTrimmed,
+		Constraint:     DropReasonBaseCap,
+		OriginalAmount: 40.0,
+		FinalAmount:    30.0,
+	}
+	if !assert.NoError(t, sink.WriteDecision(record)) {
+		return
+	}
+	assert.Equal(t, `{"timestamp":"2020-01-21T12:00:00Z","market_id":"market1","date":"2020/01/21","offer_id":5,"side":"sell","price":"2.0","action":"trimmed","constraint":"base-cap","original_amount":40,"final_amount":30}`+"\n", buf.String())
+
+	assert.Error(t, MakeJSONLinesAuditSink(failingWriter{}).WriteDecision(record))
+}
+
+func TestVolumeFilterApplyAuditSink(t *testing.T) {
+	now := time.Date(2020, 1, 21, 12, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name        string
+		capInBase   *float64
+		capInQuote  *float64
+		ops         []txnbuild.Operation
+		wantRecords []AuditRecord
+	}{
+		{
+			name:      "base cap",
+			capInBase: pointy.Float64(100.0),
+			ops: []txnbuild.Operation{
+				makeManageSellOffer("2.0", "50.0"),
+				makeManageSellOffer("2.0", "40.0"),
+				makeManageSellOffer("2.0", "10.0"),
+			},
+			wantRecords: []AuditRecord{
+				{Action: VolumeFilterOpActionTrimmed, Constraint: DropReasonBaseCap, OriginalAmount: 40.0, FinalAmount: 30.0},
+				{Action: VolumeFilterOpActionDropped, Constraint: DropReasonBaseCap, OriginalAmount: 10.0},
+			},
+		}, {
+			name:       "quote cap",
+			capInQuote: pointy.Float64(70.0),
+			ops: []txnbuild.Operation{
+				makeManageSellOffer("2.0", "40.0"),
+				makeManageSellOffer("2.0", "40.0"),
+			},
+			wantRecords: []AuditRecord{
+				{Action: VolumeFilterOpActionTrimmed, Constraint: DropReasonQuoteCap, OriginalAmount: 40.0, FinalAmount: 30.0},
+				{Action: VolumeFilterOpActionDropped, Constraint: DropReasonQuoteCap, OriginalAmount: 40.0},
+			},
+		}, {
+			name:      "no decisions",
+			capInBase: pointy.Float64(1000.0),
+			ops: []txnbuild.Operation{
+				makeManageSellOffer("2.0", "50.0"),
+			},
+			wantRecords: []AuditRecord{},
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			config := makeRawVolumeFilterConfig(k.capInBase, k.capInQuote, volumeFilterModeExact, []string{}, []string{})
+			config.AuditSink = MakeJSONLinesAuditSink(buf)
+			// 20 base units and 10 quote units were sold today
+			f := makeTestVolumeFilter(config, &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 20.0, QuoteVol: 10.0}})
+			f.marketID = "market1"
+			f.nowFn = func() time.Time { return now }
+
+			_, e := f.Apply(k.ops, []hProtocol.Offer{}, []hProtocol.Offer{})
+			if !assert.NoError(t, e) {
+				return
+			}
+			for i := range k.wantRecords {
+				k.wantRecords[i].Timestamp = now
+				k.wantRecords[i].MarketID = "market1"
+				k.wantRecords[i].Date = "2020/01/21"
+				k.wantRecords[i].Side = "sell"
+				k.wantRecords[i].Price = "2.0"
+			}
+			assert.Equal(t, k.wantRecords, readAuditRecords(t, buf))
+		})
+	}
+
+	// the decisions of a dry run are not audited
+	buf := &bytes.Buffer{}
+	config := makeRawVolumeFilterConfig(pointy.Float64(10.0), nil, volumeFilterModeExact, []string{}, []string{})
+	config.AuditSink = MakeJSONLinesAuditSink(buf)
+	f := makeTestVolumeFilter(config, &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 20.0, QuoteVol: 10.0}})
+	_, e := f.DryRun([]txnbuild.Operation{makeManageSellOffer("2.0", "50.0")})
+	if !assert.NoError(t, e) {
+		return
+	}
+	assert.Equal(t, 0, buf.Len())
+
+	// Apply fails when the decision cannot be audited
+	config.AuditSink = MakeJSONLinesAuditSink(failingWriter{})
+	_, e = f.Apply([]txnbuild.Operation{makeManageSellOffer("2.0", "50.0")}, []hProtocol.Offer{}, []hProtocol.Offer{})
+	assert.Error(t, e)
+}
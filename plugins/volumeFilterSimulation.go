@@ -0,0 +1,175 @@
+package plugins
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/stellar/go/txnbuild"
+
+	"github.com/stellar/kelp/api"
+	"github.com/stellar/kelp/queries"
+	"github.com/stellar/kelp/support/utils"
+)
+
+// inMemoryDailyVolumeQuery is an api.Query that returns the daily volume of a date from memory instead of the database, a date without
+// an entry has no volume
+type inMemoryDailyVolumeQuery struct {
+	volumeByDate map[string]*queries.DailyVolume
+}
+
+var _ api.Query = &inMemoryDailyVolumeQuery{}
+
+// Name impl.
+func (q *inMemoryDailyVolumeQuery) Name() string {
+	return "InMemoryDailyVolume"
+}
+
+// QueryRow impl. The first arg is the date (in the postgresdb.DateFormatString format) and any other args are ignored.
+func (q *inMemoryDailyVolumeQuery) QueryRow(args ...interface{}) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("expected at least 1 arg (dateUTC string), but got args %v", args)
+	}
+	dateString, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("input arg needs to be of type 'string', but was of type '%T'", args[0])
+	}
+
+	volume := q.volumeByDate[dateString]
+	if volume == nil {
+		return &queries.DailyVolume{BaseVol: 0.0, QuoteVol: 0.0}, nil
+	}
+	// return a copy so the caller cannot modify the stored volume
+	result := *volume
+	return &result, nil
+}
+
+// book adds the volume to the date, assuming that the volume was fully executed
+func (q *inMemoryDailyVolumeQuery) book(dateString string, baseVol float64, quoteVol float64) {
+	volume := q.volumeByDate[dateString]
+	if volume == nil {
+		volume = &queries.DailyVolume{}
+		q.volumeByDate[dateString] = volume
+	}
+	volume.BaseVol += baseVol
+	volume.QuoteVol += quoteVol
+}
+
+// VolumeFilterSimulation holds the aggregate statistics of a simulation of the volume filter, where the volumes are the base units of the
+// sell ops (buy ops are not included in the volumes)
+type VolumeFilterSimulation struct {
+	NumBatches    int
+	KeptVolume    float64
+	TrimmedVolume float64
+	DroppedVolume float64
+	// NumCapHits is the number of batches in which at least one op was trimmed or dropped
+	NumCapHits int
+	// CapHitFrequency is the fraction of the batches in which at least one op was trimmed or dropped
+	CapHitFrequency float64
+	DropCounts      map[VolumeFilterDropReason]int
+}
+
+// String is the stringer method
+func (s *VolumeFilterSimulation) String() string {
+	return fmt.Sprintf("VolumeFilterSimulation[numBatches=%d, keptVolume=%.7f, trimmedVolume=%.7f, droppedVolume=%.7f, numCapHits=%d, capHitFrequency=%.4f, dropCounts=%s]",
+		s.NumBatches, s.KeptVolume, s.TrimmedVolume, s.DroppedVolume, s.NumCapHits, s.CapHitFrequency, summarizeDropReasons(s.DropCounts))
+}
+
+// Simulate replays the batches of ops against the daily volumes in volumeByDate instead of the database, which lets operators backtest the
+// caps of a VolumeFilterConfig offline. The batches are run in order on the date of the filter's clock, and the volume of the ops that are
+// kept in a batch is booked into the volume of that date as if it was fully executed, so the later batches see it. Like DryRun, the
+// existing offers are not re-checked against the caps. It does not mutate the ops, the volumeByDate, or the state of the filter, and does
+// not write to the AuditSink, StateStore, OnAlert, OnCapHit, or the pending volume. It cannot be used with RollingTradeCount,
+// WindowDuration, the weekly and monthly caps, or the AccountCaps since those do not use the daily volume of the market, and the buy caps
+// are checked against no volume bought.
+func (f *volumeFilter) Simulate(ops [][]txnbuild.Operation, volumeByDate map[string]*queries.DailyVolume) (*VolumeFilterSimulation, error) {
+	if f.config.RollingTradeCount > 0 || f.config.WindowDuration > 0 || f.config.hasPeriodCap() || len(f.config.AccountCaps) > 0 {
+		return nil, fmt.Errorf("cannot simulate a volume filter with RollingTradeCount, WindowDuration, a weekly or monthly cap, or AccountCaps")
+	}
+
+	volumeSource := &inMemoryDailyVolumeQuery{volumeByDate: map[string]*queries.DailyVolume{}}
+	for dateString, volume := range volumeByDate {
+		if volume != nil {
+			volumeSource.book(dateString, volume.BaseVol, volume.QuoteVol)
+		}
+	}
+	var buyVolumeSource api.Query
+	if f.config.hasBuyCap() {
+		buyVolumeSource = &inMemoryDailyVolumeQuery{volumeByDate: map[string]*queries.DailyVolume{}}
+	}
+	// DryRun does not call the callbacks of the config, but the simulated volume cannot be shared with the other filters
+	config := *f.config
+	config.SharedQueryCache = nil
+	simulated := &volumeFilter{
+		name:                      f.name,
+		configValue:               f.configValue,
+		baseAsset:                 f.baseAsset,
+		quoteAsset:                f.quoteAsset,
+		config:                    &config,
+		dailyVolumeByDateQuery:    volumeSource,
+		dailyBuyVolumeByDateQuery: buyVolumeSource,
+		marketID:                  f.marketID,
+		nowFn:                     f.nowFn,
+	}
+
+	simulation := &VolumeFilterSimulation{
+		DropCounts: map[VolumeFilterDropReason]int{},
+	}
+	for i, batchOps := range ops {
+		dateString, e := simulated.currentDateString(context.Background(), simulated.now())
+		if e != nil {
+			return nil, fmt.Errorf("could not get the current date of batch %d: %s", i, e)
+		}
+		diffs, e := simulated.DryRun(batchOps)
+		if e != nil {
+			return nil, fmt.Errorf("could not run batch %d: %s", i, e)
+		}
+
+		capHit := false
+		for _, diff := range diffs {
+			mso, ok := diff.Unfiltered.(*txnbuild.ManageSellOffer)
+			if !ok || mso.Amount == "0" {
+				continue
+			}
+			isSell, e := utils.IsSelling(simulated.baseAsset, simulated.quoteAsset, mso.Selling, mso.Buying)
+			if e != nil {
+				return nil, fmt.Errorf("error when running the isSelling check for offer '%+v': %s", *mso, e)
+			}
+			if diff.Action != VolumeFilterOpActionKept {
+				capHit = true
+			}
+			if diff.Action == VolumeFilterOpActionDropped {
+				simulation.DropCounts[diff.DropReason]++
+			}
+			if !isSell {
+				continue
+			}
+
+			if diff.Action == VolumeFilterOpActionDropped {
+				simulation.DroppedVolume += diff.TrimmedAmount
+				continue
+			}
+			simulation.TrimmedVolume += diff.TrimmedAmount
+			kept := diff.Filtered.(*txnbuild.ManageSellOffer)
+			keptAmount, e := strconv.ParseFloat(kept.Amount, 64)
+			if e != nil {
+				return nil, fmt.Errorf("could not convert amount (%s) to float: %s", kept.Amount, e)
+			}
+			keptPrice, e := strconv.ParseFloat(kept.Price, 64)
+			if e != nil {
+				return nil, fmt.Errorf("could not convert price (%s) to float: %s", kept.Price, e)
+			}
+			simulation.KeptVolume += keptAmount
+			volumeSource.book(dateString, keptAmount, keptAmount*keptPrice)
+		}
+
+		simulation.NumBatches++
+		if capHit {
+			simulation.NumCapHits++
+		}
+	}
+	if simulation.NumBatches > 0 {
+		simulation.CapHitFrequency = float64(simulation.NumCapHits) / float64(simulation.NumBatches)
+	}
+	return simulation, nil
+}
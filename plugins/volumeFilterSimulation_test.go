@@ -0,0 +1,70 @@
+package plugins
+
+import (
+	"testing"
+	"time"
+
+	"github.com/openlyinc/pointy"
+	"github.com/stellar/go/txnbuild"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/stellar/kelp/queries"
+)
+
+func TestVolumeFilterSimulate(t *testing.T) {
+	config := makeRawVolumeFilterConfig(pointy.Float64(100.0), pointy.Float64(1000.0), volumeFilterModeExact, []string{}, []string{})
+	query := &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 0.0, QuoteVol: 0.0}}
+	f := makeTestVolumeFilter(config, query)
+	now, _ := time.Parse(time.RFC3339, "2020-01-21T12:00:00Z")
+	f.nowFn = func() time.Time { return now }
+
+	volumeByDate := map[string]*queries.DailyVolume{
+		"2020/01/20": {BaseVol: 100.0, QuoteVol: 200.0},
+		"2020/01/21": {BaseVol: 20.0, QuoteVol: 40.0},
+	}
+	batches := [][]txnbuild.Operation{
+		{makeManageSellOffer("2.0", "30.0"), makeManageSellOffer("2.0", "30.0")},
+		{makeManageSellOffer("2.0", "30.0")},
+		{makeManageSellOffer("2.0", "10.0"), makeManageSellOffer("2.0", "10.0")},
+	}
+	simulation, e := f.Simulate(batches, volumeByDate)
+	if !assert.NoError(t, e) {
+		return
+	}
+	assert.Equal(t, 3, simulation.NumBatches)
+	assert.InDelta(t, 80.0, simulation.KeptVolume, 1e-7)
+	assert.InDelta(t, 10.0, simulation.TrimmedVolume, 1e-7)
+	assert.InDelta(t, 20.0, simulation.DroppedVolume, 1e-7)
+	assert.Equal(t, 2, simulation.NumCapHits)
+	assert.InDelta(t, 2.0/3.0, simulation.CapHitFrequency, 1e-9)
+	assert.Equal(t, map[VolumeFilterDropReason]int{DropReasonBaseCap: 2}, simulation.DropCounts)
+
+	// the simulation does not touch the volume source of the filter, the ops, or the passed in volumes
+	assert.Equal(t, 0, len(query.calledArgs))
+	assert.Equal(t, "30.0", batches[1][0].(*txnbuild.ManageSellOffer).Amount)
+	assert.Equal(t, &queries.DailyVolume{BaseVol: 20.0, QuoteVol: 40.0}, volumeByDate["2020/01/21"])
+
+	// the quote cap binds with the volume booked by the earlier batches, and a date without volume starts from zero
+	config = makeRawVolumeFilterConfig(nil, pointy.Float64(100.0), volumeFilterModeIgnore, []string{}, []string{})
+	f = makeTestVolumeFilter(config, query)
+	f.nowFn = func() time.Time { return now }
+	simulation, e = f.Simulate(batches, map[string]*queries.DailyVolume{})
+	if !assert.NoError(t, e) {
+		return
+	}
+	assert.InDelta(t, 50.0, simulation.KeptVolume, 1e-7)
+	assert.InDelta(t, 0.0, simulation.TrimmedVolume, 1e-7)
+	assert.InDelta(t, 60.0, simulation.DroppedVolume, 1e-7)
+	assert.Equal(t, 2, simulation.NumCapHits)
+	assert.Equal(t, map[VolumeFilterDropReason]int{DropReasonQuoteCap: 2}, simulation.DropCounts)
+
+	simulation, e = f.Simulate([][]txnbuild.Operation{}, volumeByDate)
+	if assert.NoError(t, e) {
+		assert.Equal(t, 0, simulation.NumBatches)
+		assert.Equal(t, 0.0, simulation.CapHitFrequency)
+	}
+
+	config.RollingTradeCount = 10
+	_, e = f.Simulate(batches, volumeByDate)
+	assert.Error(t, e)
+}
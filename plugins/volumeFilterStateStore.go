@@ -0,0 +1,129 @@
+package plugins
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// PersistedTBB is the state of the TBB (to-be-booked) accumulator of the volume filter at the end of a batch on a given date, along with the
+// daily volume (OTB) that the batch was checked against so the volume executed since the batch can be told apart on a restart
+type PersistedTBB struct {
+	Date           string  `json:"date"`
+	SellBaseUnits  float64 `json:"sell_base_units"`
+	SellQuoteUnits float64 `json:"sell_quote_units"`
+	OTBBaseUnits   float64 `json:"otb_base_units"`
+	OTBQuoteUnits  float64 `json:"otb_quote_units"`
+}
+
+// VolumeFilterStateStore persists the TBB accumulator of the volume filter, keyed by market ID, so it can be restored after a restart
+type VolumeFilterStateStore interface {
+	// LoadTBB returns the persisted TBB for the market or nil if nothing was persisted
+	LoadTBB(marketID string) (*PersistedTBB, error)
+	SaveTBB(marketID string, tbb PersistedTBB) error
+}
+
+// inMemoryVolumeFilterStateStore keeps the state in memory, which does not survive a restart of the process
+type inMemoryVolumeFilterStateStore struct {
+	mutex sync.Mutex
+	state map[string]PersistedTBB
+}
+
+var _ VolumeFilterStateStore = &inMemoryVolumeFilterStateStore{}
+
+// MakeInMemoryVolumeFilterStateStore makes a VolumeFilterStateStore that keeps the state in memory, which is useful to share the state
+// between filters that are re-created within the same process
+func MakeInMemoryVolumeFilterStateStore() VolumeFilterStateStore {
+	return &inMemoryVolumeFilterStateStore{
+		state: map[string]PersistedTBB{},
+	}
+}
+
+// LoadTBB impl.
+func (s *inMemoryVolumeFilterStateStore) LoadTBB(marketID string) (*PersistedTBB, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	tbb, ok := s.state[marketID]
+	if !ok {
+		return nil, nil
+	}
+	return &tbb, nil
+}
+
+// SaveTBB impl.
+func (s *inMemoryVolumeFilterStateStore) SaveTBB(marketID string, tbb PersistedTBB) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.state[marketID] = tbb
+	return nil
+}
+
+// fileVolumeFilterStateStore keeps the state of each market in a JSON file in a directory
+type fileVolumeFilterStateStore struct {
+	dir string
+}
+
+var _ VolumeFilterStateStore = &fileVolumeFilterStateStore{}
+
+// MakeFileVolumeFilterStateStore makes a VolumeFilterStateStore that keeps the state of each market as a JSON file in the passed in directory
+func MakeFileVolumeFilterStateStore(dir string) (VolumeFilterStateStore, error) {
+	info, e := os.Stat(dir)
+	if e != nil {
+		return nil, fmt.Errorf("could not stat directory '%s': %s", dir, e)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("'%s' is not a directory", dir)
+	}
+
+	return &fileVolumeFilterStateStore{
+		dir: dir,
+	}, nil
+}
+
+func (s *fileVolumeFilterStateStore) filename(marketID string) string {
+	return filepath.Join(s.dir, fmt.Sprintf("volumeFilter_tbb_%s.json", marketID))
+}
+
+// LoadTBB impl.
+func (s *fileVolumeFilterStateStore) LoadTBB(marketID string) (*PersistedTBB, error) {
+	data, e := ioutil.ReadFile(s.filename(marketID))
+	if os.IsNotExist(e) {
+		return nil, nil
+	}
+	if e != nil {
+		return nil, fmt.Errorf("could not read persisted TBB for market '%s': %s", marketID, e)
+	}
+
+	var tbb PersistedTBB
+	e = json.Unmarshal(data, &tbb)
+	if e != nil {
+		return nil, fmt.Errorf("could not unmarshal persisted TBB for market '%s': %s", marketID, e)
+	}
+	return &tbb, nil
+}
+
+// SaveTBB impl.
+func (s *fileVolumeFilterStateStore) SaveTBB(marketID string, tbb PersistedTBB) error {
+	data, e := json.Marshal(tbb)
+	if e != nil {
+		return fmt.Errorf("could not marshal TBB for market '%s': %s", marketID, e)
+	}
+
+	// write to a temporary file and rename it so a crash does not leave a partially written file
+	filename := s.filename(marketID)
+	tmpFilename := filename + ".tmp"
+	e = ioutil.WriteFile(tmpFilename, data, 0644)
+	if e != nil {
+		return fmt.Errorf("could not write TBB for market '%s': %s", marketID, e)
+	}
+	e = os.Rename(tmpFilename, filename)
+	if e != nil {
+		return fmt.Errorf("could not rename TBB file for market '%s': %s", marketID, e)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package plugins
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVolumeFilterStateStores(t *testing.T) {
+	dir, e := ioutil.TempDir("", "volumeFilterStateStore")
+	if !assert.NoError(t, e) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	fileStore, e := MakeFileVolumeFilterStateStore(dir)
+	if !assert.NoError(t, e) {
+		return
+	}
+
+	for name, store := range map[string]VolumeFilterStateStore{
+		"in memory": MakeInMemoryVolumeFilterStateStore(),
+		"file":      fileStore,
+	} {
+		t.Run(name, func(t *testing.T) {
+			tbb, e := store.LoadTBB("market1")
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.Nil(t, tbb)
+
+			want := PersistedTBB{Date: "2020/01/21", SellBaseUnits: 5.5, SellQuoteUnits: 11.0, OTBBaseUnits: 1.0, OTBQuoteUnits: 2.0}
+			if !assert.NoError(t, store.SaveTBB("market1", want)) {
+				return
+			}
+			tbb, e = store.LoadTBB("market1")
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.Equal(t, &want, tbb)
+
+			// markets are stored independently
+			tbb, e = store.LoadTBB("market2")
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.Nil(t, tbb)
+		})
+	}
+
+	// a new file store on the same directory sees the persisted state, which is what happens on a restart
+	reopened, e := MakeFileVolumeFilterStateStore(dir)
+	if !assert.NoError(t, e) {
+		return
+	}
+	tbb, e := reopened.LoadTBB("market1")
+	if assert.NoError(t, e) {
+		assert.Equal(t, &PersistedTBB{Date: "2020/01/21", SellBaseUnits: 5.5, SellQuoteUnits: 11.0, OTBBaseUnits: 1.0, OTBQuoteUnits: 2.0}, tbb)
+	}
+
+	_, e = MakeFileVolumeFilterStateStore(filepath.Join(dir, "missing"))
+	assert.Error(t, e)
+}
@@ -1,12 +1,19 @@
 package plugins
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/openlyinc/pointy"
+	"github.com/stellar/kelp/api"
 	"github.com/stellar/kelp/queries"
+	"github.com/stellar/kelp/support/logger"
+	"github.com/stellar/kelp/support/postgresdb"
 	"github.com/stellar/kelp/support/utils"
 
 	hProtocol "github.com/stellar/go/protocols/horizon"
@@ -28,6 +35,8 @@ func makeWantVolumeFilter(config *VolumeFilterConfig, marketIDs []string, accoun
 		quoteAsset:             utils.NativeAsset,
 		config:                 config,
 		dailyVolumeByDateQuery: query,
+		// the market of the filter is always the first market ID
+		marketID: marketIDs[0],
 	}
 }
 
@@ -35,7 +44,7 @@ func TestMakeFilterVolume(t *testing.T) {
 	testAssetDisplayFn := model.MakeSdexMappedAssetDisplayFn(map[model.Asset]hProtocol.Asset{model.Asset("XLM"): utils.NativeAsset})
 	configValue := ""
 	tradingPair := &model.TradingPair{Base: "XLM", Quote: "XLM"}
-	modes := []volumeFilterMode{volumeFilterModeExact, volumeFilterModeIgnore}
+	modes := []volumeFilterMode{volumeFilterModeExact, volumeFilterModeIgnore, volumeFilterModeScale}
 
 	testCases := []struct {
 		name          string
@@ -74,25 +83,32 @@ func TestMakeFilterVolume(t *testing.T) {
 			accountIDs:    []string{},
 			wantMarketIDs: []string{"6d9862b0e2", "marketID1", "marketID2"},
 		},
+		{
+			name:          "hex market ids are canonicalized before deduping",
+			exchangeName:  "exchange 1",
+			marketIDs:     []string{"6D9862B0E2", " 96EDA0A6EC ", "96eda0a6ec", "marketID1", "MARKETID1"},
+			accountIDs:    []string{},
+			wantMarketIDs: []string{"6d9862b0e2", "96eda0a6ec", "marketID1", "MARKETID1"},
+		},
 		{
 			name:          "1 account id",
 			exchangeName:  "exchange 2",
 			marketIDs:     []string{},
-			accountIDs:    []string{"accountID"},
+			accountIDs:    []string{testAccountID1},
 			wantMarketIDs: []string{"9db20cdd56"},
 		},
 		{
 			name:          "2 account ids",
 			exchangeName:  "exchange 1",
 			marketIDs:     []string{},
-			accountIDs:    []string{"accountID1", "accountID2"},
+			accountIDs:    []string{testAccountID1, testAccountID2},
 			wantMarketIDs: []string{"6d9862b0e2"},
 		},
 		{
 			name:          "account and market ids",
 			exchangeName:  "exchange 2",
 			marketIDs:     []string{"marketID"},
-			accountIDs:    []string{"accountID"},
+			accountIDs:    []string{testAccountID1},
 			wantMarketIDs: []string{"9db20cdd56", "marketID"},
 		},
 	}
@@ -301,11 +317,11 @@ func TestVolumeFilterFn(t *testing.T) {
 				mode:                         k.mode,
 			}
 
-			actual, e := volumeFilterFn(dailyOTB, dailyTBBAccumulator, k.inputOp, utils.NativeAsset, utils.NativeAsset, lp)
+			actual, e := volumeFilterFn(dailyOTB, dailyTBBAccumulator, &VolumeFilterConfig{}, k.inputOp, utils.NativeAsset, utils.NativeAsset, lp)
 			if !assert.Nil(t, e) {
 				return
 			}
-			assert.Equal(t, k.wantOp, actual)
+			assert.Equal(t, k.wantOp, actual.Op)
 
 			wantTBBAccumulator := makeRawVolumeFilterConfig(k.wantTbbBase, k.wantTbbQuote, k.mode, marketIDs, accountIDs)
 			assert.Equal(t, wantTBBAccumulator, dailyTBBAccumulator)
@@ -314,6 +330,160 @@ func TestVolumeFilterFn(t *testing.T) {
 	}
 }
 
+func TestVolumeFilterFnBothCaps(t *testing.T) {
+	testCases := []struct {
+		name         string
+		mode         volumeFilterMode
+		otbBase      float64
+		otbQuote     float64
+		inputOp      *txnbuild.ManageSellOffer
+		capInBase    float64
+		capInQuote   float64
+		wantOp       *txnbuild.ManageSellOffer
+		wantTbbBase  float64
+		wantTbbQuote float64
+	}{
+		{
+			name:         "under both caps",
+			mode:         volumeFilterModeExact,
+			otbBase:      4.0,
+			otbQuote:     6.0,
+			inputOp:      makeManageSellOffer("2.0", "2.0"),
+			capInBase:    10.0,
+			capInQuote:   15.0,
+			wantOp:       makeManageSellOffer("2.0", "2.0"),
+			wantTbbBase:  2.0,
+			wantTbbQuote: 4.0,
+		}, {
+			name:         "base cap binds, exact mode",
+			mode:         volumeFilterModeExact,
+			inputOp:      makeManageSellOffer("2.0", "5.0"),
+			capInBase:    3.0,
+			capInQuote:   100.0,
+			wantOp:       makeManageSellOffer("2.0", "3.0000000"),
+			wantTbbBase:  3.0,
+			wantTbbQuote: 6.0,
+		}, {
+			name:         "quote cap binds after base trim, exact mode",
+			mode:         volumeFilterModeExact,
+			inputOp:      makeManageSellOffer("2.0", "100.0"),
+			capInBase:    10.0,
+			capInQuote:   15.0,
+			wantOp:       makeManageSellOffer("2.0", "7.5000000"),
+			wantTbbBase:  7.5,
+			wantTbbQuote: 15.0,
+		}, {
+			name:         "over both caps, ignore mode",
+			mode:         volumeFilterModeIgnore,
+			inputOp:      makeManageSellOffer("2.0", "100.0"),
+			capInBase:    10.0,
+			capInQuote:   15.0,
+			wantOp:       nil,
+			wantTbbBase:  0.0,
+			wantTbbQuote: 0.0,
+		}, {
+			name:         "over quote cap only, ignore mode",
+			mode:         volumeFilterModeIgnore,
+			inputOp:      makeManageSellOffer("2.0", "8.0"),
+			capInBase:    10.0,
+			capInQuote:   15.0,
+			wantOp:       nil,
+			wantTbbBase:  0.0,
+			wantTbbQuote: 0.0,
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			dailyOTB := makeRawVolumeFilterConfig(pointy.Float64(k.otbBase), pointy.Float64(k.otbQuote), k.mode, []string{}, []string{})
+			dailyTBBAccumulator := makeRawVolumeFilterConfig(pointy.Float64(0.0), pointy.Float64(0.0), k.mode, []string{}, []string{})
+			lp := limitParameters{
+				sellBaseAssetCapInBaseUnits:  pointy.Float64(k.capInBase),
+				sellBaseAssetCapInQuoteUnits: pointy.Float64(k.capInQuote),
+				mode:                         k.mode,
+			}
+
+			actual, e := volumeFilterFn(dailyOTB, dailyTBBAccumulator, &VolumeFilterConfig{}, k.inputOp, utils.NativeAsset, utils.NativeAsset, lp)
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.Equal(t, k.wantOp, actual.Op)
+			assert.InDelta(t, k.wantTbbBase, *dailyTBBAccumulator.SellBaseAssetCapInBaseUnits, 1e-9)
+			assert.InDelta(t, k.wantTbbQuote, *dailyTBBAccumulator.SellBaseAssetCapInQuoteUnits, 1e-9)
+		})
+	}
+}
+
+func TestVolumeFilterFnAccumulator(t *testing.T) {
+	type step struct {
+		inputOp      *txnbuild.ManageSellOffer
+		wantOp       *txnbuild.ManageSellOffer
+		wantTbbBase  float64
+		wantTbbQuote float64
+	}
+
+	testCases := []struct {
+		name       string
+		mode       volumeFilterMode
+		otbBase    float64
+		otbQuote   float64
+		capInBase  *float64
+		capInQuote *float64
+		steps      []step
+	}{
+		{
+			name:      "base cap, exact mode",
+			mode:      volumeFilterModeExact,
+			otbBase:   2.0,
+			otbQuote:  4.0,
+			capInBase: pointy.Float64(10.0),
+			steps: []step{
+				{inputOp: makeManageSellOffer("2.0", "3.0"), wantOp: makeManageSellOffer("2.0", "3.0"), wantTbbBase: 3.0, wantTbbQuote: 6.0},
+				{inputOp: makeManageSellOffer("1.0", "4.0"), wantOp: makeManageSellOffer("1.0", "4.0"), wantTbbBase: 7.0, wantTbbQuote: 10.0},
+				// trimmed to the remaining headroom of 10.0 - 2.0 - 7.0
+				{inputOp: makeManageSellOffer("2.0", "5.0"), wantOp: makeManageSellOffer("2.0", "1.0000000"), wantTbbBase: 8.0, wantTbbQuote: 12.0},
+				// no headroom left so it is dropped and the accumulator does not change
+				{inputOp: makeManageSellOffer("2.0", "1.0"), wantOp: nil, wantTbbBase: 8.0, wantTbbQuote: 12.0},
+			},
+		}, {
+			name:       "quote cap, ignore mode",
+			mode:       volumeFilterModeIgnore,
+			capInQuote: pointy.Float64(10.0),
+			steps: []step{
+				{inputOp: makeManageSellOffer("2.0", "2.0"), wantOp: makeManageSellOffer("2.0", "2.0"), wantTbbBase: 2.0, wantTbbQuote: 4.0},
+				// exactly reaches the cap
+				{inputOp: makeManageSellOffer("2.0", "3.0"), wantOp: makeManageSellOffer("2.0", "3.0"), wantTbbBase: 5.0, wantTbbQuote: 10.0},
+				{inputOp: makeManageSellOffer("1.0", "1.0"), wantOp: nil, wantTbbBase: 5.0, wantTbbQuote: 10.0},
+			},
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			dailyOTB := makeRawVolumeFilterConfig(pointy.Float64(k.otbBase), pointy.Float64(k.otbQuote), k.mode, []string{}, []string{})
+			dailyTBBAccumulator := makeRawVolumeFilterConfig(pointy.Float64(0.0), pointy.Float64(0.0), k.mode, []string{}, []string{})
+			lp := limitParameters{
+				sellBaseAssetCapInBaseUnits:  k.capInBase,
+				sellBaseAssetCapInQuoteUnits: k.capInQuote,
+				mode:                         k.mode,
+			}
+
+			for i, s := range k.steps {
+				actual, e := volumeFilterFn(dailyOTB, dailyTBBAccumulator, &VolumeFilterConfig{}, s.inputOp, utils.NativeAsset, utils.NativeAsset, lp)
+				if !assert.NoError(t, e, "step %d", i) {
+					return
+				}
+				assert.Equal(t, s.wantOp, actual.Op, "step %d", i)
+				assert.InDelta(t, s.wantTbbBase, *dailyTBBAccumulator.SellBaseAssetCapInBaseUnits, 1e-9, "tbb base after step %d", i)
+				assert.InDelta(t, s.wantTbbQuote, *dailyTBBAccumulator.SellBaseAssetCapInQuoteUnits, 1e-9, "tbb quote after step %d", i)
+				// the OTB is never modified by the filter
+				assert.Equal(t, k.otbBase, *dailyOTB.SellBaseAssetCapInBaseUnits, "otb base after step %d", i)
+				assert.Equal(t, k.otbQuote, *dailyOTB.SellBaseAssetCapInQuoteUnits, "otb quote after step %d", i)
+			}
+		})
+	}
+}
+
 func makeManageSellOffer(price string, amount string) *txnbuild.ManageSellOffer {
 	return &txnbuild.ManageSellOffer{
 		Buying:  txnbuild.NativeAsset{},
@@ -322,3 +492,3376 @@ func makeManageSellOffer(price string, amount string) *txnbuild.ManageSellOffer
 		Amount:  amount,
 	}
 }
+
+// mockDailyVolumeQuery is an api.Query that returns a fixed DailyVolume and records the args it was called with
+type mockDailyVolumeQuery struct {
+	result     *queries.DailyVolume
+	err        error
+	mutex      sync.Mutex
+	calledArgs [][]interface{}
+}
+
+// Name impl.
+func (q *mockDailyVolumeQuery) Name() string {
+	return "mockDailyVolumeQuery"
+}
+
+// QueryRow impl.
+func (q *mockDailyVolumeQuery) QueryRow(args ...interface{}) (interface{}, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.calledArgs = append(q.calledArgs, args)
+	if q.err != nil {
+		return nil, q.err
+	}
+	return q.result, nil
+}
+
+// testAccountID1 and testAccountID2 are valid Stellar account IDs used as the optionalAccountIDs of the config
+const (
+	testAccountID1 = "GAZVMWLVURCPWMUAP4P3ZAIY6VJTJG5MU5B4VIL4XGVCZAGWUMDCGORF"
+	testAccountID2 = "GDUHXCT6SNAFC7LUZ6YMRYBGQX4JLB45LBEP3KBUSAQLOWQ5P4BDOMO2"
+)
+
+func makeTestVolumeFilter(config *VolumeFilterConfig, query *mockDailyVolumeQuery) *volumeFilter {
+	return &volumeFilter{
+		name:                   "volumeFilter",
+		configValue:            "",
+		baseAsset:              utils.NativeAsset,
+		quoteAsset:             utils.NativeAsset,
+		config:                 config,
+		dailyVolumeByDateQuery: query,
+	}
+}
+
+func TestVolumeFilterConfigValidateMonitorOnly(t *testing.T) {
+	config := makeRawVolumeFilterConfig(nil, nil, volumeFilterModeExact, []string{"marketID"}, []string{})
+	assert.Error(t, config.Validate())
+
+	config.MonitorOnly = true
+	assert.NoError(t, config.Validate())
+}
+
+func TestVolumeFilterConfigValidateFields(t *testing.T) {
+	testCases := []struct {
+		name    string
+		modify  func(c *VolumeFilterConfig)
+		wantErr bool
+	}{
+		{
+			name:    "valid",
+			modify:  func(c *VolumeFilterConfig) {},
+			wantErr: false,
+		}, {
+			name:    "base and quote caps",
+			modify:  func(c *VolumeFilterConfig) { c.SellBaseAssetCapInQuoteUnits = pointy.Float64(2.0) },
+			wantErr: false,
+		}, {
+			name:    "zero cap",
+			modify:  func(c *VolumeFilterConfig) { c.SellBaseAssetCapInBaseUnits = pointy.Float64(0.0) },
+			wantErr: false,
+		}, {
+			name:    "negative cap",
+			modify:  func(c *VolumeFilterConfig) { c.BuyBaseAssetCapInQuoteUnits = pointy.Float64(-1.0) },
+			wantErr: true,
+		}, {
+			name:    "negative weekly cap",
+			modify:  func(c *VolumeFilterConfig) { c.SellBaseAssetCapInBaseUnitsWeekly = pointy.Float64(-1.0) },
+			wantErr: true,
+		}, {
+			name: "weekly cap with a reset offset",
+			modify: func(c *VolumeFilterConfig) {
+				c.SellBaseAssetCapInBaseUnitsWeekly = pointy.Float64(1.0)
+				c.ResetUTCOffset = -5 * time.Hour
+			},
+			wantErr: true,
+		}, {
+			name:    "missing mode",
+			modify:  func(c *VolumeFilterConfig) { c.mode = "" },
+			wantErr: true,
+		}, {
+			name:    "invalid mode",
+			modify:  func(c *VolumeFilterConfig) { c.mode = "trim" },
+			wantErr: true,
+		}, {
+			name:    "empty market ID",
+			modify:  func(c *VolumeFilterConfig) { c.additionalMarketIDs = []string{"marketID", " "} },
+			wantErr: true,
+		}, {
+			name:    "invalid account ID",
+			modify:  func(c *VolumeFilterConfig) { c.optionalAccountIDs = []string{testAccountID1, "accountID"} },
+			wantErr: true,
+		}, {
+			name: "secret seed as account ID",
+			modify: func(c *VolumeFilterConfig) {
+				c.optionalAccountIDs = []string{"SBHZ6DRSIHII2HJ7WHDL5NIK6CT7KEB2FPWIRCR3QBHK6RDMRYF5EYQ2"}
+			},
+			wantErr: true,
+		}, {
+			name: "wrapping cap schedule entry",
+			modify: func(c *VolumeFilterConfig) {
+				c.CapSchedule = []VolumeCapScheduleEntry{{Start: 22 * time.Hour, End: 6 * time.Hour, SellBaseAssetCapInBaseUnits: pointy.Float64(1.0)}}
+			},
+			wantErr: false,
+		}, {
+			name: "empty cap schedule entry",
+			modify: func(c *VolumeFilterConfig) {
+				c.CapSchedule = []VolumeCapScheduleEntry{{Start: 6 * time.Hour, End: 6 * time.Hour, SellBaseAssetCapInBaseUnits: pointy.Float64(1.0)}}
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			config := makeRawVolumeFilterConfig(pointy.Float64(1.0), nil, volumeFilterModeExact, []string{"marketID"}, []string{testAccountID1, testAccountID2})
+			k.modify(config)
+			e := config.Validate()
+			assert.Equal(t, k.wantErr, e != nil, fmt.Sprintf("%v", e))
+		})
+	}
+}
+
+func TestVolumeFilterApplyMonitorOnly(t *testing.T) {
+	config := makeRawVolumeFilterConfig(nil, nil, volumeFilterModeExact, []string{"marketID"}, []string{})
+	config.MonitorOnly = true
+	query := &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 1000.0, QuoteVol: 2000.0}}
+	f := makeTestVolumeFilter(config, query)
+
+	ops := []txnbuild.Operation{
+		makeManageSellOffer("2.0", "100.0"),
+		makeManageSellOffer("2.1", "200.0"),
+		makeManageSellOffer("2.2", "0"),
+	}
+	actual, e := f.Apply(ops, []hProtocol.Offer{}, []hProtocol.Offer{})
+	if !assert.NoError(t, e) {
+		return
+	}
+	assert.Equal(t, ops, actual)
+
+	// volume should still be computed for today even though no ops are modified
+	if !assert.Equal(t, 1, len(query.calledArgs)) {
+		return
+	}
+	assert.Equal(t, []interface{}{time.Now().UTC().Format(postgresdb.DateFormatString)}, query.calledArgs[0])
+}
+
+func makeSellingOffer(id int64, priceN int32, priceD int32, amount string) hProtocol.Offer {
+	return hProtocol.Offer{
+		ID:      id,
+		Seller:  "seller",
+		Selling: utils.NativeAsset,
+		Buying:  utils.NativeAsset,
+		Amount:  amount,
+		PriceR:  hProtocol.Price{N: priceN, D: priceD},
+		Price:   fmt.Sprintf("%.7f", float64(priceN)/float64(priceD)),
+	}
+}
+
+func TestVolumeFilterApplyCapHoldsWithDeletes(t *testing.T) {
+	deleteOp := makeManageSellOffer("2.0000000", "0")
+	deleteOp.OfferID = 1
+	sellingOffers := []hProtocol.Offer{makeSellingOffer(1, 2, 1, "4.0000000")}
+
+	// the cap is already used up by the executed volume, the unsold volume of the deleted offer was never counted so it does not free any cap
+	config := makeRawVolumeFilterConfig(pointy.Float64(10.0), nil, volumeFilterModeExact, []string{}, []string{})
+	query := &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 10.0, QuoteVol: 20.0}}
+	f := makeTestVolumeFilter(config, query)
+
+	ops := []txnbuild.Operation{deleteOp, makeManageSellOffer("2.0", "5.0")}
+	actual, e := f.Apply(ops, sellingOffers, []hProtocol.Offer{})
+	if !assert.NoError(t, e) {
+		return
+	}
+	assert.Equal(t, []txnbuild.Operation{deleteOp}, actual)
+}
+
+func TestVolumeFilterApplyCountDeletesAsFreeingCap(t *testing.T) {
+	deleteOp := makeManageSellOffer("2.0000000", "0")
+	deleteOp.OfferID = 1
+	sellingOffers := []hProtocol.Offer{makeSellingOffer(1, 2, 1, "4.0000000")}
+
+	testCases := []struct {
+		name           string
+		countDeletes   bool
+		otbBase        float64
+		maxBatchVolume *float64
+		ops            []txnbuild.Operation
+		wantOps        []txnbuild.Operation
+	}{
+		{
+			name:    "not set, the deleted offer never counts",
+			otbBase: 4.0,
+			ops:     []txnbuild.Operation{makeManageSellOffer("2.0", "3.0"), deleteOp, makeManageSellOffer("2.0", "3.0")},
+			wantOps: []txnbuild.Operation{makeManageSellOffer("2.0", "3.0"), deleteOp, makeManageSellOffer("2.0", "3.0")},
+		}, {
+			name:         "set, the delete restores the headroom for the ops after it",
+			countDeletes: true,
+			otbBase:      4.0,
+			// the deleted offer counts 4 base units until the delete op so the first op is trimmed to the remaining 2 base units
+			ops:     []txnbuild.Operation{makeManageSellOffer("2.0", "3.0"), deleteOp, makeManageSellOffer("2.0", "3.0")},
+			wantOps: []txnbuild.Operation{makeManageSellOffer("2.0", "2.0000000"), deleteOp, makeManageSellOffer("2.0", "3.0")},
+		}, {
+			name:         "set, a delete at the start frees the headroom for all the ops",
+			countDeletes: true,
+			otbBase:      4.0,
+			ops:          []txnbuild.Operation{deleteOp, makeManageSellOffer("2.0", "5.0")},
+			wantOps:      []txnbuild.Operation{deleteOp, makeManageSellOffer("2.0", "5.0")},
+		}, {
+			name:         "set, the delete does not free the cap used by the executed volume",
+			countDeletes: true,
+			otbBase:      10.0,
+			ops:          []txnbuild.Operation{deleteOp, makeManageSellOffer("2.0", "5.0")},
+			wantOps:      []txnbuild.Operation{deleteOp},
+		}, {
+			name:           "set, the freed volume does not count toward the max batch volume",
+			countDeletes:   true,
+			otbBase:        0.0,
+			maxBatchVolume: pointy.Float64(3.0),
+			ops:            []txnbuild.Operation{deleteOp, makeManageSellOffer("2.0", "5.0")},
+			wantOps:        []txnbuild.Operation{deleteOp, makeManageSellOffer("2.0", "3.0000000")},
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			config := makeRawVolumeFilterConfig(pointy.Float64(10.0), nil, volumeFilterModeExact, []string{}, []string{})
+			config.CountDeletesAsFreeingCap = k.countDeletes
+			config.MaxBatchVolume = k.maxBatchVolume
+			query := &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: k.otbBase, QuoteVol: 2 * k.otbBase}}
+			f := makeTestVolumeFilter(config, query)
+
+			actual, e := f.Apply(k.ops, sellingOffers, []hProtocol.Offer{})
+			if assert.NoError(t, e) {
+				assert.Equal(t, k.wantOps, actual)
+			}
+		})
+	}
+}
+
+func TestVolumeFilterApplyWithCancellations(t *testing.T) {
+	makeCancelOp := func(offer hProtocol.Offer) txnbuild.Operation {
+		op := convertOffer2MSO(offer)
+		op.Amount = "0"
+		return op
+	}
+	offer1 := makeSellingOffer(1, 2, 1, "1.0000000")
+	offer2 := makeSellingOffer(2, 3, 1, "2.0000000")
+	offer3 := makeSellingOffer(3, 4, 1, "1.0000000")
+	deleteOp := makeManageSellOffer("2.0000000", "0")
+	deleteOp.OfferID = 1
+	payment := &txnbuild.Payment{Destination: "destination", Amount: "1.0", Asset: txnbuild.NativeAsset{}}
+
+	testCases := []struct {
+		name          string
+		otbBase       float64
+		ops           []txnbuild.Operation
+		buyingOffers  []hProtocol.Offer
+		wantKeptOps   []txnbuild.Operation
+		wantCancelOps []txnbuild.Operation
+	}{
+		{
+			name:          "no breach keeps the existing offers",
+			otbBase:       0.0,
+			ops:           []txnbuild.Operation{makeManageSellOffer("2.5", "5.0")},
+			buyingOffers:  []hProtocol.Offer{},
+			wantKeptOps:   []txnbuild.Operation{makeManageSellOffer("2.5", "5.0")},
+			wantCancelOps: []txnbuild.Operation{},
+		}, {
+			name:          "no breach separates the deletes",
+			otbBase:       0.0,
+			ops:           []txnbuild.Operation{deleteOp, makeManageSellOffer("2.5", "5.0")},
+			buyingOffers:  []hProtocol.Offer{},
+			wantKeptOps:   []txnbuild.Operation{makeManageSellOffer("2.5", "5.0")},
+			wantCancelOps: []txnbuild.Operation{deleteOp},
+		}, {
+			name:          "breach cancels all the existing offers",
+			otbBase:       8.0,
+			ops:           []txnbuild.Operation{makeManageSellOffer("2.5", "5.0")},
+			buyingOffers:  []hProtocol.Offer{offer3},
+			wantKeptOps:   []txnbuild.Operation{},
+			wantCancelOps: []txnbuild.Operation{makeCancelOp(offer1), makeCancelOp(offer2), makeCancelOp(offer3)},
+		}, {
+			name:          "breach keeps the ops that are not offers",
+			otbBase:       10.0,
+			ops:           []txnbuild.Operation{makeManageSellOffer("2.5", "5.0"), payment, makeManageSellOffer("2.6", "1.0")},
+			buyingOffers:  []hProtocol.Offer{},
+			wantKeptOps:   []txnbuild.Operation{payment},
+			wantCancelOps: []txnbuild.Operation{makeCancelOp(offer1), makeCancelOp(offer2)},
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			config := makeRawVolumeFilterConfig(pointy.Float64(10.0), nil, volumeFilterModeExact, []string{}, []string{})
+			query := &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: k.otbBase, QuoteVol: 0.0}}
+			f := makeTestVolumeFilter(config, query)
+
+			keptOps, cancelOps, e := f.ApplyWithCancellations(k.ops, []hProtocol.Offer{offer1, offer2}, k.buyingOffers)
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.Equal(t, k.wantKeptOps, keptOps)
+			assert.Equal(t, k.wantCancelOps, cancelOps)
+		})
+	}
+}
+
+func TestVolumeFilterConfigActiveSellCaps(t *testing.T) {
+	config := makeRawVolumeFilterConfig(pointy.Float64(100.0), nil, volumeFilterModeExact, []string{}, []string{})
+	config.CapSchedule = []VolumeCapScheduleEntry{
+		// overnight, wraps around midnight
+		{Start: 22 * time.Hour, End: 6 * time.Hour, SellBaseAssetCapInBaseUnits: pointy.Float64(10.0)},
+		// active hours
+		{Start: 9 * time.Hour, End: 17 * time.Hour, SellBaseAssetCapInBaseUnits: pointy.Float64(500.0)},
+		// overlaps with active hours, tighter cap should win
+		{Start: 12 * time.Hour, End: 13 * time.Hour, SellBaseAssetCapInBaseUnits: pointy.Float64(200.0), SellBaseAssetCapInQuoteUnits: pointy.Float64(50.0)},
+	}
+	if !assert.NoError(t, config.Validate()) {
+		return
+	}
+
+	testCases := []struct {
+		timeOfDay      string
+		wantCapInBase  *float64
+		wantCapInQuote *float64
+	}{
+		{timeOfDay: "02:00:00", wantCapInBase: pointy.Float64(10.0), wantCapInQuote: nil},
+		{timeOfDay: "23:30:00", wantCapInBase: pointy.Float64(10.0), wantCapInQuote: nil},
+		{timeOfDay: "07:00:00", wantCapInBase: pointy.Float64(100.0), wantCapInQuote: nil},
+		{timeOfDay: "10:00:00", wantCapInBase: pointy.Float64(500.0), wantCapInQuote: nil},
+		{timeOfDay: "12:30:00", wantCapInBase: pointy.Float64(200.0), wantCapInQuote: pointy.Float64(50.0)},
+		{timeOfDay: "17:00:00", wantCapInBase: pointy.Float64(100.0), wantCapInQuote: nil},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.timeOfDay, func(t *testing.T) {
+			now, e := time.Parse(time.RFC3339, "2020-01-21T"+k.timeOfDay+"Z")
+			if !assert.NoError(t, e) {
+				return
+			}
+
+			capInBase, capInQuote := config.activeSellCaps(now)
+			assert.Equal(t, k.wantCapInBase, capInBase)
+			assert.Equal(t, k.wantCapInQuote, capInQuote)
+		})
+	}
+
+	// times of day are interpreted in the schedule location
+	config.ScheduleLocation = time.FixedZone("UTC-5", -5*60*60)
+	now, _ := time.Parse(time.RFC3339, "2020-01-21T15:00:00Z") // 10:00 in UTC-5
+	capInBase, _ := config.activeSellCaps(now)
+	assert.Equal(t, pointy.Float64(500.0), capInBase)
+}
+
+func TestVolumeFilterApplyCapSchedule(t *testing.T) {
+	config := makeRawVolumeFilterConfig(pointy.Float64(100.0), nil, volumeFilterModeExact, []string{}, []string{})
+	config.CapSchedule = []VolumeCapScheduleEntry{
+		{Start: 0, End: 6 * time.Hour, SellBaseAssetCapInBaseUnits: pointy.Float64(10.0)},
+	}
+
+	testCases := []struct {
+		now    string
+		wantOp *txnbuild.ManageSellOffer
+	}{
+		{now: "2020-01-21T03:00:00Z", wantOp: makeManageSellOffer("2.0", "10.0000000")},
+		{now: "2020-01-21T15:00:00Z", wantOp: makeManageSellOffer("2.0", "50.0")},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.now, func(t *testing.T) {
+			now, e := time.Parse(time.RFC3339, k.now)
+			if !assert.NoError(t, e) {
+				return
+			}
+			query := &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 0.0, QuoteVol: 0.0}}
+			f := makeTestVolumeFilter(config, query)
+			f.nowFn = func() time.Time { return now }
+
+			actual, e := f.Apply([]txnbuild.Operation{makeManageSellOffer("2.0", "50.0")}, []hProtocol.Offer{}, []hProtocol.Offer{})
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.Equal(t, []txnbuild.Operation{k.wantOp}, actual)
+			assert.Equal(t, []interface{}{now.Format(postgresdb.DateFormatString)}, query.calledArgs[0])
+		})
+	}
+}
+
+func TestMakeFilterVolumeCustomVolumeSQL(t *testing.T) {
+	testAssetDisplayFn := model.MakeSdexMappedAssetDisplayFn(map[model.Asset]hProtocol.Asset{model.Asset("XLM"): utils.NativeAsset})
+	tradingPair := &model.TradingPair{Base: "XLM", Quote: "XLM"}
+	config := makeRawVolumeFilterConfig(pointy.Float64(1.0), nil, volumeFilterModeExact, []string{}, []string{})
+	config.CustomVolumeSQL = "SELECT SUM(base), SUM(quote) FROM my_volume_view WHERE day = $1 AND desk = $2"
+	config.CustomVolumeSQLArgs = []interface{}{"desk1"}
+	db := &sql.DB{}
+
+	actual, e := makeFilterVolume("", "exchange 1", tradingPair, testAssetDisplayFn, utils.NativeAsset, utils.NativeAsset, db, config)
+	if !assert.NoError(t, e) {
+		return
+	}
+
+	wantQuery, e := queries.MakeDailyVolumeWithCustomQuery(db, config.CustomVolumeSQL, "desk1")
+	if !assert.NoError(t, e) {
+		return
+	}
+	assert.Equal(t, wantQuery, actual.(*volumeFilter).dailyVolumeByDateQuery)
+}
+
+func TestVolumeFilterApplyQueryCircuitBreaker(t *testing.T) {
+	testCases := []struct {
+		name     string
+		failOpen bool
+	}{
+		{
+			name:     "fail open",
+			failOpen: true,
+		}, {
+			name:     "fail closed",
+			failOpen: false,
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			config := makeRawVolumeFilterConfig(pointy.Float64(1.0), nil, volumeFilterModeExact, []string{}, []string{})
+			config.QueryCircuitBreaker = &QueryCircuitBreakerConfig{FailureThreshold: 3, FailOpen: k.failOpen}
+			if !assert.NoError(t, config.Validate()) {
+				return
+			}
+			query := &mockDailyVolumeQuery{
+				result: &queries.DailyVolume{BaseVol: 0.0, QuoteVol: 0.0},
+				err:    fmt.Errorf("db unavailable"),
+			}
+			f := makeTestVolumeFilter(config, query)
+			ops := []txnbuild.Operation{makeManageSellOffer("2.0", "5.0")}
+
+			// failures below the threshold always fail closed
+			for i := 0; i < 2; i++ {
+				_, e := f.Apply(ops, []hProtocol.Offer{}, []hProtocol.Offer{})
+				assert.Error(t, e)
+				assert.False(t, f.breakerOpen)
+			}
+
+			// breaker opens on the third failure and stays open while failures continue
+			for i := 0; i < 2; i++ {
+				actual, e := f.Apply(ops, []hProtocol.Offer{}, []hProtocol.Offer{})
+				assert.True(t, f.breakerOpen)
+				if k.failOpen {
+					assert.NoError(t, e)
+					assert.Equal(t, ops, actual)
+				} else {
+					assert.Error(t, e)
+				}
+			}
+			assert.Equal(t, 4, f.consecutiveQueryFailures)
+
+			// a successful query closes the breaker and the caps apply again
+			query.err = nil
+			actual, e := f.Apply([]txnbuild.Operation{makeManageSellOffer("2.0", "5.0")}, []hProtocol.Offer{}, []hProtocol.Offer{})
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.False(t, f.breakerOpen)
+			assert.Equal(t, 0, f.consecutiveQueryFailures)
+			if assert.Equal(t, 1, len(actual)) {
+				assert.Equal(t, "1.0000000", actual[0].(*txnbuild.ManageSellOffer).Amount)
+			}
+
+			// the failure count starts over after recovery
+			query.err = fmt.Errorf("db unavailable")
+			_, e = f.Apply(ops, []hProtocol.Offer{}, []hProtocol.Offer{})
+			assert.Error(t, e)
+			assert.False(t, f.breakerOpen)
+		})
+	}
+
+	invalid := makeRawVolumeFilterConfig(pointy.Float64(1.0), nil, volumeFilterModeExact, []string{}, []string{})
+	invalid.QueryCircuitBreaker = &QueryCircuitBreakerConfig{FailureThreshold: 0}
+	assert.Error(t, invalid.Validate())
+}
+
+func TestMakeFilterVolumeMinFillPriceToCount(t *testing.T) {
+	testAssetDisplayFn := model.MakeSdexMappedAssetDisplayFn(map[model.Asset]hProtocol.Asset{model.Asset("XLM"): utils.NativeAsset})
+	tradingPair := &model.TradingPair{Base: "XLM", Quote: "XLM"}
+	config := makeRawVolumeFilterConfig(pointy.Float64(1.0), nil, volumeFilterModeExact, []string{}, []string{testAccountID1})
+	config.MinFillPriceToCount = pointy.Float64(0.01)
+	db := &sql.DB{}
+
+	actual, e := makeFilterVolume("", "exchange 1", tradingPair, testAssetDisplayFn, utils.NativeAsset, utils.NativeAsset, db, config)
+	if !assert.NoError(t, e) {
+		return
+	}
+
+	wantQuery, e := queries.MakeDailyVolumeByDateForMarketIdsActionAbovePrice(db, []string{"6d9862b0e2"}, "sell", []string{testAccountID1}, pointy.Float64(0.01))
+	if !assert.NoError(t, e) {
+		return
+	}
+	assert.Equal(t, wantQuery, actual.(*volumeFilter).dailyVolumeByDateQuery)
+
+	config.MinFillPriceToCount = pointy.Float64(-1.0)
+	assert.Error(t, config.Validate())
+}
+
+func TestMakeFilterVolumeMaxMarketIDs(t *testing.T) {
+	testAssetDisplayFn := model.MakeSdexMappedAssetDisplayFn(map[model.Asset]hProtocol.Asset{model.Asset("XLM"): utils.NativeAsset})
+	tradingPair := &model.TradingPair{Base: "XLM", Quote: "XLM"}
+	makeMarketIDs := func(n int) []string {
+		ids := []string{}
+		for i := 0; i < n; i++ {
+			ids = append(ids, fmt.Sprintf("market%d", i))
+		}
+		return ids
+	}
+
+	testCases := []struct {
+		name                   string
+		numAdditionalMarketIDs int
+		maxMarketIDs           int
+		customVolumeSQL        string
+		wantErr                bool
+	}{
+		{
+			name:                   "below the default limit",
+			numAdditionalMarketIDs: defaultMaxMarketIDs - 2,
+			wantErr:                false,
+		}, {
+			name:                   "at the default limit",
+			numAdditionalMarketIDs: defaultMaxMarketIDs - 1,
+			wantErr:                false,
+		}, {
+			name:                   "above the default limit",
+			numAdditionalMarketIDs: defaultMaxMarketIDs,
+			wantErr:                true,
+		}, {
+			name:                   "at a raised limit",
+			numAdditionalMarketIDs: 49,
+			maxMarketIDs:           50,
+			wantErr:                false,
+		}, {
+			name:                   "above a raised limit",
+			numAdditionalMarketIDs: 50,
+			maxMarketIDs:           50,
+			wantErr:                true,
+		}, {
+			name:                   "market IDs are not used by a custom query",
+			numAdditionalMarketIDs: defaultMaxMarketIDs,
+			customVolumeSQL:        "SELECT 1.0, 2.0 WHERE $1 = $1",
+			wantErr:                false,
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			config := makeRawVolumeFilterConfig(pointy.Float64(1.0), nil, volumeFilterModeExact, makeMarketIDs(k.numAdditionalMarketIDs), []string{})
+			config.MaxMarketIDs = k.maxMarketIDs
+			config.CustomVolumeSQL = k.customVolumeSQL
+			_, e := makeFilterVolume("", "exchange 1", tradingPair, testAssetDisplayFn, utils.NativeAsset, utils.NativeAsset, &sql.DB{}, config)
+			if k.wantErr {
+				assert.Error(t, e)
+				return
+			}
+			assert.NoError(t, e)
+		})
+	}
+}
+
+func TestVolumeFilterApplyOnQueryErrorBehavior(t *testing.T) {
+	deleteOp := makeManageSellOffer("2.0", "0")
+	deleteOp.OfferID = 1
+	ops := []txnbuild.Operation{makeManageSellOffer("2.0", "5.0"), deleteOp, makeManageSellOffer("2.1", "5.0")}
+	testCases := []struct {
+		behavior QueryErrorBehavior
+		wantOps  []txnbuild.Operation
+		wantErr  bool
+	}{
+		{
+			behavior: "",
+			wantErr:  true,
+		}, {
+			behavior: QueryErrorBehaviorFail,
+			wantErr:  true,
+		}, {
+			behavior: QueryErrorBehaviorBlockAll,
+			wantOps:  []txnbuild.Operation{deleteOp},
+		}, {
+			behavior: QueryErrorBehaviorAllowAll,
+			wantOps:  ops,
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(string(k.behavior), func(t *testing.T) {
+			config := makeRawVolumeFilterConfig(pointy.Float64(1.0), nil, volumeFilterModeExact, []string{}, []string{})
+			config.OnQueryErrorBehavior = k.behavior
+			if !assert.NoError(t, config.Validate()) {
+				return
+			}
+			f := makeTestVolumeFilter(config, &mockDailyVolumeQuery{err: fmt.Errorf("db unavailable")})
+
+			actual, e := f.Apply(ops, []hProtocol.Offer{}, []hProtocol.Offer{})
+			if k.wantErr {
+				assert.Error(t, e)
+			} else if assert.NoError(t, e) {
+				assert.Equal(t, k.wantOps, actual)
+			}
+
+			// the streaming version sends the same ops
+			in := make(chan txnbuild.Operation, len(ops))
+			out := make(chan txnbuild.Operation, len(ops))
+			for _, op := range ops {
+				in <- op
+			}
+			close(in)
+			e = f.ApplyStream(in, out, []hProtocol.Offer{}, []hProtocol.Offer{})
+			close(out)
+			if k.wantErr {
+				assert.Error(t, e)
+				return
+			}
+			if !assert.NoError(t, e) {
+				return
+			}
+			actualOps := []txnbuild.Operation{}
+			for op := range out {
+				actualOps = append(actualOps, op)
+			}
+			assert.Equal(t, k.wantOps, actualOps)
+		})
+	}
+
+	config := makeRawVolumeFilterConfig(pointy.Float64(1.0), nil, volumeFilterModeExact, []string{}, []string{})
+	config.OnQueryErrorBehavior = "dropAll"
+	assert.Error(t, config.Validate())
+	config.OnQueryErrorBehavior = QueryErrorBehaviorAllowAll
+	config.QueryCircuitBreaker = &QueryCircuitBreakerConfig{FailureThreshold: 3}
+	assert.Error(t, config.Validate())
+	config.OnQueryErrorBehavior = QueryErrorBehaviorFail
+	assert.NoError(t, config.Validate())
+}
+
+func TestVolumeFilterApplyMaxBatchVolume(t *testing.T) {
+	testCases := []struct {
+		name        string
+		mode        volumeFilterMode
+		dailyCap    float64
+		wantAmounts []string
+	}{
+		{
+			name:        "batch limit binds before daily cap, exact mode",
+			mode:        volumeFilterModeExact,
+			dailyCap:    100.0,
+			wantAmounts: []string{"4.0", "2.0000000"},
+		}, {
+			name:        "batch limit binds before daily cap, ignore mode",
+			mode:        volumeFilterModeIgnore,
+			dailyCap:    100.0,
+			wantAmounts: []string{"4.0"},
+		}, {
+			name:        "daily cap binds before batch limit, exact mode",
+			mode:        volumeFilterModeExact,
+			dailyCap:    15.0,
+			wantAmounts: []string{"4.0", "1.0000000"},
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			config := makeRawVolumeFilterConfig(pointy.Float64(k.dailyCap), nil, k.mode, []string{}, []string{})
+			config.MaxBatchVolume = pointy.Float64(6.0)
+			if !assert.NoError(t, config.Validate()) {
+				return
+			}
+			query := &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 10.0, QuoteVol: 20.0}}
+			f := makeTestVolumeFilter(config, query)
+
+			ops := []txnbuild.Operation{
+				makeManageSellOffer("2.0", "4.0"),
+				makeManageSellOffer("2.0", "4.0"),
+				makeManageSellOffer("2.0", "4.0"),
+			}
+			actual, e := f.Apply(ops, []hProtocol.Offer{}, []hProtocol.Offer{})
+			if !assert.NoError(t, e) {
+				return
+			}
+
+			actualAmounts := []string{}
+			for _, op := range actual {
+				actualAmounts = append(actualAmounts, op.(*txnbuild.ManageSellOffer).Amount)
+			}
+			assert.Equal(t, k.wantAmounts, actualAmounts)
+		})
+	}
+
+	invalid := makeRawVolumeFilterConfig(pointy.Float64(1.0), nil, volumeFilterModeExact, []string{}, []string{})
+	invalid.MaxBatchVolume = pointy.Float64(0.0)
+	assert.Error(t, invalid.Validate())
+}
+
+func TestVolumeFilterApplyMinSellBaseAmount(t *testing.T) {
+	testCases := []struct {
+		name        string
+		otbBase     float64
+		ops         []txnbuild.Operation
+		wantAmounts []string
+	}{
+		{
+			name:        "trimmed amount is above the min",
+			otbBase:     95.0,
+			ops:         []txnbuild.Operation{makeManageSellOffer("2.0", "1.5"), makeManageSellOffer("2.0", "4.0")},
+			wantAmounts: []string{"1.5", "3.5000000"},
+		}, {
+			name:        "trimmed amount is below the min",
+			otbBase:     99.0,
+			ops:         []txnbuild.Operation{makeManageSellOffer("2.0", "4.0"), makeManageSellOffer("2.0", "0.5")},
+			wantAmounts: []string{"0.5"},
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			config := makeRawVolumeFilterConfig(pointy.Float64(100.0), nil, volumeFilterModeExact, []string{}, []string{})
+			config.MinSellBaseAmount = pointy.Float64(2.0)
+			if !assert.NoError(t, config.Validate()) {
+				return
+			}
+			f := makeTestVolumeFilter(config, &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: k.otbBase, QuoteVol: 2 * k.otbBase}})
+
+			actual, e := f.Apply(k.ops, []hProtocol.Offer{}, []hProtocol.Offer{})
+			if !assert.NoError(t, e) {
+				return
+			}
+			actualAmounts := []string{}
+			for _, op := range actual {
+				actualAmounts = append(actualAmounts, op.(*txnbuild.ManageSellOffer).Amount)
+			}
+			assert.Equal(t, k.wantAmounts, actualAmounts)
+		})
+	}
+
+	config := makeRawVolumeFilterConfig(pointy.Float64(100.0), nil, volumeFilterModeExact, []string{}, []string{})
+	config.MinSellBaseAmount = pointy.Float64(2.0)
+	f := makeTestVolumeFilter(config, &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 99.0, QuoteVol: 198.0}})
+	diffs, e := f.DryRun([]txnbuild.Operation{makeManageSellOffer("2.0", "4.0")})
+	if assert.NoError(t, e) && assert.Equal(t, 1, len(diffs)) {
+		assert.Equal(t, VolumeFilterOpActionDropped, diffs[0].Action)
+		assert.Equal(t, DropReasonMinSellBaseAmount, diffs[0].DropReason)
+	}
+
+	config.MinSellBaseAmount = pointy.Float64(-1.0)
+	assert.Error(t, config.Validate())
+}
+
+func TestVolumeFilterApplyDecisionHook(t *testing.T) {
+	testCases := []struct {
+		name        string
+		makeHook    func() DecisionHook
+		wantAmounts []string
+	}{
+		{
+			name: "nil hook",
+			makeHook: func() DecisionHook {
+				return nil
+			},
+			wantAmounts: []string{"4.0", "1.0000000"},
+		}, {
+			name: "hook vetoes the trim",
+			makeHook: func() DecisionHook {
+				return func(op *txnbuild.ManageSellOffer, proposed *VolumeFilterResult) *VolumeFilterResult {
+					if proposed.Op != nil && proposed.Op.Amount != op.Amount {
+						return &VolumeFilterResult{Op: op}
+					}
+					return nil
+				}
+			},
+			wantAmounts: []string{"4.0", "4.0"},
+		}, {
+			name: "hook forces a drop of the first op",
+			makeHook: func() DecisionHook {
+				calls := 0
+				return func(op *txnbuild.ManageSellOffer, proposed *VolumeFilterResult) *VolumeFilterResult {
+					calls++
+					if calls == 1 {
+						return &VolumeFilterResult{}
+					}
+					return nil
+				}
+			},
+			// the dropped op is removed from the TBB so the second op fits under the cap without a trim
+			wantAmounts: []string{"4.0"},
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			config := makeRawVolumeFilterConfig(pointy.Float64(15.0), nil, volumeFilterModeExact, []string{}, []string{})
+			config.DecisionHook = k.makeHook()
+			query := &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 10.0, QuoteVol: 20.0}}
+			f := makeTestVolumeFilter(config, query)
+
+			ops := []txnbuild.Operation{
+				makeManageSellOffer("2.0", "4.0"),
+				makeManageSellOffer("2.0", "4.0"),
+			}
+			actual, e := f.Apply(ops, []hProtocol.Offer{}, []hProtocol.Offer{})
+			if !assert.NoError(t, e) {
+				return
+			}
+
+			actualAmounts := []string{}
+			for _, op := range actual {
+				actualAmounts = append(actualAmounts, op.(*txnbuild.ManageSellOffer).Amount)
+			}
+			assert.Equal(t, k.wantAmounts, actualAmounts)
+		})
+	}
+}
+
+func TestVolumeFilterApplyAlertCaps(t *testing.T) {
+	config := makeRawVolumeFilterConfig(pointy.Float64(15.0), pointy.Float64(100.0), volumeFilterModeIgnore, []string{}, []string{})
+	config.AlertCapInBaseUnits = pointy.Float64(12.0)
+	config.AlertCapInQuoteUnits = pointy.Float64(50.0)
+	alerts := []VolumeCapAlert{}
+	config.OnAlert = func(alert VolumeCapAlert) {
+		alerts = append(alerts, alert)
+	}
+	if !assert.NoError(t, config.Validate()) {
+		return
+	}
+	f := makeTestVolumeFilter(config, &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 10.0, QuoteVol: 20.0}})
+
+	// the second op crosses the base alert cap (13 > 12) but the ops are untouched
+	ops := []txnbuild.Operation{
+		makeManageSellOffer("2.0", "1.0"),
+		makeManageSellOffer("2.0", "2.0"),
+	}
+	actual, e := f.Apply(ops, []hProtocol.Offer{}, []hProtocol.Offer{})
+	if !assert.NoError(t, e) {
+		return
+	}
+	assert.Equal(t, ops, actual)
+	if !assert.Equal(t, 1, len(alerts)) {
+		return
+	}
+	assert.Equal(t, "base", alerts[0].Units)
+	assert.Equal(t, 12.0, alerts[0].AlertCap)
+	assert.InDelta(t, 13.0, alerts[0].ProjectedSold, 1e-9)
+
+	// the first op crosses the base alert cap again (a new batch alerts again) and the second op crosses the enforcing cap (17 > 15) so
+	// it is dropped without an alert for the quote cap
+	alerts = []VolumeCapAlert{}
+	actual, e = f.Apply([]txnbuild.Operation{
+		makeManageSellOffer("2.0", "3.0"),
+		makeManageSellOffer("2.0", "4.0"),
+	}, []hProtocol.Offer{}, []hProtocol.Offer{})
+	if !assert.NoError(t, e) {
+		return
+	}
+	if assert.Equal(t, 1, len(actual)) {
+		assert.Equal(t, "3.0", actual[0].(*txnbuild.ManageSellOffer).Amount)
+	}
+	if assert.Equal(t, 1, len(alerts)) {
+		assert.Equal(t, "base", alerts[0].Units)
+	}
+}
+
+func TestVolumeFilterApplyOnCapHit(t *testing.T) {
+	type capHit struct {
+		marketID  string
+		cap       string
+		projected float64
+		limit     float64
+	}
+	hits := []capHit{}
+	config := makeRawVolumeFilterConfig(pointy.Float64(15.0), pointy.Float64(30.0), volumeFilterModeExact, []string{}, []string{})
+	config.OnCapHit = func(marketID string, cap string, projected float64, limit float64) {
+		hits = append(hits, capHit{marketID: marketID, cap: cap, projected: projected, limit: limit})
+	}
+	f := makeTestVolumeFilter(config, &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 10.0, QuoteVol: 20.0}})
+	f.marketID = "market1"
+
+	// the first op is within the caps, the second op hits the base cap and is trimmed to 3.0 which is exactly at the quote cap, and the
+	// third op hits both caps and is dropped
+	actual, e := f.Apply([]txnbuild.Operation{
+		makeManageSellOffer("2.0", "2.0"),
+		makeManageSellOffer("2.0", "4.0"),
+		makeManageSellOffer("2.0", "1.0"),
+	}, []hProtocol.Offer{}, []hProtocol.Offer{})
+	if !assert.NoError(t, e) {
+		return
+	}
+	assert.Equal(t, 2, len(actual))
+	if !assert.Equal(t, 3, len(hits)) {
+		return
+	}
+	assert.Equal(t, capHit{marketID: "market1", cap: "base", projected: 16.0, limit: 15.0}, hits[0])
+	assert.Equal(t, capHit{marketID: "market1", cap: "base", projected: 16.0, limit: 15.0}, hits[1])
+	assert.Equal(t, "quote", hits[2].cap)
+	assert.InDelta(t, 32.0, hits[2].projected, 1e-9)
+	assert.Equal(t, 30.0, hits[2].limit)
+}
+
+func TestVolumeFilterConfigValidateAlertCaps(t *testing.T) {
+	testCases := []struct {
+		name       string
+		alertBase  *float64
+		alertQuote *float64
+		wantErr    bool
+	}{
+		{name: "below the enforcing caps", alertBase: pointy.Float64(5.0), alertQuote: pointy.Float64(50.0), wantErr: false},
+		{name: "equal to the enforcing cap", alertBase: pointy.Float64(10.0), wantErr: false},
+		// there is no enforcing quote cap
+		{name: "quote alert cap without an enforcing cap", alertQuote: pointy.Float64(500.0), wantErr: false},
+		{name: "above the enforcing cap", alertBase: pointy.Float64(11.0), wantErr: true},
+		{name: "zero", alertBase: pointy.Float64(0.0), wantErr: true},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			config := makeRawVolumeFilterConfig(pointy.Float64(10.0), nil, volumeFilterModeExact, []string{}, []string{})
+			config.AlertCapInBaseUnits = k.alertBase
+			config.AlertCapInQuoteUnits = k.alertQuote
+			e := config.Validate()
+			if k.wantErr {
+				assert.Error(t, e)
+			} else {
+				assert.NoError(t, e)
+			}
+		})
+	}
+}
+
+// recordingLogger is a logger.Logger that records the formatted entries
+type recordingLogger struct {
+	entries []string
+}
+
+var _ logger.Logger = &recordingLogger{}
+
+func (l *recordingLogger) Info(msg string) {
+	l.entries = append(l.entries, msg)
+}
+
+func (l *recordingLogger) Infof(msg string, args ...interface{}) {
+	l.entries = append(l.entries, fmt.Sprintf(msg, args...))
+}
+
+func (l *recordingLogger) Error(msg string) {
+	l.entries = append(l.entries, msg)
+}
+
+func (l *recordingLogger) Errorf(msg string, args ...interface{}) {
+	l.entries = append(l.entries, fmt.Sprintf(msg, args...))
+}
+
+func TestVolumeFilterApplyTraceOps(t *testing.T) {
+	testCases := []struct {
+		name        string
+		traceOps    bool
+		wantEntries []string
+	}{
+		{
+			name:        "off by default",
+			traceOps:    false,
+			wantEntries: nil,
+		}, {
+			name:     "traces the trimmed op only",
+			traceOps: true,
+			wantEntries: []string{
+				"volumeFilter: trace: op modified (dropReason=), " +
+					"before: ManageSellOffer[offerID=5, price=2.0, amount=4.0, selling=native, buying=native, sourceAccount=GSOURCE], " +
+					"after: ManageSellOffer[offerID=5, price=2.0, amount=2.0000000, selling=native, buying=native, sourceAccount=GSOURCE]",
+			},
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			traceLogger := &recordingLogger{}
+			config := makeRawVolumeFilterConfig(pointy.Float64(15.0), nil, volumeFilterModeExact, []string{}, []string{})
+			config.TraceOps = k.traceOps
+			config.TraceLogger = traceLogger
+			f := makeTestVolumeFilter(config, &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 10.0, QuoteVol: 20.0}})
+
+			ops := []txnbuild.Operation{}
+			for i, amount := range []string{"3.0", "4.0"} {
+				op := makeManageSellOffer("2.0", amount)
+				op.OfferID = int64(4 + i)
+				op.SourceAccount = &txnbuild.SimpleAccount{AccountID: "GSOURCE"}
+				ops = append(ops, op)
+			}
+			sellingOffers := []hProtocol.Offer{}
+			for _, op := range ops {
+				sellingOffers = append(sellingOffers, makeSellingOffer(op.(*txnbuild.ManageSellOffer).OfferID, 2, 1, "1.0"))
+			}
+			_, e := f.Apply(ops, sellingOffers, []hProtocol.Offer{})
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.Equal(t, k.wantEntries, traceLogger.entries)
+		})
+	}
+}
+
+func TestVolumeFilterFnQuoteFeeRate(t *testing.T) {
+	testCases := []struct {
+		name         string
+		mode         volumeFilterMode
+		quoteFeeRate float64
+		wantOp       *txnbuild.ManageSellOffer
+		wantTbbBase  float64
+		wantTbbQuote float64
+	}{
+		{
+			name:         "fee-exclusive at the cap boundary",
+			mode:         volumeFilterModeExact,
+			quoteFeeRate: 0.0,
+			wantOp:       makeManageSellOffer("2.0", "5.0"),
+			wantTbbBase:  5.0,
+			wantTbbQuote: 10.0,
+		}, {
+			name:         "fee-inclusive crosses the cap boundary, exact mode",
+			mode:         volumeFilterModeExact,
+			quoteFeeRate: 0.01,
+			wantOp:       makeManageSellOffer("2.0", "4.9504950"),
+			wantTbbBase:  4.9504950,
+			wantTbbQuote: 9.9999999,
+		}, {
+			name:         "fee-inclusive crosses the cap boundary, ignore mode",
+			mode:         volumeFilterModeIgnore,
+			quoteFeeRate: 0.01,
+			wantOp:       nil,
+			wantTbbBase:  0.0,
+			wantTbbQuote: 0.0,
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			dailyOTB := makeRawVolumeFilterConfig(pointy.Float64(0.0), pointy.Float64(0.0), k.mode, []string{}, []string{})
+			dailyTBBAccumulator := makeRawVolumeFilterConfig(pointy.Float64(0.0), pointy.Float64(0.0), k.mode, []string{}, []string{})
+			lp := limitParameters{
+				sellBaseAssetCapInBaseUnits:  nil,
+				sellBaseAssetCapInQuoteUnits: pointy.Float64(10.0),
+				mode:                         k.mode,
+				quoteFeeRate:                 k.quoteFeeRate,
+			}
+
+			actual, e := volumeFilterFn(dailyOTB, dailyTBBAccumulator, &VolumeFilterConfig{}, makeManageSellOffer("2.0", "5.0"), utils.NativeAsset, utils.NativeAsset, lp)
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.Equal(t, k.wantOp, actual.Op)
+			assert.InDelta(t, k.wantTbbBase, *dailyTBBAccumulator.SellBaseAssetCapInBaseUnits, 1e-7)
+			assert.InDelta(t, k.wantTbbQuote, *dailyTBBAccumulator.SellBaseAssetCapInQuoteUnits, 1e-6)
+		})
+	}
+
+	invalid := makeRawVolumeFilterConfig(nil, pointy.Float64(10.0), volumeFilterModeExact, []string{}, []string{})
+	invalid.QuoteCapFeeRate = pointy.Float64(-0.01)
+	assert.Error(t, invalid.Validate())
+}
+
+func TestVolumeFilterFnDropReason(t *testing.T) {
+	buyOp := &txnbuild.ManageSellOffer{
+		Selling: txnbuild.NativeAsset{},
+		Buying:  txnbuild.CreditAsset{Code: "USD", Issuer: "GBAA4Z4QG5JOTMPF4GNDI6NLNZLBKIDO3JKFCYR4HMUNRKT2CIJLVZTF"},
+		Price:   "2.0",
+		Amount:  "5.0",
+	}
+	quoteAsset := hProtocol.Asset{Type: "credit_alphanum4", Code: "USD", Issuer: "GBAA4Z4QG5JOTMPF4GNDI6NLNZLBKIDO3JKFCYR4HMUNRKT2CIJLVZTF"}
+
+	testCases := []struct {
+		name           string
+		op             *txnbuild.ManageSellOffer
+		baseAsset      hProtocol.Asset
+		quoteAsset     hProtocol.Asset
+		lp             limitParameters
+		wantKept       bool
+		wantDropReason VolumeFilterDropReason
+	}{
+		{
+			name:           "kept",
+			op:             makeManageSellOffer("2.0", "5.0"),
+			baseAsset:      utils.NativeAsset,
+			quoteAsset:     utils.NativeAsset,
+			lp:             limitParameters{sellBaseAssetCapInBaseUnits: pointy.Float64(10.0), mode: volumeFilterModeIgnore},
+			wantKept:       true,
+			wantDropReason: DropReasonNone,
+		}, {
+			name:           "base cap",
+			op:             makeManageSellOffer("2.0", "5.0"),
+			baseAsset:      utils.NativeAsset,
+			quoteAsset:     utils.NativeAsset,
+			lp:             limitParameters{sellBaseAssetCapInBaseUnits: pointy.Float64(1.0), sellBaseAssetCapInQuoteUnits: pointy.Float64(1.0), mode: volumeFilterModeIgnore},
+			wantDropReason: DropReasonBaseCap,
+		}, {
+			name:           "quote cap",
+			op:             makeManageSellOffer("2.0", "5.0"),
+			baseAsset:      utils.NativeAsset,
+			quoteAsset:     utils.NativeAsset,
+			lp:             limitParameters{sellBaseAssetCapInBaseUnits: pointy.Float64(10.0), sellBaseAssetCapInQuoteUnits: pointy.Float64(1.0), mode: volumeFilterModeIgnore},
+			wantDropReason: DropReasonQuoteCap,
+		}, {
+			name:           "quote cap with nothing left in exact mode",
+			op:             makeManageSellOffer("2.0", "5.0"),
+			baseAsset:      utils.NativeAsset,
+			quoteAsset:     utils.NativeAsset,
+			lp:             limitParameters{sellBaseAssetCapInQuoteUnits: pointy.Float64(0.0), mode: volumeFilterModeExact},
+			wantDropReason: DropReasonQuoteCap,
+		}, {
+			name:           "max batch volume",
+			op:             makeManageSellOffer("2.0", "5.0"),
+			baseAsset:      utils.NativeAsset,
+			quoteAsset:     utils.NativeAsset,
+			lp:             limitParameters{sellBaseAssetCapInBaseUnits: pointy.Float64(10.0), maxBatchVolumeInBaseUnits: pointy.Float64(1.0), mode: volumeFilterModeIgnore},
+			wantDropReason: DropReasonMaxBatchVolume,
+		}, {
+			name:           "buy side",
+			op:             buyOp,
+			baseAsset:      quoteAsset,
+			quoteAsset:     utils.NativeAsset,
+			lp:             limitParameters{sellBaseAssetCapInBaseUnits: pointy.Float64(10.0), mode: volumeFilterModeIgnore},
+			wantDropReason: DropReasonBuySide,
+		}, {
+			name:           "buy base cap",
+			op:             buyOp,
+			baseAsset:      quoteAsset,
+			quoteAsset:     utils.NativeAsset,
+			lp:             limitParameters{buyBaseAssetCapInBaseUnits: pointy.Float64(1.0), mode: volumeFilterModeIgnore},
+			wantDropReason: DropReasonBuyBaseCap,
+		}, {
+			name:           "buy quote cap",
+			op:             buyOp,
+			baseAsset:      quoteAsset,
+			quoteAsset:     utils.NativeAsset,
+			lp:             limitParameters{buyBaseAssetCapInQuoteUnits: pointy.Float64(1.0), mode: volumeFilterModeIgnore},
+			wantDropReason: DropReasonBuyQuoteCap,
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			dailyOTB := makeRawVolumeFilterConfig(pointy.Float64(0.0), pointy.Float64(0.0), k.lp.mode, []string{}, []string{})
+			dailyOTB.BuyBaseAssetCapInBaseUnits = pointy.Float64(0.0)
+			dailyOTB.BuyBaseAssetCapInQuoteUnits = pointy.Float64(0.0)
+			dailyTBBAccumulator := makeRawVolumeFilterConfig(pointy.Float64(0.0), pointy.Float64(0.0), k.lp.mode, []string{}, []string{})
+			dailyBuyTBBAccumulator := &VolumeFilterConfig{
+				BuyBaseAssetCapInBaseUnits:  pointy.Float64(0.0),
+				BuyBaseAssetCapInQuoteUnits: pointy.Float64(0.0),
+			}
+
+			actual, e := volumeFilterFn(dailyOTB, dailyTBBAccumulator, dailyBuyTBBAccumulator, k.op, k.baseAsset, k.quoteAsset, k.lp)
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.Equal(t, k.wantKept, actual.Op != nil)
+			assert.Equal(t, k.wantDropReason, actual.DropReason)
+		})
+	}
+}
+
+// makeBuyOp makes an op that buys the USD base asset by selling the native quote asset, where the amount is in quote units and the price is
+// in base units per quote unit
+func makeBuyOp(price string, amount string) *txnbuild.ManageSellOffer {
+	return &txnbuild.ManageSellOffer{
+		Selling: txnbuild.NativeAsset{},
+		Buying:  txnbuild.CreditAsset{Code: "USD", Issuer: "GBAA4Z4QG5JOTMPF4GNDI6NLNZLBKIDO3JKFCYR4HMUNRKT2CIJLVZTF"},
+		Price:   price,
+		Amount:  amount,
+	}
+}
+
+var testBuyBaseAsset = hProtocol.Asset{Type: "credit_alphanum4", Code: "USD", Issuer: "GBAA4Z4QG5JOTMPF4GNDI6NLNZLBKIDO3JKFCYR4HMUNRKT2CIJLVZTF"}
+
+func TestVolumeFilterFnBuyBaseCap(t *testing.T) {
+	testCases := []struct {
+		name           string
+		mode           volumeFilterMode
+		buyCapInBase   float64
+		otbBuyBase     float64
+		tbbBuyBase     float64
+		inputOp        *txnbuild.ManageSellOffer
+		wantOp         *txnbuild.ManageSellOffer
+		wantDropReason VolumeFilterDropReason
+		wantTbbBuyBase float64
+	}{
+		{
+			name:           "keep buying base, exact mode",
+			mode:           volumeFilterModeExact,
+			buyCapInBase:   20.0,
+			otbBuyBase:     5.0,
+			tbbBuyBase:     0.0,
+			inputOp:        makeBuyOp("2.0", "5.0"),
+			wantOp:         makeBuyOp("2.0", "5.0"),
+			wantTbbBuyBase: 10.0,
+		}, {
+			name:           "trim buying base, exact mode",
+			mode:           volumeFilterModeExact,
+			buyCapInBase:   20.0,
+			otbBuyBase:     10.0,
+			tbbBuyBase:     6.0,
+			inputOp:        makeBuyOp("2.0", "5.0"),
+			wantOp:         makeBuyOp("2.0", "2.0000000"),
+			wantTbbBuyBase: 10.0,
+		}, {
+			name:           "nothing left to buy, exact mode",
+			mode:           volumeFilterModeExact,
+			buyCapInBase:   20.0,
+			otbBuyBase:     15.0,
+			tbbBuyBase:     5.0,
+			inputOp:        makeBuyOp("2.0", "5.0"),
+			wantOp:         nil,
+			wantDropReason: DropReasonBuyBaseCap,
+			wantTbbBuyBase: 5.0,
+		}, {
+			name:           "keep buying base, ignore mode",
+			mode:           volumeFilterModeIgnore,
+			buyCapInBase:   20.0,
+			otbBuyBase:     10.0,
+			tbbBuyBase:     0.0,
+			inputOp:        makeBuyOp("2.0", "5.0"),
+			wantOp:         makeBuyOp("2.0", "5.0"),
+			wantTbbBuyBase: 10.0,
+		}, {
+			name:           "don't keep buying base, ignore mode",
+			mode:           volumeFilterModeIgnore,
+			buyCapInBase:   20.0,
+			otbBuyBase:     10.0,
+			tbbBuyBase:     6.0,
+			inputOp:        makeBuyOp("2.0", "5.0"),
+			wantOp:         nil,
+			wantDropReason: DropReasonBuyBaseCap,
+			wantTbbBuyBase: 6.0,
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			dailyOTB := makeRawVolumeFilterConfig(pointy.Float64(0.0), pointy.Float64(0.0), k.mode, []string{}, []string{})
+			dailyOTB.BuyBaseAssetCapInBaseUnits = pointy.Float64(k.otbBuyBase)
+			dailyOTB.BuyBaseAssetCapInQuoteUnits = pointy.Float64(0.0)
+			dailyTBBAccumulator := makeRawVolumeFilterConfig(pointy.Float64(0.0), pointy.Float64(0.0), k.mode, []string{}, []string{})
+			dailyBuyTBBAccumulator := &VolumeFilterConfig{
+				BuyBaseAssetCapInBaseUnits:  pointy.Float64(k.tbbBuyBase),
+				BuyBaseAssetCapInQuoteUnits: pointy.Float64(0.0),
+			}
+			lp := limitParameters{
+				buyBaseAssetCapInBaseUnits: pointy.Float64(k.buyCapInBase),
+				mode:                       k.mode,
+			}
+
+			actual, e := volumeFilterFn(dailyOTB, dailyTBBAccumulator, dailyBuyTBBAccumulator, k.inputOp, testBuyBaseAsset, utils.NativeAsset, lp)
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.Equal(t, k.wantOp, actual.Op)
+			assert.Equal(t, k.wantDropReason, actual.DropReason)
+			assert.InDelta(t, k.wantTbbBuyBase, *dailyBuyTBBAccumulator.BuyBaseAssetCapInBaseUnits, 1e-9)
+			// the sell side is unaffected
+			assert.Equal(t, 0.0, *dailyTBBAccumulator.SellBaseAssetCapInBaseUnits)
+		})
+	}
+}
+
+func TestVolumeFilterFnBuyQuoteCap(t *testing.T) {
+	testCases := []struct {
+		name            string
+		mode            volumeFilterMode
+		buyCapInBase    *float64
+		buyCapInQuote   float64
+		otbBuyQuote     float64
+		tbbBuyQuote     float64
+		inputOp         *txnbuild.ManageSellOffer
+		wantOp          *txnbuild.ManageSellOffer
+		wantDropReason  VolumeFilterDropReason
+		wantTbbBuyBase  float64
+		wantTbbBuyQuote float64
+	}{
+		{
+			name:            "keep buying quote, exact mode",
+			mode:            volumeFilterModeExact,
+			buyCapInQuote:   10.0,
+			otbBuyQuote:     3.0,
+			tbbBuyQuote:     0.0,
+			inputOp:         makeBuyOp("2.0", "5.0"),
+			wantOp:          makeBuyOp("2.0", "5.0"),
+			wantTbbBuyBase:  10.0,
+			wantTbbBuyQuote: 5.0,
+		}, {
+			name:            "trim buying quote, exact mode",
+			mode:            volumeFilterModeExact,
+			buyCapInQuote:   10.0,
+			otbBuyQuote:     5.0,
+			tbbBuyQuote:     2.0,
+			inputOp:         makeBuyOp("2.0", "5.0"),
+			wantOp:          makeBuyOp("2.0", "3.0000000"),
+			wantTbbBuyBase:  6.0,
+			wantTbbBuyQuote: 5.0,
+		}, {
+			name:            "nothing left to spend, exact mode",
+			mode:            volumeFilterModeExact,
+			buyCapInQuote:   10.0,
+			otbBuyQuote:     8.0,
+			tbbBuyQuote:     2.0,
+			inputOp:         makeBuyOp("2.0", "5.0"),
+			wantOp:          nil,
+			wantDropReason:  DropReasonBuyQuoteCap,
+			wantTbbBuyBase:  0.0,
+			wantTbbBuyQuote: 2.0,
+		}, {
+			name:            "don't keep buying quote, ignore mode",
+			mode:            volumeFilterModeIgnore,
+			buyCapInQuote:   10.0,
+			otbBuyQuote:     5.0,
+			tbbBuyQuote:     2.0,
+			inputOp:         makeBuyOp("2.0", "5.0"),
+			wantOp:          nil,
+			wantDropReason:  DropReasonBuyQuoteCap,
+			wantTbbBuyBase:  0.0,
+			wantTbbBuyQuote: 2.0,
+		}, {
+			name:            "base cap trims before quote cap, exact mode",
+			mode:            volumeFilterModeExact,
+			buyCapInBase:    pointy.Float64(4.0),
+			buyCapInQuote:   10.0,
+			otbBuyQuote:     5.0,
+			tbbBuyQuote:     2.0,
+			inputOp:         makeBuyOp("2.0", "5.0"),
+			wantOp:          makeBuyOp("2.0", "2.0000000"),
+			wantTbbBuyBase:  4.0,
+			wantTbbBuyQuote: 4.0,
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			dailyOTB := makeRawVolumeFilterConfig(pointy.Float64(0.0), pointy.Float64(0.0), k.mode, []string{}, []string{})
+			dailyOTB.BuyBaseAssetCapInBaseUnits = pointy.Float64(0.0)
+			dailyOTB.BuyBaseAssetCapInQuoteUnits = pointy.Float64(k.otbBuyQuote)
+			dailyTBBAccumulator := makeRawVolumeFilterConfig(pointy.Float64(0.0), pointy.Float64(0.0), k.mode, []string{}, []string{})
+			dailyBuyTBBAccumulator := &VolumeFilterConfig{
+				BuyBaseAssetCapInBaseUnits:  pointy.Float64(0.0),
+				BuyBaseAssetCapInQuoteUnits: pointy.Float64(k.tbbBuyQuote),
+			}
+			lp := limitParameters{
+				buyBaseAssetCapInBaseUnits:  k.buyCapInBase,
+				buyBaseAssetCapInQuoteUnits: pointy.Float64(k.buyCapInQuote),
+				mode:                        k.mode,
+			}
+
+			actual, e := volumeFilterFn(dailyOTB, dailyTBBAccumulator, dailyBuyTBBAccumulator, k.inputOp, testBuyBaseAsset, utils.NativeAsset, lp)
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.Equal(t, k.wantOp, actual.Op)
+			assert.Equal(t, k.wantDropReason, actual.DropReason)
+			assert.InDelta(t, k.wantTbbBuyBase, *dailyBuyTBBAccumulator.BuyBaseAssetCapInBaseUnits, 1e-9)
+			assert.InDelta(t, k.wantTbbBuyQuote, *dailyBuyTBBAccumulator.BuyBaseAssetCapInQuoteUnits, 1e-9)
+			// the sell side is unaffected
+			assert.Equal(t, 0.0, *dailyTBBAccumulator.SellBaseAssetCapInBaseUnits)
+			assert.Equal(t, 0.0, *dailyTBBAccumulator.SellBaseAssetCapInQuoteUnits)
+		})
+	}
+}
+
+func TestVolumeFilterApplyBuyBaseCap(t *testing.T) {
+	config := makeRawVolumeFilterConfig(pointy.Float64(100.0), nil, volumeFilterModeExact, []string{}, []string{})
+	config.BuyBaseAssetCapInBaseUnits = pointy.Float64(20.0)
+	if !assert.NoError(t, config.Validate()) {
+		return
+	}
+	f := makeTestVolumeFilter(config, &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 0.0, QuoteVol: 0.0}})
+	f.baseAsset = testBuyBaseAsset
+	buyQuery := &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 12.0, QuoteVol: 6.0}}
+	f.dailyBuyVolumeByDateQuery = buyQuery
+
+	// 12 base was bought today so the first op (buying 6 base) is kept and the second op (buying 4 base) is trimmed to buy 2 base
+	actual, e := f.Apply([]txnbuild.Operation{makeBuyOp("2.0", "3.0"), makeBuyOp("2.0", "2.0")}, []hProtocol.Offer{}, []hProtocol.Offer{})
+	if !assert.NoError(t, e) {
+		return
+	}
+	assert.Equal(t, []txnbuild.Operation{makeBuyOp("2.0", "3.0"), makeBuyOp("2.0", "1.0000000")}, actual)
+	assert.Equal(t, 1, len(buyQuery.calledArgs))
+
+	// a failure of the buy query fails the batch
+	buyQuery.err = fmt.Errorf("buy query failed")
+	_, e = f.Apply([]txnbuild.Operation{makeBuyOp("2.0", "3.0")}, []hProtocol.Offer{}, []hProtocol.Offer{})
+	assert.Error(t, e)
+
+	config.CustomVolumeSQL = "SELECT 1.0, 2.0 WHERE $1 = $1"
+	assert.Error(t, config.Validate())
+}
+
+func TestVolumeFilterApplyInterleavedBuyAndSell(t *testing.T) {
+	config := makeRawVolumeFilterConfig(pointy.Float64(100.0), nil, volumeFilterModeExact, []string{}, []string{})
+	config.BuyBaseAssetCapInBaseUnits = pointy.Float64(20.0)
+	if !assert.NoError(t, config.Validate()) {
+		return
+	}
+	f := makeTestVolumeFilter(config, &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 90.0, QuoteVol: 180.0}})
+	f.baseAsset = testBuyBaseAsset
+	f.dailyBuyVolumeByDateQuery = &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 12.0, QuoteVol: 6.0}}
+	makeSellOp := func(price string, amount string) *txnbuild.ManageSellOffer {
+		return &txnbuild.ManageSellOffer{
+			Selling: txnbuild.CreditAsset{Code: "USD", Issuer: "GBAA4Z4QG5JOTMPF4GNDI6NLNZLBKIDO3JKFCYR4HMUNRKT2CIJLVZTF"},
+			Buying:  txnbuild.NativeAsset{},
+			Price:   price,
+			Amount:  amount,
+		}
+	}
+
+	// each side is trimmed against its own cap and the ops of the other side do not count towards it
+	actual, batch, e := f.apply([]txnbuild.Operation{
+		makeSellOp("2.0", "5.0"),
+		makeBuyOp("2.0", "3.0"),
+		makeSellOp("2.0", "10.0"),
+		makeBuyOp("2.0", "2.0"),
+	}, []hProtocol.Offer{}, []hProtocol.Offer{})
+	if !assert.NoError(t, e) {
+		return
+	}
+	assert.Equal(t, []txnbuild.Operation{
+		makeSellOp("2.0", "5.0"),
+		makeBuyOp("2.0", "3.0"),
+		makeSellOp("2.0", "5.0000000"),
+		makeBuyOp("2.0", "1.0000000"),
+	}, actual)
+	assert.InDelta(t, 10.0, *batch.dailyTBB.SellBaseAssetCapInBaseUnits, 1e-7)
+	assert.InDelta(t, 20.0, *batch.dailyTBB.SellBaseAssetCapInQuoteUnits, 1e-7)
+	assert.InDelta(t, 8.0, *batch.dailyBuyTBB.BuyBaseAssetCapInBaseUnits, 1e-7)
+	assert.InDelta(t, 4.0, *batch.dailyBuyTBB.BuyBaseAssetCapInQuoteUnits, 1e-7)
+	assert.Nil(t, batch.dailyTBB.BuyBaseAssetCapInBaseUnits)
+	assert.Nil(t, batch.dailyBuyTBB.SellBaseAssetCapInBaseUnits)
+}
+
+func TestSummarizeDropReasons(t *testing.T) {
+	assert.Equal(t, "[]", summarizeDropReasons(map[VolumeFilterDropReason]int{}))
+	assert.Equal(t, "[base-cap=2, max-batch-volume=1, quote-cap=3]", summarizeDropReasons(map[VolumeFilterDropReason]int{
+		DropReasonQuoteCap:       3,
+		DropReasonBaseCap:        2,
+		DropReasonMaxBatchVolume: 1,
+	}))
+}
+
+func TestVolumeFilterApplyResetUTCOffset(t *testing.T) {
+	testCases := []struct {
+		name     string
+		now      string
+		offset   time.Duration
+		wantDate string
+	}{
+		{
+			name:     "no offset",
+			now:      "2020-01-21T23:30:00Z",
+			offset:   0,
+			wantDate: "2020/01/21",
+		}, {
+			name:     "positive offset crosses midnight",
+			now:      "2020-01-21T23:30:00Z",
+			offset:   2 * time.Hour,
+			wantDate: "2020/01/22",
+		}, {
+			name:     "negative offset crosses midnight",
+			now:      "2020-01-21T03:30:00Z",
+			offset:   -5 * time.Hour,
+			wantDate: "2020/01/20",
+		}, {
+			name:     "offset that does not cross midnight",
+			now:      "2020-01-21T12:00:00Z",
+			offset:   -5 * time.Hour,
+			wantDate: "2020/01/21",
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			config := makeRawVolumeFilterConfig(pointy.Float64(100.0), nil, volumeFilterModeExact, []string{}, []string{})
+			config.ResetUTCOffset = k.offset
+			if !assert.NoError(t, config.Validate()) {
+				return
+			}
+			query := &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 0.0, QuoteVol: 0.0}}
+			f := makeTestVolumeFilter(config, query)
+			now, _ := time.Parse(time.RFC3339, k.now)
+			f.nowFn = func() time.Time { return now }
+
+			_, e := f.Apply([]txnbuild.Operation{makeManageSellOffer("2.0", "5.0")}, []hProtocol.Offer{}, []hProtocol.Offer{})
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.Equal(t, [][]interface{}{{k.wantDate}}, query.calledArgs)
+		})
+	}
+
+	// the query buckets the trades by the same shifted day
+	testAssetDisplayFn := model.MakeSdexMappedAssetDisplayFn(map[model.Asset]hProtocol.Asset{model.Asset("XLM"): utils.NativeAsset})
+	tradingPair := &model.TradingPair{Base: "XLM", Quote: "XLM"}
+	for _, offset := range []time.Duration{2 * time.Hour, -5 * time.Hour} {
+		config := makeRawVolumeFilterConfig(pointy.Float64(100.0), nil, volumeFilterModeExact, []string{}, []string{})
+		config.ResetUTCOffset = offset
+		db := &sql.DB{}
+		actual, e := makeFilterVolume("", "exchange 1", tradingPair, testAssetDisplayFn, utils.NativeAsset, utils.NativeAsset, db, config)
+		if !assert.NoError(t, e) {
+			return
+		}
+		wantQuery, e := queries.MakeDailyVolumeByShiftedDateForMarketIdsAction(db, []string{"6d9862b0e2"}, "sell", []string{}, nil, -offset, false)
+		if !assert.NoError(t, e) {
+			return
+		}
+		assert.Equal(t, wantQuery, actual.(*volumeFilter).dailyVolumeByDateQuery)
+	}
+
+	for _, offset := range []time.Duration{15 * time.Hour, -15 * time.Hour} {
+		invalid := makeRawVolumeFilterConfig(pointy.Float64(100.0), nil, volumeFilterModeExact, []string{}, []string{})
+		invalid.ResetUTCOffset = offset
+		assert.Error(t, invalid.Validate())
+	}
+}
+
+func TestVolumeFilterApplyWindowDuration(t *testing.T) {
+	now, _ := time.Parse(time.RFC3339, "2020-01-21T03:30:00Z")
+	testCases := []struct {
+		name          string
+		window        time.Duration
+		wantQueryArgs [][]interface{}
+	}{
+		{
+			name:          "zero window uses the calendar day",
+			window:        0,
+			wantQueryArgs: [][]interface{}{{"2020/01/21"}},
+		}, {
+			name:          "trailing 24 hours",
+			window:        24 * time.Hour,
+			wantQueryArgs: [][]interface{}{{now.Add(-24 * time.Hour), now}},
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			config := makeRawVolumeFilterConfig(pointy.Float64(10.0), nil, volumeFilterModeExact, []string{}, []string{})
+			config.WindowDuration = k.window
+			if !assert.NoError(t, config.Validate()) {
+				return
+			}
+			query := &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 8.0, QuoteVol: 16.0}}
+			f := makeTestVolumeFilter(config, query)
+			f.nowFn = func() time.Time { return now }
+
+			actual, e := f.Apply([]txnbuild.Operation{makeManageSellOffer("2.0", "5.0")}, []hProtocol.Offer{}, []hProtocol.Offer{})
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.Equal(t, []txnbuild.Operation{makeManageSellOffer("2.0", "2.0000000")}, actual)
+			assert.Equal(t, k.wantQueryArgs, query.calledArgs)
+
+			_, _, e = f.RemainingCapForDate("2020/01/21")
+			assert.Equal(t, k.window > 0, e != nil)
+		})
+	}
+
+	for _, modify := range []func(c *VolumeFilterConfig){
+		func(c *VolumeFilterConfig) { c.WindowDuration = -time.Hour },
+		func(c *VolumeFilterConfig) { c.RollingTradeCount = 3 },
+		func(c *VolumeFilterConfig) { c.MinFillPriceToCount = pointy.Float64(1.0) },
+	} {
+		invalid := makeRawVolumeFilterConfig(pointy.Float64(10.0), nil, volumeFilterModeExact, []string{}, []string{})
+		invalid.WindowDuration = 24 * time.Hour
+		modify(invalid)
+		assert.Error(t, invalid.Validate())
+	}
+}
+
+func TestVolumeFilterApplyResetTimeUTC(t *testing.T) {
+	testCases := []struct {
+		name      string
+		now       string
+		resetTime time.Duration
+		wantDate  string
+	}{
+		{
+			name:      "before the reset time",
+			now:       "2020-01-21T21:30:00Z",
+			resetTime: 22 * time.Hour,
+			wantDate:  "2020/01/20",
+		}, {
+			name:      "at the reset time",
+			now:       "2020-01-21T22:00:00Z",
+			resetTime: 22 * time.Hour,
+			wantDate:  "2020/01/21",
+		}, {
+			name:      "after midnight UTC",
+			now:       "2020-01-22T01:00:00Z",
+			resetTime: 22 * time.Hour,
+			wantDate:  "2020/01/21",
+		}, {
+			name:      "wraps past 24h",
+			now:       "2020-01-21T01:00:00Z",
+			resetTime: 26 * time.Hour,
+			wantDate:  "2020/01/20",
+		}, {
+			name:      "wraps negative values",
+			now:       "2020-01-21T21:30:00Z",
+			resetTime: -2 * time.Hour,
+			wantDate:  "2020/01/20",
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			config := makeRawVolumeFilterConfig(pointy.Float64(100.0), nil, volumeFilterModeExact, []string{}, []string{})
+			config.ResetTimeUTC = k.resetTime
+			if !assert.NoError(t, config.Validate()) {
+				return
+			}
+			query := &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 0.0, QuoteVol: 0.0}}
+			f := makeTestVolumeFilter(config, query)
+			now, _ := time.Parse(time.RFC3339, k.now)
+			f.nowFn = func() time.Time { return now }
+
+			_, e := f.Apply([]txnbuild.Operation{makeManageSellOffer("2.0", "5.0")}, []hProtocol.Offer{}, []hProtocol.Offer{})
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.Equal(t, [][]interface{}{{k.wantDate}}, query.calledArgs)
+		})
+	}
+
+	// the query buckets the trades by the same shifted day
+	testAssetDisplayFn := model.MakeSdexMappedAssetDisplayFn(map[model.Asset]hProtocol.Asset{model.Asset("XLM"): utils.NativeAsset})
+	tradingPair := &model.TradingPair{Base: "XLM", Quote: "XLM"}
+	config := makeRawVolumeFilterConfig(pointy.Float64(100.0), nil, volumeFilterModeExact, []string{}, []string{})
+	config.ResetTimeUTC = 46 * time.Hour
+	db := &sql.DB{}
+	actual, e := makeFilterVolume("", "exchange 1", tradingPair, testAssetDisplayFn, utils.NativeAsset, utils.NativeAsset, db, config)
+	if !assert.NoError(t, e) {
+		return
+	}
+	wantQuery, e := queries.MakeDailyVolumeByShiftedDateForMarketIdsAction(db, []string{"6d9862b0e2"}, "sell", []string{}, nil, 22*time.Hour, false)
+	if !assert.NoError(t, e) {
+		return
+	}
+	assert.Equal(t, wantQuery, actual.(*volumeFilter).dailyVolumeByDateQuery)
+
+	for _, modify := range []func(c *VolumeFilterConfig){
+		func(c *VolumeFilterConfig) { c.ResetUTCOffset = time.Hour },
+		func(c *VolumeFilterConfig) { c.UseDatabaseTradingDate = true },
+		func(c *VolumeFilterConfig) { c.WindowDuration = 24 * time.Hour },
+	} {
+		invalid := makeRawVolumeFilterConfig(pointy.Float64(100.0), nil, volumeFilterModeExact, []string{}, []string{})
+		invalid.ResetTimeUTC = 22 * time.Hour
+		modify(invalid)
+		assert.Error(t, invalid.Validate())
+	}
+}
+
+// mockCurrentTradingDateQuery is an api.Query that returns a fixed trading date
+type mockCurrentTradingDateQuery struct {
+	result string
+	err    error
+}
+
+// Name impl.
+func (q *mockCurrentTradingDateQuery) Name() string {
+	return "mockCurrentTradingDateQuery"
+}
+
+// QueryRow impl.
+func (q *mockCurrentTradingDateQuery) QueryRow(args ...interface{}) (interface{}, error) {
+	if q.err != nil {
+		return nil, q.err
+	}
+	return q.result, nil
+}
+
+func TestVolumeFilterApplyDatabaseTradingDate(t *testing.T) {
+	testCases := []struct {
+		name          string
+		now           string
+		dbDate        string
+		dbErr         error
+		wantErr       bool
+		wantQueryArgs [][]interface{}
+	}{
+		{
+			name:          "database date matches the local clock",
+			now:           "2020-01-21T12:00:00Z",
+			dbDate:        "2020/01/21",
+			wantQueryArgs: [][]interface{}{{"2020/01/21"}},
+		}, {
+			name:          "database date is ahead of the local clock",
+			now:           "2020-01-21T23:59:30Z",
+			dbDate:        "2020/01/22",
+			wantQueryArgs: [][]interface{}{{"2020/01/22"}},
+		}, {
+			name:          "database date is behind the local clock",
+			now:           "2020-01-22T00:00:30Z",
+			dbDate:        "2020/01/21",
+			wantQueryArgs: [][]interface{}{{"2020/01/21"}},
+		}, {
+			name:    "database date query fails",
+			now:     "2020-01-21T12:00:00Z",
+			dbErr:   fmt.Errorf("connection refused"),
+			wantErr: true,
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			config := makeRawVolumeFilterConfig(pointy.Float64(100.0), nil, volumeFilterModeExact, []string{}, []string{})
+			config.UseDatabaseTradingDate = true
+			if !assert.NoError(t, config.Validate()) {
+				return
+			}
+			query := &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 0.0, QuoteVol: 0.0}}
+			f := makeTestVolumeFilter(config, query)
+			f.currentTradingDateQuery = &mockCurrentTradingDateQuery{result: k.dbDate, err: k.dbErr}
+			now, _ := time.Parse(time.RFC3339, k.now)
+			f.nowFn = func() time.Time { return now }
+
+			_, e := f.Apply([]txnbuild.Operation{makeManageSellOffer("2.0", "5.0")}, []hProtocol.Offer{}, []hProtocol.Offer{})
+			if k.wantErr {
+				assert.Error(t, e)
+				assert.Empty(t, query.calledArgs)
+				return
+			}
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.Equal(t, k.wantQueryArgs, query.calledArgs)
+		})
+	}
+
+	invalid := makeRawVolumeFilterConfig(pointy.Float64(100.0), nil, volumeFilterModeExact, []string{}, []string{})
+	invalid.UseDatabaseTradingDate = true
+	invalid.ResetUTCOffset = time.Hour
+	assert.Error(t, invalid.Validate())
+}
+
+func TestVolumeFilterRemainingCapForDate(t *testing.T) {
+	testCases := []struct {
+		name               string
+		baseCap            *float64
+		quoteCap           *float64
+		date               string
+		queryErr           error
+		wantBaseRemaining  *float64
+		wantQuoteRemaining *float64
+		wantErr            bool
+	}{
+		{
+			name:               "both caps",
+			baseCap:            pointy.Float64(100.0),
+			quoteCap:           pointy.Float64(50.0),
+			date:               "2020/01/20",
+			wantBaseRemaining:  pointy.Float64(40.0),
+			wantQuoteRemaining: pointy.Float64(20.0),
+		}, {
+			name:               "only base cap",
+			baseCap:            pointy.Float64(100.0),
+			date:               "2020/01/20",
+			wantBaseRemaining:  pointy.Float64(40.0),
+			wantQuoteRemaining: nil,
+		}, {
+			name:               "volume exceeded the cap",
+			quoteCap:           pointy.Float64(25.0),
+			date:               "2020/01/20",
+			wantBaseRemaining:  nil,
+			wantQuoteRemaining: pointy.Float64(0.0),
+		}, {
+			name:    "invalid date",
+			baseCap: pointy.Float64(100.0),
+			date:    "2020-01-20",
+			wantErr: true,
+		}, {
+			name:     "query error",
+			baseCap:  pointy.Float64(100.0),
+			date:     "2020/01/20",
+			queryErr: fmt.Errorf("connection refused"),
+			wantErr:  true,
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			config := makeRawVolumeFilterConfig(k.baseCap, k.quoteCap, volumeFilterModeExact, []string{}, []string{})
+			query := &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 60.0, QuoteVol: 30.0}, err: k.queryErr}
+			f := makeTestVolumeFilter(config, query)
+
+			baseRemaining, quoteRemaining, e := f.RemainingCapForDate(k.date)
+			if k.wantErr {
+				assert.Error(t, e)
+				return
+			}
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.Equal(t, k.wantBaseRemaining, baseRemaining)
+			assert.Equal(t, k.wantQuoteRemaining, quoteRemaining)
+			assert.Equal(t, [][]interface{}{{k.date}}, query.calledArgs)
+		})
+	}
+}
+
+func TestVolumeFilterCurrentDailyVolume(t *testing.T) {
+	now, _ := time.Parse(time.RFC3339, "2020-01-20T15:00:00Z")
+	testCases := []struct {
+		name           string
+		prefetchedDate string
+		queryErr       error
+		wantVolume     *queries.DailyVolume
+		wantNumQueries int
+		wantErr        bool
+	}{
+		{
+			name:           "queried",
+			wantVolume:     &queries.DailyVolume{BaseVol: 60.0, QuoteVol: 30.0},
+			wantNumQueries: 1,
+		}, {
+			name:           "pre-fetched for today",
+			prefetchedDate: "2020/01/20",
+			wantVolume:     &queries.DailyVolume{BaseVol: 8.0, QuoteVol: 16.0},
+			wantNumQueries: 0,
+		}, {
+			name:           "pre-fetched for yesterday",
+			prefetchedDate: "2020/01/19",
+			wantVolume:     &queries.DailyVolume{BaseVol: 60.0, QuoteVol: 30.0},
+			wantNumQueries: 1,
+		}, {
+			name:     "query error",
+			queryErr: fmt.Errorf("connection refused"),
+			wantErr:  true,
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			config := makeRawVolumeFilterConfig(pointy.Float64(100.0), nil, volumeFilterModeExact, []string{}, []string{})
+			query := &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 60.0, QuoteVol: 30.0}, err: k.queryErr}
+			f := makeTestVolumeFilter(config, query)
+			f.nowFn = func() time.Time { return now }
+			if k.prefetchedDate != "" {
+				f.refresher = &dailyVolumeRefresher{
+					prefetched:     &queries.DailyVolume{BaseVol: 8.0, QuoteVol: 16.0},
+					prefetchedDate: k.prefetchedDate,
+					prefetchedAt:   now,
+				}
+			}
+
+			actual, e := f.CurrentDailyVolume()
+			if k.wantErr {
+				assert.Error(t, e)
+				return
+			}
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.Equal(t, k.wantVolume, actual)
+			assert.Equal(t, k.wantNumQueries, len(query.calledArgs))
+
+			// the returned volume is a copy
+			actual.BaseVol = 0.0
+			assert.Equal(t, 60.0, query.result.BaseVol)
+			if f.refresher != nil {
+				assert.Equal(t, 8.0, f.refresher.prefetched.BaseVol)
+			}
+
+			// reading the volume does not close the query circuit breaker
+			f.consecutiveQueryFailures = 2
+			f.breakerOpen = true
+			_, e = f.CurrentDailyVolume()
+			if assert.NoError(t, e) {
+				assert.Equal(t, 2, f.consecutiveQueryFailures)
+				assert.True(t, f.breakerOpen)
+			}
+		})
+	}
+}
+
+func TestVolumeFilterBackgroundRefresh(t *testing.T) {
+	config := makeRawVolumeFilterConfig(pointy.Float64(10.0), nil, volumeFilterModeExact, []string{}, []string{})
+	query := &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 8.0, QuoteVol: 16.0}}
+	f := makeTestVolumeFilter(config, query)
+	now, _ := time.Parse(time.RFC3339, "2020-01-21T15:00:00Z")
+	f.nowFn = func() time.Time { return now }
+
+	assert.Error(t, f.StartBackgroundRefresh(0))
+	if !assert.NoError(t, f.StartBackgroundRefresh(time.Hour)) {
+		return
+	}
+	assert.Error(t, f.StartBackgroundRefresh(time.Hour), "cannot start the background refresh twice")
+
+	dateString := now.Format(postgresdb.DateFormatString)
+	isPrefetched := false
+	for i := 0; i < 100 && !isPrefetched; i++ {
+		_, isPrefetched = f.prefetchedDailyVolume(now, dateString)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !assert.True(t, isPrefetched, "background refresh did not pre-fetch the daily volume") {
+		f.Stop()
+		return
+	}
+
+	// Apply uses the pre-fetched value without calling the query
+	query.err = fmt.Errorf("query should not be called")
+	actual, e := f.Apply([]txnbuild.Operation{makeManageSellOffer("2.0", "5.0")}, []hProtocol.Offer{}, []hProtocol.Offer{})
+	if assert.NoError(t, e) && assert.Equal(t, 1, len(actual)) {
+		assert.Equal(t, "2.0000000", actual[0].(*txnbuild.ManageSellOffer).Amount)
+	}
+
+	// the pre-fetched value is not used after rollover to the next day
+	f.nowFn = func() time.Time { return now.Add(24 * time.Hour) }
+	_, e = f.Apply([]txnbuild.Operation{makeManageSellOffer("2.0", "5.0")}, []hProtocol.Offer{}, []hProtocol.Offer{})
+	assert.Error(t, e)
+
+	f.Stop()
+	assert.Equal(t, 2, len(query.calledArgs), "one call from the background refresh and one from Apply after rollover")
+	_, isPrefetched = f.prefetchedDailyVolume(now, dateString)
+	assert.False(t, isPrefetched)
+
+	// stopping again is a no-op and the refresh can be restarted
+	f.Stop()
+	f.nowFn = func() time.Time { return now }
+	query.err = nil
+	if assert.NoError(t, f.StartBackgroundRefresh(time.Hour)) {
+		f.Stop()
+	}
+}
+
+func TestVolumeFilterApplyMaxStaleBeforeBlock(t *testing.T) {
+	now, _ := time.Parse(time.RFC3339, "2020-01-21T15:00:00Z")
+	testCases := []struct {
+		name                string
+		maxStaleBeforeBlock time.Duration
+		prefetchedAge       time.Duration
+		wantQueried         bool
+		wantAmount          string
+	}{
+		{
+			name:                "disabled",
+			maxStaleBeforeBlock: 0,
+			prefetchedAge:       time.Hour,
+			wantQueried:         false,
+			wantAmount:          "2.0000000",
+		}, {
+			name:                "within the staleness bound",
+			maxStaleBeforeBlock: time.Minute,
+			prefetchedAge:       30 * time.Second,
+			wantQueried:         false,
+			wantAmount:          "2.0000000",
+		}, {
+			name:                "exceeds the staleness bound",
+			maxStaleBeforeBlock: time.Minute,
+			prefetchedAge:       2 * time.Minute,
+			wantQueried:         true,
+			wantAmount:          "1.0000000",
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			config := makeRawVolumeFilterConfig(pointy.Float64(10.0), nil, volumeFilterModeExact, []string{}, []string{})
+			config.MaxStaleBeforeBlock = k.maxStaleBeforeBlock
+			if !assert.NoError(t, config.Validate()) {
+				return
+			}
+			// more volume was sold since the value was pre-fetched
+			query := &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 9.0, QuoteVol: 18.0}}
+			f := makeTestVolumeFilter(config, query)
+			f.nowFn = func() time.Time { return now }
+			// a stalled refresher that pre-fetched the value in the past
+			f.refresher = &dailyVolumeRefresher{
+				prefetched:     &queries.DailyVolume{BaseVol: 8.0, QuoteVol: 16.0},
+				prefetchedDate: now.Format(postgresdb.DateFormatString),
+				prefetchedAt:   now.Add(-k.prefetchedAge),
+			}
+
+			actual, e := f.Apply([]txnbuild.Operation{makeManageSellOffer("2.0", "5.0")}, []hProtocol.Offer{}, []hProtocol.Offer{})
+			if !assert.NoError(t, e) || !assert.Equal(t, 1, len(actual)) {
+				return
+			}
+			assert.Equal(t, k.wantAmount, actual[0].(*txnbuild.ManageSellOffer).Amount)
+			assert.Equal(t, k.wantQueried, len(query.calledArgs) == 1)
+		})
+	}
+
+	invalid := makeRawVolumeFilterConfig(pointy.Float64(10.0), nil, volumeFilterModeExact, []string{}, []string{})
+	invalid.MaxStaleBeforeBlock = -time.Second
+	assert.Error(t, invalid.Validate())
+}
+
+func TestVolumeFilterApplyStream(t *testing.T) {
+	testCases := []struct {
+		name          string
+		sellingOffers []hProtocol.Offer
+		guard         *MaxOpsGuard
+		makeOps       func() []txnbuild.Operation
+	}{
+		{
+			name:          "new ops are kept, trimmed, and dropped",
+			sellingOffers: []hProtocol.Offer{},
+			makeOps: func() []txnbuild.Operation {
+				return []txnbuild.Operation{
+					makeManageSellOffer("2.0", "3.0"),
+					makeManageSellOffer("2.1", "3.0"),
+					makeManageSellOffer("2.2", "3.0"),
+					makeManageSellOffer("2.3", "3.0"),
+				}
+			},
+		}, {
+			name:          "delete of an existing offer does not free cap",
+			sellingOffers: []hProtocol.Offer{makeSellingOffer(1, 2, 1, "4.0000000")},
+			makeOps: func() []txnbuild.Operation {
+				deleteOp := makeManageSellOffer("2.0000000", "0")
+				deleteOp.OfferID = 1
+				return []txnbuild.Operation{deleteOp, makeManageSellOffer("2.0", "5.0"), makeManageSellOffer("2.1", "5.0")}
+			},
+		}, {
+			name:          "max ops guard truncates",
+			sellingOffers: []hProtocol.Offer{},
+			guard:         &MaxOpsGuard{MaxOps: 2, Truncate: true},
+			makeOps: func() []txnbuild.Operation {
+				return []txnbuild.Operation{
+					makeManageSellOffer("2.0", "1.0"),
+					makeManageSellOffer("2.1", "1.0"),
+					makeManageSellOffer("2.2", "1.0"),
+				}
+			},
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			makeFilter := func() *volumeFilter {
+				config := makeRawVolumeFilterConfig(pointy.Float64(17.0), nil, volumeFilterModeExact, []string{}, []string{})
+				config.MaxOpsGuard = k.guard
+				return makeTestVolumeFilter(config, &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 10.0, QuoteVol: 20.0}})
+			}
+
+			wantOps, e := makeFilter().Apply(k.makeOps(), k.sellingOffers, []hProtocol.Offer{})
+			if !assert.NoError(t, e) {
+				return
+			}
+
+			in := make(chan txnbuild.Operation)
+			out := make(chan txnbuild.Operation)
+			errCh := make(chan error, 1)
+			go func() {
+				errCh <- makeFilter().ApplyStream(in, out, k.sellingOffers, []hProtocol.Offer{})
+				close(out)
+			}()
+			go func() {
+				for _, op := range k.makeOps() {
+					in <- op
+				}
+				close(in)
+			}()
+
+			actualOps := []txnbuild.Operation{}
+			for op := range out {
+				actualOps = append(actualOps, op)
+			}
+			if !assert.NoError(t, <-errCh) {
+				return
+			}
+			assert.Equal(t, wantOps, actualOps)
+		})
+	}
+}
+
+func TestVolumeFilterApplyStreamErrorDrainsOps(t *testing.T) {
+	testCases := []struct {
+		name     string
+		guard    *MaxOpsGuard
+		queryErr error
+	}{
+		{
+			name:  "max ops guard exceeded",
+			guard: &MaxOpsGuard{MaxOps: 2, Truncate: false},
+		}, {
+			name:     "query error",
+			queryErr: fmt.Errorf("connection refused"),
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			config := makeRawVolumeFilterConfig(pointy.Float64(100.0), nil, volumeFilterModeExact, []string{}, []string{})
+			config.MaxOpsGuard = k.guard
+			f := makeTestVolumeFilter(config, &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 0.0, QuoteVol: 0.0}, err: k.queryErr})
+
+			in := make(chan txnbuild.Operation)
+			out := make(chan txnbuild.Operation, 10)
+			producerDone := make(chan struct{})
+			// the producer sends all its ops on the unbuffered channel, which blocks forever unless the ops are drained after the error
+			go func() {
+				defer close(producerDone)
+				for i := 0; i < 5; i++ {
+					in <- makeManageSellOffer("2.0", "1.0")
+				}
+				close(in)
+			}()
+
+			assert.Error(t, f.ApplyStream(in, out, []hProtocol.Offer{}, []hProtocol.Offer{}))
+			select {
+			case <-producerDone:
+			case <-time.After(time.Second):
+				assert.Fail(t, "the producer was blocked after ApplyStream returned an error")
+			}
+		})
+	}
+}
+
+func TestVolumeFilterApplyPricePrecisionGuard(t *testing.T) {
+	testCases := []struct {
+		name       string
+		guard      *PricePrecisionGuard
+		price      string
+		wantPrices []string
+	}{
+		{
+			name:       "no guard",
+			guard:      nil,
+			price:      "2.12345",
+			wantPrices: []string{"2.12345"},
+		}, {
+			name:       "within precision",
+			guard:      &PricePrecisionGuard{Precision: 4, Round: false},
+			price:      "2.1234000",
+			wantPrices: []string{"2.1234000"},
+		}, {
+			name:       "over-precise price is rounded",
+			guard:      &PricePrecisionGuard{Precision: 4, Round: true},
+			price:      "2.12345",
+			wantPrices: []string{"2.1235"},
+		}, {
+			name:       "over-precise price is dropped",
+			guard:      &PricePrecisionGuard{Precision: 4, Round: false},
+			price:      "2.12345",
+			wantPrices: []string{},
+		}, {
+			name:       "price that rounds to zero is dropped",
+			guard:      &PricePrecisionGuard{Precision: 2, Round: true},
+			price:      "0.001",
+			wantPrices: []string{},
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			config := makeRawVolumeFilterConfig(pointy.Float64(100.0), nil, volumeFilterModeExact, []string{}, []string{})
+			config.PricePrecisionGuard = k.guard
+			if !assert.NoError(t, config.Validate()) {
+				return
+			}
+			query := &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 0.0, QuoteVol: 0.0}}
+			f := makeTestVolumeFilter(config, query)
+
+			actual, e := f.Apply([]txnbuild.Operation{makeManageSellOffer(k.price, "5.0")}, []hProtocol.Offer{}, []hProtocol.Offer{})
+			if !assert.NoError(t, e) {
+				return
+			}
+
+			actualPrices := []string{}
+			for _, op := range actual {
+				actualPrices = append(actualPrices, op.(*txnbuild.ManageSellOffer).Price)
+			}
+			assert.Equal(t, k.wantPrices, actualPrices)
+		})
+	}
+
+	invalid := makeRawVolumeFilterConfig(pointy.Float64(100.0), nil, volumeFilterModeExact, []string{}, []string{})
+	invalid.PricePrecisionGuard = &PricePrecisionGuard{Precision: 8}
+	assert.Error(t, invalid.Validate())
+}
+
+func TestVolumeFilterDryRun(t *testing.T) {
+	config := makeRawVolumeFilterConfig(pointy.Float64(17.0), nil, volumeFilterModeExact, []string{}, []string{})
+	query := &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 10.0, QuoteVol: 20.0}}
+	f := makeTestVolumeFilter(config, query)
+
+	deleteOp := makeManageSellOffer("2.0", "0")
+	deleteOp.OfferID = 1
+	payment := &txnbuild.Payment{Destination: "destination", Amount: "1.0", Asset: txnbuild.NativeAsset{}}
+	ops := []txnbuild.Operation{
+		deleteOp,
+		makeManageSellOffer("2.0", "4.0"),
+		payment,
+		makeManageSellOffer("2.1", "5.0"),
+		makeManageSellOffer("2.2", "1.0"),
+	}
+
+	diffs, e := f.DryRun(ops)
+	if !assert.NoError(t, e) {
+		return
+	}
+
+	wantDiffs := []VolumeFilterOpDiff{
+		{Unfiltered: ops[0], Filtered: ops[0], Action: VolumeFilterOpActionKept},
+		{Unfiltered: ops[1], Filtered: makeManageSellOffer("2.0", "4.0"), Action: VolumeFilterOpActionKept},
+		{Unfiltered: ops[2], Filtered: ops[2], Action: VolumeFilterOpActionKept},
+		{Unfiltered: ops[3], Filtered: makeManageSellOffer("2.1", "3.0000000"), Action: VolumeFilterOpActionTrimmed, TrimmedAmount: 2.0},
+		{Unfiltered: ops[4], Filtered: nil, Action: VolumeFilterOpActionDropped, DropReason: DropReasonBaseCap, TrimmedAmount: 1.0},
+	}
+	if !assert.Equal(t, len(wantDiffs), len(diffs)) {
+		return
+	}
+	for i, want := range wantDiffs {
+		assert.Equal(t, want.String(), diffs[i].String(), "diff at index %d", i)
+	}
+	assert.Equal(t, "VolumeFilterOpDiff[action=trimmed, unfiltered=ManageSellOffer[offerID=0, price=2.1, amount=5.0], filtered=ManageSellOffer[offerID=0, price=2.1, amount=3.0000000], trimmedAmount=2.0000000]", diffs[3].String())
+
+	// the ops are not mutated
+	assert.Equal(t, makeManageSellOffer("2.1", "5.0"), ops[3])
+	// the dry run is repeatable since it does not change the state of the filter
+	again, e := f.DryRun(ops)
+	if !assert.NoError(t, e) {
+		return
+	}
+	assert.Equal(t, diffs, again)
+
+	// the dry run does not close the query circuit breaker
+	f.consecutiveQueryFailures = 2
+	f.breakerOpen = true
+	_, e = f.DryRun(ops)
+	if assert.NoError(t, e) {
+		assert.Equal(t, 2, f.consecutiveQueryFailures)
+		assert.True(t, f.breakerOpen)
+	}
+}
+
+func TestVolumeFilterDryRunScaleMode(t *testing.T) {
+	config := makeRawVolumeFilterConfig(pointy.Float64(15.0), nil, volumeFilterModeScale, []string{}, []string{})
+	f := makeTestVolumeFilter(config, &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 10.0, QuoteVol: 20.0}})
+	now, _ := time.Parse(time.RFC3339, "2020-01-21T12:00:00Z")
+	f.nowFn = func() time.Time { return now }
+
+	// the 10.0 units of the ops are scaled down to the 5.0 units of headroom, which is a trim of the unfiltered ops
+	ops := []txnbuild.Operation{makeManageSellOffer("2.0", "4.0"), makeManageSellOffer("2.0", "6.0")}
+	diffs, e := f.DryRun(ops)
+	if !assert.NoError(t, e) || !assert.Equal(t, 2, len(diffs)) {
+		return
+	}
+	assert.Equal(t, VolumeFilterOpActionTrimmed, diffs[0].Action)
+	assert.Equal(t, makeManageSellOffer("2.0", "2.0000000"), diffs[0].Filtered)
+	assert.InDelta(t, 2.0, diffs[0].TrimmedAmount, 1e-7)
+	assert.Equal(t, VolumeFilterOpActionTrimmed, diffs[1].Action)
+	assert.Equal(t, makeManageSellOffer("2.0", "3.0000000"), diffs[1].Filtered)
+	assert.InDelta(t, 3.0, diffs[1].TrimmedAmount, 1e-7)
+
+	simulation, e := f.Simulate([][]txnbuild.Operation{ops}, map[string]*queries.DailyVolume{"2020/01/21": {BaseVol: 10.0, QuoteVol: 20.0}})
+	if assert.NoError(t, e) {
+		assert.InDelta(t, 5.0, simulation.TrimmedVolume, 1e-7)
+	}
+}
+
+func TestVolumeFilterDryRunSkipsCallbacks(t *testing.T) {
+	config := makeRawVolumeFilterConfig(pointy.Float64(15.0), nil, volumeFilterModeExact, []string{}, []string{})
+	config.AlertCapInBaseUnits = pointy.Float64(12.0)
+	numAlerts := 0
+	config.OnAlert = func(alert VolumeCapAlert) {
+		numAlerts++
+	}
+	numCapHits := 0
+	config.OnCapHit = func(marketID string, cap string, projected float64, limit float64) {
+		numCapHits++
+	}
+	numDecisions := 0
+	config.DecisionHook = func(op *txnbuild.ManageSellOffer, proposed *VolumeFilterResult) *VolumeFilterResult {
+		numDecisions++
+		return proposed
+	}
+	f := makeTestVolumeFilter(config, &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 10.0, QuoteVol: 20.0}})
+
+	// the ops cross the alert cap and the first op is trimmed by the base cap
+	ops := []txnbuild.Operation{makeManageSellOffer("2.0", "6.0"), makeManageSellOffer("2.0", "1.0")}
+	diffs, e := f.DryRun(ops)
+	if !assert.NoError(t, e) || !assert.Equal(t, 2, len(diffs)) {
+		return
+	}
+	assert.Equal(t, VolumeFilterOpActionTrimmed, diffs[0].Action)
+	assert.Equal(t, VolumeFilterOpActionDropped, diffs[1].Action)
+	assert.Equal(t, 0, numAlerts)
+	assert.Equal(t, 0, numCapHits)
+	assert.Equal(t, 0, numDecisions)
+
+	// the same ops call the callbacks in Apply
+	_, e = f.Apply(ops, []hProtocol.Offer{}, []hProtocol.Offer{})
+	if assert.NoError(t, e) {
+		assert.Equal(t, 1, numAlerts)
+		assert.True(t, numCapHits > 0)
+		assert.Equal(t, 2, numDecisions)
+	}
+}
+
+// mockRecentTradesQuery is an api.Query that sums the volume of the most recent numTrades trades of a fixed dataset
+type mockRecentTradesQuery struct {
+	// trades are ordered from the oldest to the most recent trade
+	trades    []queries.DailyVolume
+	numTrades int
+}
+
+// Name impl.
+func (q *mockRecentTradesQuery) Name() string {
+	return "mockRecentTradesQuery"
+}
+
+// QueryRow impl.
+func (q *mockRecentTradesQuery) QueryRow(args ...interface{}) (interface{}, error) {
+	if len(args) != 0 {
+		return nil, fmt.Errorf("expected 0 args, but got args %v", args)
+	}
+
+	total := &queries.DailyVolume{}
+	for i := len(q.trades) - 1; i >= 0 && i >= len(q.trades)-q.numTrades; i-- {
+		total.BaseVol += q.trades[i].BaseVol
+		total.QuoteVol += q.trades[i].QuoteVol
+	}
+	return total, nil
+}
+
+func TestVolumeFilterApplyRollingTradeCount(t *testing.T) {
+	trades := []queries.DailyVolume{
+		{BaseVol: 100.0, QuoteVol: 200.0},
+		{BaseVol: 3.0, QuoteVol: 6.0},
+		{BaseVol: 4.0, QuoteVol: 8.0},
+		{BaseVol: 5.0, QuoteVol: 10.0},
+	}
+
+	testCases := []struct {
+		name        string
+		numTrades   int
+		wantAmounts []string
+	}{
+		{
+			name:        "window excludes the old large trade",
+			numTrades:   3,
+			wantAmounts: []string{"5.0", "3.0000000"},
+		}, {
+			name:        "window includes the old large trade",
+			numTrades:   4,
+			wantAmounts: []string{},
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			config := makeRawVolumeFilterConfig(pointy.Float64(20.0), nil, volumeFilterModeExact, []string{}, []string{})
+			config.RollingTradeCount = k.numTrades
+			if !assert.NoError(t, config.Validate()) {
+				return
+			}
+			f := &volumeFilter{
+				name:                   "volumeFilter",
+				baseAsset:              utils.NativeAsset,
+				quoteAsset:             utils.NativeAsset,
+				config:                 config,
+				dailyVolumeByDateQuery: &mockRecentTradesQuery{trades: trades, numTrades: k.numTrades},
+			}
+
+			ops := []txnbuild.Operation{makeManageSellOffer("2.0", "5.0"), makeManageSellOffer("2.0", "5.0")}
+			actual, e := f.Apply(ops, []hProtocol.Offer{}, []hProtocol.Offer{})
+			if !assert.NoError(t, e) {
+				return
+			}
+
+			actualAmounts := []string{}
+			for _, op := range actual {
+				actualAmounts = append(actualAmounts, op.(*txnbuild.ManageSellOffer).Amount)
+			}
+			assert.Equal(t, k.wantAmounts, actualAmounts)
+
+			_, _, e = f.RemainingCapForDate("2020/01/21")
+			assert.Error(t, e)
+		})
+	}
+
+	invalid := makeRawVolumeFilterConfig(pointy.Float64(20.0), nil, volumeFilterModeExact, []string{}, []string{})
+	invalid.RollingTradeCount = 3
+	invalid.MinFillPriceToCount = pointy.Float64(1.0)
+	assert.Error(t, invalid.Validate())
+}
+
+func TestVolumeFilterApplyStateStoreRestart(t *testing.T) {
+	restingOffer := makeSellingOffer(1, 2, 1, "6.0000000")
+	testCases := []struct {
+		name             string
+		restartAt        string
+		restartOTB       float64
+		restartOffers    []hProtocol.Offer
+		restartOps       []txnbuild.Operation
+		wantAmounts      []string
+		wantPersistent   PersistedTBB
+		wantLaterAmounts []string
+	}{
+		{
+			name:             "restart on the same day restores the TBB that was executed but not yet recorded",
+			restartAt:        "2020-01-21T18:00:00Z",
+			restartOffers:    []hProtocol.Offer{},
+			restartOps:       []txnbuild.Operation{makeManageSellOffer("2.0", "5.0")},
+			wantAmounts:      []string{"4.0000000"},
+			wantPersistent:   PersistedTBB{Date: "2020/01/21", SellBaseUnits: 10.0, SellQuoteUnits: 20.0},
+			wantLaterAmounts: []string{"5.0"},
+		}, {
+			name:             "restart on the same day does not restore the TBB of the resting offers",
+			restartAt:        "2020-01-21T18:00:00Z",
+			restartOffers:    []hProtocol.Offer{restingOffer},
+			restartOps:       []txnbuild.Operation{makeManageSellOffer("2.0", "3.0")},
+			wantAmounts:      []string{"3.0"},
+			wantPersistent:   PersistedTBB{Date: "2020/01/21", SellBaseUnits: 9.0, SellQuoteUnits: 18.0},
+			wantLaterAmounts: []string{"5.0"},
+		}, {
+			name:             "restart on the same day does not restore the TBB that was recorded in the daily volume",
+			restartAt:        "2020-01-21T18:00:00Z",
+			restartOTB:       6.0,
+			restartOffers:    []hProtocol.Offer{},
+			restartOps:       []txnbuild.Operation{makeManageSellOffer("2.0", "5.0")},
+			wantAmounts:      []string{"4.0000000"},
+			wantPersistent:   PersistedTBB{Date: "2020/01/21", SellBaseUnits: 4.0, SellQuoteUnits: 8.0, OTBBaseUnits: 6.0, OTBQuoteUnits: 12.0},
+			wantLaterAmounts: []string{"4.0000000"},
+		}, {
+			name:             "restart on the next day resets the TBB",
+			restartAt:        "2020-01-22T01:00:00Z",
+			restartOffers:    []hProtocol.Offer{},
+			restartOps:       []txnbuild.Operation{makeManageSellOffer("2.0", "5.0")},
+			wantAmounts:      []string{"5.0"},
+			wantPersistent:   PersistedTBB{Date: "2020/01/22", SellBaseUnits: 5.0, SellQuoteUnits: 10.0},
+			wantLaterAmounts: []string{"5.0"},
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			store := MakeInMemoryVolumeFilterStateStore()
+			makeFilter := func(now string, otbBase float64) *volumeFilter {
+				config := makeRawVolumeFilterConfig(pointy.Float64(10.0), nil, volumeFilterModeExact, []string{}, []string{})
+				config.StateStore = store
+				f := makeTestVolumeFilter(config, &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: otbBase, QuoteVol: 2 * otbBase}})
+				f.marketID = "market1"
+				nowTime, _ := time.Parse(time.RFC3339, now)
+				f.nowFn = func() time.Time { return nowTime }
+				return f
+			}
+			amounts := func(ops []txnbuild.Operation) []string {
+				actualAmounts := []string{}
+				for _, op := range ops {
+					actualAmounts = append(actualAmounts, op.(*txnbuild.ManageSellOffer).Amount)
+				}
+				return actualAmounts
+			}
+
+			// the first run places an op that is not in the daily volume
+			_, e := makeFilter("2020-01-21T12:00:00Z", 0.0).Apply([]txnbuild.Operation{makeManageSellOffer("2.0", "6.0")}, []hProtocol.Offer{}, []hProtocol.Offer{})
+			if !assert.NoError(t, e) {
+				return
+			}
+
+			// the restarted filter
+			f := makeFilter(k.restartAt, k.restartOTB)
+			actual, e := f.Apply(k.restartOps, k.restartOffers, []hProtocol.Offer{})
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.Equal(t, k.wantAmounts, amounts(actual))
+
+			persisted, e := store.LoadTBB("market1")
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.Equal(t, &k.wantPersistent, persisted)
+
+			// later batches of the same filter compute the TBB from their own ops
+			actual, e = f.Apply([]txnbuild.Operation{makeManageSellOffer("2.0", "5.0")}, []hProtocol.Offer{}, []hProtocol.Offer{})
+			if assert.NoError(t, e) {
+				assert.Equal(t, k.wantLaterAmounts, amounts(actual))
+			}
+		})
+	}
+}
+
+// pendingVolumeCall is the args of a call to mockPendingVolumeQuery.RecordPendingVolume
+type pendingVolumeCall struct {
+	marketID string
+	dateUTC  string
+	baseVol  float64
+	quoteVol float64
+}
+
+// mockPendingVolumeQuery is a mockDailyVolumeQuery that also records the pending volume
+type mockPendingVolumeQuery struct {
+	mockDailyVolumeQuery
+	recordErr error
+	recorded  []pendingVolumeCall
+}
+
+// RecordPendingVolume impl.
+func (q *mockPendingVolumeQuery) RecordPendingVolume(ctx context.Context, marketID string, dateUTC string, baseVol float64, quoteVol float64) error {
+	q.recorded = append(q.recorded, pendingVolumeCall{marketID: marketID, dateUTC: dateUTC, baseVol: baseVol, quoteVol: quoteVol})
+	return q.recordErr
+}
+
+func TestVolumeFilterApplyRecordPendingVolume(t *testing.T) {
+	restingOffer := makeSellingOffer(1, 2, 1, "6.0000000")
+	testCases := []struct {
+		name string
+		// laterVolume is the daily volume of the next batch, including the recorded pending volume of 6 base units
+		laterVolume *queries.DailyVolume
+		laterOffers []hProtocol.Offer
+		wantOps     []txnbuild.Operation
+	}{
+		{
+			name:        "the pending volume of the offer that is still on the book is not counted twice",
+			laterVolume: &queries.DailyVolume{BaseVol: 6.0, QuoteVol: 12.0},
+			laterOffers: []hProtocol.Offer{restingOffer},
+			wantOps:     []txnbuild.Operation{makeManageSellOffer("2.0", "4.0000000")},
+		}, {
+			name:        "the pending volume of the offer that was executed but not settled is counted",
+			laterVolume: &queries.DailyVolume{BaseVol: 6.0, QuoteVol: 12.0},
+			laterOffers: []hProtocol.Offer{},
+			wantOps:     []txnbuild.Operation{makeManageSellOffer("2.0", "4.0000000")},
+		}, {
+			name:        "the pending volume of the offer that was settled is not counted twice",
+			laterVolume: &queries.DailyVolume{BaseVol: 12.0, QuoteVol: 24.0},
+			laterOffers: []hProtocol.Offer{},
+			wantOps:     []txnbuild.Operation{makeManageSellOffer("2.0", "4.0000000")},
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			config := makeRawVolumeFilterConfig(pointy.Float64(10.0), nil, volumeFilterModeExact, []string{}, []string{})
+			config.RecordPendingVolume = true
+			if !assert.NoError(t, config.Validate()) {
+				return
+			}
+			query := &mockPendingVolumeQuery{mockDailyVolumeQuery: mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 0.0, QuoteVol: 0.0}}}
+			f := makeTestVolumeFilter(config, nil)
+			f.dailyVolumeByDateQuery = query
+			f.marketID = "market1"
+			f.pending = &pendingVolumeState{}
+			now, _ := time.Parse(time.RFC3339, "2020-01-21T12:00:00Z")
+			f.nowFn = func() time.Time { return now }
+
+			// the volume of the batch is only recorded once its ops were submitted
+			_, e := f.Apply([]txnbuild.Operation{makeManageSellOffer("2.0", "6.0")}, []hProtocol.Offer{}, []hProtocol.Offer{})
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.Equal(t, 0, len(query.recorded))
+			if !assert.NoError(t, f.PostSubmit()) {
+				return
+			}
+			assert.Equal(t, []pendingVolumeCall{{marketID: "market1", dateUTC: "2020/01/21", baseVol: 6.0, quoteVol: 12.0}}, query.recorded)
+			// there is nothing new to record until the next batch
+			if !assert.NoError(t, f.PostSubmit()) {
+				return
+			}
+			assert.Equal(t, 1, len(query.recorded))
+
+			query.result = k.laterVolume
+			actual, e := f.Apply([]txnbuild.Operation{makeManageSellOffer("2.0", "5.0")}, k.laterOffers, []hProtocol.Offer{})
+			if assert.NoError(t, e) {
+				assert.Equal(t, k.wantOps, actual)
+			}
+		})
+	}
+}
+
+func TestVolumeFilterPostSubmitRecordError(t *testing.T) {
+	config := makeRawVolumeFilterConfig(pointy.Float64(10.0), nil, volumeFilterModeExact, []string{}, []string{})
+	config.RecordPendingVolume = true
+	query := &mockPendingVolumeQuery{
+		mockDailyVolumeQuery: mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 0.0, QuoteVol: 0.0}},
+		recordErr:            fmt.Errorf("db unavailable"),
+	}
+	f := makeTestVolumeFilter(config, nil)
+	f.dailyVolumeByDateQuery = query
+	f.pending = &pendingVolumeState{}
+
+	_, e := f.Apply([]txnbuild.Operation{makeManageSellOffer("2.0", "6.0")}, []hProtocol.Offer{}, []hProtocol.Offer{})
+	if !assert.NoError(t, e) {
+		return
+	}
+	assert.Error(t, f.PostSubmit())
+	assert.Nil(t, f.pending.recorded)
+
+	config.RollingTradeCount = 10
+	assert.Error(t, config.Validate())
+}
+
+// makeSellBaseOp makes an op that sells the USD base asset for the native quote asset
+func makeSellBaseOp(price string, amount string) *txnbuild.ManageSellOffer {
+	return &txnbuild.ManageSellOffer{
+		Selling: txnbuild.CreditAsset{Code: "USD", Issuer: "GBAA4Z4QG5JOTMPF4GNDI6NLNZLBKIDO3JKFCYR4HMUNRKT2CIJLVZTF"},
+		Buying:  txnbuild.NativeAsset{},
+		Price:   price,
+		Amount:  amount,
+	}
+}
+
+func TestVolumeFilterApplyMaxNetPosition(t *testing.T) {
+	testCases := []struct {
+		name        string
+		mode        volumeFilterMode
+		netPosition float64
+		ops         []txnbuild.Operation
+		wantOps     []txnbuild.Operation
+	}{
+		{
+			name:        "long limit, exact mode",
+			mode:        volumeFilterModeExact,
+			netPosition: 4.0,
+			// buys 4 and then 6 base units, the second op is trimmed to buy the remaining 2 base units
+			ops:     []txnbuild.Operation{makeBuyOp("2.0", "2.0"), makeBuyOp("2.0", "3.0"), makeBuyOp("2.0", "1.0")},
+			wantOps: []txnbuild.Operation{makeBuyOp("2.0", "2.0"), makeBuyOp("2.0", "1.0000000")},
+		}, {
+			name:        "long limit, ignore mode",
+			mode:        volumeFilterModeIgnore,
+			netPosition: 4.0,
+			ops:         []txnbuild.Operation{makeBuyOp("2.0", "2.0"), makeBuyOp("2.0", "3.0"), makeBuyOp("2.0", "1.0")},
+			wantOps:     []txnbuild.Operation{makeBuyOp("2.0", "2.0"), makeBuyOp("2.0", "1.0")},
+		}, {
+			name:        "short limit, exact mode",
+			mode:        volumeFilterModeExact,
+			netPosition: -4.0,
+			ops:         []txnbuild.Operation{makeSellBaseOp("0.5", "4.0"), makeSellBaseOp("0.5", "5.0"), makeSellBaseOp("0.5", "1.0")},
+			wantOps:     []txnbuild.Operation{makeSellBaseOp("0.5", "4.0"), makeSellBaseOp("0.5", "2.0000000")},
+		}, {
+			name:        "short limit, ignore mode",
+			mode:        volumeFilterModeIgnore,
+			netPosition: -4.0,
+			ops:         []txnbuild.Operation{makeSellBaseOp("0.5", "4.0"), makeSellBaseOp("0.5", "5.0"), makeSellBaseOp("0.5", "1.0")},
+			wantOps:     []txnbuild.Operation{makeSellBaseOp("0.5", "4.0"), makeSellBaseOp("0.5", "1.0")},
+		}, {
+			name:        "beyond the limit only allows reducing the position",
+			mode:        volumeFilterModeExact,
+			netPosition: 15.0,
+			ops:         []txnbuild.Operation{makeBuyOp("2.0", "1.0"), makeSellBaseOp("0.5", "3.0"), makeSellBaseOp("0.5", "30.0")},
+			wantOps:     []txnbuild.Operation{makeSellBaseOp("0.5", "3.0"), makeSellBaseOp("0.5", "22.0000000")},
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			config := makeRawVolumeFilterConfig(nil, nil, k.mode, []string{}, []string{})
+			config.MaxNetPositionInBaseUnits = pointy.Float64(10.0)
+			if !assert.Error(t, config.Validate()) {
+				return
+			}
+			netPosition := k.netPosition
+			config.NetPosition = func() (float64, error) {
+				return netPosition, nil
+			}
+			if !assert.NoError(t, config.Validate()) {
+				return
+			}
+			f := makeTestVolumeFilter(config, &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 0.0, QuoteVol: 0.0}})
+			f.baseAsset = testBuyBaseAsset
+
+			actual, e := f.Apply(k.ops, []hProtocol.Offer{}, []hProtocol.Offer{})
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.Equal(t, k.wantOps, actual)
+		})
+	}
+}
+
+func TestVolumeFilterApplyMaxNetPositionError(t *testing.T) {
+	config := makeRawVolumeFilterConfig(nil, nil, volumeFilterModeExact, []string{}, []string{})
+	config.MaxNetPositionInBaseUnits = pointy.Float64(10.0)
+	config.NetPosition = func() (float64, error) {
+		return 0.0, fmt.Errorf("position unavailable")
+	}
+	f := makeTestVolumeFilter(config, &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 0.0, QuoteVol: 0.0}})
+	f.baseAsset = testBuyBaseAsset
+
+	_, e := f.Apply([]txnbuild.Operation{makeBuyOp("2.0", "1.0")}, []hProtocol.Offer{}, []hProtocol.Offer{})
+	assert.Error(t, e)
+
+	config.MaxNetPositionInBaseUnits = pointy.Float64(0.0)
+	assert.Error(t, config.Validate())
+}
+
+func TestVolumeFilterApplyPeriodCaps(t *testing.T) {
+	testCases := []struct {
+		name             string
+		capInBase        *float64
+		weeklyCapInBase  *float64
+		monthlyCapInBase *float64
+		weeklyCapInQuote *float64
+		wantAmount       string
+		wantBaseWindow   string
+		wantQuoteWindow  string
+	}{
+		{
+			name:             "daily cap binds",
+			capInBase:        pointy.Float64(100.0),
+			weeklyCapInBase:  pointy.Float64(500.0),
+			monthlyCapInBase: pointy.Float64(2000.0),
+			wantAmount:       "80.0000000",
+			wantBaseWindow:   capWindowDaily,
+			wantQuoteWindow:  capWindowDaily,
+		}, {
+			name:            "weekly cap binds",
+			capInBase:       pointy.Float64(100.0),
+			weeklyCapInBase: pointy.Float64(150.0),
+			wantAmount:      "50.0000000",
+			wantBaseWindow:  capWindowWeekly,
+			wantQuoteWindow: capWindowDaily,
+		}, {
+			name:             "monthly cap binds over the weekly cap",
+			capInBase:        pointy.Float64(100.0),
+			weeklyCapInBase:  pointy.Float64(150.0),
+			monthlyCapInBase: pointy.Float64(1000.0),
+			wantAmount:       "20.0000000",
+			wantBaseWindow:   capWindowMonthly,
+			wantQuoteWindow:  capWindowDaily,
+		}, {
+			name:             "weekly cap without a daily cap",
+			weeklyCapInBase:  pointy.Float64(150.0),
+			weeklyCapInQuote: pointy.Float64(100.0),
+			wantAmount:       "10.0000000",
+			wantBaseWindow:   capWindowWeekly,
+			wantQuoteWindow:  capWindowWeekly,
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			config := makeRawVolumeFilterConfig(k.capInBase, nil, volumeFilterModeExact, []string{}, []string{})
+			config.SellBaseAssetCapInBaseUnitsWeekly = k.weeklyCapInBase
+			config.SellBaseAssetCapInQuoteUnitsWeekly = k.weeklyCapInQuote
+			config.SellBaseAssetCapInBaseUnitsMonthly = k.monthlyCapInBase
+			if !assert.NoError(t, config.Validate()) {
+				return
+			}
+			// 20 units were sold today, 100 units this week, and 980 units this month
+			f := makeTestVolumeFilter(config, &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 20.0, QuoteVol: 10.0}})
+			weeklyQuery := &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 100.0, QuoteVol: 80.0}}
+			monthlyQuery := &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 980.0, QuoteVol: 500.0}}
+			if k.weeklyCapInBase != nil || k.weeklyCapInQuote != nil {
+				f.periodCaps = append(f.periodCaps, periodSellCap{window: capWindowWeekly, capInBase: k.weeklyCapInBase, capInQuote: k.weeklyCapInQuote, query: weeklyQuery})
+			}
+			if k.monthlyCapInBase != nil {
+				f.periodCaps = append(f.periodCaps, periodSellCap{window: capWindowMonthly, capInBase: k.monthlyCapInBase, query: monthlyQuery})
+			}
+			now, _ := time.Parse(time.RFC3339, "2020-01-22T12:00:00Z")
+			f.nowFn = func() time.Time { return now }
+
+			ops, batch, e := f.apply([]txnbuild.Operation{makeManageSellOffer("2.0", "100.0")}, []hProtocol.Offer{}, []hProtocol.Offer{})
+			if !assert.NoError(t, e) {
+				return
+			}
+			if !assert.Equal(t, 1, len(ops)) {
+				return
+			}
+			assert.Equal(t, k.wantAmount, ops[0].(*txnbuild.ManageSellOffer).Amount)
+			assert.Equal(t, k.wantBaseWindow, batch.lp.sellBaseCapWindow)
+			assert.Equal(t, k.wantQuoteWindow, batch.lp.sellQuoteCapWindow)
+			for _, pc := range f.periodCaps {
+				assert.Equal(t, [][]interface{}{{"2020/01/22"}}, pc.query.(*mockDailyVolumeQuery).calledArgs)
+			}
+		})
+	}
+
+	// the filter makes a query for each of the windows
+	testAssetDisplayFn := model.MakeSdexMappedAssetDisplayFn(map[model.Asset]hProtocol.Asset{model.Asset("XLM"): utils.NativeAsset})
+	tradingPair := &model.TradingPair{Base: "XLM", Quote: "XLM"}
+	config := makeRawVolumeFilterConfig(pointy.Float64(100.0), nil, volumeFilterModeExact, []string{}, []string{})
+	config.SellBaseAssetCapInQuoteUnitsWeekly = pointy.Float64(500.0)
+	config.SellBaseAssetCapInBaseUnitsMonthly = pointy.Float64(1000.0)
+	db := &sql.DB{}
+	actual, e := makeFilterVolume("", "exchange 1", tradingPair, testAssetDisplayFn, utils.NativeAsset, utils.NativeAsset, db, config)
+	if !assert.NoError(t, e) {
+		return
+	}
+	wantWeeklyQuery, e := queries.MakeWeeklyVolumeByDateForMarketIdsAction(db, []string{"6d9862b0e2"}, "sell", []string{})
+	if !assert.NoError(t, e) {
+		return
+	}
+	wantMonthlyQuery, e := queries.MakeMonthlyVolumeByDateForMarketIdsAction(db, []string{"6d9862b0e2"}, "sell", []string{})
+	if !assert.NoError(t, e) {
+		return
+	}
+	assert.Equal(t, []periodSellCap{
+		{window: capWindowWeekly, capInQuote: config.SellBaseAssetCapInQuoteUnitsWeekly, query: wantWeeklyQuery},
+		{window: capWindowMonthly, capInBase: config.SellBaseAssetCapInBaseUnitsMonthly, query: wantMonthlyQuery},
+	}, actual.(*volumeFilter).periodCaps)
+
+	// a weekly cap alone is a valid config, but cannot be combined with queries that are not bucketed by date
+	weeklyOnly := makeRawVolumeFilterConfig(nil, nil, volumeFilterModeExact, []string{}, []string{})
+	weeklyOnly.SellBaseAssetCapInBaseUnitsWeekly = pointy.Float64(100.0)
+	assert.NoError(t, weeklyOnly.Validate())
+	for _, modify := range []func(c *VolumeFilterConfig){
+		func(c *VolumeFilterConfig) { c.WindowDuration = 24 * time.Hour },
+		func(c *VolumeFilterConfig) { c.RollingTradeCount = 10 },
+		func(c *VolumeFilterConfig) { c.ResetTimeUTC = 22 * time.Hour },
+		func(c *VolumeFilterConfig) { c.MinFillPriceToCount = pointy.Float64(0.1) },
+	} {
+		invalid := makeRawVolumeFilterConfig(nil, nil, volumeFilterModeExact, []string{}, []string{})
+		invalid.SellBaseAssetCapInBaseUnitsMonthly = pointy.Float64(100.0)
+		modify(invalid)
+		assert.Error(t, invalid.Validate())
+	}
+}
+
+func TestVolumeFilterApplyMinQueryInterval(t *testing.T) {
+	config := makeRawVolumeFilterConfig(pointy.Float64(100.0), nil, volumeFilterModeExact, []string{}, []string{})
+	config.BuyBaseAssetCapInBaseUnits = pointy.Float64(100.0)
+	config.MinQueryInterval = 10 * time.Second
+	if !assert.NoError(t, config.Validate()) {
+		return
+	}
+	query := &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 10.0, QuoteVol: 20.0}}
+	buyQuery := &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 0.0, QuoteVol: 0.0}}
+	f := makeTestVolumeFilter(config, query)
+	f.dailyBuyVolumeByDateQuery = buyQuery
+	f.queryCache = &queryResultCache{results: map[api.Query]*cachedQueryResult{}}
+	now, _ := time.Parse(time.RFC3339, "2020-01-21T23:59:50Z")
+	f.nowFn = func() time.Time { return now }
+
+	testCases := []struct {
+		advance        time.Duration
+		wantNumQueries int
+	}{
+		{advance: 0, wantNumQueries: 1},
+		{advance: time.Second, wantNumQueries: 1},
+		{advance: 5 * time.Second, wantNumQueries: 1},
+		{advance: 4 * time.Second, wantNumQueries: 2}, // 10s since the first query
+		{advance: time.Second, wantNumQueries: 2},
+		{advance: 10 * time.Second, wantNumQueries: 3},
+	}
+	for i, k := range testCases {
+		now = now.Add(k.advance)
+		ops, e := f.Apply([]txnbuild.Operation{makeManageSellOffer("2.0", "5.0")}, []hProtocol.Offer{}, []hProtocol.Offer{})
+		if !assert.NoError(t, e) {
+			return
+		}
+		assert.Equal(t, 1, len(ops))
+		assert.Equal(t, k.wantNumQueries, len(query.calledArgs), "apply at index %d", i)
+		assert.Equal(t, k.wantNumQueries, len(buyQuery.calledArgs), "apply at index %d", i)
+	}
+
+	// a new date runs the query again within the interval
+	f = makeTestVolumeFilter(config, &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 10.0, QuoteVol: 20.0}})
+	f.queryCache = &queryResultCache{results: map[api.Query]*cachedQueryResult{}}
+	f.nowFn = func() time.Time { return now }
+	now, _ = time.Parse(time.RFC3339, "2020-01-21T23:59:58Z")
+	_, e := f.Apply([]txnbuild.Operation{}, []hProtocol.Offer{}, []hProtocol.Offer{})
+	if !assert.NoError(t, e) {
+		return
+	}
+	now = now.Add(3 * time.Second)
+	_, e = f.Apply([]txnbuild.Operation{}, []hProtocol.Offer{}, []hProtocol.Offer{})
+	if !assert.NoError(t, e) {
+		return
+	}
+	assert.Equal(t, [][]interface{}{{"2020/01/21"}, {"2020/01/22"}}, f.dailyVolumeByDateQuery.(*mockDailyVolumeQuery).calledArgs)
+
+	// the filter only caches the results when there is a MinQueryInterval
+	testAssetDisplayFn := model.MakeSdexMappedAssetDisplayFn(map[model.Asset]hProtocol.Asset{model.Asset("XLM"): utils.NativeAsset})
+	tradingPair := &model.TradingPair{Base: "XLM", Quote: "XLM"}
+	actual, e := makeFilterVolume("", "exchange 1", tradingPair, testAssetDisplayFn, utils.NativeAsset, utils.NativeAsset, &sql.DB{}, config)
+	if assert.NoError(t, e) {
+		assert.NotNil(t, actual.(*volumeFilter).queryCache)
+	}
+	config.MinQueryInterval = 0
+	actual, e = makeFilterVolume("", "exchange 1", tradingPair, testAssetDisplayFn, utils.NativeAsset, utils.NativeAsset, &sql.DB{}, config)
+	if assert.NoError(t, e) {
+		assert.Nil(t, actual.(*volumeFilter).queryCache)
+	}
+
+	config.MinQueryInterval = -time.Second
+	assert.Error(t, config.Validate())
+}
+
+func TestVolumeFilterMinQueryIntervalConcurrentUse(t *testing.T) {
+	config := makeRawVolumeFilterConfig(pointy.Float64(100.0), nil, volumeFilterModeExact, []string{}, []string{})
+	config.MinQueryInterval = time.Millisecond
+	f := makeTestVolumeFilter(config, &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 10.0, QuoteVol: 20.0}})
+	f.queryCache = &queryResultCache{results: map[api.Query]*cachedQueryResult{}}
+
+	// CurrentDailyVolume can be called (e.g. by a metrics endpoint) while Apply runs, which is checked by the race detector
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			_, e := f.Apply([]txnbuild.Operation{makeManageSellOffer("2.0", "5.0")}, []hProtocol.Offer{}, []hProtocol.Offer{})
+			assert.NoError(t, e)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			dailyVolume, e := f.CurrentDailyVolume()
+			if assert.NoError(t, e) {
+				assert.Equal(t, 10.0, dailyVolume.BaseVol)
+			}
+		}
+	}()
+	wg.Wait()
+}
+
+// stalledDailyVolumeQuery is an api.ContextQuery that blocks until the context is done like a query on a stalled db connection, and then
+// returns the error of the context, or the result when there is one (like a query that completes right at the deadline)
+type stalledDailyVolumeQuery struct {
+	mockDailyVolumeQuery
+}
+
+// QueryRowContext impl.
+func (q *stalledDailyVolumeQuery) QueryRowContext(ctx context.Context, args ...interface{}) (interface{}, error) {
+	q.calledArgs = append(q.calledArgs, args)
+	<-ctx.Done()
+	if q.result != nil {
+		return q.result, nil
+	}
+	return nil, ctx.Err()
+}
+
+func TestVolumeFilterApplyQueryTimeout(t *testing.T) {
+	config := makeRawVolumeFilterConfig(pointy.Float64(100.0), nil, volumeFilterModeExact, []string{}, []string{})
+	config.BuyBaseAssetCapInBaseUnits = pointy.Float64(100.0)
+	config.QueryTimeout = 10 * time.Millisecond
+	if !assert.NoError(t, config.Validate()) {
+		return
+	}
+	query := &stalledDailyVolumeQuery{}
+	buyQuery := &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 0.0, QuoteVol: 0.0}}
+	f := makeTestVolumeFilter(config, nil)
+	f.dailyVolumeByDateQuery = query
+	f.dailyBuyVolumeByDateQuery = buyQuery
+
+	// the stalled query fails the batch once the deadline is exceeded
+	start := time.Now()
+	_, e := f.Apply([]txnbuild.Operation{makeManageSellOffer("2.0", "5.0")}, []hProtocol.Offer{}, []hProtocol.Offer{})
+	if assert.Error(t, e) {
+		assert.Contains(t, e.Error(), context.DeadlineExceeded.Error())
+	}
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, 1, len(query.calledArgs))
+	assert.Equal(t, 0, len(buyQuery.calledArgs))
+
+	// a query that does not support a context is not run once the deadline was exceeded by an earlier query of the batch
+	f.dailyVolumeByDateQuery = &stalledDailyVolumeQuery{mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 0.0, QuoteVol: 0.0}}}
+	_, e = f.Apply([]txnbuild.Operation{makeManageSellOffer("2.0", "5.0")}, []hProtocol.Offer{}, []hProtocol.Offer{})
+	assert.Error(t, e)
+	assert.Equal(t, 0, len(buyQuery.calledArgs))
+
+	// the window query of a non-daily mode is bounded by the same deadline
+	windowConfig := makeRawVolumeFilterConfig(pointy.Float64(100.0), nil, volumeFilterModeExact, []string{}, []string{})
+	windowConfig.WindowDuration = 24 * time.Hour
+	windowConfig.QueryTimeout = 10 * time.Millisecond
+	if !assert.NoError(t, windowConfig.Validate()) {
+		return
+	}
+	windowQuery := &stalledDailyVolumeQuery{}
+	windowFilter := makeTestVolumeFilter(windowConfig, nil)
+	windowFilter.dailyVolumeByDateQuery = windowQuery
+	start = time.Now()
+	_, e = windowFilter.Apply([]txnbuild.Operation{makeManageSellOffer("2.0", "5.0")}, []hProtocol.Offer{}, []hProtocol.Offer{})
+	if assert.Error(t, e) {
+		assert.Contains(t, e.Error(), context.DeadlineExceeded.Error())
+	}
+	assert.True(t, time.Since(start) < time.Second)
+	if assert.Equal(t, 1, len(windowQuery.calledArgs)) {
+		assert.Equal(t, 2, len(windowQuery.calledArgs[0]))
+	}
+
+	config.QueryTimeout = -time.Second
+	assert.Error(t, config.Validate())
+}
+
+func TestVolumeFilterApplyScaleMode(t *testing.T) {
+	testCases := []struct {
+		name           string
+		capInBase      *float64
+		capInQuote     *float64
+		maxBatchVolume *float64
+		sellingOffers  []hProtocol.Offer
+		wantAmounts    []string
+		wantTotal      float64
+	}{
+		{
+			name:        "base cap",
+			capInBase:   pointy.Float64(100.0),
+			wantAmounts: []string{"26.6666666", "26.6666666", "26.6666666"},
+			wantTotal:   80.0,
+		}, {
+			name:        "quote cap",
+			capInQuote:  pointy.Float64(130.0),
+			wantAmounts: []string{"20.0000000", "20.0000000", "20.0000000"},
+			wantTotal:   60.0,
+		}, {
+			name:           "max batch volume",
+			capInBase:      pointy.Float64(1000.0),
+			maxBatchVolume: pointy.Float64(30.0),
+			wantAmounts:    []string{"10.0000000", "10.0000000", "10.0000000"},
+			wantTotal:      30.0,
+		}, {
+			name:          "resting offer reserves headroom",
+			capInBase:     pointy.Float64(100.0),
+			sellingOffers: []hProtocol.Offer{makeSellingOffer(1, 2, 1, "20.0")},
+			wantAmounts:   []string{"20.0000000", "20.0000000", "20.0000000"},
+			wantTotal:     60.0,
+		}, {
+			name:        "ops fit within the cap",
+			capInBase:   pointy.Float64(200.0),
+			wantAmounts: []string{"40.0", "40.0", "40.0"},
+			wantTotal:   120.0,
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			config := makeRawVolumeFilterConfig(k.capInBase, k.capInQuote, volumeFilterModeScale, []string{}, []string{})
+			config.MaxBatchVolume = k.maxBatchVolume
+			if !assert.NoError(t, config.Validate()) {
+				return
+			}
+			// 20 base units and 10 quote units were sold today
+			f := makeTestVolumeFilter(config, &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 20.0, QuoteVol: 10.0}})
+			ops := []txnbuild.Operation{
+				makeManageSellOffer("2.0", "40.0"),
+				makeManageSellOffer("2.0", "40.0"),
+				makeManageSellOffer("2.0", "40.0"),
+			}
+			sellingOffers := k.sellingOffers
+			if sellingOffers == nil {
+				sellingOffers = []hProtocol.Offer{}
+			}
+
+			filtered, e := f.Apply(ops, sellingOffers, []hProtocol.Offer{})
+			if !assert.NoError(t, e) {
+				return
+			}
+			amounts := []string{}
+			total := 0.0
+			for _, op := range filtered {
+				mso := op.(*txnbuild.ManageSellOffer)
+				if mso.OfferID != 0 {
+					// the resting offer is kept as-is
+					continue
+				}
+				amounts = append(amounts, mso.Amount)
+				amount, e := strconv.ParseFloat(mso.Amount, 64)
+				if !assert.NoError(t, e) {
+					return
+				}
+				total += amount
+			}
+			assert.Equal(t, k.wantAmounts, amounts)
+			assert.InDelta(t, k.wantTotal, total, 1e-6)
+		})
+	}
+
+	mode, e := parseVolumeFilterMode("scale")
+	if assert.NoError(t, e) {
+		assert.Equal(t, volumeFilterModeScale, mode)
+		assert.True(t, mode.trimsOps())
+	}
+	assert.False(t, volumeFilterModeIgnore.trimsOps())
+}
+
+func TestVolumeFilterApplyIncludeFlippedMarketIDs(t *testing.T) {
+	config := makeRawVolumeFilterConfig(pointy.Float64(100.0), pointy.Float64(100.0), volumeFilterModeExact, []string{}, []string{})
+	config.BuyBaseAssetCapInBaseUnits = pointy.Float64(100.0)
+	config.IncludeFlippedMarketIDs = true
+	if !assert.NoError(t, config.Validate()) {
+		return
+	}
+	// 20 base units were sold for 10 quote units on the market, and 5 quote units were bought for 15 base units on the flipped market
+	f := makeTestVolumeFilter(config, &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 20.0, QuoteVol: 10.0}})
+	f.dailyBuyVolumeByDateQuery = &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 30.0, QuoteVol: 1.0}}
+	flippedQuery := &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 5.0, QuoteVol: 15.0}}
+	flippedBuyQuery := &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 2.0, QuoteVol: 40.0}}
+	f.flippedDailyVolumeByDateQuery = flippedQuery
+	f.flippedDailyBuyVolumeByDateQuery = flippedBuyQuery
+	now, _ := time.Parse(time.RFC3339, "2020-01-22T12:00:00Z")
+	f.nowFn = func() time.Time { return now }
+
+	ops, batch, e := f.apply([]txnbuild.Operation{makeManageSellOffer("1.0", "100.0")}, []hProtocol.Offer{}, []hProtocol.Offer{})
+	if !assert.NoError(t, e) {
+		return
+	}
+	assert.InDelta(t, 35.0, *batch.dailyOTB.SellBaseAssetCapInBaseUnits, 1e-7)
+	assert.InDelta(t, 15.0, *batch.dailyOTB.SellBaseAssetCapInQuoteUnits, 1e-7)
+	assert.InDelta(t, 70.0, *batch.dailyOTB.BuyBaseAssetCapInBaseUnits, 1e-7)
+	assert.InDelta(t, 3.0, *batch.dailyOTB.BuyBaseAssetCapInQuoteUnits, 1e-7)
+	if assert.Equal(t, 1, len(ops)) {
+		// the base cap binds with the volume sold on the flipped market
+		assert.Equal(t, "65.0000000", ops[0].(*txnbuild.ManageSellOffer).Amount)
+	}
+	assert.Equal(t, [][]interface{}{{"2020/01/22"}}, flippedQuery.calledArgs)
+	assert.Equal(t, [][]interface{}{{"2020/01/22"}}, flippedBuyQuery.calledArgs)
+
+	// the query result is not modified when the flipped volume is added
+	assert.Equal(t, &queries.DailyVolume{BaseVol: 20.0, QuoteVol: 10.0}, f.dailyVolumeByDateQuery.(*mockDailyVolumeQuery).result)
+
+	// an error on the flipped market fails the batch
+	flippedQuery.err = fmt.Errorf("query failed")
+	_, e = f.Apply([]txnbuild.Operation{makeManageSellOffer("1.0", "100.0")}, []hProtocol.Offer{}, []hProtocol.Offer{})
+	assert.Error(t, e)
+
+	// the filter queries the flipped market with the flipped actions
+	testAssetDisplayFn := model.MakeSdexMappedAssetDisplayFn(map[model.Asset]hProtocol.Asset{
+		model.Asset("XLM"): utils.NativeAsset,
+		model.Asset("USD"): testBuyBaseAsset,
+	})
+	tradingPair := &model.TradingPair{Base: "XLM", Quote: "USD"}
+	db := &sql.DB{}
+	actual, e := makeFilterVolume("", "exchange 1", tradingPair, testAssetDisplayFn, utils.NativeAsset, testBuyBaseAsset, db, config)
+	if !assert.NoError(t, e) {
+		return
+	}
+	flippedMarketID := MakeMarketID("exchange 1", "USD:GBAA4Z4QG5JOTMPF4GNDI6NLNZLBKIDO3JKFCYR4HMUNRKT2CIJLVZTF", "native")
+	wantFlippedQuery, e := queries.MakeDailyVolumeByShiftedDateForMarketIdsAction(db, []string{flippedMarketID}, "buy", []string{}, nil, 0, false)
+	if !assert.NoError(t, e) {
+		return
+	}
+	wantFlippedBuyQuery, e := queries.MakeDailyVolumeByShiftedDateForMarketIdsAction(db, []string{flippedMarketID}, "sell", []string{}, nil, 0, false)
+	if !assert.NoError(t, e) {
+		return
+	}
+	assert.Equal(t, wantFlippedQuery, actual.(*volumeFilter).flippedDailyVolumeByDateQuery)
+	assert.Equal(t, wantFlippedBuyQuery, actual.(*volumeFilter).flippedDailyBuyVolumeByDateQuery)
+
+	config.IncludeFlippedMarketIDs = false
+	actual, e = makeFilterVolume("", "exchange 1", tradingPair, testAssetDisplayFn, utils.NativeAsset, testBuyBaseAsset, db, config)
+	if assert.NoError(t, e) {
+		assert.Nil(t, actual.(*volumeFilter).flippedDailyVolumeByDateQuery)
+		assert.Nil(t, actual.(*volumeFilter).flippedDailyBuyVolumeByDateQuery)
+	}
+
+	config.IncludeFlippedMarketIDs = true
+	config.RollingTradeCount = 10
+	assert.Error(t, config.Validate())
+}
+
+// mockAccountVolumeQuery is an api.Query that returns a fixed daily volume for each account and records the args it was called with
+type mockAccountVolumeQuery struct {
+	result     map[string]*queries.DailyVolume
+	calledArgs [][]interface{}
+}
+
+// Name impl.
+func (q *mockAccountVolumeQuery) Name() string {
+	return "mockAccountVolumeQuery"
+}
+
+// QueryRow impl.
+func (q *mockAccountVolumeQuery) QueryRow(args ...interface{}) (interface{}, error) {
+	q.calledArgs = append(q.calledArgs, args)
+	return q.result, nil
+}
+
+func TestVolumeFilterApplyAccountCaps(t *testing.T) {
+	makeAccountOp := func(accountID string, price string, amount string) *txnbuild.ManageSellOffer {
+		op := makeManageSellOffer(price, amount)
+		if accountID != "" {
+			op.SourceAccount = &txnbuild.SimpleAccount{AccountID: accountID}
+		}
+		return op
+	}
+
+	testCases := []struct {
+		name             string
+		mode             volumeFilterMode
+		defaultAccountID string
+		ops              []txnbuild.Operation
+		wantAmounts      []string
+		wantDropCounts   map[VolumeFilterDropReason]int
+	}{
+		{
+			name: "each account is trimmed to its own cap",
+			mode: volumeFilterModeExact,
+			ops: []txnbuild.Operation{
+				makeAccountOp(testAccountID1, "2.0", "8.0"),
+				makeAccountOp(testAccountID2, "2.0", "8.0"),
+				makeAccountOp(testAccountID1, "2.0", "1.0"),
+			},
+			// account 1 already sold 5 of its cap of 10, account 2 already sold 10 quote units of its quote cap of 20
+			wantAmounts:    []string{"5.0000000", "5.0000000"},
+			wantDropCounts: map[VolumeFilterDropReason]int{DropReasonAccountBaseCap: 1},
+		}, {
+			name: "ignore mode drops the op of the account",
+			mode: volumeFilterModeIgnore,
+			ops: []txnbuild.Operation{
+				makeAccountOp(testAccountID1, "2.0", "8.0"),
+				makeAccountOp(testAccountID2, "2.0", "8.0"),
+				makeAccountOp(testAccountID1, "2.0", "5.0"),
+			},
+			wantAmounts:    []string{"5.0"},
+			wantDropCounts: map[VolumeFilterDropReason]int{DropReasonAccountBaseCap: 1, DropReasonAccountQuoteCap: 1},
+		}, {
+			name: "ops without a capped account are only limited by the market cap",
+			mode: volumeFilterModeExact,
+			ops: []txnbuild.Operation{
+				makeAccountOp("", "2.0", "8.0"),
+				makeAccountOp("GCS2JAJH2T6KABTXOPUPSBTSVQ3BTU2ZJTNHVJTNUSYHAA7LG4GKCC6J", "2.0", "8.0"),
+			},
+			wantAmounts:    []string{"8.0", "8.0"},
+			wantDropCounts: map[VolumeFilterDropReason]int{},
+		}, {
+			name:             "ops without a source account use the default account",
+			mode:             volumeFilterModeExact,
+			defaultAccountID: testAccountID1,
+			ops:              []txnbuild.Operation{makeAccountOp("", "2.0", "8.0")},
+			wantAmounts:      []string{"5.0000000"},
+			wantDropCounts:   map[VolumeFilterDropReason]int{},
+		}, {
+			name: "the market cap binds before the account cap",
+			mode: volumeFilterModeExact,
+			ops: []txnbuild.Operation{
+				makeAccountOp("", "2.0", "80.0"),
+				makeAccountOp(testAccountID1, "2.0", "4.0"),
+			},
+			wantAmounts:    []string{"80.0", "1.0000000"},
+			wantDropCounts: map[VolumeFilterDropReason]int{},
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			config := makeRawVolumeFilterConfig(pointy.Float64(100.0), nil, k.mode, []string{}, []string{})
+			config.AccountCaps = map[string]AccountVolumeCap{
+				testAccountID1: {SellBaseAssetCapInBaseUnits: pointy.Float64(10.0)},
+				testAccountID2: {SellBaseAssetCapInQuoteUnits: pointy.Float64(20.0)},
+			}
+			config.DefaultAccountID = k.defaultAccountID
+			if !assert.NoError(t, config.Validate()) {
+				return
+			}
+			f := makeTestVolumeFilter(config, &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 19.0, QuoteVol: 38.0}})
+			accountQuery := &mockAccountVolumeQuery{result: map[string]*queries.DailyVolume{
+				testAccountID1: {BaseVol: 5.0, QuoteVol: 10.0},
+				testAccountID2: {BaseVol: 5.0, QuoteVol: 10.0},
+			}}
+			f.dailyVolumeByAccountQuery = accountQuery
+			now, _ := time.Parse(time.RFC3339, "2020-01-22T12:00:00Z")
+			f.nowFn = func() time.Time { return now }
+
+			actual, batch, e := f.apply(k.ops, []hProtocol.Offer{}, []hProtocol.Offer{})
+			if !assert.NoError(t, e) {
+				return
+			}
+			actualAmounts := []string{}
+			for _, op := range actual {
+				actualAmounts = append(actualAmounts, op.(*txnbuild.ManageSellOffer).Amount)
+			}
+			assert.Equal(t, k.wantAmounts, actualAmounts)
+			assert.Equal(t, k.wantDropCounts, batch.dropCounts)
+			assert.Equal(t, [][]interface{}{{"2020/01/22"}}, accountQuery.calledArgs)
+		})
+	}
+
+	// the filter queries the volume of the accounts of the AccountCaps
+	config := makeRawVolumeFilterConfig(pointy.Float64(100.0), nil, volumeFilterModeExact, []string{}, []string{})
+	config.AccountCaps = map[string]AccountVolumeCap{
+		testAccountID2: {SellBaseAssetCapInBaseUnits: pointy.Float64(10.0)},
+		testAccountID1: {SellBaseAssetCapInBaseUnits: pointy.Float64(10.0)},
+	}
+	testAssetDisplayFn := model.MakeSdexMappedAssetDisplayFn(map[model.Asset]hProtocol.Asset{model.Asset("XLM"): utils.NativeAsset})
+	tradingPair := &model.TradingPair{Base: "XLM", Quote: "XLM"}
+	db := &sql.DB{}
+	actual, e := makeFilterVolume("", "exchange 1", tradingPair, testAssetDisplayFn, utils.NativeAsset, utils.NativeAsset, db, config)
+	if !assert.NoError(t, e) {
+		return
+	}
+	wantQuery, e := queries.MakeDailyVolumeByAccountForMarketIdsAction(db, []string{MakeMarketID("exchange 1", "native", "native")}, "sell", []string{testAccountID1, testAccountID2}, nil, 0)
+	if !assert.NoError(t, e) {
+		return
+	}
+	assert.Equal(t, wantQuery, actual.(*volumeFilter).dailyVolumeByAccountQuery)
+
+	for _, accountCaps := range []map[string]AccountVolumeCap{
+		{"GABC": {SellBaseAssetCapInBaseUnits: pointy.Float64(10.0)}},
+		{testAccountID1: {}},
+		{testAccountID1: {SellBaseAssetCapInQuoteUnits: pointy.Float64(-1.0)}},
+	} {
+		config.AccountCaps = accountCaps
+		assert.Error(t, config.Validate(), fmt.Sprintf("%v", accountCaps))
+	}
+	config.AccountCaps = map[string]AccountVolumeCap{testAccountID1: {SellBaseAssetCapInBaseUnits: pointy.Float64(10.0)}}
+	config.DefaultAccountID = testAccountID2
+	assert.Error(t, config.Validate())
+	config.DefaultAccountID = ""
+	config.RollingTradeCount = 10
+	assert.Error(t, config.Validate())
+}
+
+// mockUSDPriceFeed is an api.PriceFeed that returns a fixed price and counts the number of calls
+type mockUSDPriceFeed struct {
+	price    float64
+	err      error
+	numCalls int
+}
+
+// GetPrice impl.
+func (p *mockUSDPriceFeed) GetPrice() (float64, error) {
+	p.numCalls++
+	return p.price, p.err
+}
+
+func TestVolumeFilterApplySellCapInUSD(t *testing.T) {
+	testCases := []struct {
+		name           string
+		mode           volumeFilterMode
+		wantAmounts    []string
+		wantDropCounts map[VolumeFilterDropReason]int
+	}{
+		{
+			name:           "exact mode trims the op that crosses the cap",
+			mode:           volumeFilterModeExact,
+			wantAmounts:    []string{"3.0", "2.0000000"},
+			wantDropCounts: map[VolumeFilterDropReason]int{},
+		}, {
+			name:           "ignore mode drops the op that crosses the cap",
+			mode:           volumeFilterModeIgnore,
+			wantAmounts:    []string{"3.0"},
+			wantDropCounts: map[VolumeFilterDropReason]int{DropReasonUSDCap: 1},
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			// 10 base units were already sold, which are worth $20 at the current price, so $10 (5 base units) of the cap is left
+			config := makeRawVolumeFilterConfig(nil, nil, k.mode, []string{}, []string{})
+			config.SellBaseAssetCapInUSD = pointy.Float64(30.0)
+			priceFeed := &mockUSDPriceFeed{price: 2.0}
+			config.PriceFeed = priceFeed
+			if !assert.NoError(t, config.Validate()) {
+				return
+			}
+			f := makeTestVolumeFilter(config, &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 10.0, QuoteVol: 1.0}})
+
+			actual, batch, e := f.apply([]txnbuild.Operation{makeManageSellOffer("0.1", "3.0"), makeManageSellOffer("0.1", "4.0")}, []hProtocol.Offer{}, []hProtocol.Offer{})
+			if !assert.NoError(t, e) {
+				return
+			}
+			actualAmounts := []string{}
+			for _, op := range actual {
+				actualAmounts = append(actualAmounts, op.(*txnbuild.ManageSellOffer).Amount)
+			}
+			assert.Equal(t, k.wantAmounts, actualAmounts)
+			assert.Equal(t, k.wantDropCounts, batch.dropCounts)
+			// the price is fetched once for the batch
+			assert.Equal(t, 1, priceFeed.numCalls)
+		})
+	}
+
+	config := makeRawVolumeFilterConfig(nil, nil, volumeFilterModeExact, []string{}, []string{})
+	config.SellBaseAssetCapInUSD = pointy.Float64(30.0)
+	assert.Error(t, config.Validate())
+
+	config.PriceFeed = &mockUSDPriceFeed{err: fmt.Errorf("feed failed")}
+	f := makeTestVolumeFilter(config, &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 10.0, QuoteVol: 1.0}})
+	_, e := f.Apply([]txnbuild.Operation{makeManageSellOffer("0.1", "3.0")}, []hProtocol.Offer{}, []hProtocol.Offer{})
+	assert.Error(t, e)
+
+	config.PriceFeed = &mockUSDPriceFeed{price: 0.0}
+	_, e = f.Apply([]txnbuild.Operation{makeManageSellOffer("0.1", "3.0")}, []hProtocol.Offer{}, []hProtocol.Offer{})
+	assert.Error(t, e)
+}
@@ -0,0 +1,84 @@
+package plugins
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stellar/kelp/queries"
+)
+
+// VolumeQueryCache is a short-lived cache of the results of the daily volume queries that is shared between the volume filters, so the
+// filters that are chained on the same market in a tick reuse the result of a single query. The results are keyed by the date, the action,
+// the market IDs, and the account IDs of the query (see volumeQueryCacheKey) and expire after the TTL, which is usually the tick interval.
+// It is safe for concurrent use.
+type VolumeQueryCache struct {
+	ttl     time.Duration
+	mutex   sync.Mutex
+	entries map[string]volumeQueryCacheEntry
+}
+
+type volumeQueryCacheEntry struct {
+	result    *queries.DailyVolume
+	queriedAt time.Time
+}
+
+// MakeVolumeQueryCache makes a VolumeQueryCache where the results expire after the passed in TTL, which needs to be positive
+func MakeVolumeQueryCache(ttl time.Duration) (*VolumeQueryCache, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("the TTL of the volume query cache needs to be positive but was %s", ttl)
+	}
+
+	return &VolumeQueryCache{
+		ttl:     ttl,
+		entries: map[string]volumeQueryCacheEntry{},
+	}, nil
+}
+
+// Invalidate removes all the results from the cache so the next lookup of every query runs the query again
+func (c *VolumeQueryCache) Invalidate() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.entries = map[string]volumeQueryCacheEntry{}
+}
+
+// get returns the result cached for the key if it was queried within the TTL of the passed in time
+func (c *VolumeQueryCache) get(key string, now time.Time) (*queries.DailyVolume, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if now.Sub(entry.queriedAt) >= c.ttl {
+		// the result expired so drop it to keep entries from accumulating across the dates
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.result, true
+}
+
+// put caches the result for the key as queried at the passed in time
+func (c *VolumeQueryCache) put(key string, result *queries.DailyVolume, now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.entries[key] = volumeQueryCacheEntry{
+		result:    result,
+		queriedAt: now,
+	}
+}
+
+// volumeQueryCacheKey makes the key of a daily volume query without the date, which includes everything that goes into the SQL of the query
+// so that filters share a result only when they run the same query
+func volumeQueryCacheKey(action string, marketIDs []string, accountIDs []string, minFillPriceToCount *float64, resetTimeOfDay time.Duration) string {
+	minPrice := "<nil>"
+	if minFillPriceToCount != nil {
+		minPrice = fmt.Sprintf("%g", *minFillPriceToCount)
+	}
+	return fmt.Sprintf("action=%s|marketIDs=%s|accountIDs=%s|minFillPrice=%s|resetTimeOfDay=%s",
+		action, strings.Join(marketIDs, ","), strings.Join(accountIDs, ","), minPrice, resetTimeOfDay)
+}
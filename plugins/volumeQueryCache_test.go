@@ -0,0 +1,116 @@
+package plugins
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/openlyinc/pointy"
+	hProtocol "github.com/stellar/go/protocols/horizon"
+	"github.com/stellar/go/txnbuild"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/stellar/kelp/api"
+	"github.com/stellar/kelp/model"
+	"github.com/stellar/kelp/queries"
+	"github.com/stellar/kelp/support/utils"
+)
+
+func TestVolumeQueryCache(t *testing.T) {
+	_, e := MakeVolumeQueryCache(0)
+	assert.Error(t, e)
+
+	c, e := MakeVolumeQueryCache(10 * time.Second)
+	if !assert.NoError(t, e) {
+		return
+	}
+	now := time.Date(2020, 1, 21, 12, 0, 0, 0, time.UTC)
+	result := &queries.DailyVolume{BaseVol: 1.0, QuoteVol: 2.0}
+	c.put("key1", result, now)
+
+	actual, ok := c.get("key1", now.Add(9*time.Second))
+	if assert.True(t, ok) {
+		assert.Equal(t, result, actual)
+	}
+	_, ok = c.get("key2", now)
+	assert.False(t, ok)
+	_, ok = c.get("key1", now.Add(10*time.Second))
+	assert.False(t, ok)
+
+	c.put("key1", result, now)
+	c.Invalidate()
+	_, ok = c.get("key1", now)
+	assert.False(t, ok)
+}
+
+func TestVolumeFilterApplySharedQueryCache(t *testing.T) {
+	c, e := MakeVolumeQueryCache(10 * time.Second)
+	if !assert.NoError(t, e) {
+		return
+	}
+	now, _ := time.Parse(time.RFC3339, "2020-01-21T12:00:00Z")
+	nowFn := func() time.Time { return now }
+	makeFilter := func(key string) (*volumeFilter, *mockDailyVolumeQuery) {
+		config := makeRawVolumeFilterConfig(pointy.Float64(100.0), nil, volumeFilterModeExact, []string{}, []string{})
+		config.SharedQueryCache = c
+		query := &mockDailyVolumeQuery{result: &queries.DailyVolume{BaseVol: 10.0, QuoteVol: 20.0}}
+		f := makeTestVolumeFilter(config, query)
+		f.sharedCacheKeys = map[api.Query]string{query: key}
+		f.nowFn = nowFn
+		return f, query
+	}
+	f1, query1 := makeFilter("market1")
+	f2, query2 := makeFilter("market1")
+	f3, query3 := makeFilter("market2")
+
+	apply := func(f *volumeFilter) {
+		_, e := f.Apply([]txnbuild.Operation{makeManageSellOffer("2.0", "5.0")}, []hProtocol.Offer{}, []hProtocol.Offer{})
+		assert.NoError(t, e)
+	}
+	apply(f1)
+	apply(f2)
+	apply(f3)
+	assert.Equal(t, 1, len(query1.calledArgs))
+	assert.Equal(t, 0, len(query2.calledArgs), "the filter on the same market reuses the result")
+	assert.Equal(t, 1, len(query3.calledArgs), "the filter on a different market runs its own query")
+
+	// the result expires after the TTL
+	now = now.Add(10 * time.Second)
+	apply(f2)
+	assert.Equal(t, 1, len(query2.calledArgs))
+
+	// and it can be refreshed before the TTL
+	c.Invalidate()
+	apply(f1)
+	assert.Equal(t, 2, len(query1.calledArgs))
+
+	// the filters made by the factory share the cache when they run the same query
+	testAssetDisplayFn := model.MakeSdexMappedAssetDisplayFn(map[model.Asset]hProtocol.Asset{model.Asset("XLM"): utils.NativeAsset})
+	factory := &FilterFactory{
+		ExchangeName:     "exchange 1",
+		TradingPair:      &model.TradingPair{Base: "XLM", Quote: "XLM"},
+		AssetDisplayFn:   testAssetDisplayFn,
+		BaseAsset:        utils.NativeAsset,
+		QuoteAsset:       utils.NativeAsset,
+		DB:               &sql.DB{},
+		VolumeQueryCache: c,
+	}
+	sharedCacheKey := func(configInput string) string {
+		filter, e := factory.MakeFilter(configInput)
+		if !assert.NoError(t, e) {
+			return ""
+		}
+		f := filter.(*volumeFilter)
+		return f.sharedCacheKeys[f.dailyVolumeByDateQuery]
+	}
+	baseKey := sharedCacheKey("volume/daily/sell/base/3500.0/exact")
+	assert.NotEqual(t, "", baseKey)
+	assert.Equal(t, baseKey, sharedCacheKey("volume/daily/sell/quote/1000.0/ignore"))
+	assert.NotEqual(t, baseKey, sharedCacheKey("volume/daily:market_ids=[4c19915f47]/sell/base/3500.0/exact"))
+
+	factory.VolumeQueryCache = nil
+	filter, e := factory.MakeFilter("volume/daily/sell/base/3500.0/exact")
+	if assert.NoError(t, e) {
+		assert.Nil(t, filter.(*volumeFilter).sharedCacheKeys)
+	}
+}
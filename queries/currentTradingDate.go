@@ -0,0 +1,57 @@
+package queries
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/stellar/kelp/api"
+)
+
+// sqlQueryCurrentTradingDate uses the same DATE() bucketing as the daily volume queries, formatted to match postgresdb.DateFormatString
+const sqlQueryCurrentTradingDate = "SELECT TO_CHAR(DATE(NOW() AT TIME ZONE 'UTC'), 'YYYY/MM/DD')"
+
+// CurrentTradingDate is a query that fetches the current trading date as reported by the database
+type CurrentTradingDate struct {
+	db       *sql.DB
+	sqlQuery string
+}
+
+var _ api.ContextQuery = &CurrentTradingDate{}
+
+// MakeCurrentTradingDate makes the CurrentTradingDate query
+func MakeCurrentTradingDate(db *sql.DB) (*CurrentTradingDate, error) {
+	if db == nil {
+		return nil, fmt.Errorf("the provided db should be non-nil")
+	}
+
+	return &CurrentTradingDate{
+		db:       db,
+		sqlQuery: sqlQueryCurrentTradingDate,
+	}, nil
+}
+
+// Name impl.
+func (q *CurrentTradingDate) Name() string {
+	return "CurrentTradingDate"
+}
+
+// QueryRow impl.
+func (q *CurrentTradingDate) QueryRow(args ...interface{}) (interface{}, error) {
+	return q.QueryRowContext(context.Background(), args...)
+}
+
+// QueryRowContext impl.
+func (q *CurrentTradingDate) QueryRowContext(ctx context.Context, args ...interface{}) (interface{}, error) {
+	if len(args) != 0 {
+		return nil, fmt.Errorf("expected 0 args, but got args %v", args)
+	}
+
+	row := q.db.QueryRowContext(ctx, q.sqlQuery)
+	var dateString string
+	e := row.Scan(&dateString)
+	if e != nil {
+		return nil, fmt.Errorf("could not read data from CurrentTradingDate query: %s", e)
+	}
+	return dateString, nil
+}
@@ -0,0 +1,37 @@
+package queries
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/stellar/kelp/support/postgresdb"
+)
+
+func TestCurrentTradingDate_QueryRow(t *testing.T) {
+	db := connectTestDb()
+	defer db.Close()
+
+	q, e := MakeCurrentTradingDate(db)
+	if !assert.NoError(t, e) {
+		return
+	}
+
+	before := time.Now().UTC().Format(postgresdb.DateFormatString)
+	result, e := q.QueryRow()
+	if !assert.NoError(t, e) {
+		return
+	}
+	after := time.Now().UTC().Format(postgresdb.DateFormatString)
+
+	dateString, ok := result.(string)
+	if !assert.True(t, ok) {
+		return
+	}
+	// the date could roll over between the two readings of the local clock
+	assert.Contains(t, []string{before, after}, dateString)
+
+	_, e = q.QueryRow("unexpected")
+	assert.Error(t, e)
+}
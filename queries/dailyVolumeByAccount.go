@@ -0,0 +1,113 @@
+package queries
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/stellar/kelp/api"
+)
+
+// sqlQueryDailyValuesByAccountTemplate queries the trades table to get the values of each account for a given day, the date expression is
+// the third param
+const sqlQueryDailyValuesByAccountTemplate = "SELECT account_id, SUM(base_volume) as total_base_volume, SUM(counter_cost) as total_counter_volume FROM trades WHERE market_id IN (%[1]s) AND account_id IN (%[2]s) AND %[3]s = $1 and action = $2%[4]s group by account_id"
+
+// DailyVolumeByAccount is a query that fetches the daily volume of each of a set of accounts, which is used to enforce a separate cap for
+// each account trading on the same markets
+type DailyVolumeByAccount struct {
+	db         *sql.DB
+	sqlQuery   string
+	action     string
+	accountIDs []string
+	minPrice   *float64
+}
+
+var _ api.ContextQuery = &DailyVolumeByAccount{}
+
+// MakeDailyVolumeByAccountForMarketIdsAction makes the DailyVolumeByAccount query for a set of marketIds, an action, and the accountIDs,
+// with the same optionalMinPrice and dayStart as MakeDailyVolumeByShiftedDateForMarketIdsAction
+func MakeDailyVolumeByAccountForMarketIdsAction(
+	db *sql.DB,
+	marketIDs []string,
+	action string,
+	accountIDs []string,
+	optionalMinPrice *float64,
+	dayStart time.Duration,
+) (*DailyVolumeByAccount, error) {
+	if db == nil {
+		return nil, fmt.Errorf("the provided db should be non-nil")
+	}
+	if len(accountIDs) == 0 {
+		return nil, fmt.Errorf("need at least 1 account ID")
+	}
+	if dayStart <= -24*time.Hour || dayStart >= 24*time.Hour {
+		return nil, fmt.Errorf("the dayStart needs to be within (-24h, 24h) but was %s", dayStart)
+	}
+
+	minPriceClause := ""
+	if optionalMinPrice != nil {
+		minPriceClause = sqlQueryDailyValuesMinPriceClause
+	}
+	sqlQuery := fmt.Sprintf(sqlQueryDailyValuesByAccountTemplate, makeInClause(marketIDs), makeInClause(accountIDs), makeDateExpression(dayStart), minPriceClause)
+	return &DailyVolumeByAccount{
+		db:         db,
+		sqlQuery:   sqlQuery,
+		action:     action,
+		accountIDs: accountIDs,
+		minPrice:   optionalMinPrice,
+	}, nil
+}
+
+// Name impl.
+func (q *DailyVolumeByAccount) Name() string {
+	return "DailyVolumeByAccount"
+}
+
+// QueryRow impl. The result is a map[string]*DailyVolume keyed by the account ID, which has an entry with a zero volume for every account
+// that did not trade on the date.
+func (q *DailyVolumeByAccount) QueryRow(args ...interface{}) (interface{}, error) {
+	return q.QueryRowContext(context.Background(), args...)
+}
+
+// QueryRowContext impl.
+func (q *DailyVolumeByAccount) QueryRowContext(ctx context.Context, args ...interface{}) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("expected 1 arg (dateUTC string), but got args %v", args)
+	} else if _, ok := args[0].(string); !ok {
+		return nil, fmt.Errorf("input arg needs to be of type 'string', but was of type '%T'", args[0])
+	}
+
+	queryArgs := []interface{}{args[0], q.action}
+	if q.minPrice != nil {
+		queryArgs = append(queryArgs, *q.minPrice)
+	}
+	rows, e := q.db.QueryContext(ctx, q.sqlQuery, queryArgs...)
+	if e != nil {
+		return nil, fmt.Errorf("could not execute DailyVolumeByAccount query: %s", e)
+	}
+	defer rows.Close()
+
+	volumes := map[string]*DailyVolume{}
+	for _, accountID := range q.accountIDs {
+		volumes[accountID] = &DailyVolume{BaseVol: 0, QuoteVol: 0}
+	}
+	for rows.Next() {
+		var accountID string
+		var baseVol sql.NullFloat64
+		var quoteVol sql.NullFloat64
+		e = rows.Scan(&accountID, &baseVol, &quoteVol)
+		if e != nil {
+			return nil, fmt.Errorf("could not read row from DailyVolumeByAccount query: %s", e)
+		}
+
+		volumes[accountID] = &DailyVolume{
+			BaseVol:  baseVol.Float64,
+			QuoteVol: quoteVol.Float64,
+		}
+	}
+	if e = rows.Err(); e != nil {
+		return nil, fmt.Errorf("could not read rows from DailyVolumeByAccount query: %s", e)
+	}
+	return volumes, nil
+}
@@ -0,0 +1,155 @@
+package queries
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/openlyinc/pointy"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/stellar/kelp/kelpdb"
+	"github.com/stellar/kelp/model"
+	"github.com/stellar/kelp/support/postgresdb"
+)
+
+func TestMakeDailyVolumeByAccountForMarketIdsAction(t *testing.T) {
+	q, e := MakeDailyVolumeByAccountForMarketIdsAction(&sql.DB{}, []string{"market1"}, "sell", []string{"account1", "account2"}, pointy.Float64(0.01), 22*time.Hour)
+	if !assert.NoError(t, e) {
+		return
+	}
+	assert.Equal(t, "DailyVolumeByAccount", q.Name())
+	assert.Equal(t, "SELECT account_id, SUM(base_volume) as total_base_volume, SUM(counter_cost) as total_counter_volume FROM trades WHERE market_id IN ('market1') AND account_id IN ('account1', 'account2') AND DATE(date_utc - INTERVAL '79200 seconds') = $1 and action = $2 AND counter_price > $3 group by account_id", q.sqlQuery)
+
+	_, e = MakeDailyVolumeByAccountForMarketIdsAction(nil, []string{"market1"}, "sell", []string{"account1"}, nil, 0)
+	assert.Error(t, e)
+	_, e = MakeDailyVolumeByAccountForMarketIdsAction(&sql.DB{}, []string{"market1"}, "sell", []string{}, nil, 0)
+	assert.Error(t, e)
+	_, e = MakeDailyVolumeByAccountForMarketIdsAction(&sql.DB{}, []string{"market1"}, "sell", []string{"account1"}, nil, 24*time.Hour)
+	assert.Error(t, e)
+
+	for _, args := range [][]interface{}{
+		{},
+		{5},
+		{"2020/01/20", "2020/01/21"},
+	} {
+		_, e = q.QueryRow(args...)
+		assert.Error(t, e, fmt.Sprintf("%v", args))
+	}
+}
+
+func TestDailyVolumeByAccount_QueryRow(t *testing.T) {
+	// setup db
+	today, _ := time.Parse(time.RFC3339, "2020-01-21T15:00:00Z")
+	tomorrow, _ := time.Parse(time.RFC3339, "2020-01-22T15:00:00Z")
+	setupStatements := []string{
+		kelpdb.SqlTradesTableCreate,
+		"ALTER TABLE trades DROP COLUMN IF EXISTS account_id",
+		"ALTER TABLE trades DROP COLUMN IF EXISTS order_id",
+		kelpdb.SqlTradesTableAlter1,
+		kelpdb.SqlTradesTableAlter2,
+		"DELETE FROM trades", // clear table
+		fmt.Sprintf(kelpdb.SqlTradesInsertTemplate,
+			"market1",
+			"1",
+			today.Format(postgresdb.TimestampFormatString),
+			model.OrderActionSell.String(),
+			model.OrderTypeLimit.String(),
+			0.10,  // price
+			100.0, // volume
+			10.0,  // cost
+			0.0,   // fee
+			"accountID1",
+			"",
+		),
+		fmt.Sprintf(kelpdb.SqlTradesInsertTemplate,
+			"market1",
+			"2",
+			today.Add(time.Second*1).Format(postgresdb.TimestampFormatString),
+			model.OrderActionSell.String(),
+			model.OrderTypeLimit.String(),
+			0.12, // price
+			6.0,  // volume
+			0.72, // cost
+			0.0,  // fee
+			"accountID1",
+			"",
+		),
+		fmt.Sprintf(kelpdb.SqlTradesInsertTemplate,
+			"market1",
+			"3",
+			today.Format(postgresdb.TimestampFormatString),
+			model.OrderActionSell.String(),
+			model.OrderTypeLimit.String(),
+			0.11,  // price
+			101.0, // volume
+			11.11, // cost
+			0.0,   // fee
+			"accountID2",
+			"",
+		),
+		// a buy, a trade on the next day, and a trade of an account that is not queried are not counted
+		fmt.Sprintf(kelpdb.SqlTradesInsertTemplate,
+			"market1",
+			"4",
+			today.Add(time.Second*2).Format(postgresdb.TimestampFormatString),
+			model.OrderActionBuy.String(),
+			model.OrderTypeLimit.String(),
+			0.12,  // price
+			102.0, // volume
+			12.24, // cost
+			0.0,   // fee
+			"accountID1",
+			"",
+		),
+		fmt.Sprintf(kelpdb.SqlTradesInsertTemplate,
+			"market1",
+			"5",
+			tomorrow.Format(postgresdb.TimestampFormatString),
+			model.OrderActionSell.String(),
+			model.OrderTypeLimit.String(),
+			0.12,  // price
+			102.0, // volume
+			12.24, // cost
+			0.0,   // fee
+			"accountID2",
+			"",
+		),
+		fmt.Sprintf(kelpdb.SqlTradesInsertTemplate,
+			"market1",
+			"6",
+			today.Format(postgresdb.TimestampFormatString),
+			model.OrderActionSell.String(),
+			model.OrderTypeLimit.String(),
+			0.10,  // price
+			100.0, // volume
+			10.0,  // cost
+			0.0,   // fee
+			"accountID4",
+			"",
+		),
+	}
+	db := connectTestDb()
+	defer db.Close()
+	for _, s := range setupStatements {
+		_, e := db.Exec(s)
+		if e != nil {
+			panic(e)
+		}
+	}
+
+	q, e := MakeDailyVolumeByAccountForMarketIdsAction(db, []string{"market1"}, "sell", []string{"accountID1", "accountID2", "accountID3"}, nil, 0)
+	if !assert.NoError(t, e) {
+		return
+	}
+	result, e := q.QueryRow(today.Format(postgresdb.DateFormatString))
+	if !assert.NoError(t, e) {
+		return
+	}
+	assert.Equal(t, map[string]*DailyVolume{
+		"accountID1": {BaseVol: 106.0, QuoteVol: 10.72},
+		"accountID2": {BaseVol: 101.0, QuoteVol: 11.11},
+		"accountID3": {BaseVol: 0.0, QuoteVol: 0.0},
+	}, result)
+}
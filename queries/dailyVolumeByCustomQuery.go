@@ -0,0 +1,105 @@
+package queries
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/stellar/kelp/api"
+)
+
+// dailyVolumeNumColumns is the number of columns a custom query needs to return to be read into a DailyVolume (base volume, quote volume)
+const dailyVolumeNumColumns = 2
+
+// DailyVolumeByCustomQuery is a query that fetches the daily volume using custom user-supplied SQL, useful for a different schema or a materialized view
+type DailyVolumeByCustomQuery struct {
+	db       *sql.DB
+	sqlQuery string
+	args     []interface{}
+}
+
+var _ api.ContextQuery = &DailyVolumeByCustomQuery{}
+
+// MakeDailyVolumeWithCustomQuery makes a DailyVolumeByCustomQuery query. The sqlTemplate receives the date string as $1 followed by any
+// additional args as $2, $3, etc. and needs to return exactly one row with two columns: the total base volume and the total quote volume.
+func MakeDailyVolumeWithCustomQuery(db *sql.DB, sqlTemplate string, args ...interface{}) (*DailyVolumeByCustomQuery, error) {
+	if db == nil {
+		return nil, fmt.Errorf("the provided db should be non-nil")
+	}
+
+	if strings.TrimSpace(sqlTemplate) == "" {
+		return nil, fmt.Errorf("the provided sqlTemplate should be non-empty")
+	}
+
+	if !strings.Contains(sqlTemplate, "$1") {
+		return nil, fmt.Errorf("the provided sqlTemplate should use the date parameter ($1): %s", sqlTemplate)
+	}
+
+	return &DailyVolumeByCustomQuery{
+		db:       db,
+		sqlQuery: sqlTemplate,
+		args:     args,
+	}, nil
+}
+
+// Name impl.
+func (q *DailyVolumeByCustomQuery) Name() string {
+	return "DailyVolumeByCustomQuery"
+}
+
+// QueryRow impl.
+func (q *DailyVolumeByCustomQuery) QueryRow(args ...interface{}) (interface{}, error) {
+	return q.QueryRowContext(context.Background(), args...)
+}
+
+// QueryRowContext impl.
+func (q *DailyVolumeByCustomQuery) QueryRowContext(ctx context.Context, args ...interface{}) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("expected 1 arg (dateUTC string), but got args %v", args)
+	} else if _, ok := args[0].(string); !ok {
+		return nil, fmt.Errorf("input arg needs to be of type 'string', but was of type '%T'", args[0])
+	}
+
+	queryArgs := append([]interface{}{args[0]}, q.args...)
+	rows, e := q.db.QueryContext(ctx, q.sqlQuery, queryArgs...)
+	if e != nil {
+		return nil, fmt.Errorf("could not run custom daily volume query: %s", e)
+	}
+	defer rows.Close()
+
+	columns, e := rows.Columns()
+	if e != nil {
+		return nil, fmt.Errorf("could not read columns from custom daily volume query: %s", e)
+	}
+	if len(columns) != dailyVolumeNumColumns {
+		return nil, fmt.Errorf("custom daily volume query needs to return %d columns (base volume, quote volume) but returned %d columns: %v", dailyVolumeNumColumns, len(columns), columns)
+	}
+
+	if !rows.Next() {
+		if e = rows.Err(); e != nil {
+			return nil, fmt.Errorf("could not read data from custom daily volume query: %s", e)
+		}
+		return &DailyVolume{
+			BaseVol:  0,
+			QuoteVol: 0,
+		}, nil
+	}
+
+	var baseVol sql.NullFloat64
+	var quoteVol sql.NullFloat64
+	e = rows.Scan(&baseVol, &quoteVol)
+	if e != nil {
+		return nil, fmt.Errorf("could not scan data from custom daily volume query into DailyVolume: %s", e)
+	}
+
+	if rows.Next() {
+		return nil, fmt.Errorf("custom daily volume query needs to return at most 1 row but returned more")
+	}
+
+	// SUM over no matching rows returns NULL, which we treat as no volume
+	return &DailyVolume{
+		BaseVol:  baseVol.Float64,
+		QuoteVol: quoteVol.Float64,
+	}, nil
+}
@@ -0,0 +1,90 @@
+package queries
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/stellar/kelp/support/postgresdb"
+)
+
+// sqlQueryCustomDailyVolumeInMemory aggregates over an in-memory table so the test does not depend on the trades schema
+const sqlQueryCustomDailyVolumeInMemory = "SELECT SUM(base) as total_base_volume, SUM(quote) as total_quote_volume FROM (VALUES ('2020/01/20', 100.0, 10.0, 'sell'), ('2020/01/21', 101.0, 11.11, 'sell'), ('2020/01/21', 6.0, 0.72, 'sell'), ('2020/01/21', 50.0, 5.0, 'buy')) AS t(day, base, quote, action) WHERE day = $1 AND action = $2"
+
+func TestMakeDailyVolumeWithCustomQuery(t *testing.T) {
+	testCases := []struct {
+		name        string
+		db          *sql.DB
+		sqlTemplate string
+		wantErr     bool
+	}{
+		{
+			name:        "valid",
+			db:          &sql.DB{},
+			sqlTemplate: sqlQueryCustomDailyVolumeInMemory,
+			wantErr:     false,
+		}, {
+			name:        "nil db",
+			db:          nil,
+			sqlTemplate: sqlQueryCustomDailyVolumeInMemory,
+			wantErr:     true,
+		}, {
+			name:        "empty sql",
+			db:          &sql.DB{},
+			sqlTemplate: "  ",
+			wantErr:     true,
+		}, {
+			name:        "sql without date parameter",
+			db:          &sql.DB{},
+			sqlTemplate: "SELECT 1.0, 2.0",
+			wantErr:     true,
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			q, e := MakeDailyVolumeWithCustomQuery(k.db, k.sqlTemplate, "sell")
+			if k.wantErr {
+				assert.Error(t, e)
+				return
+			}
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.Equal(t, "DailyVolumeByCustomQuery", q.Name())
+		})
+	}
+}
+
+func TestDailyVolumeByCustomQuery_QueryRow(t *testing.T) {
+	db := connectTestDb()
+	defer db.Close()
+
+	yesterday, _ := time.Parse(time.RFC3339, "2020-01-20T15:00:00Z")
+	today, _ := time.Parse(time.RFC3339, "2020-01-21T15:00:00Z")
+	tomorrow, _ := time.Parse(time.RFC3339, "2020-01-22T15:00:00Z")
+
+	sellQuery, e := MakeDailyVolumeWithCustomQuery(db, sqlQueryCustomDailyVolumeInMemory, "sell")
+	if !assert.NoError(t, e) {
+		return
+	}
+	runQueryAndVerifyValues(t, sellQuery, yesterday, 100.0, 10.0)
+	runQueryAndVerifyValues(t, sellQuery, today, 107.0, 11.83)
+	runQueryAndVerifyValues(t, sellQuery, tomorrow, 0.0, 0.0)
+
+	buyQuery, e := MakeDailyVolumeWithCustomQuery(db, sqlQueryCustomDailyVolumeInMemory, "buy")
+	if !assert.NoError(t, e) {
+		return
+	}
+	runQueryAndVerifyValues(t, buyQuery, today, 50.0, 5.0)
+
+	// a query that does not match the shape of DailyVolume should fail
+	badQuery, e := MakeDailyVolumeWithCustomQuery(db, "SELECT SUM(base) FROM (VALUES ('2020/01/21', 1.0)) AS t(day, base) WHERE day = $1")
+	if !assert.NoError(t, e) {
+		return
+	}
+	_, e = badQuery.QueryRow(today.Format(postgresdb.DateFormatString))
+	assert.Error(t, e)
+}
@@ -1,27 +1,49 @@
 package queries
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/stellar/kelp/api"
+	"github.com/stellar/kelp/kelpdb"
 )
 
-// sqlQueryDailyValuesTemplateAllAccounts queries the trades table to get the values for a given day
-const sqlQueryDailyValuesTemplateAllAccounts = "SELECT SUM(base_volume) as total_base_volume, SUM(counter_cost) as total_counter_volume FROM trades WHERE market_id IN (%s) AND DATE(date_utc) = $1 and action = $2 group by DATE(date_utc)"
+// sqlQueryDailyValuesTemplateAllAccounts queries the trades table to get the values for a given day, the date expression is the second param
+const sqlQueryDailyValuesTemplateAllAccounts = "SELECT SUM(base_volume) as total_base_volume, SUM(counter_cost) as total_counter_volume FROM trades WHERE market_id IN (%[1]s) AND %[2]s = $1 and action = $2%[3]s group by %[2]s"
 
-// sqlQueryDailyValuesTemplateSpecificAccounts queries the trades table to get the values for a given day filtered by specific accounts
-const sqlQueryDailyValuesTemplateSpecificAccounts = "SELECT SUM(base_volume) as total_base_volume, SUM(counter_cost) as total_counter_volume FROM trades WHERE market_id IN (%s) AND account_id IN (%s) AND DATE(date_utc) = $1 and action = $2 group by DATE(date_utc)"
+// sqlQueryDailyValuesTemplateSpecificAccounts queries the trades table to get the values for a given day filtered by specific accounts, the
+// date expression is the third param
+const sqlQueryDailyValuesTemplateSpecificAccounts = "SELECT SUM(base_volume) as total_base_volume, SUM(counter_cost) as total_counter_volume FROM trades WHERE market_id IN (%[1]s) AND account_id IN (%[2]s) AND %[3]s = $1 and action = $2%[4]s group by %[3]s"
+
+// sqlQueryDailyValuesDateExpression buckets the trades by their UTC date
+const sqlQueryDailyValuesDateExpression = "DATE(date_utc)"
+
+// sqlQueryDailyValuesShiftedDateExpression buckets the trades by the UTC date of the day that starts at an offset (in seconds) from midnight
+// UTC, which can be negative, and cannot use the index on DATE(date_utc)
+const sqlQueryDailyValuesShiftedDateExpression = "DATE(date_utc - INTERVAL '%d seconds')"
+
+// sqlQueryDailyValuesWithPendingTemplate adds the pending volume of the markets on the date to the settled volume of the trades queried by
+// the first param, the markets are the second param
+const sqlQueryDailyValuesWithPendingTemplate = "SELECT COALESCE(SUM(total_base_volume), 0) as total_base_volume, COALESCE(SUM(total_counter_volume), 0) as total_counter_volume FROM (%[1]s UNION ALL SELECT base_volume as total_base_volume, counter_cost as total_counter_volume FROM pending_volumes WHERE market_id IN (%[2]s) AND trading_date = $1 and action = $2) AS volumes"
+
+// sqlQueryAccountsClause restricts the trades to specific accounts, which is appended to the WHERE clause of the queries that sum the volume
+const sqlQueryAccountsClause = " AND account_id IN (%s)"
+
+// sqlQueryDailyValuesMinPriceClause restricts the trades to those executed above a minimum price
+const sqlQueryDailyValuesMinPriceClause = " AND counter_price > $3"
 
 // DailyVolumeByDate is a query that fetches the daily volume of sales
 type DailyVolumeByDate struct {
 	db       *sql.DB
 	sqlQuery string
 	action   string
+	minPrice *float64
 }
 
-var _ api.Query = &DailyVolumeByDate{}
+var _ api.ContextQuery = &DailyVolumeByDate{}
 
 // DailyVolume represents any volume value which can be either bought or sold depending on the query
 type DailyVolume struct {
@@ -35,16 +57,54 @@ func MakeDailyVolumeByDateForMarketIdsAction(
 	marketIDs []string,
 	action string,
 	optionalAccountIDs []string,
+) (*DailyVolumeByDate, error) {
+	return MakeDailyVolumeByDateForMarketIdsActionAbovePrice(db, marketIDs, action, optionalAccountIDs, nil)
+}
+
+// MakeDailyVolumeByDateForMarketIdsActionAbovePrice makes the DailyVolumeByDate query for a set of marketIds and an action, only counting
+// trades that were executed at a price strictly above the optionalMinPrice (in quote units) when it is non-nil
+func MakeDailyVolumeByDateForMarketIdsActionAbovePrice(
+	db *sql.DB,
+	marketIDs []string,
+	action string,
+	optionalAccountIDs []string,
+	optionalMinPrice *float64,
+) (*DailyVolumeByDate, error) {
+	return MakeDailyVolumeByShiftedDateForMarketIdsAction(db, marketIDs, action, optionalAccountIDs, optionalMinPrice, 0, false)
+}
+
+// MakeDailyVolumeByShiftedDateForMarketIdsAction makes the DailyVolumeByDate query for a set of marketIds and an action like
+// MakeDailyVolumeByDateForMarketIdsActionAbovePrice, where each day starts at dayStart after midnight UTC instead of at midnight UTC. The day
+// is identified by the UTC date on which it starts, e.g. with a dayStart of 22h the date "2020/01/20" covers the trades executed from
+// 2020-01-20T22:00:00Z (inclusive) to 2020-01-21T22:00:00Z (exclusive). A negative dayStart starts the day before midnight UTC, where the
+// day is identified by the UTC date on which it ends, e.g. with a dayStart of -5h the date "2020/01/20" covers the trades executed from
+// 2020-01-19T19:00:00Z (inclusive) to 2020-01-20T19:00:00Z (exclusive). The dayStart needs to be within (-24h, 24h). When includePending is
+// set the query adds the pending volume of the markets on the date (recorded with RecordPendingVolume) to the settled volume of the trades.
+func MakeDailyVolumeByShiftedDateForMarketIdsAction(
+	db *sql.DB,
+	marketIDs []string,
+	action string,
+	optionalAccountIDs []string,
+	optionalMinPrice *float64,
+	dayStart time.Duration,
+	includePending bool,
 ) (*DailyVolumeByDate, error) {
 	if db == nil {
 		return nil, fmt.Errorf("the provided db should be non-nil")
 	}
+	if dayStart <= -24*time.Hour || dayStart >= 24*time.Hour {
+		return nil, fmt.Errorf("the dayStart needs to be within (-24h, 24h) but was %s", dayStart)
+	}
 
-	sqlQuery := makeSQLQueryDailyVolume(marketIDs, optionalAccountIDs)
+	sqlQuery := makeSQLQueryDailyVolume(marketIDs, optionalAccountIDs, optionalMinPrice != nil, dayStart)
+	if includePending {
+		sqlQuery = fmt.Sprintf(sqlQueryDailyValuesWithPendingTemplate, sqlQuery, makeInClause(marketIDs))
+	}
 	return &DailyVolumeByDate{
 		db:       db,
 		sqlQuery: sqlQuery,
 		action:   action,
+		minPrice: optionalMinPrice,
 	}, nil
 }
 
@@ -55,13 +115,22 @@ func (q *DailyVolumeByDate) Name() string {
 
 // QueryRow impl.
 func (q *DailyVolumeByDate) QueryRow(args ...interface{}) (interface{}, error) {
+	return q.QueryRowContext(context.Background(), args...)
+}
+
+// QueryRowContext impl.
+func (q *DailyVolumeByDate) QueryRowContext(ctx context.Context, args ...interface{}) (interface{}, error) {
 	if len(args) != 1 {
 		return nil, fmt.Errorf("expected 1 arg (dateUTC string), but got args %v", args)
 	} else if _, ok := args[0].(string); !ok {
 		return nil, fmt.Errorf("input arg needs to be of type 'string', but was of type '%T'", args[0])
 	}
 
-	row := q.db.QueryRow(q.sqlQuery, args[0], q.action)
+	queryArgs := []interface{}{args[0], q.action}
+	if q.minPrice != nil {
+		queryArgs = append(queryArgs, *q.minPrice)
+	}
+	row := q.db.QueryRowContext(ctx, q.sqlQuery, queryArgs...)
 
 	var baseVol sql.NullFloat64
 	var quoteVol sql.NullFloat64
@@ -89,24 +158,69 @@ func (q *DailyVolumeByDate) QueryRow(args ...interface{}) (interface{}, error) {
 	}, nil
 }
 
-func makeSQLQueryDailyVolume(marketIDs []string, optionalAccountIDs []string) string {
-	// add filter on marketIDs
-	marketsInClauseParts := []string{}
-	for _, mid := range marketIDs {
-		marketsInValue := fmt.Sprintf("'%s'", mid)
-		marketsInClauseParts = append(marketsInClauseParts, marketsInValue)
+// RecordPendingVolume records the volume of the action that was placed on the market on the date (in the postgresdb.DateFormatString
+// format) but is not yet settled in the trades table, replacing any pending volume recorded earlier for the same market, date, and action
+func (q *DailyVolumeByDate) RecordPendingVolume(ctx context.Context, marketID string, dateUTC string, baseVol float64, quoteVol float64) error {
+	_, e := q.db.ExecContext(ctx, kelpdb.SqlPendingVolumesUpsert, marketID, dateUTC, q.action, baseVol, quoteVol)
+	if e != nil {
+		return fmt.Errorf("could not record the pending volume of market '%s' on date (%s): %s", marketID, dateUTC, e)
+	}
+	return nil
+}
+
+func makeSQLQueryDailyVolume(marketIDs []string, optionalAccountIDs []string, filterByMinPrice bool, dayStart time.Duration) string {
+	minPriceClause := ""
+	if filterByMinPrice {
+		minPriceClause = sqlQueryDailyValuesMinPriceClause
 	}
-	marketsInClause := strings.Join(marketsInClauseParts, ", ")
+
+	dateExpression := makeDateExpression(dayStart)
+	marketsInClause := makeInClause(marketIDs)
 	if len(optionalAccountIDs) == 0 {
-		return fmt.Sprintf(sqlQueryDailyValuesTemplateAllAccounts, marketsInClause)
+		return fmt.Sprintf(sqlQueryDailyValuesTemplateAllAccounts, marketsInClause, dateExpression, minPriceClause)
 	}
 
 	// include filter on account_id
-	accountsInClauseParts := []string{}
-	for _, aid := range optionalAccountIDs {
-		accountsInValue := fmt.Sprintf("'%s'", aid)
-		accountsInClauseParts = append(accountsInClauseParts, accountsInValue)
+	accountsInClause := makeInClause(optionalAccountIDs)
+	return fmt.Sprintf(sqlQueryDailyValuesTemplateSpecificAccounts, marketsInClause, accountsInClause, dateExpression, minPriceClause)
+}
+
+// makeDateExpression makes the expression that buckets the trades by the date of the day that starts at dayStart from midnight UTC
+func makeDateExpression(dayStart time.Duration) string {
+	if dayStart == 0 {
+		return sqlQueryDailyValuesDateExpression
+	}
+	return fmt.Sprintf(sqlQueryDailyValuesShiftedDateExpression, int64(dayStart/time.Second))
+}
+
+// makeAccountsClause returns the sqlQueryAccountsClause for the accounts, or an empty string when there are no accounts
+func makeAccountsClause(optionalAccountIDs []string) string {
+	if len(optionalAccountIDs) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(sqlQueryAccountsClause, makeInClause(optionalAccountIDs))
+}
+
+// scanSummedVolume reads the summed base and quote volume from the row, the sums are null when there are no trades which is read as 0
+func scanSummedVolume(row *sql.Row, queryName string) (*DailyVolume, error) {
+	var baseVol sql.NullFloat64
+	var quoteVol sql.NullFloat64
+	e := row.Scan(&baseVol, &quoteVol)
+	if e != nil {
+		return nil, fmt.Errorf("could not read data from %s query: %s", queryName, e)
+	}
+
+	return &DailyVolume{
+		BaseVol:  baseVol.Float64,
+		QuoteVol: quoteVol.Float64,
+	}, nil
+}
+
+// makeInClause makes the comma-separated list of quoted values used in an IN clause
+func makeInClause(values []string) string {
+	parts := []string{}
+	for _, v := range values {
+		parts = append(parts, fmt.Sprintf("'%s'", v))
 	}
-	accountsInClause := strings.Join(accountsInClauseParts, ", ")
-	return fmt.Sprintf(sqlQueryDailyValuesTemplateSpecificAccounts, marketsInClause, accountsInClause)
+	return strings.Join(parts, ", ")
 }
@@ -1,6 +1,7 @@
 package queries
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"os"
@@ -8,6 +9,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/openlyinc/pointy"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/stellar/kelp/api"
@@ -233,3 +235,249 @@ func runQueryAndVerifyValues(t *testing.T, query api.Query, inputDate time.Time,
 	assert.Equal(t, wantBaseVol, dailyVolume.BaseVol)
 	assert.Equal(t, wantQuoteVol, dailyVolume.QuoteVol)
 }
+
+func TestDailyVolumeByDate_QueryRowAbovePrice(t *testing.T) {
+	testCases := []struct {
+		name           string
+		minPrice       *float64
+		wantTodayBase  float64
+		wantTodayQuote float64
+	}{
+		{
+			name:           "no min price",
+			minPrice:       nil,
+			wantTodayBase:  1300.0,
+			wantTodayQuote: 12.0,
+		}, {
+			name:           "excludes dust fills",
+			minPrice:       pointy.Float64(0.01),
+			wantTodayBase:  100.0,
+			wantTodayQuote: 10.0,
+		}, {
+			name:           "excludes fills at the min price",
+			minPrice:       pointy.Float64(0.1),
+			wantTodayBase:  0.0,
+			wantTodayQuote: 0.0,
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			// setup db
+			today, _ := time.Parse(time.RFC3339, "2020-01-21T15:00:00Z")
+			setupStatements := []string{
+				kelpdb.SqlTradesTableCreate,
+				"ALTER TABLE trades DROP COLUMN IF EXISTS account_id",
+				"ALTER TABLE trades DROP COLUMN IF EXISTS order_id",
+				kelpdb.SqlTradesTableAlter1,
+				kelpdb.SqlTradesTableAlter2,
+				"DELETE FROM trades", // clear table
+				fmt.Sprintf(kelpdb.SqlTradesInsertTemplate,
+					"market1",
+					"1",
+					today.Format(postgresdb.TimestampFormatString),
+					model.OrderActionSell.String(),
+					model.OrderTypeLimit.String(),
+					0.10,  // price
+					100.0, // volume
+					10.0,  // cost
+					0.0,   // fee
+					"accountID1",
+					"",
+				),
+				// dust fills
+				fmt.Sprintf(kelpdb.SqlTradesInsertTemplate,
+					"market1",
+					"2",
+					today.Add(time.Second*1).Format(postgresdb.TimestampFormatString),
+					model.OrderActionSell.String(),
+					model.OrderTypeLimit.String(),
+					0.001,  // price
+					1000.0, // volume
+					1.0,    // cost
+					0.0,    // fee
+					"accountID1",
+					"",
+				),
+				fmt.Sprintf(kelpdb.SqlTradesInsertTemplate,
+					"market1",
+					"3",
+					today.Add(time.Second*2).Format(postgresdb.TimestampFormatString),
+					model.OrderActionSell.String(),
+					model.OrderTypeLimit.String(),
+					0.005, // price
+					200.0, // volume
+					1.0,   // cost
+					0.0,   // fee
+					"accountID1",
+					"",
+				),
+			}
+			db := connectTestDb()
+			defer db.Close()
+			for _, s := range setupStatements {
+				_, e := db.Exec(s)
+				if e != nil {
+					panic(e)
+				}
+			}
+
+			// make query being tested
+			dailyVolumeByDateQuery, e := MakeDailyVolumeByDateForMarketIdsActionAbovePrice(
+				db,
+				[]string{"market1"},
+				"sell",
+				[]string{},
+				k.minPrice,
+			)
+			if !assert.NoError(t, e) {
+				return
+			}
+
+			runQueryAndVerifyValues(t, dailyVolumeByDateQuery, today, k.wantTodayBase, k.wantTodayQuote)
+		})
+	}
+}
+
+func TestMakeDailyVolumeByShiftedDateForMarketIdsAction(t *testing.T) {
+	testCases := []struct {
+		name           string
+		dayStart       time.Duration
+		includePending bool
+		wantQuery      string
+		wantErr        bool
+	}{
+		{
+			name:      "midnight UTC",
+			dayStart:  0,
+			wantQuery: "SELECT SUM(base_volume) as total_base_volume, SUM(counter_cost) as total_counter_volume FROM trades WHERE market_id IN ('market1') AND account_id IN ('account1') AND DATE(date_utc) = $1 and action = $2 group by DATE(date_utc)",
+		}, {
+			name:      "shifted day",
+			dayStart:  22 * time.Hour,
+			wantQuery: "SELECT SUM(base_volume) as total_base_volume, SUM(counter_cost) as total_counter_volume FROM trades WHERE market_id IN ('market1') AND account_id IN ('account1') AND DATE(date_utc - INTERVAL '79200 seconds') = $1 and action = $2 group by DATE(date_utc - INTERVAL '79200 seconds')",
+		}, {
+			name:           "include pending",
+			dayStart:       0,
+			includePending: true,
+			wantQuery:      "SELECT COALESCE(SUM(total_base_volume), 0) as total_base_volume, COALESCE(SUM(total_counter_volume), 0) as total_counter_volume FROM (SELECT SUM(base_volume) as total_base_volume, SUM(counter_cost) as total_counter_volume FROM trades WHERE market_id IN ('market1') AND account_id IN ('account1') AND DATE(date_utc) = $1 and action = $2 group by DATE(date_utc) UNION ALL SELECT base_volume as total_base_volume, counter_cost as total_counter_volume FROM pending_volumes WHERE market_id IN ('market1') AND trading_date = $1 and action = $2) AS volumes",
+		}, {
+			name:      "negative day start",
+			dayStart:  -5 * time.Hour,
+			wantQuery: "SELECT SUM(base_volume) as total_base_volume, SUM(counter_cost) as total_counter_volume FROM trades WHERE market_id IN ('market1') AND account_id IN ('account1') AND DATE(date_utc - INTERVAL '-18000 seconds') = $1 and action = $2 group by DATE(date_utc - INTERVAL '-18000 seconds')",
+		}, {
+			name:     "day start of -24h",
+			dayStart: -24 * time.Hour,
+			wantErr:  true,
+		}, {
+			name:     "day start of 24h",
+			dayStart: 24 * time.Hour,
+			wantErr:  true,
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			q, e := MakeDailyVolumeByShiftedDateForMarketIdsAction(&sql.DB{}, []string{"market1"}, "sell", []string{"account1"}, nil, k.dayStart, k.includePending)
+			if k.wantErr {
+				assert.Error(t, e)
+				return
+			}
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.Equal(t, k.wantQuery, q.sqlQuery)
+		})
+	}
+}
+
+func TestDailyVolumeByDate_QueryRowContext(t *testing.T) {
+	db := connectTestDb()
+	defer db.Close()
+	_, e := db.Exec(kelpdb.SqlTradesTableCreate)
+	if e != nil {
+		panic(e)
+	}
+
+	q, e := MakeDailyVolumeByDateForMarketIdsAction(db, []string{"market1"}, "sell", []string{})
+	if !assert.NoError(t, e) {
+		return
+	}
+	result, e := q.QueryRowContext(context.Background(), "2020/01/21")
+	if assert.NoError(t, e) {
+		assert.IsType(t, &DailyVolume{}, result)
+	}
+
+	// a query with a context that is done fails instead of blocking on the db
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	_, e = q.QueryRowContext(ctx, "2020/01/21")
+	assert.Error(t, e)
+
+	_, e = q.QueryRowContext(context.Background(), 5)
+	assert.Error(t, e)
+}
+
+func TestDailyVolumeByDate_RecordPendingVolume(t *testing.T) {
+	// setup db
+	today, _ := time.Parse(time.RFC3339, "2020-01-21T15:00:00Z")
+	todayString := today.Format(postgresdb.DateFormatString)
+	setupStatements := []string{
+		kelpdb.SqlTradesTableCreate,
+		"ALTER TABLE trades DROP COLUMN IF EXISTS account_id",
+		"ALTER TABLE trades DROP COLUMN IF EXISTS order_id",
+		kelpdb.SqlTradesTableAlter1,
+		kelpdb.SqlTradesTableAlter2,
+		kelpdb.SqlPendingVolumesTableCreate,
+		"DELETE FROM trades",          // clear table
+		"DELETE FROM pending_volumes", // clear table
+		fmt.Sprintf(kelpdb.SqlTradesInsertTemplate,
+			"market1",
+			"1",
+			today.Format(postgresdb.TimestampFormatString),
+			model.OrderActionSell.String(),
+			model.OrderTypeLimit.String(),
+			0.10,  // price
+			100.0, // volume
+			10.0,  // cost
+			0.0,   // fee
+			"",
+			"",
+		),
+	}
+	db := connectTestDb()
+	defer db.Close()
+	for _, s := range setupStatements {
+		_, e := db.Exec(s)
+		if e != nil {
+			panic(e)
+		}
+	}
+
+	q, e := MakeDailyVolumeByShiftedDateForMarketIdsAction(db, []string{"market1", "market2"}, "sell", []string{}, nil, 0, true)
+	if !assert.NoError(t, e) {
+		return
+	}
+	runQueryAndVerifyValues(t, q, today, 100.0, 10.0)
+
+	// the pending volume of both markets is added to the settled volume, and recording again replaces the pending volume of the market
+	for _, args := range [][]interface{}{
+		{"market1", 5.0, 0.5},
+		{"market1", 6.0, 0.25},
+		{"market2", 7.0, 0.75},
+	} {
+		e = q.RecordPendingVolume(context.Background(), args[0].(string), todayString, args[1].(float64), args[2].(float64))
+		if !assert.NoError(t, e) {
+			return
+		}
+	}
+	runQueryAndVerifyValues(t, q, today, 113.0, 11.0)
+
+	// the pending volume of another date or action is not counted
+	buyQuery, e := MakeDailyVolumeByShiftedDateForMarketIdsAction(db, []string{"market1"}, "buy", []string{}, nil, 0, true)
+	if !assert.NoError(t, e) {
+		return
+	}
+	runQueryAndVerifyValues(t, buyQuery, today, 0.0, 0.0)
+	runQueryAndVerifyValues(t, q, today.Add(24*time.Hour), 0.0, 0.0)
+}
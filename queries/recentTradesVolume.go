@@ -0,0 +1,67 @@
+package queries
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/stellar/kelp/api"
+)
+
+// sqlQueryRecentTradesVolumeTemplate sums the volume of the most recent trades on the markets, optionally filtered by account_id
+const sqlQueryRecentTradesVolumeTemplate = "SELECT SUM(base_volume) as total_base_volume, SUM(counter_cost) as total_counter_volume FROM (SELECT base_volume, counter_cost FROM trades WHERE market_id IN (%s)%s AND action = $1 ORDER BY date_utc DESC LIMIT $2) AS recent_trades"
+
+// RecentTradesVolume is a query that fetches the total volume of the most recent N trades, regardless of when they were executed.
+// The query sorts the trades of the markets by date_utc, which needs an index on (market_id, date_utc) to stay cheap on a large trades table.
+type RecentTradesVolume struct {
+	db        *sql.DB
+	sqlQuery  string
+	action    string
+	numTrades int
+}
+
+var _ api.ContextQuery = &RecentTradesVolume{}
+
+// MakeRecentTradesVolumeForMarketIdsAction makes the RecentTradesVolume query for the most recent numTrades trades on a set of marketIds
+// with an action
+func MakeRecentTradesVolumeForMarketIdsAction(
+	db *sql.DB,
+	marketIDs []string,
+	action string,
+	optionalAccountIDs []string,
+	numTrades int,
+) (*RecentTradesVolume, error) {
+	if db == nil {
+		return nil, fmt.Errorf("the provided db should be non-nil")
+	}
+	if numTrades <= 0 {
+		return nil, fmt.Errorf("the number of trades needs to be positive but was %d", numTrades)
+	}
+
+	return &RecentTradesVolume{
+		db:        db,
+		sqlQuery:  fmt.Sprintf(sqlQueryRecentTradesVolumeTemplate, makeInClause(marketIDs), makeAccountsClause(optionalAccountIDs)),
+		action:    action,
+		numTrades: numTrades,
+	}, nil
+}
+
+// Name impl.
+func (q *RecentTradesVolume) Name() string {
+	return "RecentTradesVolume"
+}
+
+// QueryRow impl.
+func (q *RecentTradesVolume) QueryRow(args ...interface{}) (interface{}, error) {
+	return q.QueryRowContext(context.Background(), args...)
+}
+
+// QueryRowContext impl.
+func (q *RecentTradesVolume) QueryRowContext(ctx context.Context, args ...interface{}) (interface{}, error) {
+	if len(args) != 0 {
+		return nil, fmt.Errorf("expected 0 args, but got args %v", args)
+	}
+
+	row := q.db.QueryRowContext(ctx, q.sqlQuery, q.action, q.numTrades)
+	return scanSummedVolume(row, "RecentTradesVolume")
+}
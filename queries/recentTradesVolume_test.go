@@ -0,0 +1,113 @@
+package queries
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/stellar/kelp/kelpdb"
+	"github.com/stellar/kelp/model"
+	"github.com/stellar/kelp/support/postgresdb"
+)
+
+func TestMakeRecentTradesVolumeForMarketIdsAction(t *testing.T) {
+	testCases := []struct {
+		name      string
+		db        *sql.DB
+		numTrades int
+		wantErr   bool
+	}{
+		{
+			name:      "valid",
+			db:        &sql.DB{},
+			numTrades: 2,
+			wantErr:   false,
+		}, {
+			name:      "nil db",
+			db:        nil,
+			numTrades: 2,
+			wantErr:   true,
+		}, {
+			name:      "zero trades",
+			db:        &sql.DB{},
+			numTrades: 0,
+			wantErr:   true,
+		},
+	}
+
+	for _, k := range testCases {
+		t.Run(k.name, func(t *testing.T) {
+			q, e := MakeRecentTradesVolumeForMarketIdsAction(k.db, []string{"market1"}, "sell", []string{}, k.numTrades)
+			if k.wantErr {
+				assert.Error(t, e)
+				return
+			}
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.Equal(t, "RecentTradesVolume", q.Name())
+		})
+	}
+}
+
+func TestRecentTradesVolume_QueryRow(t *testing.T) {
+	db := connectTestDb()
+	defer db.Close()
+
+	start, _ := time.Parse(time.RFC3339, "2020-01-20T15:00:00Z")
+	setupStatements := []string{
+		kelpdb.SqlTradesTableCreate,
+		"ALTER TABLE trades DROP COLUMN IF EXISTS account_id",
+		"ALTER TABLE trades DROP COLUMN IF EXISTS order_id",
+		kelpdb.SqlTradesTableAlter1,
+		kelpdb.SqlTradesTableAlter2,
+		"DELETE FROM trades", // clear table
+	}
+	// trades are a day apart so the most recent trades span multiple dates
+	for i, volume := range []float64{100.0, 10.0, 20.0, 30.0} {
+		setupStatements = append(setupStatements, fmt.Sprintf(kelpdb.SqlTradesInsertTemplate,
+			"market1",
+			fmt.Sprintf("%d", i),
+			start.Add(time.Duration(i)*24*time.Hour).Format(postgresdb.TimestampFormatString),
+			model.OrderActionSell.String(),
+			model.OrderTypeLimit.String(),
+			0.10,         // price
+			volume,       // volume
+			volume*0.10,  // cost
+			0.0,          // fee
+			"accountID1", // accountID
+			"",           // orderID
+		))
+	}
+	for _, s := range setupStatements {
+		_, e := db.Exec(s)
+		if e != nil {
+			panic(e)
+		}
+	}
+
+	for _, kase := range []struct {
+		numTrades int
+		wantBase  float64
+		wantQuote float64
+	}{
+		{numTrades: 1, wantBase: 30.0, wantQuote: 3.0},
+		{numTrades: 3, wantBase: 60.0, wantQuote: 6.0},
+		{numTrades: 10, wantBase: 160.0, wantQuote: 16.0},
+	} {
+		q, e := MakeRecentTradesVolumeForMarketIdsAction(db, []string{"market1"}, "sell", []string{}, kase.numTrades)
+		if !assert.NoError(t, e) {
+			return
+		}
+		result, e := q.QueryRow()
+		if !assert.NoError(t, e) {
+			return
+		}
+		dailyVolume := result.(*DailyVolume)
+		assert.InDelta(t, kase.wantBase, dailyVolume.BaseVol, 1e-7)
+		assert.InDelta(t, kase.wantQuote, dailyVolume.QuoteVol, 1e-7)
+	}
+}
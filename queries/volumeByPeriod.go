@@ -0,0 +1,124 @@
+package queries
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/stellar/kelp/api"
+	"github.com/stellar/kelp/support/postgresdb"
+)
+
+// sqlQueryVolumeByPeriodTemplate sums the volume of the trades on the markets that were executed within the bounds of a period, optionally
+// filtered by account_id
+const sqlQueryVolumeByPeriodTemplate = "SELECT SUM(base_volume) as total_base_volume, SUM(counter_cost) as total_counter_volume FROM trades WHERE market_id IN (%s)%s AND action = $1 AND date_utc >= $2 AND date_utc < $3"
+
+// VolumePeriod is the period over which the VolumeByPeriod query sums the volume
+type VolumePeriod string
+
+// type of VolumePeriod
+const (
+	// VolumePeriodWeek is the ISO week, which starts on Monday at midnight UTC
+	VolumePeriodWeek VolumePeriod = "week"
+	// VolumePeriodMonth is the calendar month, which starts on the first day of the month at midnight UTC
+	VolumePeriodMonth VolumePeriod = "month"
+)
+
+// VolumeByPeriod is a query that fetches the volume of the trades executed in the week or month containing a date, which is the analog of
+// DailyVolumeByDate for periods that are longer than a day
+type VolumeByPeriod struct {
+	db       *sql.DB
+	sqlQuery string
+	action   string
+	period   VolumePeriod
+}
+
+var _ api.ContextQuery = &VolumeByPeriod{}
+
+// MakeWeeklyVolumeByDateForMarketIdsAction makes the VolumeByPeriod query over the ISO week for a set of marketIds and an action
+func MakeWeeklyVolumeByDateForMarketIdsAction(
+	db *sql.DB,
+	marketIDs []string,
+	action string,
+	optionalAccountIDs []string,
+) (*VolumeByPeriod, error) {
+	return makeVolumeByPeriod(db, marketIDs, action, optionalAccountIDs, VolumePeriodWeek)
+}
+
+// MakeMonthlyVolumeByDateForMarketIdsAction makes the VolumeByPeriod query over the calendar month for a set of marketIds and an action
+func MakeMonthlyVolumeByDateForMarketIdsAction(
+	db *sql.DB,
+	marketIDs []string,
+	action string,
+	optionalAccountIDs []string,
+) (*VolumeByPeriod, error) {
+	return makeVolumeByPeriod(db, marketIDs, action, optionalAccountIDs, VolumePeriodMonth)
+}
+
+func makeVolumeByPeriod(
+	db *sql.DB,
+	marketIDs []string,
+	action string,
+	optionalAccountIDs []string,
+	period VolumePeriod,
+) (*VolumeByPeriod, error) {
+	if db == nil {
+		return nil, fmt.Errorf("the provided db should be non-nil")
+	}
+
+	return &VolumeByPeriod{
+		db:       db,
+		sqlQuery: fmt.Sprintf(sqlQueryVolumeByPeriodTemplate, makeInClause(marketIDs), makeAccountsClause(optionalAccountIDs)),
+		action:   action,
+		period:   period,
+	}, nil
+}
+
+// Name impl.
+func (q *VolumeByPeriod) Name() string {
+	return "VolumeByPeriod"
+}
+
+// PeriodBounds returns the start (inclusive) and the end (exclusive) of the period containing the passed in date
+func PeriodBounds(period VolumePeriod, date time.Time) (time.Time, time.Time, error) {
+	day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	switch period {
+	case VolumePeriodWeek:
+		// the ISO week starts on Monday, which is day 1 of time.Weekday
+		daysSinceMonday := (int(day.Weekday()) + 6) % 7
+		start := day.AddDate(0, 0, -daysSinceMonday)
+		return start, start.AddDate(0, 0, 7), nil
+	case VolumePeriodMonth:
+		start := time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 1, 0), nil
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("unrecognized volume period '%s'", period)
+}
+
+// QueryRow impl. The arg is the date (in the postgresdb.DateFormatString format) that is contained in the period.
+func (q *VolumeByPeriod) QueryRow(args ...interface{}) (interface{}, error) {
+	return q.QueryRowContext(context.Background(), args...)
+}
+
+// QueryRowContext impl.
+func (q *VolumeByPeriod) QueryRowContext(ctx context.Context, args ...interface{}) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("expected 1 arg (dateUTC string), but got args %v", args)
+	}
+	dateString, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("input arg needs to be of type 'string', but was of type '%T'", args[0])
+	}
+	date, e := time.Parse(postgresdb.DateFormatString, dateString)
+	if e != nil {
+		return nil, fmt.Errorf("could not parse date '%s': %s", dateString, e)
+	}
+	start, end, e := PeriodBounds(q.period, date)
+	if e != nil {
+		return nil, fmt.Errorf("could not compute the bounds of the period: %s", e)
+	}
+
+	row := q.db.QueryRowContext(ctx, q.sqlQuery, q.action, start.Format(postgresdb.TimestampFormatString), end.Format(postgresdb.TimestampFormatString))
+	return scanSummedVolume(row, "VolumeByPeriod")
+}
@@ -0,0 +1,155 @@
+package queries
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/stellar/kelp/kelpdb"
+	"github.com/stellar/kelp/model"
+	"github.com/stellar/kelp/support/postgresdb"
+)
+
+func TestMakeVolumeByPeriodForMarketIdsAction(t *testing.T) {
+	q, e := MakeWeeklyVolumeByDateForMarketIdsAction(&sql.DB{}, []string{"market1"}, "sell", []string{"account1"})
+	if !assert.NoError(t, e) {
+		return
+	}
+	assert.Equal(t, "VolumeByPeriod", q.Name())
+	assert.Equal(t, VolumePeriodWeek, q.period)
+	assert.Equal(t, "SELECT SUM(base_volume) as total_base_volume, SUM(counter_cost) as total_counter_volume FROM trades WHERE market_id IN ('market1') AND account_id IN ('account1') AND action = $1 AND date_utc >= $2 AND date_utc < $3", q.sqlQuery)
+
+	q, e = MakeMonthlyVolumeByDateForMarketIdsAction(&sql.DB{}, []string{"market1", "market2"}, "buy", []string{})
+	if !assert.NoError(t, e) {
+		return
+	}
+	assert.Equal(t, VolumePeriodMonth, q.period)
+	assert.Equal(t, "SELECT SUM(base_volume) as total_base_volume, SUM(counter_cost) as total_counter_volume FROM trades WHERE market_id IN ('market1', 'market2') AND action = $1 AND date_utc >= $2 AND date_utc < $3", q.sqlQuery)
+
+	_, e = MakeWeeklyVolumeByDateForMarketIdsAction(nil, []string{"market1"}, "sell", []string{})
+	assert.Error(t, e)
+	_, e = MakeMonthlyVolumeByDateForMarketIdsAction(nil, []string{"market1"}, "sell", []string{})
+	assert.Error(t, e)
+
+	for _, args := range [][]interface{}{
+		{},
+		{time.Now()},
+		{"2020-01-20"},
+		{"2020/01/20", "2020/01/21"},
+	} {
+		_, e = q.QueryRow(args...)
+		assert.Error(t, e, fmt.Sprintf("%v", args))
+	}
+}
+
+func TestPeriodBounds(t *testing.T) {
+	testCases := []struct {
+		period    VolumePeriod
+		date      string
+		wantStart string
+		wantEnd   string
+	}{
+		{period: VolumePeriodWeek, date: "2020/01/20", wantStart: "2020/01/20", wantEnd: "2020/01/27"}, // Monday
+		{period: VolumePeriodWeek, date: "2020/01/26", wantStart: "2020/01/20", wantEnd: "2020/01/27"}, // Sunday
+		{period: VolumePeriodWeek, date: "2020/01/01", wantStart: "2019/12/30", wantEnd: "2020/01/06"}, // ISO week spanning the year
+		{period: VolumePeriodMonth, date: "2020/01/31", wantStart: "2020/01/01", wantEnd: "2020/02/01"},
+		{period: VolumePeriodMonth, date: "2020/02/29", wantStart: "2020/02/01", wantEnd: "2020/03/01"},
+		{period: VolumePeriodMonth, date: "2020/12/01", wantStart: "2020/12/01", wantEnd: "2021/01/01"},
+	}
+
+	for _, kase := range testCases {
+		t.Run(fmt.Sprintf("%s/%s", kase.period, kase.date), func(t *testing.T) {
+			date, e := time.Parse(postgresdb.DateFormatString, kase.date)
+			if !assert.NoError(t, e) {
+				return
+			}
+			start, end, e := PeriodBounds(kase.period, date)
+			if !assert.NoError(t, e) {
+				return
+			}
+			assert.Equal(t, kase.wantStart, start.Format(postgresdb.DateFormatString))
+			assert.Equal(t, kase.wantEnd, end.Format(postgresdb.DateFormatString))
+		})
+	}
+
+	_, _, e := PeriodBounds(VolumePeriod("year"), time.Now())
+	assert.Error(t, e)
+}
+
+func TestVolumeByPeriod_QueryRow(t *testing.T) {
+	db := connectTestDb()
+	defer db.Close()
+
+	setupStatements := []string{
+		kelpdb.SqlTradesTableCreate,
+		"ALTER TABLE trades DROP COLUMN IF EXISTS account_id",
+		"ALTER TABLE trades DROP COLUMN IF EXISTS order_id",
+		kelpdb.SqlTradesTableAlter1,
+		kelpdb.SqlTradesTableAlter2,
+		"DELETE FROM trades", // clear table
+	}
+	// 2020-01-26 is a Sunday and 2020-02-02 is the Sunday of the following week
+	for i, kase := range []struct {
+		dateUTC string
+		volume  float64
+	}{
+		{dateUTC: "2020-01-19T23:00:00Z", volume: 100.0},
+		{dateUTC: "2020-01-20T00:00:00Z", volume: 10.0},
+		{dateUTC: "2020-01-26T23:59:00Z", volume: 20.0},
+		{dateUTC: "2020-01-31T12:00:00Z", volume: 30.0},
+		{dateUTC: "2020-02-02T12:00:00Z", volume: 40.0},
+	} {
+		date, _ := time.Parse(time.RFC3339, kase.dateUTC)
+		setupStatements = append(setupStatements, fmt.Sprintf(kelpdb.SqlTradesInsertTemplate,
+			"market1",
+			fmt.Sprintf("%d", i),
+			date.Format(postgresdb.TimestampFormatString),
+			model.OrderActionSell.String(),
+			model.OrderTypeLimit.String(),
+			0.10,             // price
+			kase.volume,      // volume
+			kase.volume*0.10, // cost
+			0.0,              // fee
+			"accountID1",     // accountID
+			"",               // orderID
+		))
+	}
+	for _, s := range setupStatements {
+		_, e := db.Exec(s)
+		if e != nil {
+			panic(e)
+		}
+	}
+
+	weekly, e := MakeWeeklyVolumeByDateForMarketIdsAction(db, []string{"market1"}, "sell", []string{})
+	if !assert.NoError(t, e) {
+		return
+	}
+	monthly, e := MakeMonthlyVolumeByDateForMarketIdsAction(db, []string{"market1"}, "sell", []string{})
+	if !assert.NoError(t, e) {
+		return
+	}
+	for _, kase := range []struct {
+		q         *VolumeByPeriod
+		date      string
+		wantBase  float64
+		wantQuote float64
+	}{
+		{q: weekly, date: "2020/01/22", wantBase: 30.0, wantQuote: 3.0},
+		{q: weekly, date: "2020/01/31", wantBase: 70.0, wantQuote: 7.0},
+		{q: monthly, date: "2020/01/22", wantBase: 160.0, wantQuote: 16.0},
+		{q: monthly, date: "2020/02/01", wantBase: 40.0, wantQuote: 4.0},
+		{q: monthly, date: "2020/03/01", wantBase: 0.0, wantQuote: 0.0},
+	} {
+		result, e := kase.q.QueryRow(kase.date)
+		if !assert.NoError(t, e) {
+			return
+		}
+		dailyVolume := result.(*DailyVolume)
+		assert.InDelta(t, kase.wantBase, dailyVolume.BaseVol, 1e-7, kase.date)
+		assert.InDelta(t, kase.wantQuote, dailyVolume.QuoteVol, 1e-7, kase.date)
+	}
+}
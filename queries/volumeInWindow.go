@@ -0,0 +1,73 @@
+package queries
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/stellar/kelp/api"
+	"github.com/stellar/kelp/support/postgresdb"
+)
+
+// sqlQueryVolumeInWindowTemplate sums the volume of the trades on the markets that were executed in a time window, optionally filtered by account_id
+const sqlQueryVolumeInWindowTemplate = "SELECT SUM(base_volume) as total_base_volume, SUM(counter_cost) as total_counter_volume FROM trades WHERE market_id IN (%s)%s AND action = $1 AND date_utc > $2 AND date_utc <= $3"
+
+// VolumeInWindow is a query that fetches the total volume of the trades executed in a time window, which can be used for a rolling window
+// (e.g. the trailing 24 hours) instead of the calendar day used by DailyVolumeByDate
+type VolumeInWindow struct {
+	db       *sql.DB
+	sqlQuery string
+	action   string
+}
+
+var _ api.ContextQuery = &VolumeInWindow{}
+
+// MakeVolumeInWindowForMarketIdsAction makes the VolumeInWindow query for a set of marketIds and an action
+func MakeVolumeInWindowForMarketIdsAction(
+	db *sql.DB,
+	marketIDs []string,
+	action string,
+	optionalAccountIDs []string,
+) (*VolumeInWindow, error) {
+	if db == nil {
+		return nil, fmt.Errorf("the provided db should be non-nil")
+	}
+
+	return &VolumeInWindow{
+		db:       db,
+		sqlQuery: fmt.Sprintf(sqlQueryVolumeInWindowTemplate, makeInClause(marketIDs), makeAccountsClause(optionalAccountIDs)),
+		action:   action,
+	}, nil
+}
+
+// Name impl.
+func (q *VolumeInWindow) Name() string {
+	return "VolumeInWindow"
+}
+
+// QueryRow impl. The args are the start (exclusive) and the end (inclusive) of the window as time.Time values.
+func (q *VolumeInWindow) QueryRow(args ...interface{}) (interface{}, error) {
+	return q.QueryRowContext(context.Background(), args...)
+}
+
+// QueryRowContext impl.
+func (q *VolumeInWindow) QueryRowContext(ctx context.Context, args ...interface{}) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("expected 2 args (start and end time.Time), but got args %v", args)
+	}
+	start, ok := args[0].(time.Time)
+	if !ok {
+		return nil, fmt.Errorf("start arg needs to be of type 'time.Time', but was of type '%T'", args[0])
+	}
+	end, ok := args[1].(time.Time)
+	if !ok {
+		return nil, fmt.Errorf("end arg needs to be of type 'time.Time', but was of type '%T'", args[1])
+	}
+	if end.Before(start) {
+		return nil, fmt.Errorf("the end of the window (%s) needs to be after the start (%s)", end, start)
+	}
+
+	row := q.db.QueryRowContext(ctx, q.sqlQuery, q.action, start.UTC().Format(postgresdb.TimestampFormatString), end.UTC().Format(postgresdb.TimestampFormatString))
+	return scanSummedVolume(row, "VolumeInWindow")
+}
@@ -0,0 +1,97 @@
+package queries
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/stellar/kelp/kelpdb"
+	"github.com/stellar/kelp/model"
+	"github.com/stellar/kelp/support/postgresdb"
+)
+
+func TestMakeVolumeInWindowForMarketIdsAction(t *testing.T) {
+	q, e := MakeVolumeInWindowForMarketIdsAction(&sql.DB{}, []string{"market1"}, "sell", []string{"account1"})
+	if !assert.NoError(t, e) {
+		return
+	}
+	assert.Equal(t, "VolumeInWindow", q.Name())
+	assert.Equal(t, "SELECT SUM(base_volume) as total_base_volume, SUM(counter_cost) as total_counter_volume FROM trades WHERE market_id IN ('market1') AND account_id IN ('account1') AND action = $1 AND date_utc > $2 AND date_utc <= $3", q.sqlQuery)
+
+	_, e = MakeVolumeInWindowForMarketIdsAction(nil, []string{"market1"}, "sell", []string{})
+	assert.Error(t, e)
+
+	now := time.Now()
+	for _, args := range [][]interface{}{
+		{},
+		{"2020/01/20", now},
+		{now, "2020/01/21"},
+		{now, now.Add(-time.Hour)},
+	} {
+		_, e = q.QueryRow(args...)
+		assert.Error(t, e, fmt.Sprintf("%v", args))
+	}
+}
+
+func TestVolumeInWindow_QueryRow(t *testing.T) {
+	db := connectTestDb()
+	defer db.Close()
+
+	start, _ := time.Parse(time.RFC3339, "2020-01-20T15:00:00Z")
+	setupStatements := []string{
+		kelpdb.SqlTradesTableCreate,
+		"ALTER TABLE trades DROP COLUMN IF EXISTS account_id",
+		"ALTER TABLE trades DROP COLUMN IF EXISTS order_id",
+		kelpdb.SqlTradesTableAlter1,
+		kelpdb.SqlTradesTableAlter2,
+		"DELETE FROM trades", // clear table
+	}
+	// trades are 6 hours apart so a 24 hour window spans two calendar dates
+	for i, volume := range []float64{100.0, 10.0, 20.0, 30.0, 40.0, 50.0} {
+		setupStatements = append(setupStatements, fmt.Sprintf(kelpdb.SqlTradesInsertTemplate,
+			"market1",
+			fmt.Sprintf("%d", i),
+			start.Add(time.Duration(i)*6*time.Hour).Format(postgresdb.TimestampFormatString),
+			model.OrderActionSell.String(),
+			model.OrderTypeLimit.String(),
+			0.10,         // price
+			volume,       // volume
+			volume*0.10,  // cost
+			0.0,          // fee
+			"accountID1", // accountID
+			"",           // orderID
+		))
+	}
+	for _, s := range setupStatements {
+		_, e := db.Exec(s)
+		if e != nil {
+			panic(e)
+		}
+	}
+
+	q, e := MakeVolumeInWindowForMarketIdsAction(db, []string{"market1"}, "sell", []string{})
+	if !assert.NoError(t, e) {
+		return
+	}
+	end := start.Add(30 * time.Hour)
+	for _, kase := range []struct {
+		window    time.Duration
+		wantBase  float64
+		wantQuote float64
+	}{
+		{window: time.Hour, wantBase: 50.0, wantQuote: 5.0},
+		{window: 24 * time.Hour, wantBase: 140.0, wantQuote: 14.0},
+		{window: 48 * time.Hour, wantBase: 250.0, wantQuote: 25.0},
+	} {
+		result, e := q.QueryRow(end.Add(-kase.window), end)
+		if !assert.NoError(t, e) {
+			return
+		}
+		dailyVolume := result.(*DailyVolume)
+		assert.InDelta(t, kase.wantBase, dailyVolume.BaseVol, 1e-7)
+		assert.InDelta(t, kase.wantQuote, dailyVolume.QuoteVol, 1e-7)
+	}
+}
@@ -505,7 +505,9 @@ func (t *Trader) update() plugins.UpdateLoopResult {
 			// if there is an error we want it to count towards the delete cycles threshold, so run the check
 			if e != nil {
 				t.deleteAllOffers(true)
+				return
 			}
+			t.postSubmitFilters()
 		})
 		if e != nil {
 			log.Println(e)
@@ -544,6 +546,20 @@ func (t *Trader) update() plugins.UpdateLoopResult {
 	}
 }
 
+// postSubmitFilters notifies the submitFilters that the ops were submitted, errors are logged since the ops were already submitted
+func (t *Trader) postSubmitFilters() {
+	for i, filter := range t.submitFilters {
+		postSubmitFilter, ok := filter.(plugins.PostSubmitFilter)
+		if !ok {
+			continue
+		}
+		e := postSubmitFilter.PostSubmit()
+		if e != nil {
+			log.Printf("error in post submit of filter index %d: %s\n", i, e)
+		}
+	}
+}
+
 func (t *Trader) getBalances() (*api.Balance /*baseBalance*/, *api.Balance /*quoteBalance*/, error) {
 	baseBalance, e := t.exchangeShim.GetBalanceHack(t.assetBase)
 	if e != nil {